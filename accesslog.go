@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// AccessEntry describes the request handled by the router. Route is the template of the matched route and Tags
+// are its tags, they are empty if none matches. Query and Header are redacted according to AccessLogConfig.
+type AccessEntry struct {
+	Method        string
+	Route         string
+	Tags          map[string]string
+	Path          string
+	Query         url.Values
+	Header        http.Header
+	Status        int
+	Duration      time.Duration
+	RequestBytes  int64
+	ResponseBytes int64
+	RemoteIP      string
+	Err           error
+}
+
+// Redacted replaces values of redacted query parameters and headers.
+const Redacted = "[REDACTED]"
+
+// Attrs returns attributes of the entry, e.g. for slog handlers or adapters of other structured loggers.
+func (e AccessEntry) Attrs() []slog.Attr {
+	attrs := []slog.Attr{
+		slog.String("method", e.Method),
+		slog.String("route", e.Route),
+		slog.String("path", e.Path),
+		slog.Int("status", e.Status),
+		slog.Duration("duration", e.Duration),
+		slog.Int64("request_bytes", e.RequestBytes),
+		slog.Int64("response_bytes", e.ResponseBytes),
+		slog.String("remote_ip", e.RemoteIP),
+	}
+	if len(e.Query) > 0 {
+		attrs = append(attrs, slog.String("query", e.Query.Encode()))
+	}
+	for name, values := range e.Header {
+		attrs = append(attrs, slog.Any("header."+name, values))
+	}
+	for key, value := range e.Tags {
+		attrs = append(attrs, slog.String("tag."+key, value))
+	}
+	if e.Err != nil {
+		attrs = append(attrs, slog.String("error", e.Err.Error()))
+	}
+	return attrs
+}
+
+// AccessLogger is notified after each request handled by the router, including ones rejected by its middleware.
+type AccessLogger interface {
+	LogAccess(ctx context.Context, entry AccessEntry)
+}
+
+// AccessLoggerFunc adapts function to AccessLogger.
+type AccessLoggerFunc func(ctx context.Context, entry AccessEntry)
+
+func (f AccessLoggerFunc) LogAccess(ctx context.Context, entry AccessEntry) {
+	f(ctx, entry)
+}
+
+// SlogAccessLogger logs entries with the logger, responses with 5xx status codes are logged as errors.
+type SlogAccessLogger struct {
+	Logger *slog.Logger
+}
+
+func (sal SlogAccessLogger) LogAccess(ctx context.Context, entry AccessEntry) {
+	level := slog.LevelInfo
+	if entry.Status >= http.StatusInternalServerError {
+		level = slog.LevelError
+	}
+	sal.Logger.LogAttrs(ctx, level, "request", entry.Attrs()...)
+}
+
+// AccessLogConfig selects logged headers and redacts values of query parameters and headers by names.
+type AccessLogConfig struct {
+	Headers []string
+	Redact  []string
+}
+
+// AccessLog sets logger of requests handled by the router. Endpoints built with AccessLog(false) aren't logged.
+func (rt *Router) AccessLog(logger AccessLogger, config AccessLogConfig) *Router {
+	rt.accessLogger = logger
+	rt.accessLogConfig = config
+	return rt
+}
+
+// AccessLog enables or disables logging of requests of the endpoint by AccessLogger of the router, it is enabled by default.
+func (b builder) AccessLog(enabled bool) Builder {
+	cloned := b.clone()
+	cloned.noAccessLog = !enabled
+	return cloned
+}
+
+type accessLogKey struct{}
+
+// accessRecord collects the entry while the request is served, the router sets the matched endpoint and its error.
+type accessRecord struct {
+	endpoint *EndpointProcessor
+	err      error
+}
+
+// recordError keeps the error reported to hooks for the access log entry of the request.
+// Errors of route observers are reported without request.
+func recordError(r *http.Request, err error) {
+	if r == nil {
+		return
+	}
+	if record, logged := r.Context().Value(accessLogKey{}).(*accessRecord); logged {
+		record.err = err
+	}
+}
+
+func (rt *Router) serveLogged(w http.ResponseWriter, r *http.Request, serve func(w http.ResponseWriter, r *http.Request)) {
+	startedAt := rt.now()
+	record := &accessRecord{}
+	counted := &countingResponseWriter{ResponseWriter: w}
+	logged := r.WithContext(context.WithValue(r.Context(), accessLogKey{}, record))
+	var body *countingReader
+	if r.Body != nil && r.Body != http.NoBody {
+		// replaced in the copy, so the request of the caller keeps its body
+		body = &countingReader{ReadCloser: r.Body}
+		logged.Body = body
+	}
+	defer func() {
+		if record.endpoint != nil && record.endpoint.noAccessLog {
+			return
+		}
+		entry := AccessEntry{
+			Method:        r.Method,
+			Path:          r.URL.Path,
+			Query:         rt.accessLogConfig.redactQuery(r.URL.Query()),
+			Header:        rt.accessLogConfig.selectHeaders(r.Header),
+			Status:        counted.statusCode,
+			Duration:      rt.now().Sub(startedAt),
+			ResponseBytes: counted.written,
+			RemoteIP:      ClientIP(r),
+			Err:           record.err,
+		}
+		if record.endpoint != nil {
+			entry.Route = record.endpoint.route.Template
+			if record.endpoint.route.tags != nil {
+				entry.Tags = record.endpoint.route.Tags()
+			}
+		}
+		if entry.Status == 0 {
+			entry.Status = http.StatusOK
+		}
+		if body != nil {
+			entry.RequestBytes = body.read
+		}
+		rt.accessLogger.LogAccess(r.Context(), entry)
+	}()
+	serve(counted, logged)
+}
+
+func (config AccessLogConfig) redacted(name string) bool {
+	for _, redacted := range config.Redact {
+		if http.CanonicalHeaderKey(redacted) == http.CanonicalHeaderKey(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactQuery replaces values of the query in place, it is parsed by URL.Query anew for each call, so URL of
+// the request is not changed.
+func (config AccessLogConfig) redactQuery(query url.Values) url.Values {
+	for name, values := range query {
+		if config.redacted(name) {
+			query[name] = redactedValues(values)
+		}
+	}
+	return query
+}
+
+func (config AccessLogConfig) selectHeaders(header http.Header) http.Header {
+	if len(config.Headers) == 0 {
+		return nil
+	}
+	selected := http.Header{}
+	for _, name := range config.Headers {
+		values := header.Values(name)
+		if len(values) == 0 {
+			continue
+		}
+		if config.redacted(name) {
+			values = redactedValues(values)
+		}
+		selected[http.CanonicalHeaderKey(name)] = append([]string(nil), values...)
+	}
+	return selected
+}
+
+func redactedValues(values []string) []string {
+	redacted := make([]string, len(values))
+	for i := range redacted {
+		redacted[i] = Redacted
+	}
+	return redacted
+}
+
+// countingResponseWriter records the status code and the size of the response body.
+type countingResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+	written    int64
+}
+
+func (crw *countingResponseWriter) WriteHeader(statusCode int) {
+	if crw.statusCode == 0 && statusCode >= http.StatusOK {
+		crw.statusCode = statusCode
+	}
+	crw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (crw *countingResponseWriter) Write(data []byte) (int, error) {
+	if crw.statusCode == 0 {
+		crw.statusCode = http.StatusOK
+	}
+	n, err := crw.ResponseWriter.Write(data)
+	crw.written += int64(n)
+	return n, err
+}
+
+func (crw *countingResponseWriter) Flush() {
+	if flusher, ok := crw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (crw *countingResponseWriter) Unwrap() http.ResponseWriter {
+	return crw.ResponseWriter
+}
+
+type countingReader struct {
+	io.ReadCloser
+	read int64
+}
+
+func (cr *countingReader) Read(data []byte) (int, error) {
+	n, err := cr.ReadCloser.Read(data)
+	cr.read += int64(n)
+	return n, err
+}
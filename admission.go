@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+)
+
+// AdmissionError is returned when admission controller rejects the request, e.g. as too expensive.
+// Its status code is the one of the cause or 429 Too Many Requests.
+type AdmissionError struct {
+	Cause error
+}
+
+func (e AdmissionError) Error() string {
+	return "request is not admitted: " + e.Cause.Error()
+}
+
+func (e AdmissionError) Unwrap() error {
+	return e.Cause
+}
+
+func (e AdmissionError) StatusCode() int {
+	return errorStatusCode(e.Cause, http.StatusTooManyRequests)
+}
+
+// Admit registers admission controller invoked after binding before the handler with the bound handler parameter
+// of type T, e.g. to reject requests with too big page size or date range. Controller is a function of signature
+// func(T) error, returned error is mapped as AdmissionError.
+func (b builder) Admit(controller interface{}) Builder {
+	controllerType := reflect.TypeOf(controller)
+	if controllerType == nil || controllerType.Kind() != reflect.Func ||
+		controllerType.NumIn() != 1 || controllerType.NumOut() != 1 || controllerType.Out(0) != errorType {
+		b.addError(InvalidMappingError(fmt.Errorf("admission controller %T is not func(T) error", controller)))
+		return b
+	}
+
+	cloned := b.clone()
+	admissions := make([]reflect.Value, len(cloned.admissions), len(cloned.admissions)+1)
+	copy(admissions, cloned.admissions)
+	cloned.admissions = append(admissions, reflect.ValueOf(controller))
+	return cloned
+}
+
+// buildAdmit binds admission controllers to the handler parameters of their types.
+func (b *builder) buildAdmit() func(values []reflect.Value) error {
+	type admission struct {
+		parameter  int
+		controller reflect.Value
+	}
+	var admissions []admission
+	serviceType := b.serviceValue.Type()
+	for _, controller := range b.admissions {
+		parameterType := controller.Type().In(0)
+		parameter := -1
+		for i := 0; i < serviceType.NumIn(); i++ {
+			if serviceType.In(i) == parameterType {
+				parameter = i
+				break
+			}
+		}
+		if parameter < 0 {
+			b.addError(InvalidMappingError(fmt.Errorf("handler has no parameter of type %s admitted by %s", parameterType, controller.Type())))
+			continue
+		}
+		admissions = append(admissions, admission{parameter: parameter, controller: controller})
+	}
+
+	return func(values []reflect.Value) error {
+		for _, admitted := range admissions {
+			results := admitted.controller.Call([]reflect.Value{values[admitted.parameter]})
+			if err, _ := results[0].Interface().(error); err != nil {
+				return AdmissionError{Cause: err}
+			}
+		}
+		return nil
+	}
+}
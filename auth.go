@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+var (
+	// ErrInvalidCredentials is returned by credential verifiers for unknown or wrong credentials,
+	// it results into 401 Unauthorized.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrForbidden is returned by credential verifiers for valid credentials without access to the route,
+	// it results into 403 Forbidden.
+	ErrForbidden = errors.New("forbidden")
+)
+
+// AuthError is returned by auth interceptors for requests without valid credentials.
+// Challenge is sent in WWW-Authenticate header of 401 Unauthorized responses.
+type AuthError struct {
+	Status    int
+	Challenge string
+	Cause     error
+}
+
+func (e AuthError) Error() string {
+	return "authentication failed: " + e.Cause.Error()
+}
+
+func (e AuthError) Unwrap() error {
+	return e.Cause
+}
+
+func (e AuthError) StatusCode() int {
+	return e.Status
+}
+
+func (e AuthError) Headers() http.Header {
+	if e.Status != http.StatusUnauthorized || e.Challenge == "" {
+		return nil
+	}
+	return http.Header{"Www-Authenticate": {e.Challenge}}
+}
+
+// authError maps errors of credential verifiers, other errors than ErrInvalidCredentials and ErrForbidden are kept.
+func authError(err error, challenge string) error {
+	switch {
+	case errors.Is(err, ErrForbidden):
+		return AuthError{Status: http.StatusForbidden, Cause: err}
+	case errors.Is(err, ErrInvalidCredentials):
+		return AuthError{Status: http.StatusUnauthorized, Challenge: challenge, Cause: err}
+	}
+	return err
+}
+
+// BasicAuth returns interceptor for Before authenticating requests with HTTP Basic credentials checked by verify.
+// The principal P returned by verify is injected into parameters of type P of the handler.
+func BasicAuth[P any](realm string, verify func(ctx context.Context, username, password string) (P, error)) func(r *http.Request) (P, error) {
+	challenge := "Basic realm=" + strconv.Quote(realm) + `, charset="UTF-8"`
+	return func(r *http.Request) (P, error) {
+		username, password, found := r.BasicAuth()
+		if !found {
+			var none P
+			return none, AuthError{Status: http.StatusUnauthorized, Challenge: challenge, Cause: errors.New("no basic credentials")}
+		}
+		principal, err := verify(r.Context(), username, password)
+		return principal, authError(err, challenge)
+	}
+}
+
+// APIKey is a location of API key in the request, either Header or Query parameter.
+type APIKey struct {
+	Header string
+	Query  string
+}
+
+func (ak APIKey) of(r *http.Request) string {
+	if ak.Header != "" {
+		return r.Header.Get(ak.Header)
+	}
+	return r.URL.Query().Get(ak.Query)
+}
+
+// APIKeyAuth returns interceptor for Before authenticating requests with API key checked by verify.
+// The principal P returned by verify is injected into parameters of type P of the handler.
+func APIKeyAuth[P any](location APIKey, verify func(ctx context.Context, key string) (P, error)) func(r *http.Request) (P, error) {
+	return func(r *http.Request) (P, error) {
+		key := location.of(r)
+		if key == "" {
+			var none P
+			return none, AuthError{Status: http.StatusUnauthorized, Cause: errors.New("no API key")}
+		}
+		principal, err := verify(r.Context(), key)
+		return principal, authError(err, "")
+	}
+}
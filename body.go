@@ -0,0 +1,71 @@
+package main
+
+import (
+	"io"
+	"net/http"
+)
+
+const defaultUnreadBodyLimit = 256 << 10
+
+// UnreadBodyPolicy defines what to do with the request body left unread by the endpoint,
+// e.g. when request is rejected before the upload is complete.
+type UnreadBodyPolicy struct {
+	// Limit of bytes drained from unread body after the response, the body is closed if it has more.
+	Limit int64
+	// CloseConnection adds "Connection: close" header to responses written before the body is read.
+	CloseConnection bool
+}
+
+var DefaultUnreadBodyPolicy = UnreadBodyPolicy{Limit: defaultUnreadBodyLimit}
+
+type trackedBody struct {
+	io.ReadCloser
+	touched  bool
+	consumed bool
+	cleanups []func()
+}
+
+// onFinish registers cleanup of resources bound to the body, e.g. spooled files, run after the response.
+func (tb *trackedBody) onFinish(cleanup func()) {
+	tb.cleanups = append(tb.cleanups, cleanup)
+}
+
+func (tb *trackedBody) Read(data []byte) (int, error) {
+	tb.touched = true
+	n, err := tb.ReadCloser.Read(data)
+	if err == io.EOF {
+		tb.consumed = true
+	}
+	return n, err
+}
+
+func trackBody(r *http.Request) *trackedBody {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+	body := &trackedBody{ReadCloser: r.Body}
+	r.Body = body
+	return body
+}
+
+func (policy UnreadBodyPolicy) finish(body *trackedBody) {
+	if body == nil {
+		return
+	}
+	for _, cleanup := range body.cleanups {
+		cleanup()
+	}
+	if body.consumed {
+		return
+	}
+	drained, _ := io.CopyN(io.Discard, body, policy.Limit+1)
+	if drained > policy.Limit {
+		body.Close()
+	}
+}
+
+func (policy UnreadBodyPolicy) markEarlyResponse(body *trackedBody, header http.Header) {
+	if policy.CloseConnection && body != nil && !body.touched {
+		header.Set("Connection", "close")
+	}
+}
@@ -1,14 +1,18 @@
 package main
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"net/url"
 	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const (
@@ -17,49 +21,78 @@ const (
 	headerParametersGroup
 	bodyParametersGroup
 	cookieParametersGroup
+	structParametersGroup
+	routeInfoParametersGroup
+	requestParametersGroup
+	responseWriterParametersGroup
+	contextParametersGroup
 
 	responseBodyParametersGroup
 	responseErrorParametersGroup
 	responseStatusCodeParametersGroup
 	responseHeaderParametersGroup
-	responseContentTypeParametersGroup
 	responseCookieParametersGroup
 
-	pathTemplateStart = "/:"
-	pathTemplateEnd   = "/"
+	pathSeparator       = "/"
+	pathParameterPrefix = ":"
 )
 
 type Builder interface {
-	Before(interceptor Interceptor) Builder
+	Before(interceptor interface{}) Builder
 	Decoder(decoder Decoder) Builder
+	DecoderFor(mediaType string, decoder Decoder) Builder
 	Handler(service interface{}) Builder
 	Encoder(encoder Encoder) Builder
+	EncoderFor(mediaType string, encoder Encoder) Builder
 	ResponseContentType(setter ContentType) Builder
-	After(interceptor Interceptor) Builder
+	Codec(codecs ...Codec) Builder
+	CodecFor(mediaTypes ...string) Builder
+	After(interceptor interface{}) Builder
 	ErrorMapping(errorMapper ErrorMapper) Builder
+	RequestErrorMapping(errorMapper ErrorMapper) Builder
+	WhenQuery(name string, values ...string) Builder
+	RequireHeader(name string, statusCode ...int) Builder
+	Constraint(name string, pattern *regexp.Regexp) Builder
+	ContextValue(extractor interface{}) Builder
+	Provides(types ...reflect.Type) Builder
+	RateLimit(limiter RateLimiter, key interface{}) Builder
+	AccessLog(enabled bool) Builder
+	Validator(validator Validator) Builder
+	NormalizeStrings(policy StringNormalization) Builder
+	PathLimits(limits PathParameterLimits) Builder
+	Limits(limits RequestLimits) Builder
+	MaxBodyBytes(n int64) Builder
+	MaxResponseBytes(n int64) Builder
+	Timeout(timeout time.Duration, statusCode ...int) Builder
+	Admit(controller interface{}) Builder
+	EchoSchema(enabled bool) Builder
+	Conditional(computeETag bool) Builder
+	Fields(queryParameter string) Builder
+	Cache(policy CachePolicy) Builder
+	Invalidates(store ResponseCache, paths func(r *http.Request) []string) Builder
+	PropagateHeaders(base *http.Client, headers ...string) Builder
+	EncoderFallback(encoders ...Encoder) Builder
+	Pagination(policy PaginationPolicy) Builder
+	TempDirs(parent string) Builder
+	Client(baseURL string, httpClient *http.Client, function interface{}) error
+	Pool(pool *WorkerPool) Builder
+	RetryHints(hints RetryHints) Builder
+	Wrap(middleware ...func(next http.Handler) http.Handler) Builder
+	Name(name string) Builder
+	Debug(enabled bool) Builder
+	UnreadBody(policy UnreadBodyPolicy) Builder
+	SpoolBody(policy SpoolPolicy) Builder
+	BufferResponse(limit int) Builder
+	TimeLayout(layout string) Builder
+	ByteEncoding(encoding ByteEncoding) Builder
+	ResponseHeader(name string, values ...string) Builder
+	ResponseHeaders(headers http.Header) Builder
+	Tag(key, value string) Builder
+	RequestExample(example interface{}) Builder
+	ResponseExample(statusCode int, example interface{}) Builder
 	Build() EndpointProcessor
 }
 
-func pathValueSegmentOffsets(requestURI string) []int {
-	var offsets []int
-	from := 0
-	for {
-		dirtyOffset := strings.Index(requestURI[from:], pathTemplateStart)
-		if dirtyOffset == -1 {
-			return offsets
-		}
-		offset := dirtyOffset + 1
-		offsets = append(offsets, offset)
-
-		from += offset
-		dirtyOffsetEnd := strings.Index(requestURI[from:], pathTemplateEnd)
-		if dirtyOffsetEnd == -1 {
-			return offsets
-		}
-		from += dirtyOffsetEnd
-	}
-}
-
 func POST(urlPathTemplate string) Builder {
 	return newBuilder(http.MethodPost, urlPathTemplate)
 }
@@ -96,49 +129,137 @@ func TRACE(urlPathTemplate string) Builder {
 	return newBuilder(http.MethodTrace, urlPathTemplate)
 }
 
-func pathValuesByOffsets(offsets []int) func(uri string) []string {
-	return func(uri string) []string {
-		var values []string
-		var from int
-		for _, offset := range offsets {
-			startAt := from + offset
-			endAt := strings.Index(uri[startAt:], "/")
-			if endAt == -1 {
-				values = append(values, uri[startAt:])
-				return values
+// Route creates builder from the method and path template separated by space, e.g. "GET /users/:id".
+func Route(route string) Builder {
+	method, urlPathTemplate, found := strings.Cut(strings.TrimSpace(route), " ")
+	urlPathTemplate = strings.TrimSpace(urlPathTemplate)
+	b := newBuilder(strings.ToUpper(method), urlPathTemplate)
+	if !found || method == "" || !strings.HasPrefix(urlPathTemplate, pathSeparator) {
+		b.addError(InvalidMappingError(fmt.Errorf("route %q is not in form of \"METHOD /path\"", route)))
+	}
+	return b
+}
+
+// Routes creates builders with handlers from the table of routes in form accepted by Route.
+// Builders are ordered by routes for the registration to be deterministic.
+func Routes(handlers map[string]interface{}) []Builder {
+	routes := make([]string, 0, len(handlers))
+	for route := range handlers {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+
+	builders := make([]Builder, 0, len(routes))
+	for _, route := range routes {
+		builders = append(builders, Route(route).Handler(handlers[route]))
+	}
+	return builders
+}
+
+// pathParameterSegments returns indexes of path segments which hold parameters in the template.
+func pathParameterSegments(urlPathTemplate string) []int {
+	var indexes []int
+	for index, segment := range strings.Split(urlPathTemplate, pathSeparator) {
+		if strings.HasPrefix(segment, pathParameterPrefix) {
+			indexes = append(indexes, index)
+		}
+	}
+	return indexes
+}
+
+// pathParameterNames returns names of path parameters in order of the template segments.
+// Unnamed parameters are named by their position.
+func pathParameterNames(urlPathTemplate string) []string {
+	segments := strings.Split(urlPathTemplate, pathSeparator)
+	var names []string
+	for _, index := range pathParameterSegments(urlPathTemplate) {
+		name := segments[index][len(pathParameterPrefix):]
+		if name == "" {
+			name = "param" + strconv.Itoa(len(names)+1)
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+func pathValuesBySegments(indexes []int) func(path string) []string {
+	return func(path string) []string {
+		segments := strings.Split(path, pathSeparator)
+		values := make([]string, 0, len(indexes))
+		for _, index := range indexes {
+			if index >= len(segments) {
+				break
 			}
-			endAt += startAt
-			values = append(values, uri[startAt:endAt])
-			from = endAt
+			values = append(values, segments[index])
 		}
 		return values
 	}
 }
 
 func newBuilder(method, urlPathTemplate string) builder {
-	pathParamsAmount := strings.Count(urlPathTemplate, pathTemplateStart)
-	var pathValues func(uri string) []string
-	if pathParamsAmount > 0 {
-		pathValues = pathValuesByOffsets(pathValueSegmentOffsets(urlPathTemplate))
-	} else {
-		pathValues = func(uri string) []string { return []string{uri} }
-	}
+	pathParameterIndexes := pathParameterSegments(urlPathTemplate)
 
 	return builder{
 		method:           method,
-		pathValues:       pathValues,
-		pathParamsAmount: pathParamsAmount,
+		pathTemplate:     urlPathTemplate,
+		pathValues:       pathValuesBySegments(pathParameterIndexes),
+		pathParamsAmount: len(pathParameterIndexes),
 		errors:           []error{},
+		unreadBodyPolicy: DefaultUnreadBodyPolicy,
+		spoolPolicy:      DefaultSpoolPolicy,
 	}
 }
 
 type builder struct {
 	method                 string
-	pathValues             func(uri string) []string
+	pathTemplate           string
+	name                   string
+	debug                  bool
+	echoSchema             bool
+	conditional            *bool
+	fieldsQuery            string
+	cachePolicy            CachePolicy
+	invalidations          []cacheInvalidation
+	propagatedHeaders      []string
+	fallbackEncoders       []Encoder
+	paginationPolicy       PaginationPolicy
+	tempDirs               *string
+	pool                   *WorkerPool
+	retryHints             *RetryHints
+	middleware             []func(next http.Handler) http.Handler
+	interceptors           []reflect.Value
+	afterInterceptors      []reflect.Value
+	provided               []reflect.Type
+	rateLimits             []rateLimit
+	noAccessLog            bool
+	unreadBodyPolicy       UnreadBodyPolicy
+	spoolPolicy            SpoolPolicy
+	bufferLimit            int
+	timeLayout             string
+	byteEncoding           ByteEncoding
+	normalization          StringNormalization
+	pathLimits             *PathParameterLimits
+	requestLimits          RequestLimits
+	maxBodyBytes           *int64
+	maxResponseBytes       int64
+	timeout                TimeoutError
+	responseHeaders        http.Header
+	tags                   *routeTags
+	requestExample         interface{}
+	responseExamples       []responseExample
+	queryConditions        url.Values
+	requiredHeaders        []requiredHeader
+	constraints            []pathConstraint
+	contextExtractors      map[reflect.Type]reflect.Value
+	validator              Validator
+	admissions             []reflect.Value
+	pathValues             func(path string) []string
 	pathParamsAmount       int
 	decoder                Decoder
+	decoders               map[string]Decoder
 	contentTypeProvider    ContentType
 	encoder                Encoder
+	encoders               []registeredEncoder
 	errors                 []error
 	parametersBy           map[int][]reflect.Type
 	serviceValue           reflect.Value
@@ -147,14 +268,18 @@ type builder struct {
 	headerParameters       func(headers http.Header) (reflect.Value, error)
 	queryParameters        func(queryValues url.Values) (reflect.Value, error)
 	cookieParameters       func(cookieValues []*http.Cookie) (reflect.Value, error)
-	bodyParameters         func(bodyReader io.Reader) (reflect.Value, error)
+	bodyParameters         func(r *http.Request) (reflect.Value, error)
+	structParameters       func(r *http.Request) (reflect.Value, error)
+	trailersBound          bool
 
 	errorMapper                  ErrorMapper
+	requestErrorMapper           ErrorMapper
 	orderOfResponseParameters    []int
 	responseHeaderParameters     func(value reflect.Value) http.Header
 	responseStatusCodeParameters func(value reflect.Value) int
 	responseCookieParameters     func(value reflect.Value) []*http.Cookie
 	responseErrorParameters      func(err error, w http.ResponseWriter, r *http.Request) error
+	wrappedResults               []int
 }
 
 func (cloned builder) clone() builder {
@@ -174,12 +299,50 @@ func (cloned builder) clone() builder {
 		copy(cloned.orderOfOtherParameters, orderOfOtherParameters)
 	}
 
+	if len(cloned.wrappedResults) > 0 {
+		wrappedResults := cloned.wrappedResults
+		cloned.wrappedResults = make([]int, len(wrappedResults))
+		copy(cloned.wrappedResults, wrappedResults)
+	}
+
 	if len(cloned.orderOfResponseParameters) > 0 {
 		orderOfResponseParameters := cloned.orderOfResponseParameters
 		cloned.orderOfResponseParameters = make([]int, len(orderOfResponseParameters))
 		copy(cloned.orderOfResponseParameters, orderOfResponseParameters)
 	}
 
+	if len(cloned.queryConditions) > 0 {
+		queryConditions := cloned.queryConditions
+		cloned.queryConditions = make(url.Values, len(queryConditions))
+		for name, values := range queryConditions {
+			valuesCloned := make([]string, len(values))
+			copy(valuesCloned, values)
+			cloned.queryConditions[name] = valuesCloned
+		}
+	}
+
+	if len(cloned.responseHeaders) > 0 {
+		cloned.responseHeaders = cloned.responseHeaders.Clone()
+	}
+
+	if len(cloned.requiredHeaders) > 0 {
+		requiredHeaders := cloned.requiredHeaders
+		cloned.requiredHeaders = make([]requiredHeader, len(requiredHeaders))
+		copy(cloned.requiredHeaders, requiredHeaders)
+	}
+
+	if len(cloned.responseExamples) > 0 {
+		responseExamples := cloned.responseExamples
+		cloned.responseExamples = make([]responseExample, len(responseExamples))
+		copy(cloned.responseExamples, responseExamples)
+	}
+
+	if len(cloned.invalidations) > 0 {
+		invalidations := cloned.invalidations
+		cloned.invalidations = make([]cacheInvalidation, len(invalidations))
+		copy(cloned.invalidations, invalidations)
+	}
+
 	if len(cloned.errors) > 0 {
 		errs := cloned.errors
 		cloned.errors = make([]error, len(errs))
@@ -188,19 +351,22 @@ func (cloned builder) clone() builder {
 	return cloned
 }
 
-// TODO: how to put before interceptors?
-// Would it be a traditional chain call?
-// Do we want interceptors to be any kind of functions with same mapping rules that main service function apply to?
-// Or just implement a specific interface?
-func (b builder) Before(interceptor Interceptor) Builder {
+func (b builder) Decoder(decoder Decoder) Builder {
 	cloned := b.clone()
-	//cloned.before = interceptor
+	cloned.decoder = decoder
 	return cloned
 }
 
-func (b builder) Decoder(decoder Decoder) Builder {
+// DecoderFor registers decoder used for requests with the media type in Content-Type header.
+// Decoder set with Decoder is used when none of registered media types match.
+func (b builder) DecoderFor(mediaType string, decoder Decoder) Builder {
 	cloned := b.clone()
-	cloned.decoder = decoder
+	decoders := make(map[string]Decoder, len(cloned.decoders)+1)
+	for registered, registeredDecoder := range cloned.decoders {
+		decoders[registered] = registeredDecoder
+	}
+	decoders[strings.ToLower(mediaType)] = decoder
+	cloned.decoders = decoders
 	return cloned
 }
 
@@ -217,78 +383,25 @@ func (b *builder) definePathParameters() {
 	}
 
 	var converters []PathParameterConverter
-	for _, pathParameterType := range pathParameters {
-		var converter PathParameterConverter
-
-		if pathParameterType.Implements(PathParameterConverterType) {
-			converter = reflect.New(pathParameterType).Elem().Interface().(PathParameterConverter)
-		} else {
-			switch pathParameterType.Kind() {
-			case reflect.String:
-				converter = stringPathParameterConverterSingleton
-			case reflect.Int8:
-				converter = IntPathParameterConverter{bitSize: 8, valueOf: func(parsed int64) reflect.Value {
-					return reflect.ValueOf(int8(parsed))
-				}}
-			case reflect.Int16:
-				converter = IntPathParameterConverter{bitSize: 16, valueOf: func(parsed int64) reflect.Value {
-					return reflect.ValueOf(int16(parsed))
-				}}
-			case reflect.Int32:
-				converter = IntPathParameterConverter{bitSize: 32, valueOf: func(parsed int64) reflect.Value {
-					return reflect.ValueOf(int32(parsed))
-				}}
-			case reflect.Int64:
-				converter = IntPathParameterConverter{bitSize: 64, valueOf: func(parsed int64) reflect.Value {
-					return reflect.ValueOf(parsed)
-				}}
-			case reflect.Int:
-				converter = IntPathParameterConverter{bitSize: 32, valueOf: func(parsed int64) reflect.Value {
-					return reflect.ValueOf(int(parsed))
-				}}
-			case reflect.Uint8:
-				converter = UintPathParameterConverter{bitSize: 8, valueOf: func(parsed uint64) reflect.Value {
-					return reflect.ValueOf(uint8(parsed))
-				}}
-			case reflect.Uint16:
-				converter = UintPathParameterConverter{bitSize: 16, valueOf: func(parsed uint64) reflect.Value {
-					return reflect.ValueOf(uint16(parsed))
-				}}
-			case reflect.Uint32:
-				converter = UintPathParameterConverter{bitSize: 32, valueOf: func(parsed uint64) reflect.Value {
-					return reflect.ValueOf(uint32(parsed))
-				}}
-			case reflect.Uint64:
-				converter = UintPathParameterConverter{bitSize: 64, valueOf: func(parsed uint64) reflect.Value {
-					return reflect.ValueOf(parsed)
-				}}
-			case reflect.Uint:
-				converter = UintPathParameterConverter{bitSize: 32, valueOf: func(parsed uint64) reflect.Value {
-					return reflect.ValueOf(uint(parsed))
-				}}
-			case reflect.Bool:
-				converter = boolPathParameterConverterSingleton
-			case reflect.Slice:
-				if pathParameterType.Elem().Kind() != reflect.Uint8 {
-					b.errors = append(b.errors, UnsupportedTypeError(errors.New("supports only slice/array of bytes")))
-					return
-				}
-				converter = sliceBytePathParameterConverterSingleton
-			case reflect.Array:
-				returnParameterTypeElem := pathParameterType.Elem()
-				if returnParameterTypeElem.Kind() != reflect.Uint8 {
-					b.errors = append(b.errors, UnsupportedTypeError(errors.New("supports only array of bytes")))
-					return
-				}
-				converter = ArrayBytePathParameterConverter{length: pathParameterType.Len(), elementType: returnParameterTypeElem}
-			default:
-				b.errors = append(b.errors, UnsupportedTypeError(errors.New("for path parameter: "+pathParameterType.String())))
-				return
-			}
+	for i, pathParameterType := range pathParameters {
+		layout := b.timeLayout
+		if isBytesKind(pathParameterType) {
+			layout = string(b.byteEncoding)
+		}
+		converter, err := newLayoutPathParameterConverter(pathParameterType, layout)
+		if err != nil {
+			b.addErrorAt(i, -1, err)
+			return
 		}
 		converters = append(converters, converter)
 	}
 
+	names := pathParameterNames(b.pathTemplate)
+	normalization := b.normalization
+	var limits PathParameterLimits
+	if b.pathLimits != nil {
+		limits = *b.pathLimits
+	}
 	if len(converters) != 0 {
 		b.pathParameters = func(pathValues []string) (values []reflect.Value, err error) {
 			amountPathValues := len(pathValues)
@@ -298,11 +411,21 @@ func (b *builder) definePathParameters() {
 			}
 			for i := 0; i < amountPathValues; i++ {
 				var value reflect.Value
-				value, err = converters[i].Convert(pathValues[i])
-				if err != nil {
+				pathValue, unescapeErr := url.PathUnescape(pathValues[i])
+				if unescapeErr != nil {
+					return values, InvalidValueError(unescapeErr)
+				}
+				if err = limits.check(pathValue); err != nil {
 					return
 				}
-				values = append(values, value)
+				if normalization.enabled() && pathParameters[i].Kind() == reflect.String {
+					pathValue = normalization.apply(pathValue)
+				}
+				value, err = converters[i].Convert(pathValue)
+				if err != nil {
+					return values, withParameter(err, names[i])
+				}
+				values = append(values, value.Convert(pathParameters[i]))
 			}
 			return
 		}
@@ -312,7 +435,7 @@ func (b *builder) definePathParameters() {
 func (b builder) Handler(service interface{}) Builder {
 	serviceType := reflect.TypeOf(service)
 	if serviceType.Kind() != reflect.Func {
-		b.errors = append(b.errors, InvalidMappingError(errors.New("handler is not a function/method")))
+		b.addError(InvalidMappingError(errors.New("handler is not a function/method")))
 		return b
 	}
 	cloned := b.clone()
@@ -331,27 +454,19 @@ func (b *builder) groupRequestParameters(serviceType reflect.Type) {
 }
 
 func (b *builder) groupRequestPathParameters(serviceType reflect.Type) {
+	if bindsPathByName(serviceType) {
+		b.pathParamsAmount = 0
+	}
 	if serviceType.NumIn() < b.pathParamsAmount {
-		b.errors = append(b.errors, InvalidMappingError(fmt.Errorf("unexpected amount of path parameters: in URI %d holders, in service function %d receivers", b.pathParamsAmount, serviceType.NumIn())))
+		b.addError(InvalidMappingError(fmt.Errorf("unexpected amount of path parameters: in URI %d holders, in service function %d receivers", b.pathParamsAmount, serviceType.NumIn())))
 		return
 	}
 
 	b.parametersBy = make(map[int][]reflect.Type)
 	for i := 0; i < b.pathParamsAmount; i++ {
 		parameterType := serviceType.In(i)
-		switch parameterType.Kind() {
-		case reflect.String,
-			reflect.Bool,
-			reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
-			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-		case reflect.Slice, reflect.Array:
-			returnParameterTypeElem := parameterType.Elem()
-			if returnParameterTypeElem.Kind() != reflect.Uint8 {
-				b.errors = append(b.errors, UnsupportedTypeError(fmt.Errorf("supports only slice/array of bytes, received: %#v", returnParameterTypeElem)))
-				return
-			}
-		default:
-			b.errors = append(b.errors, UnsupportedTypeError(fmt.Errorf("unsupported type for path parameter: %#v", parameterType)))
+		if _, err := newPathParameterConverter(parameterType); err != nil {
+			b.addErrorAt(i, -1, err)
 			return
 		}
 		b.parametersBy[pathParametersGroup] = append(b.parametersBy[pathParametersGroup], parameterType)
@@ -359,9 +474,9 @@ func (b *builder) groupRequestPathParameters(serviceType reflect.Type) {
 }
 
 func (b *builder) groupRequestOtherParameters(serviceType reflect.Type) {
-	addToGroup := func(parameterType reflect.Type, errorMsg string, group int) bool {
+	addToGroup := func(index int, parameterType reflect.Type, errorMsg string, group int) bool {
 		if len(b.parametersBy[group]) > 0 {
-			b.errors = append(b.errors, InvalidMappingError(errors.New(errorMsg)))
+			b.addErrorAt(index, -1, InvalidMappingError(errors.New(errorMsg)))
 			return false
 		}
 		b.parametersBy[group] = append(b.parametersBy[group], parameterType)
@@ -372,15 +487,26 @@ func (b *builder) groupRequestOtherParameters(serviceType reflect.Type) {
 	noError := true
 	for i := b.pathParamsAmount; noError && i < serviceType.NumIn(); i++ {
 		parameterType := serviceType.In(i)
-		switch parameterType {
-		case headersType:
-			noError = addToGroup(parameterType, "unable do mapping of headers to more than 1 parameter in service function", headerParametersGroup)
-		case urlQueryType:
-			noError = addToGroup(parameterType, "unable do mapping of URL query values to more than 1 parameter in service function", queryParametersGroup)
-		case cookiesType:
-			noError = addToGroup(parameterType, "unable do mapping of cookies to more than 1 parameter in service function", cookieParametersGroup)
+		switch {
+		case parameterType == headersType:
+			noError = addToGroup(i, parameterType, "unable do mapping of headers to more than 1 parameter in service function", headerParametersGroup)
+		case parameterType == urlQueryType:
+			noError = addToGroup(i, parameterType, "unable do mapping of URL query values to more than 1 parameter in service function", queryParametersGroup)
+		case parameterType == cookiesType:
+			noError = addToGroup(i, parameterType, "unable do mapping of cookies to more than 1 parameter in service function", cookieParametersGroup)
+		case parameterType == requestType:
+			noError = addToGroup(i, parameterType, "unable do mapping of request to more than 1 parameter in service function", requestParametersGroup)
+		case parameterType == responseWriterType:
+			noError = addToGroup(i, parameterType, "unable do mapping of response writer to more than 1 parameter in service function", responseWriterParametersGroup)
+		case parameterType == routeInfoType:
+			noError = addToGroup(i, parameterType, "unable do mapping of route info to more than 1 parameter in service function", routeInfoParametersGroup)
+		case b.hasContextExtractor(parameterType):
+			b.parametersBy[contextParametersGroup] = append(b.parametersBy[contextParametersGroup], parameterType)
+			b.orderOfOtherParameters = append(b.orderOfOtherParameters, contextParametersGroup)
+		case isBoundStruct(parameterType):
+			noError = addToGroup(i, parameterType, "unable do mapping of tagged header/query values to more than 1 parameter in service function", structParametersGroup)
 		default:
-			noError = addToGroup(parameterType, "unable do mapping of body to more than 1 parameter in service function", bodyParametersGroup)
+			noError = addToGroup(i, parameterType, "unable do mapping of body to more than 1 parameter in service function", bodyParametersGroup)
 		}
 	}
 }
@@ -388,50 +514,92 @@ func (b *builder) groupRequestOtherParameters(serviceType reflect.Type) {
 func (b *builder) groupResponseParameters(serviceType reflect.Type) {
 	for i := 0; i < serviceType.NumOut(); i++ {
 		parameterType := serviceType.Out(i)
-		switch {
-		case headersType == parameterType:
-			group := responseHeaderParametersGroup
-			b.parametersBy[group] = append(b.parametersBy[group], parameterType)
-			b.orderOfResponseParameters = append(b.orderOfResponseParameters, group)
-		case cookiesType == parameterType:
-			group := responseCookieParametersGroup
-			b.parametersBy[group] = append(b.parametersBy[group], parameterType)
-			b.orderOfResponseParameters = append(b.orderOfResponseParameters, group)
-		case httpStatusType == parameterType:
-			group := responseStatusCodeParametersGroup
-			responseStatusCodeParametersGroupTypes := b.parametersBy[group]
-			if len(responseStatusCodeParametersGroupTypes) > 0 {
-				b.errors = append(b.errors, InvalidMappingError(errors.New("unable to map multiple response status codes")))
+		if parameterType == redirectType {
+			b.wrappedResults = append(b.wrappedResults, i)
+			for _, fieldType := range redirectFields {
+				if !b.groupResponseParameter(i, fieldType) {
+					return
+				}
+			}
+			continue
+		}
+		if isTaggedResponse(parameterType) {
+			tagged, err := newTaggedResponse(parameterType)
+			if err != nil {
+				b.addErrorAt(-1, i, InvalidMappingError(err))
 				return
 			}
-			b.parametersBy[group] = append(responseStatusCodeParametersGroupTypes, parameterType)
-			b.orderOfResponseParameters = append(b.orderOfResponseParameters, group)
-		case parameterType.Implements(errorType):
-			group := responseErrorParametersGroup
-			responseErrorParametersGroupTypes := b.parametersBy[group]
-			if len(responseErrorParametersGroupTypes) > 0 {
-				b.errors = append(b.errors, InvalidMappingError(errors.New("unable to map multiple error return values")))
+			b.wrappedResults = append(b.wrappedResults, i)
+			for _, fieldType := range tagged.types(parameterType) {
+				if !b.groupResponseParameter(i, fieldType) {
+					return
+				}
+			}
+			continue
+		}
+		if parameterType.Kind() != reflect.Struct || !parameterType.Implements(responseWrapperType) {
+			if !b.groupResponseParameter(i, parameterType) {
 				return
 			}
-			b.parametersBy[group] = append(responseErrorParametersGroupTypes, parameterType)
-			b.orderOfResponseParameters = append(b.orderOfResponseParameters, group)
-		default:
-			group := responseBodyParametersGroup
-			responseBodyParametersGroupTypes := b.parametersBy[group]
-			if len(responseBodyParametersGroupTypes) > 0 {
-				b.errors = append(b.errors, InvalidMappingError(errors.New("unable to map body to multiple response entities")))
+			continue
+		}
+
+		b.wrappedResults = append(b.wrappedResults, i)
+		for _, field := range responseWrapperFields {
+			structField, _ := parameterType.FieldByName(field)
+			if !b.groupResponseParameter(i, structField.Type) {
+				return
 			}
-			b.parametersBy[group] = append(responseBodyParametersGroupTypes, parameterType)
-			b.orderOfResponseParameters = append(b.orderOfResponseParameters, group)
 		}
 	}
 }
 
+func (b *builder) groupResponseParameter(i int, parameterType reflect.Type) bool {
+	switch {
+	case headersType == parameterType:
+		group := responseHeaderParametersGroup
+		b.parametersBy[group] = append(b.parametersBy[group], parameterType)
+		b.orderOfResponseParameters = append(b.orderOfResponseParameters, group)
+	case cookiesType == parameterType:
+		group := responseCookieParametersGroup
+		b.parametersBy[group] = append(b.parametersBy[group], parameterType)
+		b.orderOfResponseParameters = append(b.orderOfResponseParameters, group)
+	case httpStatusType == parameterType:
+		group := responseStatusCodeParametersGroup
+		responseStatusCodeParametersGroupTypes := b.parametersBy[group]
+		if len(responseStatusCodeParametersGroupTypes) > 0 {
+			b.addErrorAt(-1, i, InvalidMappingError(errors.New("unable to map multiple response status codes")))
+			return false
+		}
+		b.parametersBy[group] = append(responseStatusCodeParametersGroupTypes, parameterType)
+		b.orderOfResponseParameters = append(b.orderOfResponseParameters, group)
+	case parameterType.Implements(errorType):
+		group := responseErrorParametersGroup
+		responseErrorParametersGroupTypes := b.parametersBy[group]
+		if len(responseErrorParametersGroupTypes) > 0 {
+			b.addErrorAt(-1, i, InvalidMappingError(errors.New("unable to map multiple error return values")))
+			return false
+		}
+		b.parametersBy[group] = append(responseErrorParametersGroupTypes, parameterType)
+		b.orderOfResponseParameters = append(b.orderOfResponseParameters, group)
+	default:
+		group := responseBodyParametersGroup
+		responseBodyParametersGroupTypes := b.parametersBy[group]
+		if len(responseBodyParametersGroupTypes) > 0 {
+			b.addErrorAt(-1, i, InvalidMappingError(errors.New("unable to map body to multiple response entities")))
+		}
+		b.parametersBy[group] = append(responseBodyParametersGroupTypes, parameterType)
+		b.orderOfResponseParameters = append(b.orderOfResponseParameters, group)
+	}
+	return true
+}
+
 func (b *builder) defineProviders() {
 	b.definePathParameters()
 	b.defineHeaderParameters()
 	b.defineQueryParameters()
 	b.defineCookieParameters()
+	b.defineStructParameters()
 	b.defineBodyParameters()
 
 	b.defineResponseHeaderParameters()
@@ -486,24 +654,58 @@ func (b *builder) defineBodyParameters() {
 	}
 
 	if len(bodyParameterTypes) != 1 {
-		b.errors = append(b.errors, InvalidMappingError(errors.New("doesn't support multiple return body mapped values")))
+		b.addErrorAt(b.parameterIndex(bodyParameterTypes[0]), -1, InvalidMappingError(errors.New("doesn't support multiple return body mapped values")))
+		return
+	}
+	if bodyParameterTypes[0] == readSeekerType {
+		policy := b.spoolPolicy
+		b.bodyParameters = func(r *http.Request) (reflect.Value, error) {
+			body, err := policy.spool(r)
+			return reflect.ValueOf(&body).Elem(), err
+		}
 		return
 	}
-	if b.decoder == nil {
-		b.errors = append(b.errors, InvalidMappingError(errors.New("mapping of request body to struct without decoder is impossible")))
+	if b.decoder == nil && len(b.decoders) == 0 {
+		b.addErrorAt(b.parameterIndex(bodyParameterTypes[0]), -1, InvalidMappingError(errors.New("mapping of request body to struct without decoder is impossible")))
 		return
 	}
-	b.bodyParameters = func(bodyReader io.Reader) (reflect.Value, error) {
+	selectDecoder := b.buildSelectDecoder()
+	b.bodyParameters = func(r *http.Request) (reflect.Value, error) {
 		entityPtr := reflect.New(bodyParameterTypes[0])
-		if bodyReader == nil {
+		if r.Body == nil || r.Body == http.NoBody {
 			return entityPtr.Elem(), nil
 		}
-		err := b.decoder(bodyReader)(entityPtr.Interface())
+		decoder, err := selectDecoder(r.Header.Get("Content-Type"))
+		if err != nil {
+			return entityPtr.Elem(), err
+		}
+		err = decoder.NewDecodeStream(r.Body).Decode(entityPtr.Interface())
 		return reflect.Indirect(entityPtr), err
 	}
 	return
 }
 
+func (b *builder) buildSelectDecoder() func(contentType string) (Decoder, error) {
+	fallback, decoders := b.decoder, b.decoders
+	return func(contentType string) (Decoder, error) {
+		if len(decoders) == 0 {
+			return fallback, nil
+		}
+		if contentType != "" {
+			mediaType, _, err := mime.ParseMediaType(contentType)
+			if err == nil {
+				if decoder, found := decoders[mediaType]; found {
+					return decoder, nil
+				}
+			}
+		}
+		if fallback != nil {
+			return fallback, nil
+		}
+		return nil, UnsupportedMediaTypeError(fmt.Errorf("no decoder for content type: %q", contentType))
+	}
+}
+
 func (b *builder) defineResponseHeaderParameters() {
 	headerParameterTypes, exist := b.hasParametersIn(responseHeaderParametersGroup)
 	if !exist {
@@ -511,7 +713,7 @@ func (b *builder) defineResponseHeaderParameters() {
 	}
 
 	if len(headerParameterTypes) != 1 {
-		b.errors = append(b.errors, InvalidMappingError(errors.New("supports only single response headers service function return value")))
+		b.addError(InvalidMappingError(errors.New("supports only single response headers service function return value")))
 		return
 	}
 
@@ -530,7 +732,7 @@ func (b *builder) defineResponseStatusCodeParameters() {
 	}
 
 	if len(responseStatusCodeTypes) != 1 {
-		b.errors = append(b.errors, InvalidMappingError(errors.New("supports only single response status code service function return value")))
+		b.addError(InvalidMappingError(errors.New("supports only single response status code service function return value")))
 		return
 	}
 
@@ -546,7 +748,7 @@ func (b *builder) defineResponseCookieParameters() {
 	}
 
 	if len(cookiesParameterTypes) != 1 {
-		b.errors = append(b.errors, InvalidMappingError(errors.New("supports only single response cookies service function return value")))
+		b.addError(InvalidMappingError(errors.New("supports only single response cookies service function return value")))
 		return
 	}
 
@@ -565,14 +767,58 @@ func (b *builder) defineResponseErrorParameters() {
 	}
 
 	if len(responseErrorParameterTypes) != 1 {
-		b.errors = append(b.errors, InvalidMappingError(errors.New("mapping of multiple error values of service function return clause is not supported")))
+		b.addError(InvalidMappingError(errors.New("mapping of multiple error values of service function return clause is not supported")))
 		return
 	}
 
-	b.responseErrorParameters = DefaultErrorMapper
+	b.responseErrorParameters = b.buildErrorMapper()
+}
+
+func (b *builder) buildErrorMapper() ErrorMapper {
 	if b.errorMapper != nil {
-		b.responseErrorParameters = b.errorMapper
+		return b.errorMapper
+	}
+	return DefaultErrorMapper
+}
+
+func (b *builder) buildMaxBodyBytes() int64 {
+	if b.maxBodyBytes == nil {
+		return 0
 	}
+	return *b.maxBodyBytes
+}
+
+// buildRequestErrorMapper falls back to the error mapper of the endpoint if it is set,
+// so request errors are rendered the same way as handler ones, e.g. as problem details.
+func (b *builder) buildRequestErrorMapper() ErrorMapper {
+	switch {
+	case b.requestErrorMapper != nil:
+		return b.requestErrorMapper
+	case b.errorMapper != nil:
+		return b.errorMapper
+	}
+	return DefaultRequestErrorMapper
+}
+
+func (b *builder) addError(cause error) {
+	b.addErrorAt(-1, -1, cause)
+}
+
+func (b *builder) addErrorAt(parameter, result int, cause error) {
+	buildErr := BuildError{Method: b.method, Template: b.pathTemplate, Parameter: parameter, Result: result, Cause: cause}
+	buildErr.Handler = functionName(b.serviceValue)
+	b.errors = append(b.errors, buildErr)
+}
+
+// parameterIndex returns index of the first handler parameter of the type following path parameters.
+func (b *builder) parameterIndex(parameterType reflect.Type) int {
+	serviceType := b.serviceValue.Type()
+	for i := b.pathParamsAmount; i < serviceType.NumIn(); i++ {
+		if serviceType.In(i) == parameterType {
+			return i
+		}
+	}
+	return -1
 }
 
 func (b *builder) hasParametersIn(parametersGroup int) ([]reflect.Type, bool) {
@@ -580,102 +826,508 @@ func (b *builder) hasParametersIn(parametersGroup int) ([]reflect.Type, bool) {
 	return parameters, found && len(parameters) > 0
 }
 
+func encode(encoder Encoder, w io.Writer, v interface{}) error {
+	stream := encoder.NewEncodeStream(w)
+	if err := stream.Encode(v); err != nil {
+		return err
+	}
+	return stream.Flush()
+}
+
 func (b builder) Encoder(encoder Encoder) Builder {
 	cloned := b.clone()
 	cloned.encoder = encoder
 	return cloned
 }
 
-// TODO: how to put after interceptors?
-// Would it be a traditional chain call?
-// Do we want interceptors to be any kind of functions with same mapping rules that main service function apply to?
-// Or just implement a specific interface?
-func (b builder) After(interceptor Interceptor) Builder {
+// EncoderFor registers encoder for the media type negotiated with Accept request header.
+// Encoder set with Encoder is used when Accept header is absent or none of registered media types are acceptable.
+func (b builder) EncoderFor(mediaType string, encoder Encoder) Builder {
 	cloned := b.clone()
-	//cloned.after = interceptor
+	encoders := make([]registeredEncoder, len(cloned.encoders), len(cloned.encoders)+1)
+	copy(encoders, cloned.encoders)
+	cloned.encoders = append(encoders, registeredEncoder{mediaType: mediaType, encoder: encoder})
 	return cloned
 }
 
+func (b *builder) encoderMediaTypes() []string {
+	mediaTypes := make([]string, 0, len(b.encoders))
+	for _, registered := range b.encoders {
+		mediaTypes = append(mediaTypes, registered.mediaType)
+	}
+	return mediaTypes
+}
+
 func (b builder) ErrorMapping(errorMapper ErrorMapper) Builder {
 	cloned := b.clone()
 	cloned.errorMapper = errorMapper
 	return cloned
 }
 
+// RequestErrorMapping sets mapper of RequestError returned when the request can't be bound to handler parameters.
+func (b builder) RequestErrorMapping(errorMapper ErrorMapper) Builder {
+	cloned := b.clone()
+	cloned.requestErrorMapper = errorMapper
+	return cloned
+}
+
+// WhenQuery restricts endpoint to requests with URL query parameter (and one of values if provided).
+func (b builder) WhenQuery(name string, values ...string) Builder {
+	cloned := b.clone()
+	if cloned.queryConditions == nil {
+		cloned.queryConditions = url.Values{}
+	}
+	cloned.queryConditions[name] = append(cloned.queryConditions[name], values...)
+	return cloned
+}
+
+func (b *builder) buildMatchesQuery() func(queryValues url.Values) bool {
+	queryConditions := b.queryConditions
+	if len(queryConditions) == 0 {
+		return func(queryValues url.Values) bool { return true }
+	}
+
+	return func(queryValues url.Values) bool {
+		for name, expected := range queryConditions {
+			received, found := queryValues[name]
+			if !found {
+				return false
+			}
+			if len(expected) == 0 {
+				continue
+			}
+			if !containsAny(expected, received) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func containsAny(expected, received []string) bool {
+	for _, r := range received {
+		for _, e := range expected {
+			if r == e {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Name sets name of the route reported in RouteInfo.
+func (b builder) Name(name string) Builder {
+	cloned := b.clone()
+	cloned.name = name
+	return cloned
+}
+
+// Debug includes stack trace into PanicError produced on recovery of the handler panic.
+func (b builder) Debug(enabled bool) Builder {
+	cloned := b.clone()
+	cloned.debug = enabled
+	return cloned
+}
+
+// UnreadBody sets policy for the request body left unread when the response is produced.
+func (b builder) UnreadBody(policy UnreadBodyPolicy) Builder {
+	cloned := b.clone()
+	cloned.unreadBodyPolicy = policy
+	return cloned
+}
+
+// MaxBodyBytes limits size of the request body, requests with bigger body are rejected with 413 Payload Too Large.
+// Zero or negative n disables the limit, including the default one of the router.
+func (b builder) MaxBodyBytes(n int64) Builder {
+	cloned := b.clone()
+	cloned.maxBodyBytes = &n
+	return cloned
+}
+
+// BufferResponse keeps response with body up to the limit of bytes in memory and sends it with Content-Length.
+// Buffered response is discarded if encoding fails, so the error is mapped instead of the partially written response.
+// Responses exceeding the limit are streamed. Zero limit disables buffering.
+func (b builder) BufferResponse(limit int) Builder {
+	cloned := b.clone()
+	cloned.bufferLimit = limit
+	return cloned
+}
+
+// TimeLayout sets layout of time.Time path parameters and bound struct fields without own layout tag.
+func (b builder) TimeLayout(layout string) Builder {
+	cloned := b.clone()
+	cloned.timeLayout = layout
+	return cloned
+}
+
+// ByteEncoding sets encoding of byte slice and array path parameters, e.g. of hashes in hexadecimal form.
+// Bound struct fields are decoded with ByteEncoding of their layout tag.
+func (b builder) ByteEncoding(encoding ByteEncoding) Builder {
+	cloned := b.clone()
+	cloned.byteEncoding = encoding
+	return cloned
+}
+
+// ResponseHeader adds static header sent with every response of the endpoint including error ones.
+func (b builder) ResponseHeader(name string, values ...string) Builder {
+	return b.ResponseHeaders(http.Header{name: values})
+}
+
+// ResponseHeaders adds static headers sent with every response of the endpoint including error ones.
+// Headers returned by the handler replace static ones with the same name, see mergeHeader for precedence.
+func (b builder) ResponseHeaders(headers http.Header) Builder {
+	cloned := b.clone()
+	if cloned.responseHeaders == nil {
+		cloned.responseHeaders = http.Header{}
+	}
+	for name, values := range headers {
+		for _, value := range values {
+			cloned.responseHeaders.Add(name, value)
+		}
+	}
+	return cloned
+}
+
+type responseExample struct {
+	statusCode int
+	value      interface{}
+}
+
+// RequestExample attaches example of the request body used by OpenAPI document and ReplayExamples.
+func (b builder) RequestExample(example interface{}) Builder {
+	cloned := b.clone()
+	cloned.requestExample = example
+	return cloned
+}
+
+// ResponseExample attaches example of the response body for the status code used by OpenAPI document, Mock
+// and ReplayExamples.
+func (b builder) ResponseExample(statusCode int, example interface{}) Builder {
+	cloned := b.clone()
+	cloned.responseExamples = append(cloned.responseExamples, responseExample{statusCode: statusCode, value: example})
+	return cloned
+}
+
+// Tag labels the route with the key and value reported in RouteInfo.
+func (b builder) Tag(key, value string) Builder {
+	cloned := b.clone()
+	route := cloned.routeInfo()
+	tags := route.Tags()
+	tags[key] = value
+	cloned.tags = &routeTags{values: tags}
+	return cloned
+}
+
+func (b *builder) routeInfo() RouteInfo {
+	return RouteInfo{Method: b.method, Template: b.pathTemplate, Name: b.name, tags: b.tags}
+}
+
+func (b *builder) buildMetadata() RouteMetadata {
+	metadata := RouteMetadata{Method: b.method}
+	for mediaType := range b.decoders {
+		metadata.Consumes = append(metadata.Consumes, mediaType)
+	}
+	sort.Strings(metadata.Consumes)
+	if b.decoder != nil {
+		metadata.Consumes = append(metadata.Consumes, openAPIMediaType(b.decoder.MediaType()))
+	}
+	metadata.Produces = b.encoderMediaTypes()
+	switch {
+	case b.contentTypeProvider != nil:
+		metadata.Produces = append(metadata.Produces, openAPIMediaType(b.contentTypeProvider()))
+	case b.encoder != nil:
+		metadata.Produces = append(metadata.Produces, openAPIMediaType(b.encoder.MediaType()))
+	}
+	for _, required := range b.requiredHeaders {
+		metadata.RequiredHeaders = append(metadata.RequiredHeaders, required.name)
+	}
+	return metadata
+}
+
+type requiredHeader struct {
+	name       string
+	statusCode int
+}
+
+// RequireHeader rejects requests without the header with 400 Bad Request or provided status code.
+func (b builder) RequireHeader(name string, statusCode ...int) Builder {
+	cloned := b.clone()
+	required := requiredHeader{name: http.CanonicalHeaderKey(name), statusCode: http.StatusBadRequest}
+	if len(statusCode) > 0 {
+		required.statusCode = statusCode[0]
+	}
+	cloned.requiredHeaders = append(cloned.requiredHeaders, required)
+	return cloned
+}
+
+// MissingHeaderError is a cause of RequestError returned for requests without the header required by RequireHeader.
+type MissingHeaderError struct {
+	Header string
+	Status int
+}
+
+func (e MissingHeaderError) Error() string {
+	return "missing required header: " + e.Header
+}
+
+func (e MissingHeaderError) StatusCode() int {
+	return e.Status
+}
+
+func checkRequiredHeaders(requiredHeaders []requiredHeader, r *http.Request) error {
+	for _, required := range requiredHeaders {
+		if len(r.Header[required.name]) == 0 {
+			return RequestError{Cause: MissingHeaderError{Header: required.name, Status: required.statusCode}}
+		}
+	}
+	return nil
+}
+
+// Build validates the handler against the route and returns endpoint which is immutable and safe for concurrent use.
+// State of the builder is cloned, so neither the builder nor derived builders affect the endpoint.
 func (b builder) Build() EndpointProcessor {
+	b = b.clone()
 	b.groupParameters(b.serviceValue.Type())
 	b.defineProviders()
+	constraints := b.resolveConstraints()
+	admit := b.buildAdmit()
+	limit := b.buildRateLimit()
+	interceptors := b.buildInterceptors()
+	afterInterceptors := b.buildAfterInterceptors()
+	preconditions := b.buildEchoSchema()
+	bufferLimit := b.bufferLimit
+	if b.streamsBody() {
+		if b.encoder == nil && len(b.encoders) == 0 {
+			b.addError(InvalidMappingError(errors.New("streamed response requires encoder")))
+		}
+		encoders := b.encoders
+		if b.encoder != nil {
+			encoders = append([]registeredEncoder{{mediaType: b.encoder.MediaType(), encoder: b.encoder}}, encoders...)
+		}
+		for _, registered := range encoders {
+			if !streamsItems(registered.encoder) {
+				b.addError(InvalidMappingError(fmt.Errorf("encoder of %q doesn't delimit streamed items", registered.mediaType)))
+			}
+		}
+		// items are flushed as they are produced
+		bufferLimit = 0
+	}
+	if bodyTypes, hasBody := b.hasParametersIn(responseBodyParametersGroup); hasBody && !b.streamsBody() &&
+		b.encoder == nil && len(b.encoders) == 0 && !isWritable(bodyTypes[0]) {
+		b.addError(InvalidMappingError(fmt.Errorf("response body of type %s requires encoder", bodyTypes[0])))
+	}
 	if len(b.errors) > 0 {
 		return EndpointProcessor{
+			route:          b.routeInfo(),
 			errors:         b.errors,
-			processRequest: func(r *http.Request) ([]reflect.Value, error) { return nil, nil },
+			bindParameters: func(w http.ResponseWriter, r *http.Request) ([]reflect.Value, error) { return nil, nil },
 			produceResponse: func(executionResult []reflect.Value, executionError error, w http.ResponseWriter, r *http.Request) error {
 				return nil
 			},
 		}
 	}
 	return EndpointProcessor{
-		processRequest:  b.buildProcessRequest(),
+		route:           b.routeInfo(),
+		metadata:        b.buildMetadata(),
+		issues:          b.buildIssues(),
+		queryConditions: b.queryConditions,
+		matchesQuery:    b.buildMatchesQuery(),
+		constraints:     constraints,
+		preconditions:   preconditions,
+		requiredHeaders: b.requiredHeaders,
+		writerInjected:  len(b.parametersBy[responseWriterParametersGroup]) > 0,
+		streams:         b.streamsBody(),
+		unreadBody:      b.unreadBodyPolicy,
+		bufferLimit:     bufferLimit,
+		limits:          b.requestLimits,
+		maxBodyBytes:    b.buildMaxBodyBytes(),
+		maxResponse:     b.buildMaxResponse(),
+		cache:           b.buildCachePolicy(),
+		invalidations:   b.invalidations,
+		propagated:      b.propagatedHeaders,
+		encoderFallback: len(b.fallbackEncoders) > 0,
+		tempDirs:        b.tempDirs,
+		pool:            b.pool,
+		retryHints:      b.retryHints,
+		middleware:      b.buildMiddleware(),
+		interceptors:    interceptors,
+		after:           afterInterceptors,
+		scoped:          b.scoped(),
+		pipeline:        b.buildPipeline(),
+		handler:         b.serviceValue,
+		positionalPath:  b.parametersBy[pathParametersGroup],
+		noAccessLog:     b.noAccessLog,
+		limit:           limit,
+		timeout:         b.timeout,
+		clock:           SystemClock,
+		headers:         b.responseHeaders,
+		errorMapper:     b.buildErrorMapper(),
+		requestMapper:   b.buildRequestErrorMapper(),
+		debug:           b.debug,
+		bindParameters:  b.buildBindParameters(),
+		admit:           admit,
+		invoke:          b.buildInvoke(),
+		resultError:     b.buildResultError(),
 		produceResponse: b.buildProduceResponse(),
 	}
 }
 
-func (b *builder) buildProcessRequest() func(r *http.Request) ([]reflect.Value, error) {
-	var valueCollectors []func(r *http.Request) ([]reflect.Value, error)
+func (b *builder) buildBindParameters() func(w http.ResponseWriter, r *http.Request) ([]reflect.Value, error) {
+	var valueCollectors []func(w http.ResponseWriter, r *http.Request) ([]reflect.Value, error)
 
-	if b.pathParameters != nil {
-		valueCollectors = append(valueCollectors, func(r *http.Request) ([]reflect.Value, error) {
-			return b.pathParameters(b.pathValues(r.URL.Path))
+	if pathParameters, pathValues := b.pathParameters, b.pathValues; pathParameters != nil {
+		valueCollectors = append(valueCollectors, func(w http.ResponseWriter, r *http.Request) ([]reflect.Value, error) {
+			values, err := pathParameters(pathValues(r.URL.EscapedPath()))
+			return values, requestError(err)
 		})
 	}
 
+	validate := b.buildValidate()
+	// late is index of the collector which must be called after the others, e.g. the one of trailers read after the body
+	late := -1
+	contextParameters := 0
 	for _, group := range b.orderOfOtherParameters {
 		switch group {
+		case contextParametersGroup:
+			valueCollectors = append(valueCollectors, b.buildContextValueCollector(b.parametersBy[group][contextParameters]))
+			contextParameters++
 		case headerParametersGroup:
-			valueCollectors = append(valueCollectors, func(r *http.Request) ([]reflect.Value, error) {
-				value, err := b.headerParameters(r.Header)
+			headerParameters := b.headerParameters
+			valueCollectors = append(valueCollectors, func(w http.ResponseWriter, r *http.Request) ([]reflect.Value, error) {
+				value, err := headerParameters(r.Header)
 				return []reflect.Value{value}, err
 			})
 
 		case queryParametersGroup:
-			valueCollectors = append(valueCollectors, func(r *http.Request) ([]reflect.Value, error) {
-				value, err := b.queryParameters(r.URL.Query())
+			queryParameters := b.queryParameters
+			valueCollectors = append(valueCollectors, func(w http.ResponseWriter, r *http.Request) ([]reflect.Value, error) {
+				value, err := queryParameters(r.URL.Query())
 				return []reflect.Value{value}, err
 			})
 
 		case cookieParametersGroup:
-			valueCollectors = append(valueCollectors, func(r *http.Request) ([]reflect.Value, error) {
-				value, err := b.cookieParameters(r.Cookies())
+			cookieParameters := b.cookieParameters
+			valueCollectors = append(valueCollectors, func(w http.ResponseWriter, r *http.Request) ([]reflect.Value, error) {
+				value, err := cookieParameters(r.Cookies())
 				return []reflect.Value{value}, err
 			})
+		case requestParametersGroup:
+			valueCollectors = append(valueCollectors, func(w http.ResponseWriter, r *http.Request) ([]reflect.Value, error) {
+				return []reflect.Value{reflect.ValueOf(r)}, nil
+			})
+		case responseWriterParametersGroup:
+			valueCollectors = append(valueCollectors, func(w http.ResponseWriter, r *http.Request) ([]reflect.Value, error) {
+				return []reflect.Value{reflect.ValueOf(&w).Elem()}, nil
+			})
+		case routeInfoParametersGroup:
+			routeInfo := reflect.ValueOf(b.routeInfo())
+			valueCollectors = append(valueCollectors, func(w http.ResponseWriter, r *http.Request) ([]reflect.Value, error) {
+				return []reflect.Value{routeInfo}, nil
+			})
+		case structParametersGroup:
+			if b.trailersBound {
+				late = len(valueCollectors)
+			}
+			structParameters := b.structParameters
+			valueCollectors = append(valueCollectors, func(w http.ResponseWriter, r *http.Request) ([]reflect.Value, error) {
+				value, err := structParameters(r)
+				if err == nil {
+					err = validate(value)
+				}
+				return []reflect.Value{value}, requestError(err)
+			})
 		case bodyParametersGroup:
-			valueCollectors = append(valueCollectors, func(r *http.Request) ([]reflect.Value, error) {
-				value, err := b.bodyParameters(r.Body)
-				return []reflect.Value{value}, err
+			bodyParameters := b.bodyParameters
+			validated := b.parametersBy[group][0] != readSeekerType
+			valueCollectors = append(valueCollectors, func(w http.ResponseWriter, r *http.Request) ([]reflect.Value, error) {
+				value, err := bodyParameters(r)
+				if err == nil && validated {
+					err = validate(value)
+				}
+				return []reflect.Value{value}, requestError(err)
 			})
 		}
 	}
 
-	return func(r *http.Request) ([]reflect.Value, error) {
-		serviceValue := b.serviceValue
-		var invokeValues []reflect.Value
-		for _, valueCollector := range valueCollectors {
-			values, err := valueCollector(r)
+	if late < 0 {
+		return func(w http.ResponseWriter, r *http.Request) ([]reflect.Value, error) {
+			var invokeValues []reflect.Value
+			for _, valueCollector := range valueCollectors {
+				values, err := valueCollector(w, r)
+				if err != nil {
+					return nil, err
+				}
+				invokeValues = append(invokeValues, values...)
+			}
+			return invokeValues, nil
+		}
+	}
+
+	order := make([]int, 0, len(valueCollectors))
+	for i := range valueCollectors {
+		if i != late {
+			order = append(order, i)
+		}
+	}
+	order = append(order, late)
+	return func(w http.ResponseWriter, r *http.Request) ([]reflect.Value, error) {
+		collected := make([][]reflect.Value, len(valueCollectors))
+		for _, i := range order {
+			values, err := valueCollectors[i](w, r)
 			if err != nil {
 				return nil, err
 			}
+			collected[i] = values
+		}
+		var invokeValues []reflect.Value
+		for _, values := range collected {
 			invokeValues = append(invokeValues, values...)
 		}
-		return serviceValue.Call(invokeValues), nil
+		return invokeValues, nil
+	}
+}
+
+func (b *builder) resultIndex(group int) int {
+	for index, resultGroup := range b.orderOfResponseParameters {
+		if resultGroup == group {
+			return index
+		}
+	}
+	return -1
+}
+
+func (b *builder) buildResultError() func(results []reflect.Value) error {
+	errorReturnValueIndex := b.resultIndex(responseErrorParametersGroup)
+	if errorReturnValueIndex == -1 {
+		return func(results []reflect.Value) error { return nil }
+	}
+	return func(results []reflect.Value) error {
+		err, _ := results[errorReturnValueIndex].Interface().(error)
+		return err
 	}
 }
 
+// Phases of producing the response run in declared order regardless of the order of results of the handler,
+// so the content type, headers and cookies are set before the status code is written and the body is written last.
+// The status code is sent with the first write of the body, so encoders falling back to another media type could
+// still replace Content-Type.
+const (
+	contentTypePhase = iota
+	headerPhase
+	cookiePhase
+	statusPhase
+	bodyPhase
+	responsePhases
+)
+
+// responseResolver writes the part of the response produced in its phase from results of the handler.
+type responseResolver func(results []reflect.Value, w http.ResponseWriter, r *http.Request, rep representation) error
+
 func (b *builder) buildProduceResponse() func(executionResult []reflect.Value, executionError error, w http.ResponseWriter, r *http.Request) error {
-	responseResolvers := map[int]func(results []reflect.Value, w http.ResponseWriter) error{
-		responseStatusCodeParametersGroup: func(results []reflect.Value, w http.ResponseWriter) error {
-			w.WriteHeader(http.StatusOK)
-			return nil
-		},
+	var resolvers [responsePhases]responseResolver
+	resolvers[statusPhase] = func(results []reflect.Value, w http.ResponseWriter, r *http.Request, rep representation) error {
+		w.WriteHeader(http.StatusOK)
+		return nil
 	}
 	errorReturnValueIndex := -1
 
@@ -683,30 +1335,25 @@ func (b *builder) buildProduceResponse() func(executionResult []reflect.Value, e
 		switch group {
 		case responseHeaderParametersGroup:
 			index := index
-			responseResolvers[group] = func(results []reflect.Value, w http.ResponseWriter) error {
-				headers := b.responseHeaderParameters(results[index])
-				for header, values := range headers {
-					if len(values) > 0 {
-						w.Header().Set(header, values[0])
-					}
-					for _, value := range values {
-						w.Header().Add(header, value[1:])
-					}
-				}
+			responseHeaderParameters := b.responseHeaderParameters
+			resolvers[headerPhase] = func(results []reflect.Value, w http.ResponseWriter, r *http.Request, rep representation) error {
+				mergeHeader(w.Header(), responseHeaderParameters(results[index]))
 				return nil
 			}
 
 		case responseStatusCodeParametersGroup:
 			index := index
-			responseResolvers[group] = func(results []reflect.Value, w http.ResponseWriter) error {
-				w.WriteHeader(b.responseStatusCodeParameters(results[index]))
+			responseStatusCodeParameters := b.responseStatusCodeParameters
+			resolvers[statusPhase] = func(results []reflect.Value, w http.ResponseWriter, r *http.Request, rep representation) error {
+				w.WriteHeader(responseStatusCodeParameters(results[index]))
 				return nil
 			}
 
 		case responseCookieParametersGroup:
 			index := index
-			responseResolvers[group] = func(results []reflect.Value, w http.ResponseWriter) error {
-				for _, cookieValue := range b.responseCookieParameters(results[index]) {
+			responseCookieParameters := b.responseCookieParameters
+			resolvers[cookiePhase] = func(results []reflect.Value, w http.ResponseWriter, r *http.Request, rep representation) error {
+				for _, cookieValue := range responseCookieParameters(results[index]) {
 					http.SetCookie(w, cookieValue)
 				}
 				return nil
@@ -714,31 +1361,51 @@ func (b *builder) buildProduceResponse() func(executionResult []reflect.Value, e
 
 		case responseBodyParametersGroup:
 			index := index
-			if b.encoder != nil {
-				responseResolvers[group] = func(results []reflect.Value, w http.ResponseWriter) error {
+			if b.parametersBy[group][0] == multipartType {
+				resolvers[bodyPhase] = func(results []reflect.Value, w http.ResponseWriter, r *http.Request, rep representation) error {
+					return writeMultipart(w, results[index].Interface().(MultipartResponse))
+				}
+				break
+			}
+			if (b.encoder != nil || len(b.encoders) > 0) && b.streamsBody() {
+				resolvers[bodyPhase] = func(results []reflect.Value, w http.ResponseWriter, r *http.Request, rep representation) error {
+					return encodeItems(r.Context(), rep.encoder, w, results[index])
+				}
+				break
+			}
+			if b.encoder != nil || len(b.encoders) > 0 {
+				resolvers[bodyPhase] = func(results []reflect.Value, w http.ResponseWriter, r *http.Request, rep representation) error {
 					responseEntity := results[index]
 					if responseEntity.Kind() == reflect.Ptr && responseEntity.IsNil() {
 						return nil
 					}
-					return b.encoder(w)(responseEntity.Interface())
+					return encode(rep.encoder, w, responseEntity.Interface())
 				}
 				break
 			}
 
 			returnParameterType := b.parametersBy[group][0]
+			if returnParameterType.Implements(readerType) {
+				resolvers[bodyPhase] = func(results []reflect.Value, w http.ResponseWriter, r *http.Request, rep representation) error {
+					return writeReader(w, results[index])
+				}
+				break
+			}
 			switch returnParameterType.Kind() {
 			case reflect.String:
-				responseResolvers[group] = func(results []reflect.Value, w http.ResponseWriter) error {
-					return b.encoder(w)(strings.NewReader(results[index].String()))
+				resolvers[bodyPhase] = func(results []reflect.Value, w http.ResponseWriter, r *http.Request, rep representation) error {
+					_, err := io.WriteString(w, results[index].String())
+					return err
 				}
 
 			case reflect.Slice:
-				responseResolvers[group] = func(results []reflect.Value, w http.ResponseWriter) error {
-					return b.encoder(w)(bytes.NewReader(results[index].Interface().([]byte)))
+				resolvers[bodyPhase] = func(results []reflect.Value, w http.ResponseWriter, r *http.Request, rep representation) error {
+					_, err := w.Write(results[index].Bytes())
+					return err
 				}
 
 			case reflect.Array:
-				responseResolvers[group] = func(results []reflect.Value, w http.ResponseWriter) error {
+				resolvers[bodyPhase] = func(results []reflect.Value, w http.ResponseWriter, r *http.Request, rep representation) error {
 					responseEntityValue := results[index]
 					length := responseEntityValue.Len()
 					asSlice := make([]byte, length)
@@ -755,44 +1422,128 @@ func (b *builder) buildProduceResponse() func(executionResult []reflect.Value, e
 		}
 	}
 
-	if b.contentTypeProvider != nil {
-		responseResolvers[responseContentTypeParametersGroup] = func(results []reflect.Value, w http.ResponseWriter) error {
-			w.Header().Set("Content-Type", b.contentTypeProvider())
-			return nil
+	if bodyResolver := resolvers[bodyPhase]; bodyResolver != nil {
+		if statusIndex := b.resultIndex(responseStatusCodeParametersGroup); statusIndex >= 0 {
+			resolvers[bodyPhase] = func(results []reflect.Value, w http.ResponseWriter, r *http.Request, rep representation) error {
+				if !bodyAllowed(int(results[statusIndex].Int())) {
+					return nil
+				}
+				return bodyResolver(results, w, r, rep)
+			}
 		}
 	}
 
-	var parametersGroup []int
-	for _, group := range [5]int{
-		responseContentTypeParametersGroup,
-		responseHeaderParametersGroup,
-		responseCookieParametersGroup,
-		responseStatusCodeParametersGroup,
-		responseBodyParametersGroup,
-	} {
-		if _, found := responseResolvers[group]; found {
-			parametersGroup = append(parametersGroup, group)
+	_, hasBody := b.hasParametersIn(responseBodyParametersGroup)
+	negotiate := b.buildNegotiation()
+	acceptableMediaTypes := strings.Join(b.encoderMediaTypes(), ", ")
+	switch {
+	case hasBody && b.parametersBy[responseBodyParametersGroup][0] == multipartType:
+		// the boundary is chosen before headers are sent, so the body is written with the announced one
+		bodyIndex := b.resultIndex(responseBodyParametersGroup)
+		resolvers[contentTypePhase] = func(results []reflect.Value, w http.ResponseWriter, r *http.Request, rep representation) error {
+			response := results[bodyIndex].Interface().(MultipartResponse).withBoundary()
+			results[bodyIndex] = reflect.ValueOf(response)
+			w.Header().Set("Content-Type", response.mediaType())
+			return nil
+		}
+	case hasBody && len(b.encoders) > 0:
+		resolvers[contentTypePhase] = func(results []reflect.Value, w http.ResponseWriter, r *http.Request, rep representation) error {
+			w.Header().Add("Vary", "Accept")
+			if rep.contentType != "" {
+				w.Header().Set("Content-Type", rep.contentType)
+			}
+			return nil
+		}
+	case b.contentTypeProvider != nil || hasBody && b.encoder != nil && b.encoder.MediaType() != "":
+		resolvers[contentTypePhase] = func(results []reflect.Value, w http.ResponseWriter, r *http.Request, rep representation) error {
+			w.Header().Set("Content-Type", rep.contentType)
+			return nil
+		}
+	case hasBody && b.encoder == nil && isSniffable(b.parametersBy[responseBodyParametersGroup][0]):
+		// content type of returned headers overrides the detected one as they are resolved later
+		bodyIndex := b.resultIndex(responseBodyParametersGroup)
+		resolvers[contentTypePhase] = func(results []reflect.Value, w http.ResponseWriter, r *http.Request, rep representation) error {
+			if contentType := sniffContentType(results, bodyIndex); contentType != "" {
+				w.Header().Set("Content-Type", contentType)
+			}
+			return nil
 		}
 	}
 
+	conditional := b.buildConditional()
+	paginate := b.buildPagination()
+	fields := b.buildFields()
+	var fallbackEncoders []Encoder
+	if !b.streamsBody() {
+		fallbackEncoders = b.fallbackEncoders
+	}
 	defaultResponseProcessor := func(executionResult []reflect.Value, executionError error, w http.ResponseWriter, r *http.Request) error {
-		for _, group := range parametersGroup {
-			if err := responseResolvers[group](executionResult, w); err != nil {
+		rep, acceptable := negotiate(r.Header.Get("Accept"))
+		if !acceptable && hasBody {
+			http.Error(w, "acceptable media types: "+acceptableMediaTypes, http.StatusNotAcceptable)
+			return nil
+		}
+		if paginate != nil {
+			executionResult = paginate(executionResult, w, r)
+		}
+		if fields != nil {
+			executionResult = fields(executionResult, r)
+		}
+		if len(fallbackEncoders) > 0 && rep.encoder != nil {
+			report, _ := r.Context().Value(encoderFallbackKey{}).(fallbackReporter)
+			rep.encoder = fallbackEncoder{encoders: append([]Encoder{rep.encoder}, fallbackEncoders...), report: report}
+		}
+		var finish func() error
+		for phase, resolve := range resolvers {
+			if phase == statusPhase && conditional != nil {
+				var finished bool
+				if w, finish, finished = conditional(executionResult, w, r); finished {
+					return nil
+				}
+			}
+			if resolve == nil {
+				continue
+			}
+			if err := resolve(executionResult, w, r, rep); err != nil {
 				return err
 			}
 		}
+		if finish != nil {
+			return finish()
+		}
 		return nil
 	}
 
 	if errorReturnValueIndex == -1 {
 		return defaultResponseProcessor
 	} else {
+		responseErrorParameters := b.responseErrorParameters
 		return func(executionResult []reflect.Value, executionError error, w http.ResponseWriter, r *http.Request) error {
 			errorReturn := executionResult[errorReturnValueIndex].Interface()
 			if errorReturn == nil {
 				return defaultResponseProcessor(executionResult, executionError, w, r)
 			}
-			return b.responseErrorParameters(errorReturn.(error), w, r)
+			return responseErrorParameters(errorReturn.(error), w, r)
+		}
+	}
+}
+
+// additiveHeaders are list headers to which the handler adds values instead of replacing ones set before.
+var additiveHeaders = map[string]bool{"Vary": true, "Link": true, "Set-Cookie": true}
+
+// mergeHeader writes headers returned by the handler. They take precedence over static headers, headers set by
+// middleware and interceptors and Content-Type of the encoder or detected from the body, so all their values
+// replace values of the same name, except additive headers, and empty values remove the header.
+func mergeHeader(header, returned http.Header) {
+	for name, values := range returned {
+		name = http.CanonicalHeaderKey(name)
+		switch {
+		case additiveHeaders[name]:
+			header[name] = append(header[name], values...)
+		case len(values) == 0:
+			delete(header, name)
+		default:
+			header[name] = append([]string(nil), values...)
 		}
 	}
 }
@@ -2,18 +2,28 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"encoding/xml"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"mime"
+	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
 	"net/textproto"
 	"net/url"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 type Filter string
@@ -300,20 +310,2010 @@ func newRequest(t *testing.T, httpMethod, urlString string, body io.Reader) *htt
 	return r
 }
 
-func TestPathValueSegmentOffsets(t *testing.T) {
+func TestPathValuesBySegments(t *testing.T) {
 	for index, toCheck := range []struct {
-		uri      string
-		expected []int
+		template string
+		path     string
+		expected []string
 	}{
-		{uri: "/abc/def", expected: nil},
-		{uri: "/:bcd", expected: []int{1}},
-		{uri: "/a/:bcd", expected: []int{3}},
-		{uri: "/a/:bcd/ef/:", expected: []int{3, 4}},
-		{uri: "/a/:bcd/:/ef", expected: []int{3, 1}},
+		{template: "/abc/def", path: "/abc/def", expected: []string{}},
+		{template: "/:bcd", path: "/1", expected: []string{"1"}},
+		{template: "/a/:bcd", path: "/a/x%2Fy", expected: []string{"x%2Fy"}},
+		{template: "/a/:bcd/ef/:", path: "/a/1/ef/2", expected: []string{"1", "2"}},
+		{template: "/a/:bcd/:/ef", path: "/a/1/2/ef", expected: []string{"1", "2"}},
+		{template: "/a/:bcd/:/ef", path: "/a/1", expected: []string{"1"}},
 	} {
-		offsets := pathValueSegmentOffsets(toCheck.uri)
-		if !reflect.DeepEqual(offsets, toCheck.expected) {
-			t.Error("index:", index, "unexpected:", offsets, "expects:", toCheck.expected)
+		values := pathValuesBySegments(pathParameterSegments(toCheck.template))(toCheck.path)
+		if !reflect.DeepEqual(values, toCheck.expected) {
+			t.Error("index:", index, "unexpected:", values, "expects:", toCheck.expected)
+		}
+	}
+}
+
+type RequestMeta struct {
+	RequestID string   `header:"X-Request-ID"`
+	Retries   uint8    `header:"x-retries"`
+	Tags      []string `header:"X-Tag"`
+	Page      int      `query:"page"`
+	Ignored   string
+}
+
+func TestHeaderStructParameter(t *testing.T) {
+	by := GET("/:id").Handler(func(id string, meta RequestMeta) int {
+		if meta.RequestID != "r-1" || meta.Retries != 3 || meta.Page != 2 {
+			t.Errorf("received: %#v", meta)
+		}
+		if len(meta.Tags) != 2 || meta.Tags[0] != "a" || meta.Tags[1] != "b" {
+			t.Errorf("received: %#v", meta.Tags)
+		}
+		return http.StatusNoContent
+	})
+
+	r := newGET(t, "http://localhost/1?page=2")
+	r.Header.Set("X-Request-ID", "r-1")
+	r.Header.Set("X-Retries", "3")
+	r.Header.Add("X-Tag", "a")
+	r.Header.Add("X-Tag", "b")
+	w := &httptest.ResponseRecorder{}
+
+	err := by.Build().Handle(w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusNoContent {
+		t.Error("unexpected response code", w.Code)
+	}
+
+	r = newGET(t, "http://localhost/1")
+	r.Header.Set("X-Retries", "many")
+	w = httptest.NewRecorder()
+	if err = by.Build().Handle(w, r); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusBadRequest {
+		t.Error("unexpected response code", w.Code)
+	}
+}
+
+func TestRequireHeader(t *testing.T) {
+	var stages []string
+	router := NewRouter().Hooks(Hooks{OnError: func(event ErrorEvent) { stages = append(stages, event.Stage) }})
+	err := router.Register(GET("/").
+		RequireHeader("x-tenant-id").
+		RequireHeader("X-Api-Version", http.StatusPreconditionFailed).
+		RequestErrorMapping(ProblemErrorMapper).
+		Handler(func() int { return http.StatusNoContent }))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, toCheck := range []struct {
+		headers  map[string]string
+		expected int
+	}{
+		{headers: map[string]string{"X-Tenant-Id": "t1", "X-Api-Version": "2"}, expected: http.StatusNoContent},
+		{headers: map[string]string{"X-Api-Version": "2"}, expected: http.StatusBadRequest},
+		{headers: map[string]string{"X-Tenant-Id": "t1"}, expected: http.StatusPreconditionFailed},
+	} {
+		r := newGET(t, "http://localhost/")
+		for name, value := range toCheck.headers {
+			r.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != toCheck.expected {
+			t.Error(toCheck.headers, "unexpected response code", w.Code)
+		}
+		if w.Code != http.StatusNoContent && w.Header().Get("Content-Type") != problemMediaType {
+			t.Error(toCheck.headers, "missing header is not mapped by request error mapper", w.Header())
+		}
+	}
+	if !reflect.DeepEqual(stages, []string{BindStage, BindStage}) {
+		t.Error("unexpected error stages", stages)
+	}
+}
+
+func TestDecoderFor(t *testing.T) {
+	by := POST("/").
+		DecoderFor("application/json", JSONDecoder).
+		DecoderFor("application/xml", XMLDecoder).
+		Handler(func(key Key) int {
+			if key.Value != "k" || key.Part != 1 {
+				t.Errorf("received: %#v", key)
+			}
+			return http.StatusCreated
+		})
+
+	for _, toCheck := range []struct {
+		contentType string
+		body        string
+		expected    int
+	}{
+		{contentType: "application/json; charset=utf-8", body: `{"Value": "k", "Part": 1}`, expected: http.StatusCreated},
+		{contentType: "application/xml", body: `<Key><value>k</value><position>1</position></Key>`, expected: http.StatusCreated},
+		{contentType: "text/plain", body: `k`, expected: http.StatusUnsupportedMediaType},
+	} {
+		r := newPOST(t, "http://localhost", strings.NewReader(toCheck.body))
+		r.Header.Set("Content-Type", toCheck.contentType)
+		w := httptest.NewRecorder()
+		if err := by.Build().Handle(w, r); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != toCheck.expected {
+			t.Error(toCheck.contentType, "unexpected response code", w.Code)
+		}
+	}
+}
+
+func TestRouteInfoParameter(t *testing.T) {
+	expected := RouteInfo{Method: http.MethodGet, Template: "/users/:id", Name: "get-user"}
+	by := GET("/users/:id").Name("get-user").Handler(func(id string, route RouteInfo) int {
+		if route != expected {
+			t.Errorf("received: %#v", route)
+		}
+		return http.StatusNoContent
+	})
+
+	endpoint := by.Build()
+	if endpoint.RouteInfo() != expected {
+		t.Errorf("received: %#v", endpoint.RouteInfo())
+	}
+	endpoint.preconditions = append(endpoint.preconditions, func(w http.ResponseWriter, r *http.Request) bool {
+		route, found := RouteInfoFromContext(r.Context())
+		if !found || route != expected {
+			t.Errorf("received: %#v", route)
+		}
+		return true
+	})
+
+	w := httptest.NewRecorder()
+	if err := endpoint.Handle(w, newGET(t, "http://localhost/users/1")); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusNoContent {
+		t.Error("unexpected response code", w.Code)
+	}
+}
+
+func TestPanicRecovery(t *testing.T) {
+	var mapped error
+	by := GET("/").Handler(func() { panic("boom") }).ErrorMapping(func(err error, w http.ResponseWriter, r *http.Request) error {
+		mapped = err
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return nil
+	})
+
+	w := httptest.NewRecorder()
+	if err := by.Build().Handle(w, newGET(t, "http://localhost")); err != nil {
+		t.Fatal(err)
+	}
+	var panicErr PanicError
+	if !errors.As(mapped, &panicErr) || panicErr.Value != "boom" || panicErr.Stack != nil {
+		t.Errorf("received: %#v", mapped)
+	}
+	if w.Code != http.StatusServiceUnavailable {
+		t.Error("unexpected response code", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	if err := by.Debug(true).ErrorMapping(DefaultErrorMapper).Build().Handle(w, newGET(t, "http://localhost")); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "goroutine") {
+		t.Error("unexpected response", w.Code, w.Body.String())
+	}
+}
+
+type Paging struct {
+	Page  int `query:"page"`
+	Limit int `query:"limit"`
+}
+
+type CommonParams struct {
+	TraceID string `header:"X-Trace-Id"`
+	Locale  string `header:"Accept-Language"`
+	*Paging
+}
+
+type SearchParams struct {
+	CommonParams
+	Term string `query:"q"`
+}
+
+func TestEmbeddedStructParameter(t *testing.T) {
+	by := GET("/search").Handler(func(params SearchParams) int {
+		if params.TraceID != "t-1" || params.Locale != "en" || params.Term != "feel" {
+			t.Errorf("received: %#v", params)
+		}
+		if params.Paging == nil || params.Page != 3 || params.Limit != 10 {
+			t.Errorf("received: %#v", params.Paging)
+		}
+		return http.StatusNoContent
+	})
+
+	r := newGET(t, "http://localhost/search?q=feel&page=3&limit=10")
+	r.Header.Set("X-Trace-Id", "t-1")
+	r.Header.Set("Accept-Language", "en")
+	w := httptest.NewRecorder()
+	if err := by.Build().Handle(w, r); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusNoContent {
+		t.Error("unexpected response code", w.Code)
+	}
+}
+
+type CacheLimits struct {
+	MaxSize ByteSize      `query:"max-size"`
+	TTL     time.Duration `header:"X-TTL"`
+}
+
+func TestDurationAndByteSizeParameters(t *testing.T) {
+	by := PUT("/cache/:timeout").Handler(func(timeout time.Duration, limits CacheLimits) int {
+		if timeout != 30*time.Second {
+			t.Errorf("received: %#v", timeout)
+		}
+		if limits.MaxSize != 10*MB || limits.TTL != 5*time.Minute {
+			t.Errorf("received: %#v", limits)
+		}
+		return http.StatusNoContent
+	})
+
+	r := newRequest(t, http.MethodPut, "http://localhost/cache/30s?max-size=10MB", nil)
+	r.Header.Set("X-TTL", "5m")
+	w := httptest.NewRecorder()
+	if err := by.Build().Handle(w, r); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusNoContent {
+		t.Error("unexpected response code", w.Code)
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	for _, toCheck := range []struct {
+		value    string
+		expected ByteSize
+		invalid  bool
+	}{
+		{value: "512", expected: 512},
+		{value: "10KB", expected: 10000},
+		{value: "1.5 MiB", expected: 1572864},
+		{value: "2gb", expected: 2 * GB},
+		{value: "MB", invalid: true},
+		{value: "10XB", invalid: true},
+		{value: "1.2.3KB", invalid: true},
+		{value: "100000PB", invalid: true},
+		{value: "7EiB", expected: 7 * EiB},
+		{value: "8EiB", invalid: true},
+		{value: "9.3EB", invalid: true},
+		{value: "-1KB", invalid: true},
+		{value: "NaN", invalid: true},
+		{value: "1.5", invalid: true},
+		{value: "0.5KB", expected: 500},
+		{value: "1.1KB", expected: 1100},
+		{value: "0.0001KB", invalid: true},
+	} {
+		size, err := ParseByteSize(toCheck.value)
+		if (err != nil) != toCheck.invalid || size != toCheck.expected {
+			t.Error(toCheck.value, "unexpected:", size, err)
+		}
+	}
+}
+
+type NetworkFilter struct {
+	Gateway netip.Addr `query:"gateway"`
+	DNS     []net.IP   `query:"dns"`
+}
+
+func TestNetworkParameters(t *testing.T) {
+	by := GET("/networks/:prefix/hosts/:addr").Handler(func(prefix netip.Prefix, addr netip.Addr, filter NetworkFilter) int {
+		if prefix != netip.MustParsePrefix("10.0.0.0/8") || addr != netip.MustParseAddr("10.1.2.3") {
+			t.Errorf("received: %v %v", prefix, addr)
+		}
+		if filter.Gateway != netip.MustParseAddr("fe80::1") || len(filter.DNS) != 2 || !filter.DNS[1].Equal(net.IPv4(8, 8, 8, 8)) {
+			t.Errorf("received: %#v", filter)
+		}
+		return http.StatusNoContent
+	})
+
+	w := httptest.NewRecorder()
+	r := newGET(t, "http://localhost/networks/10.0.0.0%2F8/hosts/10.1.2.3?gateway=fe80::1&dns=1.1.1.1&dns=8.8.8.8")
+	if err := by.Build().Handle(w, r); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusNoContent {
+		t.Error("unexpected response code", w.Code)
+	}
+
+	r = newGET(t, "http://localhost/networks/10.0.0.0%2F8/hosts/10.1.2.300")
+	w = httptest.NewRecorder()
+	if err := by.Build().Handle(w, r); err != nil || w.Code != http.StatusBadRequest {
+		t.Error("invalid address is accepted", err, w.Code)
+	}
+}
+
+type Color struct {
+	R, G, B uint8
+}
+
+func (c *Color) UnmarshalText(text []byte) error {
+	_, err := fmt.Sscanf(string(text), "#%02x%02x%02x", &c.R, &c.G, &c.B)
+	return err
+}
+
+type Palette struct {
+	Background *Color    `header:"X-Background"`
+	Since      time.Time `query:"since"`
+}
+
+func TestTextUnmarshalerParameters(t *testing.T) {
+	by := GET("/colors/:color").Handler(func(color Color, palette Palette) int {
+		if color != (Color{R: 0xff, G: 0x80, B: 0}) {
+			t.Errorf("received: %#v", color)
+		}
+		if palette.Background == nil || *palette.Background != (Color{B: 0xff}) {
+			t.Errorf("received: %#v", palette.Background)
+		}
+		if !palette.Since.Equal(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)) {
+			t.Errorf("received: %#v", palette.Since)
+		}
+		return http.StatusNoContent
+	})
+
+	r := newGET(t, "http://localhost/colors/%23ff8000?since=2020-01-02T03:04:05Z")
+	r.Header.Set("X-Background", "#0000ff")
+	w := httptest.NewRecorder()
+	if err := by.Build().Handle(w, r); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusNoContent {
+		t.Error("unexpected response code", w.Code)
+	}
+
+	r = newGET(t, "http://localhost/colors/red")
+	w = httptest.NewRecorder()
+	if err := by.Build().Handle(w, r); err != nil || w.Code != http.StatusBadRequest {
+		t.Error("invalid color is accepted", err, w.Code)
+	}
+}
+
+type rateLimitedError struct{}
+
+func (rateLimitedError) Error() string {
+	return "too many requests"
+}
+
+func (rateLimitedError) StatusCode() int {
+	return http.StatusTooManyRequests
+}
+
+func (rateLimitedError) Headers() http.Header {
+	return http.Header{"Retry-After": {"30"}}
+}
+
+func TestErrorWithStatusCode(t *testing.T) {
+	by := GET("/").Handler(func() error {
+		return fmt.Errorf("wrapped: %w", rateLimitedError{})
+	})
+
+	w := httptest.NewRecorder()
+	if err := by.Build().Handle(w, newGET(t, "http://localhost")); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusTooManyRequests {
+		t.Error("unexpected response code", w.Code)
+	}
+	if w.Header().Get("Retry-After") != "30" {
+		t.Error("unexpected headers", w.Header())
+	}
+}
+
+func TestRequestAndResponseWriterParameters(t *testing.T) {
+	by := GET("/files/:name").Encoder(JSONEncoder).Handler(func(name string, r *http.Request, w http.ResponseWriter) (Key, error) {
+		if r.URL.Query().Get("raw") == "" {
+			return Key{Value: name}, nil
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusPartialContent)
+		_, err := io.WriteString(w, "raw "+name)
+		return Key{}, err
+	})
+
+	w := httptest.NewRecorder()
+	if err := by.Build().Handle(w, newGET(t, "http://localhost/files/a?raw=1")); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusPartialContent || w.Body.String() != "raw a" || w.Header().Get("Content-Type") != "text/plain" {
+		t.Error("unexpected response", w.Code, w.Header(), w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	if err := by.Build().Handle(w, newGET(t, "http://localhost/files/a")); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Error("unexpected response", w.Code, w.Header(), w.Body.String())
+	}
+}
+
+type countingBody struct {
+	io.Reader
+	read   int
+	closed bool
+}
+
+func (cb *countingBody) Read(data []byte) (int, error) {
+	n, err := cb.Reader.Read(data)
+	cb.read += n
+	return n, err
+}
+
+func (cb *countingBody) Close() error {
+	cb.closed = true
+	return nil
+}
+
+func TestUnreadBody(t *testing.T) {
+	by := POST("/uploads").
+		RequireHeader("Authorization", http.StatusUnauthorized).
+		UnreadBody(UnreadBodyPolicy{Limit: 10, CloseConnection: true}).
+		Decoder(JSONDecoder).
+		Handler(func(keys []Key) int { return http.StatusCreated })
+
+	body := &countingBody{Reader: strings.NewReader(strings.Repeat("x", 100))}
+	w := httptest.NewRecorder()
+	if err := by.Build().Handle(w, newPOST(t, "http://localhost/uploads", body)); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusUnauthorized || w.Header().Get("Connection") != "close" {
+		t.Error("unexpected response", w.Code, w.Header())
+	}
+	if !body.closed || body.read > 11 {
+		t.Error("unexpected body handling", body.read, body.closed)
+	}
+
+	body = &countingBody{Reader: strings.NewReader(`[{"Value": "k"}]`)}
+	r := newPOST(t, "http://localhost/uploads", body)
+	r.Header.Set("Authorization", "token")
+	w = httptest.NewRecorder()
+	if err := by.Build().Handle(w, r); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusCreated || w.Header().Get("Connection") != "" {
+		t.Error("unexpected response", w.Code, w.Header())
+	}
+}
+
+func getUserNotes(id string, first, second *Key) {}
+
+func TestBuildError(t *testing.T) {
+	err := GET("/users/:id/notes").Decoder(JSONDecoder).Handler(getUserNotes).Build().Handle(httptest.NewRecorder(), nil)
+
+	var buildErr BuildError
+	if !errors.As(err, &buildErr) || !errors.Is(err, InvalidMapping) {
+		t.Fatal("unexpected error", err)
+	}
+	expected := BuildError{Method: http.MethodGet, Template: "/users/:id/notes", Handler: buildErr.Handler, Parameter: 2, Result: -1, Cause: buildErr.Cause}
+	if buildErr != expected || !strings.HasSuffix(buildErr.Handler, ".getUserNotes") {
+		t.Errorf("unexpected build error: %+v", buildErr)
+	}
+}
+
+func TestBuildSnapshot(t *testing.T) {
+	by := GET("/reports").WhenQuery("format", "csv").Handler(func() int { return http.StatusOK }).(builder)
+	endpoint := by.Build()
+
+	by.queryConditions["format"][0] = "xml"
+	by.serviceValue = reflect.ValueOf(func() int { return http.StatusTeapot })
+
+	if !endpoint.matchesQuery(url.Values{"format": {"csv"}}) {
+		t.Error("endpoint is affected by builder changes")
+	}
+	w := httptest.NewRecorder()
+	if err := endpoint.Handle(w, newRequest(t, http.MethodGet, "http://localhost/reports?format=csv", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK {
+		t.Error("unexpected response code", w.Code)
+	}
+}
+
+func TestSniffContentType(t *testing.T) {
+	png := []byte("\x89PNG\x0D\x0A\x1A\x0A")
+	for _, toCheck := range []struct {
+		name     string
+		by       Builder
+		expected string
+	}{
+		{name: "bytes", by: GET("/files").Handler(func() []byte { return png }), expected: "image/png"},
+		{name: "reader", by: GET("/files").Handler(func() io.Reader { return strings.NewReader("<html><body>") }), expected: "text/html; charset=utf-8"},
+		{name: "closer", by: GET("/files").Handler(func() io.ReadCloser { return io.NopCloser(bytes.NewReader(png)) }), expected: "image/png"},
+		{name: "override", by: GET("/files").Handler(func() ([]byte, http.Header) {
+			return png, http.Header{"Content-Type": {"application/octet-stream"}}
+		}), expected: "application/octet-stream"},
+		{name: "nil reader", by: GET("/files").Handler(func() io.Reader { return nil }), expected: ""},
+	} {
+		w := httptest.NewRecorder()
+		if err := toCheck.by.Build().Handle(w, newRequest(t, http.MethodGet, "http://localhost/files", nil)); err != nil {
+			t.Fatal(toCheck.name, err)
+		}
+		if contentType := w.Result().Header.Get("Content-Type"); contentType != toCheck.expected {
+			t.Error(toCheck.name, "unexpected content type", contentType)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	err := GET("/files").Handler(func() io.Reader { return strings.NewReader("plain text") }).Build().Handle(w, newRequest(t, http.MethodGet, "http://localhost/files", nil))
+	if err != nil || w.Body.String() != "plain text" {
+		t.Error("unexpected response body", w.Body.String(), err)
+	}
+}
+
+func TestErrorCachePolicy(t *testing.T) {
+	policy := ErrorCachePolicy{
+		{StatusCode: http.StatusNotFound, CacheControl: "max-age=30"},
+		{Error: InvalidValue, CacheControl: "no-store"},
+		{StatusCode: http.StatusServiceUnavailable, RetryAfter: 1500 * time.Millisecond},
+	}
+	for _, toCheck := range []struct {
+		err      error
+		expected http.Header
+	}{
+		{err: Problem{Status: http.StatusNotFound}, expected: http.Header{"Cache-Control": {"max-age=30"}}},
+		{err: InvalidValueError(errors.New("bad id")), expected: http.Header{"Cache-Control": {"no-store"}}},
+		{err: Problem{Status: http.StatusServiceUnavailable}, expected: http.Header{"Retry-After": {"2"}}},
+		{err: errors.New("unexpected"), expected: http.Header{}},
+	} {
+		err := toCheck.err
+		by := GET("/").ErrorMapping(policy.Apply(func(err error, w http.ResponseWriter, r *http.Request) error {
+			w.WriteHeader(errorStatusCode(err, http.StatusInternalServerError))
+			return nil
+		})).Handler(func() error { return err })
+
+		w := httptest.NewRecorder()
+		if err := by.Build().Handle(w, newGET(t, "http://localhost")); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(w.Header(), toCheck.expected) {
+			t.Error(toCheck.err, "unexpected headers", w.Header())
+		}
+	}
+
+	// streaming mappers flush through the policy, headers are set before the implicit status code
+	by := GET("/").ErrorMapping(policy.Apply(func(err error, w http.ResponseWriter, r *http.Request) error {
+		w.(http.Flusher).Flush()
+		return nil
+	})).Handler(func() error { return InvalidValueError(errors.New("bad id")) })
+	w := httptest.NewRecorder()
+	if err := by.Build().Handle(w, newGET(t, "http://localhost")); err != nil {
+		t.Fatal(err)
+	}
+	if !w.Flushed || w.Header().Get("Cache-Control") != "no-store" {
+		t.Error("unexpected flushed response", w.Flushed, w.Header())
+	}
+}
+
+func TestResponseWrapper(t *testing.T) {
+	by := GET("/keys/:id").Encoder(JSONEncoder).Handler(func(id string) (Response[Key], error) {
+		if id == "missing" {
+			return Response[Key]{}, Problem{Status: http.StatusNotFound}
+		}
+		return Response[Key]{
+			Body:    Key{Value: id},
+			Status:  http.StatusAccepted,
+			Headers: http.Header{"X-Key": {id}},
+			Cookies: []*http.Cookie{{Name: "key", Value: id}},
+		}, nil
+	})
+
+	w := httptest.NewRecorder()
+	if err := by.Build().Handle(w, newGET(t, "http://localhost/keys/k1")); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusAccepted || w.Header().Get("X-Key") != "k1" || w.Header().Get("Set-Cookie") != "key=k1" {
+		t.Error("unexpected response", w.Code, w.Header())
+	}
+	if strings.TrimSpace(w.Body.String()) != `{"Value":"k1","Part":0}` {
+		t.Error("unexpected response body", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	if err := by.Build().Handle(w, newGET(t, "http://localhost/keys/missing")); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusNotFound {
+		t.Error("unexpected response code", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	err := GET("/keys").Handler(func() Response[string] { return Response[string]{Body: "k1"} }).Build().Handle(w, newGET(t, "http://localhost/keys"))
+	if err != nil || w.Code != http.StatusOK || w.Body.String() != "k1" {
+		t.Error("unexpected response", w.Code, w.Body.String(), err)
+	}
+
+	err = GET("/keys").Handler(func() (Response[string], int) { return Response[string]{}, http.StatusOK }).Build().Handle(httptest.NewRecorder(), nil)
+	if !errors.Is(err, InvalidMapping) {
+		t.Error("multiple status codes are accepted", err)
+	}
+}
+
+type keyResponse struct {
+	Key     *Key           `feel:"body"`
+	Status  int            `feel:"status"`
+	Version string         `feel:"header:x-version"`
+	Links   []string       `feel:"header:Link"`
+	Headers http.Header    `feel:"headers"`
+	Cookies []*http.Cookie `feel:"cookies"`
+	trace   string
+}
+
+func TestTaggedResponse(t *testing.T) {
+	by := GET("/keys/:id").Encoder(JSONEncoder).Handler(func(id string) (keyResponse, error) {
+		if id == "none" {
+			return keyResponse{}, nil
+		}
+		return keyResponse{
+			Key:     &Key{Value: id},
+			Status:  http.StatusAccepted,
+			Version: "2",
+			Links:   []string{"</keys/a>", "</keys/b>"},
+			Headers: http.Header{"X-Key": {id}},
+			Cookies: []*http.Cookie{{Name: "key", Value: id}},
+		}, nil
+	})
+
+	w := httptest.NewRecorder()
+	if err := by.Build().Handle(w, newGET(t, "http://localhost/keys/k1")); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusAccepted || w.Header().Get("X-Version") != "2" || len(w.Header()["Link"]) != 2 ||
+		w.Header().Get("X-Key") != "k1" || w.Header().Get("Set-Cookie") != "key=k1" {
+		t.Error("unexpected response", w.Code, w.Header())
+	}
+	if strings.TrimSpace(w.Body.String()) != `{"Value":"k1","Part":0}` {
+		t.Error("unexpected response body", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	if err := by.Build().Handle(w, newGET(t, "http://localhost/keys/none")); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusNoContent || w.Header().Get("X-Version") != "" || w.Header().Get("Content-Type") != "" || w.Body.Len() != 0 {
+		t.Error("unexpected empty response", w.Code, w.Header(), w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	if err := by.BufferResponse(1024).Build().Handle(w, newGET(t, "http://localhost/keys/none")); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusNoContent || w.Header().Get("Content-Type") != "" {
+		t.Error("unexpected empty buffered response", w.Code, w.Header())
+	}
+
+	for _, handler := range []interface{}{
+		func() struct {
+			Status string `feel:"status"`
+		} {
+			return struct {
+				Status string `feel:"status"`
+			}{}
+		},
+		func() struct {
+			Body  string `feel:"body"`
+			Extra string
+		} {
+			return struct {
+				Body  string `feel:"body"`
+				Extra string
+			}{}
+		},
+		func() struct {
+			Count int `feel:"header:X-Count"`
+		} {
+			return struct {
+				Count int `feel:"header:X-Count"`
+			}{}
+		},
+		func() (struct {
+			Status int `feel:"status"`
+		}, int) {
+			return struct {
+				Status int `feel:"status"`
+			}{}, http.StatusOK
+		},
+	} {
+		if err := GET("/keys").Handler(handler).Build().Handle(httptest.NewRecorder(), nil); !errors.Is(err, InvalidMapping) {
+			t.Errorf("invalid tagged response %T is accepted: %v", handler, err)
+		}
+	}
+}
+
+func TestCreatedAndNoContent(t *testing.T) {
+	by := PUT("/keys/:id").Encoder(JSONEncoder).Handler(func(id string) Response[*Key] {
+		switch id {
+		case "new":
+			return Created("/keys/k1", &Key{Value: "k1"})
+		case "empty":
+			return Response[*Key]{}
+		}
+		return NoContent[*Key]()
+	})
+
+	for id, expected := range map[string]struct {
+		code     int
+		location string
+		body     string
+	}{
+		"new":      {code: http.StatusCreated, location: "/keys/k1", body: `{"Value":"k1","Part":0}` + "\n"},
+		"empty":    {code: http.StatusNoContent},
+		"existing": {code: http.StatusNoContent},
+	} {
+		w := httptest.NewRecorder()
+		if err := by.Build().Handle(w, newRequest(t, http.MethodPut, "http://localhost/keys/"+id, nil)); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != expected.code || w.Header().Get("Location") != expected.location || w.Body.String() != expected.body {
+			t.Error(id, "unexpected response", w.Code, w.Header(), w.Body.String())
+		}
+		if (w.Header().Get("Content-Type") != "") != (expected.body != "") {
+			t.Error(id, "unexpected content type", w.Header())
+		}
+	}
+
+	w := httptest.NewRecorder()
+	err := GET("/keys").Encoder(JSONEncoder).Handler(func() (Key, int) { return Key{}, http.StatusNotModified }).Build().Handle(w, newGET(t, "http://localhost/keys"))
+	if err != nil || w.Code != http.StatusNotModified || w.Body.Len() != 0 || w.Header().Get("Content-Type") != "" {
+		t.Error("body is sent with status not allowing it", w.Code, w.Body.String(), err)
+	}
+}
+
+type principalKey struct{}
+
+type Principal struct {
+	Subject string
+}
+
+func TestContextValueParameter(t *testing.T) {
+	by := GET("/keys/:id").
+		ContextValue(ContextKey[Principal](principalKey{})).
+		Handler(func(id string, principal Principal) string { return principal.Subject + ":" + id })
+
+	r := newGET(t, "http://localhost/keys/k1")
+	r = r.WithContext(context.WithValue(r.Context(), principalKey{}, Principal{Subject: "john"}))
+	w := httptest.NewRecorder()
+	if err := by.Build().Handle(w, r); err != nil {
+		t.Fatal(err)
+	}
+	if w.Body.String() != "john:k1" {
+		t.Error("unexpected response body", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	if err := by.Build().Handle(w, newGET(t, "http://localhost/keys/k1")); !errors.Is(err, InvalidValue) {
+		t.Error("missing context value is accepted", err)
+	}
+
+	err := GET("/keys").ContextValue(func(ctx context.Context) Principal { return Principal{} }).Handler(func() {}).Build().Handle(w, nil)
+	if !errors.Is(err, InvalidMapping) {
+		t.Error("invalid extractor is accepted", err)
+	}
+}
+
+func TestSpoolBody(t *testing.T) {
+	var spooled string
+	by := POST("/uploads").
+		SpoolBody(SpoolPolicy{Threshold: 4, Dir: t.TempDir()}).
+		Handler(func(body io.ReadSeeker) (string, error) {
+			if file, ok := body.(*os.File); ok {
+				spooled = file.Name()
+			}
+			if _, err := io.Copy(io.Discard, body); err != nil {
+				return "", err
+			}
+			if _, err := body.Seek(0, io.SeekStart); err != nil {
+				return "", err
+			}
+			data, err := io.ReadAll(body)
+			return string(data), err
+		})
+
+	for _, content := range []string{"tiny", "large upload"} {
+		spooled = ""
+		w := httptest.NewRecorder()
+		if err := by.Build().Handle(w, newPOST(t, "http://localhost/uploads", strings.NewReader(content))); err != nil {
+			t.Fatal(err)
+		}
+		if w.Body.String() != content {
+			t.Error("unexpected response body", w.Body.String())
+		}
+		if (spooled != "") != (len(content) > 4) {
+			t.Error(content, "unexpected spooling", spooled)
+		}
+		if _, err := os.Stat(spooled); spooled != "" && !os.IsNotExist(err) {
+			t.Error("spooled file is not removed", err)
+		}
+	}
+}
+
+func TestFloatPathParameters(t *testing.T) {
+	by := GET("/points/:lat/:lon").Handler(func(lat float64, lon float32) string {
+		return fmt.Sprintf("%g,%g", lat, lon)
+	})
+
+	w := httptest.NewRecorder()
+	if err := by.Build().Handle(w, newGET(t, "http://localhost/points/52.52/-13.405")); err != nil {
+		t.Fatal(err)
+	}
+	if w.Body.String() != "52.52,-13.405" {
+		t.Error("unexpected response body", w.Body.String())
+	}
+
+	for _, path := range []string{"/points/north/13.4", "/points/NaN/13.4", "/points/52.5/1e39"} {
+		w = httptest.NewRecorder()
+		if err := by.Build().Handle(w, newGET(t, "http://localhost"+path)); err != nil || w.Code != http.StatusBadRequest {
+			t.Error(path, "invalid value is accepted", err, w.Code)
+		}
+	}
+}
+
+func TestBufferResponse(t *testing.T) {
+	failing := EncoderFunc(func(writer io.Writer) func(v interface{}) error {
+		return func(v interface{}) error {
+			io.WriteString(writer, "partial")
+			return errors.New("encoding failed")
+		}
+	})
+
+	for _, toCheck := range []struct {
+		name          string
+		by            Builder
+		expectedErr   bool
+		expectedBody  string
+		contentLength string
+	}{
+		{name: "small", by: GET("/").BufferResponse(16).Handler(func() string { return "buffered" }), expectedBody: "buffered", contentLength: "8"},
+		{name: "large", by: GET("/").BufferResponse(4).Handler(func() string { return "streamed" }), expectedBody: "streamed"},
+		{name: "failed", by: GET("/").BufferResponse(16).Encoder(failing).Handler(func() Key { return Key{} }), expectedErr: true},
+	} {
+		w := httptest.NewRecorder()
+		err := toCheck.by.Build().Handle(w, newGET(t, "http://localhost"))
+		if (err != nil) != toCheck.expectedErr {
+			t.Error(toCheck.name, "unexpected error", err)
+		}
+		if w.Body.String() != toCheck.expectedBody || w.Header().Get("Content-Length") != toCheck.contentLength {
+			t.Error(toCheck.name, "unexpected response", w.Body.String(), w.Header())
+		}
+	}
+}
+
+type ReportRange struct {
+	From    time.Time     `query:"from" layout:"2006-01-02"`
+	To      time.Time     `query:"to"`
+	Timeout time.Duration `header:"X-Timeout"`
+}
+
+func TestTimeParameters(t *testing.T) {
+	by := GET("/reports/:day").
+		TimeLayout("20060102").
+		Handler(func(day time.Time, rng ReportRange) string {
+			return strings.Join([]string{day.Format(time.DateOnly), rng.From.Format(time.DateOnly), rng.To.Format(time.DateOnly), rng.Timeout.String()}, " ")
+		})
+
+	r := newGET(t, "http://localhost/reports/20240131?from=2024-01-01&to=20240201")
+	r.Header.Set("X-Timeout", "1m30s")
+	w := httptest.NewRecorder()
+	if err := by.Build().Handle(w, r); err != nil {
+		t.Fatal(err)
+	}
+	if w.Body.String() != "2024-01-31 2024-01-01 2024-02-01 1m30s" {
+		t.Error("unexpected response body", w.Body.String())
+	}
+
+	by = GET("/reports/:at").Handler(func(at time.Time) string { return at.UTC().Format(time.RFC3339) })
+	w = httptest.NewRecorder()
+	if err := by.Build().Handle(w, newGET(t, "http://localhost/reports/2024-01-31T10:00:00+02:00")); err != nil {
+		t.Fatal(err)
+	}
+	if w.Body.String() != "2024-01-31T08:00:00Z" {
+		t.Error("unexpected response body", w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	if err := by.Build().Handle(w, newGET(t, "http://localhost/reports/yesterday")); err != nil || w.Code != http.StatusBadRequest {
+		t.Error("invalid time is accepted", err, w.Code)
+	}
+}
+
+func TestStaticResponseHeaders(t *testing.T) {
+	by := GET("/keys/:id").
+		ResponseHeader("X-API-Version", "2").
+		ResponseHeaders(http.Header{"Cache-Control": {"no-cache"}}).
+		Handler(func(id int) (http.Header, error) {
+			if id == 0 {
+				return nil, Problem{Status: http.StatusNotFound}
+			}
+			return http.Header{"Cache-Control": {"max-age=60"}}, nil
+		})
+
+	w := httptest.NewRecorder()
+	if err := by.Build().Handle(w, newGET(t, "http://localhost/keys/1")); err != nil {
+		t.Fatal(err)
+	}
+	if w.Header().Get("X-API-Version") != "2" || w.Header().Get("Cache-Control") != "max-age=60" {
+		t.Error("unexpected headers", w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	if err := by.Build().Handle(w, newGET(t, "http://localhost/keys/0")); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusNotFound || w.Header().Get("X-API-Version") != "2" || w.Header().Get("Cache-Control") != "no-cache" {
+		t.Error("unexpected error response", w.Code, w.Header())
+	}
+}
+
+func TestReturnedResponseHeaders(t *testing.T) {
+	by := GET("/keys").
+		ResponseHeader("Cache-Control", "no-cache").
+		EncoderFor("application/json", JSONEncoder).
+		After(func(headers http.Header) {
+			headers.Set("X-Tag", "interceptor")
+			headers.Set("X-Trace", "t1")
+		}).
+		Handler(func() (string, http.Header) {
+			return "k1", http.Header{"x-tag": {"a", "b"}, "Vary": {"Origin"}, "Cache-Control": nil}
+		})
+
+	w := httptest.NewRecorder()
+	if err := by.Build().Handle(w, newGET(t, "http://localhost/keys")); err != nil {
+		t.Fatal(err)
+	}
+	expected := http.Header{
+		"Content-Type": {"application/json"},
+		"Vary":         {"Accept", "Origin"},
+		"X-Tag":        {"a", "b"},
+		"X-Trace":      {"t1"},
+	}
+	if !reflect.DeepEqual(w.Header(), expected) {
+		t.Error("unexpected headers", w.Header())
+	}
+}
+
+// headerOrderRecorder records headers at the time the status code is written.
+type headerOrderRecorder struct {
+	*httptest.ResponseRecorder
+	sent http.Header
+}
+
+func (r *headerOrderRecorder) WriteHeader(statusCode int) {
+	if r.sent == nil {
+		r.sent = r.Header().Clone()
+	}
+	r.ResponseRecorder.WriteHeader(statusCode)
+}
+
+func TestResponsePhases(t *testing.T) {
+	headers := http.Header{"X-Tag": {"a", "b"}}
+	cookies := []*http.Cookie{{Name: "session", Value: "s1"}}
+	for _, toCheck := range []struct {
+		name string
+		by   Builder
+	}{
+		{name: "body first", by: GET("/keys").Handler(func() (string, int, http.Header, []*http.Cookie) {
+			return "k1", http.StatusCreated, headers, cookies
+		})},
+		{name: "body last", by: GET("/keys").Handler(func() ([]*http.Cookie, http.Header, int, string) {
+			return cookies, headers, http.StatusCreated, "k1"
+		})},
+		{name: "multipart", by: GET("/keys").Handler(func() (MultipartResponse, int, http.Header) {
+			return MultipartResponse{Boundary: "b0", Parts: []Part{{Body: "k1"}}}, http.StatusCreated, headers
+		})},
+	} {
+		w := &headerOrderRecorder{ResponseRecorder: httptest.NewRecorder()}
+		if err := toCheck.by.Build().Handle(w, newGET(t, "http://localhost/keys")); err != nil {
+			t.Fatal(toCheck.name, err)
+		}
+		if w.Code != http.StatusCreated || !reflect.DeepEqual(w.sent["X-Tag"], []string{"a", "b"}) {
+			t.Error(toCheck.name, "unexpected headers sent with status", w.Code, w.sent)
+		}
+		if !reflect.DeepEqual(w.sent, w.Header()) {
+			t.Error(toCheck.name, "headers changed after status", w.sent, w.Header())
+		}
+	}
+
+	w := &headerOrderRecorder{ResponseRecorder: httptest.NewRecorder()}
+	by := GET("/keys").Handler(func() MultipartResponse { return MultipartResponse{Parts: []Part{{Body: "k1"}}} })
+	if err := by.Build().Handle(w, newGET(t, "http://localhost/keys")); err != nil {
+		t.Fatal(err)
+	}
+	_, params, err := mime.ParseMediaType(w.sent.Get("Content-Type"))
+	if err != nil || params["boundary"] == "" || !strings.HasPrefix(w.Body.String(), "--"+params["boundary"]) {
+		t.Error("unexpected multipart boundary", w.sent, w.Body.String())
+	}
+}
+
+func TestBodyWithoutEncoder(t *testing.T) {
+	for _, toCheck := range []struct {
+		body     string
+		expected string
+	}{
+		{body: "plain text", expected: "text/plain; charset=utf-8"},
+		{body: "<html><body>", expected: "text/html; charset=utf-8"},
+		{body: "", expected: ""},
+	} {
+		body := toCheck.body
+		w := httptest.NewRecorder()
+		if err := GET("/files").Handler(func() string { return body }).Build().Handle(w, newGET(t, "http://localhost/files")); err != nil {
+			t.Fatal(err)
+		}
+		if w.Body.String() != body || w.Header().Get("Content-Type") != toCheck.expected {
+			t.Error("unexpected response", w.Header(), w.Body.String())
+		}
+	}
+
+	for _, by := range []Builder{
+		GET("/keys").Handler(func() Key { return Key{} }),
+		GET("/keys").Handler(func() (*Key, error) { return nil, nil }),
+		GET("/keys").Handler(func() []int { return nil }),
+	} {
+		if err := by.Build().Handle(httptest.NewRecorder(), nil); !errors.Is(err, InvalidMapping) {
+			t.Error("body without encoder is accepted", err)
+		}
+	}
+}
+
+type Cents int64
+
+type PriceFilter struct {
+	Max Cents `query:"max"`
+}
+
+func TestRegisterConverter(t *testing.T) {
+	RegisterConverter(reflect.TypeOf(Cents(0)), ConverterFunc(func(pathPart string) (reflect.Value, error) {
+		units, fraction, _ := strings.Cut(pathPart, ".")
+		parsed, err := strconv.ParseInt(units+(fraction + "00")[:2], 10, 64)
+		if err != nil {
+			return reflect.Value{}, InvalidValueError(err)
+		}
+		return reflect.ValueOf(Cents(parsed)), nil
+	}))
+
+	by := GET("/prices/:min").Handler(func(min Cents, filter PriceFilter) string {
+		return fmt.Sprint(int64(min), int64(filter.Max))
+	})
+	w := httptest.NewRecorder()
+	if err := by.Build().Handle(w, newGET(t, "http://localhost/prices/1.5?max=12.34")); err != nil {
+		t.Fatal(err)
+	}
+	if w.Body.String() != "150 1234" {
+		t.Error("unexpected response body", w.Body.String())
+	}
+}
+
+// Level is upper-cased by UnmarshalText, which takes precedence over conversion of strings.
+type Level string
+
+func (l *Level) UnmarshalText(text []byte) error {
+	*l = Level(strings.ToUpper(string(text)))
+	return nil
+}
+
+// Priority fails UnmarshalText, which is overridden by the registered converter.
+type Priority string
+
+func (p *Priority) UnmarshalText(text []byte) error {
+	return errors.New("unmarshalled")
+}
+
+func TestConverterPrecedence(t *testing.T) {
+	registered := func(parameterType reflect.Type, value interface{}) {
+		RegisterConverter(parameterType, ConverterFunc(func(pathPart string) (reflect.Value, error) {
+			return reflect.ValueOf(value), nil
+		}))
+		t.Cleanup(func() {
+			registeredConverters.Lock()
+			defer registeredConverters.Unlock()
+			delete(registeredConverters.byType, parameterType)
+		})
+	}
+	registered(reflect.TypeOf(Priority("")), Priority("registered"))
+	epoch := time.Unix(0, 0)
+	registered(timeType, epoch)
+
+	for _, toCheck := range []struct {
+		parameterType reflect.Type
+		layout        string
+		value         string
+		expected      interface{}
+	}{
+		{parameterType: reflect.TypeOf(Level("")), value: "debug", expected: Level("DEBUG")},
+		{parameterType: durationType, value: "1m", expected: time.Minute},
+		{parameterType: reflect.TypeOf(int64(0)), value: "60", expected: int64(60)},
+		{parameterType: reflect.TypeOf(Priority("")), value: "high", expected: Priority("registered")},
+		{parameterType: timeType, layout: "2006-01-02", value: "2024-01-02", expected: epoch},
+	} {
+		converter, err := newLayoutPathParameterConverter(toCheck.parameterType, toCheck.layout)
+		if err != nil {
+			t.Fatal(toCheck.parameterType, err)
+		}
+		converted, err := converter.Convert(toCheck.value)
+		if err != nil || converted.Interface() != toCheck.expected {
+			t.Error(toCheck.parameterType, "unexpected conversion", converted, err)
+		}
+	}
+}
+
+type Signup struct {
+	Email string
+	Age   int
+}
+
+func (s Signup) Validate() error {
+	var fields []FieldError
+	if !strings.Contains(s.Email, "@") {
+		fields = append(fields, FieldError{Field: "Email", Message: "must be an email address"})
+	}
+	if s.Age < 18 {
+		fields = append(fields, FieldError{Field: "Age", Message: "must be at least 18"})
+	}
+	if len(fields) > 0 {
+		return ValidationError{Fields: fields}
+	}
+	return nil
+}
+
+func TestValidation(t *testing.T) {
+	by := POST("/signups").Decoder(JSONDecoder).
+		Validator(func(v interface{}) error {
+			if signup, ok := v.(Signup); ok && strings.HasSuffix(signup.Email, ".invalid") {
+				return errors.New("email domain is not allowed")
+			}
+			return nil
+		}).
+		Handler(func(signup Signup) string { return signup.Email })
+
+	w := httptest.NewRecorder()
+	if err := by.Build().Handle(w, newPOST(t, "http://localhost/signups", strings.NewReader(`{"Email": "a@b.c", "Age": 20}`))); err != nil {
+		t.Fatal(err)
+	}
+	if w.Body.String() != "a@b.c" {
+		t.Error("unexpected response body", w.Body.String())
+	}
+
+	var validationErr ValidationError
+	by = by.RequestErrorMapping(func(err error, w http.ResponseWriter, r *http.Request) error {
+		if !errors.As(err, &validationErr) {
+			t.Error("unexpected error", err)
+		}
+		return DefaultRequestErrorMapper(err, w, r)
+	})
+	w = httptest.NewRecorder()
+	if err := by.Build().Handle(w, newPOST(t, "http://localhost/signups", strings.NewReader(`{"Email": "a", "Age": 1}`))); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusBadRequest || len(validationErr.Fields) != 2 {
+		t.Error("invalid body is accepted", w.Code, validationErr)
+	}
+
+	validationErr = ValidationError{}
+	if err := by.Build().Handle(httptest.NewRecorder(), newPOST(t, "http://localhost/signups", strings.NewReader(`{"Email": "a@b.invalid", "Age": 20}`))); err != nil {
+		t.Fatal(err)
+	}
+	if validationErr.Cause == nil {
+		t.Error("validator is not applied", validationErr)
+	}
+}
+
+type ContactQuery struct {
+	Email string   `query:"email" normalize:"trim,lower"`
+	Name  string   `query:"name"`
+	Tags  []string `query:"tag"`
+	Raw   string   `query:"raw" normalize:"-"`
+}
+
+func TestNormalizeStrings(t *testing.T) {
+	by := GET("/contacts/:group").
+		NormalizeStrings(StringNormalization{Trim: true, CollapseSpaces: true}).
+		Handler(func(group string, query ContactQuery) string {
+			return strings.Join([]string{group, query.Email, query.Name, strings.Join(query.Tags, "|"), query.Raw}, ",")
+		})
+
+	w := httptest.NewRecorder()
+	r := newGET(t, "http://localhost/contacts/%20sales%20?email=%20Jo@Example.COM%20&name=Jo%20%20%20Doe%20&tag=%20a%20&tag=b%20%20c&raw=%20x%20")
+	if err := by.Build().Handle(w, r); err != nil {
+		t.Fatal(err)
+	}
+	if w.Body.String() != "sales,jo@example.com,Jo Doe,a|b c, x " {
+		t.Error("unexpected response body", w.Body.String())
+	}
+
+	type invalid struct {
+		Count int `query:"count" normalize:"trim"`
+	}
+	if err := GET("/contacts").Handler(func(query invalid) {}).Build().Handle(httptest.NewRecorder(), newGET(t, "http://localhost/contacts")); !errors.Is(err, InvalidMapping) {
+		t.Error("normalization of non-string field is accepted", err)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	late := make(chan error, 1)
+	by := GET("/reports/:id").
+		Timeout(20*time.Millisecond, http.StatusGatewayTimeout).
+		Handler(func(id int, r *http.Request, w http.ResponseWriter) (string, error) {
+			if id == 0 {
+				return "fast", nil
+			}
+			<-r.Context().Done()
+			time.Sleep(10 * time.Millisecond)
+			_, err := io.WriteString(w, "late")
+			late <- err
+			return "late", nil
+		})
+
+	w := httptest.NewRecorder()
+	if err := by.Build().Handle(w, newGET(t, "http://localhost/reports/0")); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || w.Body.String() != "fast" {
+		t.Error("unexpected response", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	if err := by.Build().Handle(w, newGET(t, "http://localhost/reports/1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-late; !errors.Is(err, http.ErrHandlerTimeout) {
+		t.Error("late write is accepted", err)
+	}
+	if w.Code != http.StatusGatewayTimeout || strings.Contains(w.Body.String(), "late") {
+		t.Error("unexpected timeout response", w.Code, w.Body.String())
+	}
+}
+
+func TestAdmit(t *testing.T) {
+	by := GET("/users/:id/events").
+		Admit(func(id int) error {
+			if id <= 0 {
+				return Problem{Status: http.StatusBadRequest, Detail: "unknown user"}
+			}
+			return nil
+		}).
+		Admit(func(paging Paging) error {
+			if paging.Limit > 100 {
+				return errors.New("page is too big")
+			}
+			return nil
+		}).
+		Handler(func(id int) int { return http.StatusNoContent })
+
+	if err := by.Build().Handle(httptest.NewRecorder(), newGET(t, "http://localhost/users/1/events")); !errors.Is(err, InvalidMapping) {
+		t.Error("admission of unbound parameter is accepted", err)
+	}
+
+	by = by.Handler(func(id int, paging Paging) int { return http.StatusNoContent })
+	for _, toCheck := range []struct {
+		url      string
+		expected int
+	}{
+		{url: "http://localhost/users/1/events?limit=10", expected: http.StatusNoContent},
+		{url: "http://localhost/users/0/events?limit=10", expected: http.StatusBadRequest},
+		{url: "http://localhost/users/1/events?limit=1000", expected: http.StatusTooManyRequests},
+	} {
+		w := httptest.NewRecorder()
+		if err := by.Build().Handle(w, newGET(t, toCheck.url)); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != toCheck.expected {
+			t.Error(toCheck.url, "unexpected response code", w.Code, w.Body.String())
+		}
+	}
+}
+
+// trailerBody fills trailers of the request once the body is read to the end as net/http server does.
+type trailerBody struct {
+	io.Reader
+	r       *http.Request
+	trailer http.Header
+}
+
+func (b *trailerBody) Read(p []byte) (int, error) {
+	n, err := b.Reader.Read(p)
+	if err == io.EOF {
+		for name, values := range b.trailer {
+			b.r.Trailer[name] = values
+		}
+	}
+	return n, err
+}
+
+func (b *trailerBody) Close() error {
+	return nil
+}
+
+func TestTrailers(t *testing.T) {
+	type Upload struct {
+		Name     string `query:"name"`
+		Checksum string `trailer:"x-checksum"`
+	}
+	by := POST("/uploads").
+		Decoder(JSONDecoder).
+		Handler(func(upload Upload, content string) string {
+			return strings.Join([]string{upload.Name, upload.Checksum, content}, ",")
+		})
+
+	for _, content := range []string{"data", "longer data"} {
+		r, err := http.NewRequest(http.MethodPost, "http://localhost/uploads?name=report", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Trailer = http.Header{"X-Checksum": nil}
+		r.Body = &trailerBody{Reader: strings.NewReader(strconv.Quote(content)), r: r, trailer: http.Header{"X-Checksum": {"c0ffee"}}}
+
+		w := httptest.NewRecorder()
+		if err := by.Build().Handle(w, r); err != nil {
+			t.Fatal(err)
+		}
+		if w.Body.String() != "report,c0ffee,"+content {
+			t.Error("unexpected response body", w.Body.String())
+		}
+	}
+}
+
+func TestMaxResponseBytes(t *testing.T) {
+	var reported []ErrorEvent
+	for _, buffered := range []int{0, 1 << 10} {
+		by := GET("/keys/:amount").
+			Encoder(JSONEncoder).
+			MaxResponseBytes(100).
+			BufferResponse(buffered).
+			Handler(func(amount int) []Key { return make([]Key, amount) })
+		endpoint := by.Build()
+		endpoint.hooks = Hooks{OnError: func(event ErrorEvent) { reported = append(reported, event) }}
+
+		w := httptest.NewRecorder()
+		if err := endpoint.Handle(w, newGET(t, "http://localhost/keys/2")); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != http.StatusOK {
+			t.Error("unexpected response code", w.Code)
+		}
+
+		w = httptest.NewRecorder()
+		if err := endpoint.Handle(w, newGET(t, "http://localhost/keys/10")); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != http.StatusInternalServerError || strings.Contains(w.Body.String(), "Value") {
+			t.Error("unexpected response", w.Code, w.Body.String())
+		}
+	}
+
+	var tooLarge ResponseTooLargeError
+	if len(reported) != 2 || reported[0].Stage != EncodeStage || !errors.As(reported[0].Err, &tooLarge) || tooLarge.Type != "[]main.Key" {
+		t.Errorf("unexpected reported errors %#v", reported)
+	}
+}
+
+func TestConditional(t *testing.T) {
+	modified := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	var encoded int
+	encoder := EncoderFunc(func(w io.Writer) func(v interface{}) error {
+		return func(v interface{}) error {
+			encoded++
+			return json.NewEncoder(w).Encode(v)
+		}
+	})
+	provided := GET("/keys/:id").Encoder(encoder).Conditional(false).Handler(func(id string) (Key, http.Header) {
+		return Key{Value: id}, http.Header{"Etag": {`"v1"`}, "Last-Modified": {modified.Format(http.TimeFormat)}}
+	}).Build()
+
+	for name, expected := range map[string]struct {
+		header http.Header
+		code   int
+	}{
+		"matching etag":     {header: http.Header{"If-None-Match": {`"v0", W/"v1"`}}, code: http.StatusNotModified},
+		"any etag":          {header: http.Header{"If-None-Match": {"*"}}, code: http.StatusNotModified},
+		"other etag":        {header: http.Header{"If-None-Match": {`"v0"`}}, code: http.StatusOK},
+		"etag precedes":     {header: http.Header{"If-None-Match": {`"v0"`}, "If-Modified-Since": {modified.Format(http.TimeFormat)}}, code: http.StatusOK},
+		"not modified":      {header: http.Header{"If-Modified-Since": {modified.Add(time.Hour).Format(http.TimeFormat)}}, code: http.StatusNotModified},
+		"modified":          {header: http.Header{"If-Modified-Since": {modified.Add(-time.Hour).Format(http.TimeFormat)}}, code: http.StatusOK},
+		"unconditional GET": {code: http.StatusOK},
+	} {
+		encoded = 0
+		r := newGET(t, "http://localhost/keys/k1")
+		for header, values := range expected.header {
+			r.Header[header] = values
+		}
+		w := httptest.NewRecorder()
+		if err := provided.Handle(w, r); err != nil {
+			t.Fatal(name, err)
+		}
+		if w.Code != expected.code || w.Header().Get("ETag") != `"v1"` {
+			t.Error(name, "unexpected response", w.Code, w.Header())
+		}
+		if expected.code == http.StatusNotModified && (encoded != 0 || w.Body.Len() != 0) {
+			t.Error(name, "body is encoded for not modified response", encoded, w.Body.String())
+		}
+	}
+
+	computed := GET("/keys/:id").Encoder(JSONEncoder).Conditional(true).Handler(func(id string) Key { return Key{Value: id} }).Build()
+	w := httptest.NewRecorder()
+	if err := computed.Handle(w, newGET(t, "http://localhost/keys/k1")); err != nil {
+		t.Fatal(err)
+	}
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag == "" || w.Body.String() != `{"Value":"k1","Part":0}`+"\n" {
+		t.Fatal("unexpected response", w.Code, w.Header(), w.Body.String())
+	}
+	r := newGET(t, "http://localhost/keys/k1")
+	r.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	if err := computed.Handle(w, r); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 || w.Header().Get("ETag") != etag {
+		t.Error("computed etag is not matched", w.Code, w.Header(), w.Body.String())
+	}
+}
+
+type fieldsAudit struct {
+	Created string `json:"created"`
+}
+
+type fieldsItem struct {
+	fieldsAudit
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Price int    `json:"price,omitempty"`
+}
+
+func TestFields(t *testing.T) {
+	by := GET("/items").Encoder(JSONEncoder).Fields("fields").Handler(func() []*fieldsItem {
+		return []*fieldsItem{{fieldsAudit: fieldsAudit{Created: "today"}, ID: 1, Name: "one", Price: 10}, nil}
+	})
+
+	for query, expected := range map[string]string{
+		"":                         `[{"created":"today","id":1,"name":"one","price":10},null]`,
+		"?fields=id,NAME":          `[{"id":1,"name":"one"},null]`,
+		"?fields=created&fields=x": `[{"created":"today"},null]`,
+		"?fields=":                 `[{},null]`,
+	} {
+		w := httptest.NewRecorder()
+		if err := by.Build().Handle(w, newGET(t, "http://localhost/items"+query)); err != nil {
+			t.Fatal(err)
+		}
+		if strings.TrimSpace(w.Body.String()) != expected {
+			t.Error(query, "unexpected body", w.Body.String())
+		}
+	}
+}
+
+func TestPropagateHeaders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Header.Get("X-Trace-Id"), "|", r.Header.Get("X-Tenant"), "|", r.Header.Get("Authorization"))
+	}))
+	defer server.Close()
+
+	endpoint := GET("/proxy").PropagateHeaders(nil, "x-trace-id", "X-Tenant").Handler(func(client *http.Client) (string, error) {
+		outbound, err := http.NewRequest(http.MethodGet, server.URL, nil)
+		if err != nil {
+			return "", err
+		}
+		outbound.Header.Set("X-Tenant", "outbound")
+		response, err := client.Do(outbound)
+		if err != nil {
+			return "", err
+		}
+		defer response.Body.Close()
+		body, err := io.ReadAll(response.Body)
+		return string(body), err
+	}).Build()
+
+	r := newGET(t, "http://localhost/proxy")
+	r.Header.Set("X-Trace-Id", "t1")
+	r.Header.Set("X-Tenant", "inbound")
+	r.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	if err := endpoint.Handle(w, r); err != nil {
+		t.Fatal(err)
+	}
+	if w.Body.String() != "t1|outbound|" {
+		t.Error("unexpected propagated headers", w.Body.String())
+	}
+}
+
+func TestPagination(t *testing.T) {
+	handler := func(query url.Values) PageOf[Key] {
+		if query.Get("cursor") == "2" {
+			return PageOf[Key]{Total: 3}
+		}
+		return PageOf[Key]{Items: []Key{{Value: "k1"}, {Value: "k2"}}, Total: 3, Next: "2"}
+	}
+
+	w := httptest.NewRecorder()
+	if err := GET("/keys").Encoder(JSONEncoder).Handler(handler).Build().Handle(w, newGET(t, "http://localhost/keys?limit=2")); err != nil {
+		t.Fatal(err)
+	}
+	if w.Header().Get("X-Total-Count") != "3" || w.Header().Get("Link") != `</keys?cursor=2&limit=2>; rel="next"` ||
+		w.Body.String() != `[{"Value":"k1","Part":0},{"Value":"k2","Part":0}]`+"\n" {
+		t.Error("unexpected first page", w.Header(), w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	by := GET("/keys").Encoder(JSONEncoder).Pagination(PaginationPolicy{TotalHeader: "X-Total", Envelope: true}).Handler(handler)
+	if err := by.Build().Handle(w, newGET(t, "http://localhost/keys?cursor=2")); err != nil {
+		t.Fatal(err)
+	}
+	if w.Header().Get("X-Total") != "3" || w.Header().Get("Link") != "" || w.Body.String() != `{"items":null,"total":3}`+"\n" {
+		t.Error("unexpected last page", w.Header(), w.Body.String())
+	}
+}
+
+func TestRedirect(t *testing.T) {
+	by := GET("/links/:id").Handler(func(id string) (Redirect, error) {
+		switch id {
+		case "moved":
+			return Redirect{URL: "https://example.com/moved", Status: http.StatusMovedPermanently}, nil
+		case "missing":
+			return Redirect{}, rateLimitedError{}
+		}
+		return Redirect{URL: "/links/moved"}, nil
+	})
+
+	for id, expected := range map[string]struct {
+		code     int
+		location string
+	}{
+		"moved":   {code: http.StatusMovedPermanently, location: "https://example.com/moved"},
+		"other":   {code: http.StatusFound, location: "/links/moved"},
+		"missing": {code: http.StatusTooManyRequests},
+	} {
+		w := httptest.NewRecorder()
+		if err := by.Build().Handle(w, newGET(t, "http://localhost/links/"+id)); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != expected.code || w.Header().Get("Location") != expected.location {
+			t.Error(id, "unexpected response", w.Code, w.Header())
+		}
+	}
+}
+
+type snapshotCredentials struct {
+	User     string
+	Password string `redact:"true"`
+	Tags     []int
+}
+
+func TestPanicParameterSnapshot(t *testing.T) {
+	var mapped error
+	by := POST("/users/:id").Decoder(JSONDecoder).Handler(func(id int, headers http.Header, credentials *snapshotCredentials) {
+		panic("boom")
+	}).ErrorMapping(func(err error, w http.ResponseWriter, r *http.Request) error {
+		mapped = err
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil
+	})
+
+	r := newRequest(t, http.MethodPost, "http://localhost/users/7", strings.NewReader(`{"User":"bob","Password":"secret","Tags":[1,2,3,4,5,6,7,8,9,10]}`))
+	r.Header.Set("Authorization", "Bearer secret")
+	if err := by.Build().Handle(httptest.NewRecorder(), r); err != nil {
+		t.Fatal(err)
+	}
+	var panicErr PanicError
+	if !errors.As(mapped, &panicErr) {
+		t.Fatalf("received: %#v", mapped)
+	}
+	expected := []ParameterSnapshot{
+		{Type: "int", Value: "7"},
+		{Type: "http.Header", Value: "Authorization"},
+		{Type: "*main.snapshotCredentials", Value: `{User:"bob" Password:[redacted] Tags:[1 2 3 4 5 6 7 8 …2 more]}`},
+	}
+	if !reflect.DeepEqual(panicErr.Parameters, expected) {
+		t.Errorf("unexpected snapshot: %#v", panicErr.Parameters)
+	}
+}
+
+func TestTempDirs(t *testing.T) {
+	parent := t.TempDir()
+	var dirs []TempDir
+	by := POST("/uploads/:name").TempDirs(parent).Handler(func(name string, dir TempDir, r *http.Request) error {
+		dirs = append(dirs, dir)
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(string(dir), name), data, 0o600); err != nil {
+			return err
+		}
+		if name == "panic" {
+			panic("boom")
+		}
+		return nil
+	})
+
+	for _, name := range []string{"ok", "panic"} {
+		w := httptest.NewRecorder()
+		if err := by.Build().Handle(w, newRequest(t, http.MethodPost, "http://localhost/uploads/"+name, strings.NewReader("data"))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(dirs) != 2 || dirs[0] == dirs[1] || filepath.Dir(string(dirs[0])) != parent {
+		t.Fatal("unexpected directories", dirs)
+	}
+	if entries, err := os.ReadDir(parent); err != nil || len(entries) != 0 {
+		t.Error("directories are not removed", entries, err)
+	}
+}
+
+func TestPool(t *testing.T) {
+	pool := NewWorkerPool(1, 0, RejectOverflow)
+	defer pool.Close()
+	started, release := make(chan struct{}), make(chan struct{})
+	endpoint := GET("/reports/:id").Pool(pool).Handler(func(id string) string {
+		if id == "slow" {
+			close(started)
+			<-release
+		}
+		return id
+	}).Build()
+
+	slow := make(chan *httptest.ResponseRecorder)
+	go func() {
+		w := httptest.NewRecorder()
+		_ = endpoint.Handle(w, newGET(t, "http://localhost/reports/slow"))
+		slow <- w
+	}()
+	<-started
+
+	w := httptest.NewRecorder()
+	if err := endpoint.Handle(w, newGET(t, "http://localhost/reports/fast")); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusServiceUnavailable {
+		t.Error("overflow is not rejected", w.Code, w.Body.String())
+	}
+
+	close(release)
+	if w := <-slow; w.Code != http.StatusOK || w.Body.String() != "slow" {
+		t.Error("unexpected response", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	if err := endpoint.Handle(w, newGET(t, "http://localhost/reports/fast")); err != nil || w.Body.String() != "fast" {
+		t.Error("unexpected response", w.Code, w.Body.String(), err)
+	}
+
+	pool.Close()
+	w = httptest.NewRecorder()
+	if err := endpoint.Handle(w, newGET(t, "http://localhost/reports/fast")); err != nil || w.Code != http.StatusServiceUnavailable {
+		t.Error("handler is queued to the closed pool", w.Code, w.Body.String(), err)
+	}
+}
+
+func TestMultipartResponse(t *testing.T) {
+	by := GET("/documents/:id").Handler(func(id string) MultipartResponse {
+		return MultipartResponse{Parts: []Part{
+			{Name: "metadata", Body: Key{Value: id}},
+			{Name: "document", Filename: id + ".txt", Body: io.NopCloser(strings.NewReader("content"))},
+			{Header: http.Header{"Content-Type": {"text/markdown"}}, Body: "# notes"},
+		}}
+	})
+
+	w := httptest.NewRecorder()
+	if err := by.Build().Handle(w, newGET(t, "http://localhost/documents/d1")); err != nil {
+		t.Fatal(err)
+	}
+	mediaType, params, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatal("unexpected content type", w.Header(), err)
+	}
+	reader := multipart.NewReader(w.Body, params["boundary"])
+	for _, expected := range []struct {
+		disposition string
+		contentType string
+		body        string
+	}{
+		{disposition: `inline; name=metadata`, contentType: "application/json", body: `{"Value":"d1","Part":0}` + "\n"},
+		{disposition: `attachment; filename=d1.txt; name=document`, contentType: "application/octet-stream", body: "content"},
+		{contentType: "text/markdown", body: "# notes"},
+	} {
+		part, err := reader.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(part)
+		if part.Header.Get("Content-Disposition") != expected.disposition || part.Header.Get("Content-Type") != expected.contentType || string(body) != expected.body {
+			t.Error("unexpected part", part.Header, string(body))
+		}
+	}
+	if _, err := reader.NextPart(); err != io.EOF {
+		t.Error("unexpected parts after last one", err)
+	}
+}
+
+func TestRetryHints(t *testing.T) {
+	by := GET("/jobs/:id").Timeout(1500 * time.Millisecond).RetryHints(RetryHints{
+		RetryAfter:    10 * time.Second,
+		PollAfter:     2 * time.Second,
+		KeepAlive:     5 * time.Second,
+		TimeoutHeader: "X-Request-Timeout",
+	}).Handler(func(id string) (int, error) {
+		switch id {
+		case "accepted":
+			return http.StatusAccepted, nil
+		case "limited":
+			return 0, rateLimitedError{}
+		case "unavailable":
+			return http.StatusServiceUnavailable, nil
+		}
+		return http.StatusOK, nil
+	})
+
+	for id, expected := range map[string]http.Header{
+		"accepted":    {"Retry-After": {"2"}, "Keep-Alive": {"timeout=5"}, "X-Request-Timeout": {"2"}},
+		"limited":     {"Retry-After": {"30"}, "Keep-Alive": {"timeout=5"}, "X-Request-Timeout": {"2"}},
+		"unavailable": {"Retry-After": {"10"}, "Keep-Alive": {"timeout=5"}, "X-Request-Timeout": {"2"}},
+		"done":        {},
+	} {
+		w := httptest.NewRecorder()
+		if err := by.Build().Handle(w, newGET(t, "http://localhost/jobs/"+id)); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"Retry-After", "Keep-Alive", "X-Request-Timeout"} {
+			if w.Header().Get(name) != expected.Get(name) {
+				t.Error(id, "unexpected header", name, w.Header().Get(name))
+			}
+		}
+	}
+}
+
+type principal struct {
+	Name string
+}
+
+func TestBeforeInterceptors(t *testing.T) {
+	endpoint := GET("/accounts/:id").Before(func(w http.ResponseWriter, r *http.Request) bool {
+		if r.URL.Query().Get("maintenance") != "" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return false
+		}
+		return true
+	}).Before(func(id string, headers http.Header) (principal, error) {
+		if headers.Get("X-User") == "" {
+			return principal{}, rateLimitedError{}
+		}
+		return principal{Name: headers.Get("X-User") + "@" + id}, nil
+	}).Handler(func(id string, user principal) string {
+		return id + ":" + user.Name
+	}).Build()
+
+	for target, expected := range map[string]int{
+		"http://localhost/accounts/7":               http.StatusOK,
+		"http://localhost/accounts/7?anonymous=1":   http.StatusTooManyRequests,
+		"http://localhost/accounts/7?maintenance=1": http.StatusServiceUnavailable,
+	} {
+		r := newGET(t, target)
+		if r.URL.Query().Get("anonymous") == "" {
+			r.Header.Set("X-User", "ann")
+		}
+		w := httptest.NewRecorder()
+		if err := endpoint.Handle(w, r); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != expected {
+			t.Error(target, "unexpected status", w.Code)
+		}
+		if expected == http.StatusOK && !strings.Contains(w.Body.String(), "7:ann@7") {
+			t.Error("unexpected body", w.Body.String())
+		}
+	}
+
+	invalid := GET("/accounts").Before(func(body principal) error { return nil }).
+		Handler(func(body principal) string { return body.Name }).Build()
+	if err := invalid.Handle(httptest.NewRecorder(), newGET(t, "http://localhost/accounts")); err == nil {
+		t.Error("expected error of body bound twice")
+	}
+}
+
+func TestByteArrayPathParameters(t *testing.T) {
+	type digest [4]byte
+	hexEndpoint := GET("/blobs/:digest").ByteEncoding(HexBytes).Handler(func(d digest) string {
+		return fmt.Sprint(d[0], d[3])
+	}).Build()
+	rawEndpoint := GET("/codes/:code").Handler(func(code [3]byte) string { return string(code[:]) }).Build()
+
+	for _, tc := range []struct {
+		endpoint EndpointProcessor
+		target   string
+		expected int
+		body     string
+	}{
+		{endpoint: hexEndpoint, target: "http://localhost/blobs/0A0b0C0f", expected: http.StatusOK, body: "10 15"},
+		{endpoint: hexEndpoint, target: "http://localhost/blobs/0a0b0c", expected: http.StatusBadRequest},
+		{endpoint: hexEndpoint, target: "http://localhost/blobs/zz0b0c0d", expected: http.StatusBadRequest},
+		{endpoint: rawEndpoint, target: "http://localhost/codes/abc", expected: http.StatusOK, body: "abc"},
+		{endpoint: rawEndpoint, target: "http://localhost/codes/ab", expected: http.StatusBadRequest},
+		{endpoint: rawEndpoint, target: "http://localhost/codes/%C3%A9%C3%A9", expected: http.StatusBadRequest},
+	} {
+		w := httptest.NewRecorder()
+		if err := tc.endpoint.Handle(w, newGET(t, tc.target)); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != tc.expected || !strings.Contains(w.Body.String(), tc.body) {
+			t.Error(tc.target, "unexpected response", w.Code, w.Body.String())
+		}
+	}
+
+	var query struct {
+		Token [6]byte `query:"token" layout:"base64"`
+	}
+	endpoint := GET("/tokens").Handler(func(q struct {
+		Token [6]byte `query:"token" layout:"base64"`
+	}) string {
+		query = q
+		return "ok"
+	}).Build()
+	w := httptest.NewRecorder()
+	if err := endpoint.Handle(w, newGET(t, "http://localhost/tokens?token=-_-_-_-_")); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || query.Token != [6]byte{0xfb, 0xff, 0xbf, 0xfb, 0xff, 0xbf} {
+		t.Error("unexpected token", w.Code, query.Token)
+	}
+}
+
+func TestAfterInterceptors(t *testing.T) {
+	type order struct {
+		ID    string
+		Total int
+	}
+	endpoint := GET("/orders/:id").Encoder(JSONEncoder).Handler(func(id string) (order, int, error) {
+		if id == "limited" {
+			return order{}, 0, rateLimitedError{}
+		}
+		return order{ID: id, Total: 10}, http.StatusOK, nil
+	}).After(func(o order, status int, err error, headers http.Header) (order, int) {
+		if err != nil {
+			return o, status
+		}
+		headers.Set("X-Order", o.ID)
+		o.Total *= 2
+		return o, http.StatusAccepted
+	}).After(func(o order) error {
+		if o.ID == "void" {
+			return rateLimitedError{}
+		}
+		return nil
+	}).After(func(w http.ResponseWriter, r *http.Request) bool {
+		if r.URL.Query().Get("skip") != "" {
+			w.WriteHeader(http.StatusNoContent)
+			return false
+		}
+		return true
+	}).Build()
+
+	for target, expected := range map[string]int{
+		"http://localhost/orders/7":        http.StatusAccepted,
+		"http://localhost/orders/limited":  http.StatusTooManyRequests,
+		"http://localhost/orders/void":     http.StatusTooManyRequests,
+		"http://localhost/orders/7?skip=1": http.StatusNoContent,
+	} {
+		w := httptest.NewRecorder()
+		if err := endpoint.Handle(w, newGET(t, target)); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != expected {
+			t.Error(target, "unexpected status", w.Code)
+		}
+		if expected == http.StatusAccepted && (w.Header().Get("X-Order") != "7" || !strings.Contains(w.Body.String(), `"Total":20`)) {
+			t.Error("unexpected response", w.Header(), w.Body.String())
+		}
+	}
+
+	invalid := GET("/orders").Handler(func() order { return order{} }).After(func(status int) {}).Build()
+	if err := invalid.Handle(httptest.NewRecorder(), newGET(t, "http://localhost/orders")); err == nil {
+		t.Error("expected error of unknown handler result")
+	}
+}
+
+func TestIntegerRange(t *testing.T) {
+	endpoint := GET("/shards/:shard/items/:id").Handler(func(shard int8, id int, q struct {
+		Limit uint8 `query:"limit"`
+	}) string {
+		return fmt.Sprint(shard, id, q.Limit)
+	}).Build()
+
+	for target, expected := range map[string]string{
+		"http://localhost/shards/1/items/1099511627776?limit=5": "1 1099511627776 5",
+		"http://localhost/shards/128/items/1":                   "parameter shard: value 128 is out of range [-128, 127]",
+		"http://localhost/shards/1/items/1?limit=256":           "parameter limit: value 256 is out of range [0, 255]",
+	} {
+		w := httptest.NewRecorder()
+		if err := endpoint.Handle(w, newGET(t, target)); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(w.Body.String(), expected) {
+			t.Error(target, "unexpected response", w.Code, w.Body.String())
+		}
+		if strings.Contains(expected, "range") && w.Code != http.StatusBadRequest {
+			t.Error(target, "unexpected status", w.Code)
+		}
+	}
+}
+
+type tenantID string
+
+func TestProvide(t *testing.T) {
+	endpoint := GET("/projects").Provides(reflect.TypeOf(tenantID(""))).Before(func(w http.ResponseWriter, r *http.Request) bool {
+		return Provide(r, tenantID(r.Header.Get("X-Tenant")))
+	}).Before(func(tenant tenantID) principal {
+		return principal{Name: "admin@" + string(tenant)}
+	}).Handler(func(tenant tenantID, user principal) string {
+		return string(tenant) + ":" + user.Name
+	}).Build()
+
+	r := newGET(t, "http://localhost/projects")
+	r.Header.Set("X-Tenant", "acme")
+	w := httptest.NewRecorder()
+	if err := endpoint.Handle(w, r); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(w.Body.String(), "acme:admin@acme") {
+		t.Error("unexpected body", w.Code, w.Body.String())
+	}
+
+	misordered := GET("/projects").Before(func(user principal) error { return nil }).
+		Before(func() principal { return principal{} }).
+		Handler(func(user principal) string { return user.Name }).Build()
+	if err := misordered.Handle(httptest.NewRecorder(), newGET(t, "http://localhost/projects")); err == nil {
+		t.Error("expected error of value provided after it is requested")
+	}
+}
+
+func TestAuthInterceptors(t *testing.T) {
+	verifyBasic := func(ctx context.Context, username, password string) (principal, error) {
+		switch {
+		case password != "secret":
+			return principal{}, ErrInvalidCredentials
+		case username == "guest":
+			return principal{}, ErrForbidden
+		}
+		return principal{Name: username}, nil
+	}
+	verifyKey := func(ctx context.Context, key string) (principal, error) {
+		if key != "k1" {
+			return principal{}, ErrInvalidCredentials
+		}
+		return principal{Name: "service"}, nil
+	}
+	basic := GET("/me").Before(BasicAuth("api", verifyBasic)).Handler(func(user principal) string { return user.Name }).Build()
+	apiKey := GET("/me").Before(APIKeyAuth(APIKey{Query: "api_key"}, verifyKey)).Handler(func(user principal) string { return user.Name }).Build()
+
+	for _, tc := range []struct {
+		endpoint           EndpointProcessor
+		target             string
+		username, password string
+		status             int
+		body               string
+		challenge          string
+	}{
+		{endpoint: basic, target: "http://localhost/me", username: "ann", password: "secret", status: http.StatusOK, body: "ann"},
+		{endpoint: basic, target: "http://localhost/me", username: "ann", password: "wrong", status: http.StatusUnauthorized, challenge: `Basic realm="api", charset="UTF-8"`},
+		{endpoint: basic, target: "http://localhost/me", username: "guest", password: "secret", status: http.StatusForbidden},
+		{endpoint: basic, target: "http://localhost/me", status: http.StatusUnauthorized, challenge: `Basic realm="api", charset="UTF-8"`},
+		{endpoint: apiKey, target: "http://localhost/me?api_key=k1", status: http.StatusOK, body: "service"},
+		{endpoint: apiKey, target: "http://localhost/me?api_key=k2", status: http.StatusUnauthorized},
+	} {
+		r := newGET(t, tc.target)
+		if tc.username != "" {
+			r.SetBasicAuth(tc.username, tc.password)
+		}
+		w := httptest.NewRecorder()
+		if err := tc.endpoint.Handle(w, r); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != tc.status || !strings.Contains(w.Body.String(), tc.body) {
+			t.Error(tc.target, tc.username, "unexpected response", w.Code, w.Body.String())
+		}
+		if w.Header().Get("WWW-Authenticate") != tc.challenge {
+			t.Error("unexpected challenge", w.Header().Get("WWW-Authenticate"))
 		}
 	}
 }
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CachedResponse is a successful response stored in ResponseCache until it expires.
+type CachedResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	StoredAt   time.Time
+	Expires    time.Time
+}
+
+// ResponseCache stores responses of GET routes by keys. Implementations must be safe for concurrent use.
+type ResponseCache interface {
+	// Get returns the response stored with the key, expired ones are ignored by endpoints.
+	Get(key string) (CachedResponse, bool)
+	// Set stores the response with the key.
+	Set(key string, response CachedResponse)
+	// Invalidate removes responses having keys starting with the prefix.
+	Invalidate(prefix string)
+}
+
+// Default limits of MemoryResponseCache.
+const (
+	defaultResponseCacheEntries = 10000
+	defaultResponseCacheBytes   = 64 << 20
+)
+
+// MemoryResponseCache is ResponseCache keeping responses in memory up to the max number of entries and their total
+// size. Least recently used responses are evicted first when a limit is exceeded, expired ones are eventually
+// replaced or evicted.
+type MemoryResponseCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	maxBytes   int64
+	size       int64
+	// recent orders *cachedEntry from the most recently used one.
+	recent    *list.List
+	responses map[string]*list.Element
+}
+
+type cachedEntry struct {
+	key      string
+	response CachedResponse
+	size     int64
+}
+
+// NewMemoryResponseCache creates the cache limited to 10000 responses and 64 MiB.
+func NewMemoryResponseCache() *MemoryResponseCache {
+	return NewBoundedMemoryResponseCache(defaultResponseCacheEntries, defaultResponseCacheBytes)
+}
+
+// NewBoundedMemoryResponseCache creates the cache limited to maxEntries responses with total size of keys, headers
+// and bodies up to maxBytes. Responses bigger than maxBytes are not stored.
+func NewBoundedMemoryResponseCache(maxEntries int, maxBytes int64) *MemoryResponseCache {
+	return &MemoryResponseCache{maxEntries: maxEntries, maxBytes: maxBytes, recent: list.New(), responses: map[string]*list.Element{}}
+}
+
+func (mrc *MemoryResponseCache) Get(key string) (CachedResponse, bool) {
+	mrc.mu.Lock()
+	defer mrc.mu.Unlock()
+	element, found := mrc.responses[key]
+	if !found {
+		return CachedResponse{}, false
+	}
+	mrc.recent.MoveToFront(element)
+	return element.Value.(*cachedEntry).response, true
+}
+
+func (mrc *MemoryResponseCache) Set(key string, response CachedResponse) {
+	mrc.mu.Lock()
+	defer mrc.mu.Unlock()
+	if element, found := mrc.responses[key]; found {
+		mrc.remove(element)
+	}
+	entry := &cachedEntry{key: key, response: response, size: cachedSize(key, response)}
+	if entry.size > mrc.maxBytes {
+		return
+	}
+	mrc.responses[key] = mrc.recent.PushFront(entry)
+	mrc.size += entry.size
+	for mrc.recent.Len() > mrc.maxEntries || mrc.size > mrc.maxBytes {
+		mrc.remove(mrc.recent.Back())
+	}
+}
+
+func (mrc *MemoryResponseCache) Invalidate(prefix string) {
+	mrc.mu.Lock()
+	defer mrc.mu.Unlock()
+	for key, element := range mrc.responses {
+		if strings.HasPrefix(key, prefix) {
+			mrc.remove(element)
+		}
+	}
+}
+
+func (mrc *MemoryResponseCache) remove(element *list.Element) {
+	entry := mrc.recent.Remove(element).(*cachedEntry)
+	delete(mrc.responses, entry.key)
+	mrc.size -= entry.size
+}
+
+// cachedSize approximates memory taken by the response stored with the key.
+func cachedSize(key string, response CachedResponse) int64 {
+	size := len(key) + len(response.Body)
+	for name, values := range response.Header {
+		size += len(name)
+		for _, value := range values {
+			size += len(value)
+		}
+	}
+	return int64(size)
+}
+
+// CachePolicy configures caching of responses of GET route, HEAD requests are answered from the same responses.
+// Only responses with 200 status code, without cookies and not marked private or no-store by Cache-Control
+// are stored. Requests with Authorization or Cookie headers bypass the cache unless it is Credentialed.
+type CachePolicy struct {
+	Store ResponseCache
+	TTL   time.Duration
+	// Credentialed caches responses to requests with credentials, Key or Vary must distinguish users then,
+	// e.g. Vary: []string{"Authorization"}.
+	Credentialed bool
+	// Vary lists request headers the response depends on besides Accept, their values are part of the key.
+	Vary []string
+	// Key derives the key of the request instead of CacheKey, paths of such responses couldn't be invalidated.
+	Key func(r *http.Request) string
+	// MaxBodyBytes limits size of stored bodies, bigger responses are passed through without being recorded
+	// any further, 1 MiB by default.
+	MaxBodyBytes int64
+}
+
+// defaultCachedBodyBytes limits bodies of cached responses when CachePolicy doesn't set MaxBodyBytes.
+const defaultCachedBodyBytes = 1 << 20
+
+// CacheKey is a key of the response to the request: method, path, sorted query and values of Accept
+// and the varying headers.
+func CacheKey(r *http.Request, vary ...string) string {
+	var key strings.Builder
+	key.WriteString(cachePathPrefix(r.URL.Path))
+	key.WriteString(r.URL.Query().Encode())
+	for _, name := range append([]string{"Accept"}, vary...) {
+		key.WriteString("\n" + http.CanonicalHeaderKey(name) + ": " + strings.Join(r.Header.Values(name), ","))
+	}
+	return key.String()
+}
+
+// cachePathPrefix is a prefix of keys of all responses of the path.
+func cachePathPrefix(path string) string {
+	return http.MethodGet + " " + path + "?"
+}
+
+// Cache stores responses of the route in the store of the policy for its TTL. Responses are served from the cache
+// after preconditions of the route are checked, but before parameters are bound and the handler is invoked.
+func (b builder) Cache(policy CachePolicy) Builder {
+	cloned := b.clone()
+	cloned.cachePolicy = policy
+	return cloned
+}
+
+// Invalidates removes cached responses of paths returned by paths function from the store, once the route
+// responds with status code below 400. Responses of the request path are removed if paths is nil.
+func (b builder) Invalidates(store ResponseCache, paths func(r *http.Request) []string) Builder {
+	cloned := b.clone()
+	if paths == nil {
+		paths = func(r *http.Request) []string { return []string{r.URL.Path} }
+	}
+	cloned.invalidations = append(cloned.invalidations, cacheInvalidation{store: store, paths: paths})
+	return cloned
+}
+
+type cacheInvalidation struct {
+	store ResponseCache
+	paths func(r *http.Request) []string
+}
+
+func (b *builder) buildCachePolicy() CachePolicy {
+	policy := b.cachePolicy
+	if policy.Store == nil || policy.TTL <= 0 || b.method != http.MethodGet {
+		return CachePolicy{}
+	}
+	if policy.Key == nil {
+		vary := policy.Vary
+		policy.Key = func(r *http.Request) string { return CacheKey(r, vary...) }
+	}
+	if policy.MaxBodyBytes <= 0 {
+		policy.MaxBodyBytes = defaultCachedBodyBytes
+	}
+	return policy
+}
+
+// cacheable reports if the response to the request could be served from and stored in the cache.
+func (policy CachePolicy) cacheable(r *http.Request) bool {
+	return policy.Credentialed || r.Header.Get("Authorization") == "" && r.Header.Get("Cookie") == ""
+}
+
+// serveCached writes the cached response if it is not expired, or returns writer storing the response.
+// Headers set before the endpoint, e.g. by CORS, are not stored, cached headers are merged into them.
+func (ep EndpointProcessor) serveCached(w http.ResponseWriter, r *http.Request) (*cacheResponseWriter, bool) {
+	key := ep.cache.Key(r)
+	now := ep.clock.Now()
+	if cached, found := ep.cache.Store.Get(key); found && now.Before(cached.Expires) {
+		mergeHeader(w.Header(), cached.Header)
+		w.Header().Set("Age", strconv.FormatInt(int64(now.Sub(cached.StoredAt)/time.Second), 10))
+		w.WriteHeader(cached.StatusCode)
+		_, _ = w.Write(cached.Body)
+		return nil, true
+	}
+	return &cacheResponseWriter{ResponseWriter: w, key: key, recordBody: true, maxBody: ep.cache.MaxBodyBytes, before: w.Header().Clone()}, false
+}
+
+// store keeps the recorded response unless it failed or must not be shared.
+func (ep EndpointProcessor) store(recorder *cacheResponseWriter, err error) {
+	if err != nil || recorder.statusCode != http.StatusOK || recorder.overflowed || recorder.header.Get("Set-Cookie") != "" ||
+		!sharedCacheable(recorder.header) {
+		return
+	}
+	now := ep.clock.Now()
+	ep.cache.Store.Set(recorder.key, CachedResponse{
+		StatusCode: recorder.statusCode,
+		Header:     headerSince(recorder.before, recorder.header),
+		Body:       recorder.body.Bytes(),
+		StoredAt:   now,
+		Expires:    now.Add(ep.cache.TTL),
+	})
+}
+
+// sharedCacheable reports if Cache-Control of the response allows storing it for other requests.
+func sharedCacheable(header http.Header) bool {
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			name, _, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if strings.EqualFold(name, "private") || strings.EqualFold(name, "no-store") {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// headerSince returns values of the header added after the snapshot taken before the endpoint handled the request.
+func headerSince(before, header http.Header) http.Header {
+	added := http.Header{}
+	for name, values := range header {
+		prior := before[name]
+		if len(prior) <= len(values) && equalValues(prior, values[:len(prior)]) {
+			values = values[len(prior):]
+		}
+		if len(values) > 0 {
+			added[name] = append([]string(nil), values...)
+		}
+	}
+	return added
+}
+
+func equalValues(a, b []string) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// invalidate removes cached responses of paths affected by the successful request.
+func (ep EndpointProcessor) invalidate(recorder *cacheResponseWriter, r *http.Request, err error) {
+	if err != nil || recorder.statusCode == 0 || recorder.statusCode >= http.StatusBadRequest {
+		return
+	}
+	for _, invalidation := range ep.invalidations {
+		for _, path := range invalidation.paths(r) {
+			invalidation.store.Invalidate(cachePathPrefix(path))
+		}
+	}
+}
+
+// cacheResponseWriter records status code, header and, if asked, the body of the response passed through
+// up to maxBody bytes.
+type cacheResponseWriter struct {
+	http.ResponseWriter
+	key        string
+	recordBody bool
+	maxBody    int64
+	overflowed bool
+	before     http.Header
+	statusCode int
+	header     http.Header
+	body       bytes.Buffer
+}
+
+func (crw *cacheResponseWriter) WriteHeader(statusCode int) {
+	if crw.statusCode == 0 && statusCode >= http.StatusOK {
+		crw.statusCode = statusCode
+		crw.header = crw.Header().Clone()
+	}
+	crw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (crw *cacheResponseWriter) Write(data []byte) (int, error) {
+	if crw.statusCode == 0 {
+		crw.WriteHeader(http.StatusOK)
+	}
+	if crw.recordBody && int64(crw.body.Len()+len(data)) > crw.maxBody {
+		crw.recordBody, crw.overflowed = false, true
+		crw.body = bytes.Buffer{}
+	}
+	if crw.recordBody {
+		crw.body.Write(data)
+	}
+	return crw.ResponseWriter.Write(data)
+}
+
+func (crw *cacheResponseWriter) Flush() {
+	if flusher, ok := crw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (crw *cacheResponseWriter) Unwrap() http.ResponseWriter {
+	return crw.ResponseWriter
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Kinds of issues of endpoints reported by Router.Check.
+const (
+	MappingIssue             = "mapping"
+	UnreachableIssue         = "unreachable"
+	ShadowedIssue            = "shadowed"
+	UnusedPathParameterIssue = "unused path parameter"
+	// Deprecated: response bodies which are not written without encoder are rejected by Build.
+	MissingEncoderIssue = "missing encoder"
+)
+
+// EndpointIssue is a problem of the endpoint which doesn't prevent its registration, but likely is a mistake.
+type EndpointIssue struct {
+	Kind    string
+	Message string
+}
+
+// EndpointReport lists issues of the registered endpoint.
+type EndpointReport struct {
+	Route  RouteInfo
+	Issues []EndpointIssue
+}
+
+// Check reports issues of registered endpoints in order of registration, endpoints without issues are omitted.
+// It is meant to be called before the start of the server or in tests, e.g. to fail on any reported issue.
+func (rt *Router) Check() []EndpointReport {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+	issues := map[*EndpointProcessor][]EndpointIssue{}
+	for _, endpoint := range rt.endpoints {
+		for _, err := range endpoint.errors {
+			issues[endpoint] = append(issues[endpoint], EndpointIssue{Kind: MappingIssue, Message: err.Error()})
+		}
+		issues[endpoint] = append(issues[endpoint], endpoint.issues...)
+	}
+	rt.root.each(func(node *routeNode) {
+		for endpoint, shadowedBy := range node.shadowed() {
+			issues[endpoint] = append(issues[endpoint], EndpointIssue{
+				Kind:    ShadowedIssue,
+				Message: fmt.Sprintf("requests are handled by %s %s instead", shadowedBy.route.Method, shadowedBy.route.Template),
+			})
+		}
+	})
+
+	var reports []EndpointReport
+	for _, endpoint := range rt.endpoints {
+		if len(issues[endpoint]) > 0 {
+			reports = append(reports, EndpointReport{Route: endpoint.route, Issues: issues[endpoint]})
+		}
+	}
+	return reports
+}
+
+func (n *routeNode) each(visit func(node *routeNode)) {
+	visit(n)
+	for _, child := range n.static {
+		child.each(visit)
+	}
+	if n.parameter != nil {
+		n.parameter.each(visit)
+	}
+}
+
+// shadowed returns endpoints of the node which never handle requests with the endpoints preventing it.
+// Endpoints with the same method and query conditions are tried in order of registration, so the one without
+// constraints shadows following ones, and the last of ones without query conditions is a fallback.
+func (n *routeNode) shadowed() map[*EndpointProcessor]*EndpointProcessor {
+	shadowed := map[*EndpointProcessor]*EndpointProcessor{}
+	for i, endpoint := range n.endpoints {
+		for _, following := range n.endpoints[i+1:] {
+			if following.route.Method != endpoint.route.Method || !sameQueryConditions(following.queryConditions, endpoint.queryConditions) {
+				continue
+			}
+			switch {
+			case len(endpoint.queryConditions) > 0 && len(endpoint.constraints) == 0:
+				shadowed[following] = endpoint
+			case len(endpoint.queryConditions) == 0 && len(following.constraints) == 0:
+				shadowed[endpoint] = following
+			}
+		}
+	}
+	return shadowed
+}
+
+// buildIssues returns issues of the endpoint detectable on build.
+func (b *builder) buildIssues() []EndpointIssue {
+	var issues []EndpointIssue
+	names := map[string]bool{}
+	for _, segment := range strings.Split(b.pathTemplate, pathSeparator) {
+		if strings.HasPrefix(segment, pathParameterPrefix) {
+			name := segment[len(pathParameterPrefix):]
+			if name != "" && names[name] {
+				issues = append(issues, EndpointIssue{Kind: UnusedPathParameterIssue, Message: fmt.Sprintf("path parameter %q is repeated, constraints apply to the first one only", name)})
+			}
+			names[name] = true
+			continue
+		}
+		if escaped := (&url.URL{Path: segment}).EscapedPath(); escaped != segment {
+			// request paths are matched in escaped form
+			issues = append(issues, EndpointIssue{Kind: UnreachableIssue, Message: fmt.Sprintf("path segment %q is never matched, it must be escaped as %q", segment, escaped)})
+		}
+	}
+	return issues
+}
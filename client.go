@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ClientError is returned by client functions when the server responds with status code other than 2xx.
+type ClientError struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+func (e ClientError) Error() string {
+	body := strings.TrimSpace(string(e.Body))
+	if len(body) > 256 {
+		body = body[:256] + "…"
+	}
+	return fmt.Sprintf("response %d %s: %s", e.Status, http.StatusText(e.Status), body)
+}
+
+// StatusCode makes the error mapped with the status code of the response if it is returned by a handler.
+func (e ClientError) StatusCode() int {
+	return e.Status
+}
+
+// Client sets the function pointed by function to a client calling the route at baseURL with httpClient,
+// http.DefaultClient if it is nil. The function optionally accepts context.Context first, then values of path
+// parameters in order of the template, and then any of http.Header, url.Values and the body encoded with
+// the decoder of the route, which must be a Codec. It returns the body decoded with the encoder of the route,
+// which must be a Codec too, if it is declared, and error, e.g. ClientError for responses with status other than 2xx:
+//
+//	var update func(ctx context.Context, assortment string, id int, filter Filter) (Filter, error)
+//	err := PUT("/:assortment/filters/:id").Codec(JSON).Client("http://localhost:8080", nil, &update)
+func (b builder) Client(baseURL string, httpClient *http.Client, function interface{}) error {
+	target := reflect.ValueOf(function)
+	if target.Kind() != reflect.Ptr || target.Elem().Kind() != reflect.Func {
+		return InvalidMappingError(fmt.Errorf("client function must be a pointer to function, got %T", function))
+	}
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	call, err := b.buildClient(strings.TrimSuffix(baseURL, pathSeparator), httpClient, target.Elem().Type())
+	if err != nil {
+		return err
+	}
+	target.Elem().Set(reflect.MakeFunc(target.Elem().Type(), call))
+	return nil
+}
+
+// clientRequest describes positions of arguments of the client function, -1 if they are not accepted.
+type clientRequest struct {
+	context int
+	header  int
+	query   int
+	body    int
+}
+
+func (b *builder) buildClient(baseURL string, httpClient *http.Client, functionType reflect.Type) (func(args []reflect.Value) []reflect.Value, error) {
+	if functionType.NumOut() == 0 || functionType.NumOut() > 2 || functionType.Out(functionType.NumOut()-1) != errorType {
+		return nil, InvalidMappingError(fmt.Errorf("client function %s must return error and optionally the body before it", functionType))
+	}
+	request := clientRequest{context: -1, header: -1, query: -1, body: -1}
+	next := 0
+	if functionType.NumIn() > 0 && functionType.In(0) == contextType {
+		request.context = 0
+		next = 1
+	}
+	if functionType.NumIn() < next+b.pathParamsAmount {
+		return nil, InvalidMappingError(fmt.Errorf("client function %s has less than %d path parameters", functionType, b.pathParamsAmount))
+	}
+	pathStart := next
+	for i := next + b.pathParamsAmount; i < functionType.NumIn(); i++ {
+		position := &request.body
+		switch functionType.In(i) {
+		case headersType:
+			position = &request.header
+		case urlQueryType:
+			position = &request.query
+		}
+		if *position >= 0 {
+			return nil, InvalidMappingError(fmt.Errorf("client function %s has repeated parameter of type %s", functionType, functionType.In(i)))
+		}
+		*position = i
+	}
+
+	var requestCodec Codec
+	if request.body >= 0 {
+		if requestCodec = b.requestCodec(); requestCodec == nil {
+			return nil, InvalidMappingError(errors.New("client of the route with body requires decoder implementing Codec"))
+		}
+	}
+	var responseType reflect.Type
+	var responseCodec Codec
+	if functionType.NumOut() == 2 {
+		responseType = functionType.Out(0)
+		if responseCodec = b.responseCodec(); responseCodec == nil {
+			return nil, InvalidMappingError(errors.New("client of the route with response body requires encoder implementing Codec"))
+		}
+	}
+
+	segments := strings.Split(b.pathTemplate, pathSeparator)
+	method := b.method
+	return func(args []reflect.Value) []reflect.Value {
+		results := make([]reflect.Value, functionType.NumOut())
+		if responseType != nil {
+			results[0] = reflect.Zero(responseType)
+		}
+		fail := func(err error) []reflect.Value {
+			results[len(results)-1] = reflect.ValueOf(&err).Elem()
+			return results
+		}
+
+		ctx := context.Background()
+		if request.context >= 0 && !args[request.context].IsNil() {
+			ctx = args[request.context].Interface().(context.Context)
+		}
+		path := make([]string, len(segments))
+		param := pathStart
+		for i, segment := range segments {
+			if !strings.HasPrefix(segment, pathParameterPrefix) {
+				path[i] = segment
+				continue
+			}
+			value, err := formatPathValue(args[param])
+			if err != nil {
+				return fail(err)
+			}
+			path[i] = url.PathEscape(value)
+			param++
+		}
+		target := baseURL + strings.Join(path, pathSeparator)
+		if request.query >= 0 {
+			if query := args[request.query].Interface().(url.Values); len(query) > 0 {
+				target += "?" + query.Encode()
+			}
+		}
+
+		var body io.Reader
+		if request.body >= 0 {
+			var buffer bytes.Buffer
+			if err := encode(requestCodec, &buffer, args[request.body].Interface()); err != nil {
+				return fail(err)
+			}
+			body = &buffer
+		}
+		r, err := http.NewRequestWithContext(ctx, method, target, body)
+		if err != nil {
+			return fail(err)
+		}
+		if request.header >= 0 {
+			for name, values := range args[request.header].Interface().(http.Header) {
+				r.Header[name] = append([]string(nil), values...)
+			}
+		}
+		if requestCodec != nil && requestCodec.MediaType() != "" {
+			r.Header.Set("Content-Type", requestCodec.MediaType())
+		}
+		if responseCodec != nil && responseCodec.MediaType() != "" && r.Header.Get("Accept") == "" {
+			r.Header.Set("Accept", responseCodec.MediaType())
+		}
+
+		response, err := httpClient.Do(r)
+		if err != nil {
+			return fail(err)
+		}
+		defer response.Body.Close()
+		if response.StatusCode < 200 || response.StatusCode > 299 {
+			data, _ := io.ReadAll(io.LimitReader(response.Body, 64<<10))
+			return fail(ClientError{Status: response.StatusCode, Header: response.Header, Body: data})
+		}
+		if responseType != nil {
+			decoded := reflect.New(responseType)
+			if err := responseCodec.NewDecodeStream(response.Body).Decode(decoded.Interface()); err != nil && err != io.EOF {
+				return fail(err)
+			}
+			results[0] = decoded.Elem()
+		}
+		results[len(results)-1] = reflect.Zero(errorType)
+		return results
+	}, nil
+}
+
+// requestCodec returns codec encoding bodies decoded by the route, the default decoder is preferred.
+func (b *builder) requestCodec() Codec {
+	if codec, ok := b.decoder.(Codec); ok {
+		return codec
+	}
+	mediaTypes := make([]string, 0, len(b.decoders))
+	for mediaType := range b.decoders {
+		mediaTypes = append(mediaTypes, mediaType)
+	}
+	sort.Strings(mediaTypes)
+	for _, mediaType := range mediaTypes {
+		if codec, ok := b.decoders[mediaType].(Codec); ok {
+			return codec
+		}
+	}
+	return nil
+}
+
+// responseCodec returns codec decoding bodies encoded by the route, the default encoder is preferred.
+func (b *builder) responseCodec() Codec {
+	if codec, ok := b.encoder.(Codec); ok {
+		return codec
+	}
+	for _, registered := range b.encoders {
+		if codec, ok := registered.encoder.(Codec); ok {
+			return codec
+		}
+	}
+	return nil
+}
+
+func formatPathValue(value reflect.Value) (string, error) {
+	if marshaler, ok := value.Interface().(encoding.TextMarshaler); ok {
+		text, err := marshaler.MarshalText()
+		return string(text), err
+	}
+	return fmt.Sprint(value.Interface()), nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// Clock is a source of the current time of the router, e.g. durations reported to hooks are measured with it.
+// Tests could inject a fake one to make time dependent behavior deterministic.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// SystemClock reports the current local time.
+var SystemClock Clock = systemClock{}
+
+// Clock sets clock used by all endpoints of the router. Requests in flight keep the clock they started with.
+func (rt *Router) Clock(clock Clock) *Router {
+	rt.update(func(settings *routerSettings) { settings.clock = clock })
+	return rt
+}
+
+// routerKey is the context key of the router serving the request.
+type routerKey struct{}
+
+// nowOf reports the current time of the clock of the router serving the request, of SystemClock without router.
+func nowOf(ctx context.Context) time.Time {
+	if rt, routed := ctx.Value(routerKey{}).(*Router); routed {
+		return rt.now()
+	}
+	return SystemClock.Now()
+}
+
+// now reports the current time of the clock of the router.
+func (rt *Router) now() time.Time {
+	if clock := rt.snapshot().clock; clock != nil {
+		return clock.Now()
+	}
+	return SystemClock.Now()
+}
@@ -0,0 +1,37 @@
+// Command feel scaffolds and maintains services built with the feel router.
+//
+// Usage:
+//
+//	feel new -module example.com/users [dir]
+//	feel migrate [-w] migrations.json
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// commands are subcommands of the tool by their names, each one parses own flags from the arguments.
+var commands = map[string]func(args []string, stdout io.Writer) error{
+	"new":     newCommand,
+	"migrate": migrateCommand,
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "feel:", err)
+		os.Exit(2)
+	}
+}
+
+func run(args []string, stdout io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("command is missing, expected one of: new, migrate")
+	}
+	command, found := commands[args[0]]
+	if !found {
+		return fmt.Errorf("unknown command %q, expected one of: new, migrate", args[0])
+	}
+	return command(args[1:], stdout)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "users")
+	var stdout bytes.Buffer
+	if err := run([]string{"new", "-module", "example.com/users", dir}, &stdout); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stdout.String(), "go mod tidy") {
+		t.Error("unexpected output", stdout.String())
+	}
+
+	goMod, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(goMod), "module example.com/users\n") {
+		t.Error("unexpected go.mod", string(goMod))
+	}
+	source, err := os.ReadFile(filepath.Join(dir, "main.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := parser.ParseFile(token.NewFileSet(), "main.go", source, parser.ImportsOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var imported bool
+	for _, spec := range file.Imports {
+		path, _ := strconv.Unquote(spec.Path.Value)
+		imported = imported || path == libraryModule
+	}
+	if !imported {
+		t.Error("library is not imported")
+	}
+	if formatted, err := format.Source(source); err != nil || !bytes.Equal(formatted, source) {
+		t.Error("main.go is not formatted", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Error("unexpected files of the project", entries)
+	}
+
+	if err := run([]string{"new", "-module", "example.com/users", dir}, &stdout); err == nil {
+		t.Error("existing project is overwritten")
+	}
+	if err := run([]string{"new", t.TempDir()}, &stdout); err == nil {
+		t.Error("empty module path is accepted")
+	}
+	if err := run([]string{"old"}, &stdout); err == nil {
+		t.Error("unknown command is accepted")
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "handlers.go")
+	source := "package main\n\nimport \"strconv\"\n\nfunc post(user, post int, _ string) string {\n\treturn strconv.Itoa(user) + \"/\" + strconv.Itoa(post)\n}\n"
+	if err := os.WriteFile(file, []byte(source), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	writeMigrations := func(migrations string) string {
+		name := filepath.Join(dir, "migrations.json")
+		if err := os.WriteFile(name, []byte(migrations), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return name
+	}
+	migrations := writeMigrations(`[{"Route": {"Method": "GET", "Template": "/users/:user/posts/:post"}, "Handler": "post",
+		"File": ` + strconv.Quote(file) + `, "Line": 5, "Parameters": ["user", "post"], "Arguments": ["user", "post"],
+		"Fields": ["User", "Post"]}]`)
+
+	var stdout bytes.Buffer
+	if err := run([]string{"migrate", migrations}, &stdout); err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != file+":5: post GET binds path parameters of /users/:user/posts/:post by position\n" {
+		t.Error("unexpected report", stdout.String())
+	}
+	if rewritten, _ := os.ReadFile(file); string(rewritten) != source {
+		t.Error("source is rewritten without -w", string(rewritten))
+	}
+
+	if err := run([]string{"migrate", "-w", migrations}, &stdout); err != nil {
+		t.Fatal(err)
+	}
+	rewritten, _ := os.ReadFile(file)
+	expected := "package main\n\nimport \"strconv\"\n\nfunc post(path struct {\n\tUser int `path:\"user\"`\n\tPost int `path:\"post\"`\n}, _ string) string {\n\treturn strconv.Itoa(path.User) + \"/\" + strconv.Itoa(path.Post)\n}\n"
+	if string(rewritten) != expected {
+		t.Error("unexpected rewritten source", string(rewritten))
+	}
+
+	missing := writeMigrations(`[{"Handler": "post", "File": ` + strconv.Quote(file) + `, "Line": 1, "Parameters": ["user"], "Fields": ["User"]}]`)
+	if err := run([]string{"migrate", "-w", missing}, &stdout); err == nil {
+		t.Error("expected error of missing handler")
+	}
+	stdout.Reset()
+	mismatched := writeMigrations(`[{"Handler": "post", "File": "handlers.go", "Line": 5, "Parameters": ["user", "post"],
+		"Arguments": ["post", "user"], "Mismatched": true, "Fields": ["User", "Post"]}]`)
+	if err := run([]string{"migrate", mismatched}, &stdout); err == nil || !strings.Contains(stdout.String(), "arguments post, user") {
+		t.Error("mismatched handler is not reported", err, stdout.String())
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// pathMigration is the migration of the handler reported by Router.PathMigrations of the library.
+type pathMigration struct {
+	Route struct {
+		Method   string
+		Template string
+	}
+	Handler    string
+	File       string
+	Line       int
+	Parameters []string
+	Arguments  []string
+	Mismatched bool
+	Struct     string
+	Fields     []string
+}
+
+// migrateCommand reports migrations of handlers from positional path parameters to named ones read from the file,
+// standard input if it is "-", and rewrites their sources with -w. The file is JSON of Router.PathMigrations, e.g.
+// written by a test of the service registering its routes. Handlers whose arguments are named like path parameters
+// at other positions are reported as mismatched and fail the command, as they likely bind parameters by mistake.
+func migrateCommand(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	flags.SetOutput(stdout)
+	write := flags.Bool("w", false, "rewrite sources of handlers to bind path parameters by names")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("file of migrations is missing")
+	}
+
+	input := io.Reader(os.Stdin)
+	if name := flags.Arg(0); name != "-" {
+		file, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		input = file
+	}
+	var migrations []pathMigration
+	if err := json.NewDecoder(input).Decode(&migrations); err != nil {
+		return fmt.Errorf("file of migrations is invalid: %w", err)
+	}
+
+	var mismatched int
+	for _, migration := range migrations {
+		fmt.Fprintf(stdout, "%s:%d: %s %s binds path parameters of %s by position\n",
+			migration.File, migration.Line, migration.Handler, migration.Route.Method, migration.Route.Template)
+		if migration.Mismatched {
+			mismatched++
+			fmt.Fprintf(stdout, "\targuments %s are named like path parameters %s at other positions\n",
+				strings.Join(migration.Arguments, ", "), strings.Join(migration.Parameters, ", "))
+		}
+	}
+	if *write {
+		if err := rewritePathMigrations(migrations); err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "%d handlers are rewritten\n", len(migrations))
+	}
+	if mismatched > 0 {
+		return fmt.Errorf("%d handlers bind path parameters in other order than their names, review them", mismatched)
+	}
+	return nil
+}
+
+// rewritePathMigrations rewrites sources of handlers of the migrations to bind path parameters by names: positional
+// parameters are replaced by the struct parameter with fields of their source types and their uses in bodies by
+// the fields. Fields keep the order of the template, so mismatched handlers behave as before and should be reviewed.
+// Rewritten files are formatted and written in place.
+func rewritePathMigrations(migrations []pathMigration) error {
+	var files []string
+	byFile := map[string][]pathMigration{}
+	for _, migration := range migrations {
+		if migration.File == "" {
+			return fmt.Errorf("source of handler %s is not found", migration.Handler)
+		}
+		if len(migration.Fields) != len(migration.Parameters) {
+			return fmt.Errorf("fields of handler %s don't match its path parameters", migration.Handler)
+		}
+		if _, found := byFile[migration.File]; !found {
+			files = append(files, migration.File)
+		}
+		byFile[migration.File] = append(byFile[migration.File], migration)
+	}
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		source, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		rewritten, err := rewritePathSource(file, source, byFile[file])
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(file, rewritten, info.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sourceEdit replaces the source between offsets with the text.
+type sourceEdit struct {
+	start, end int
+	text       string
+}
+
+func rewritePathSource(name string, source []byte, migrations []pathMigration) ([]byte, error) {
+	fileSet := token.NewFileSet()
+	file, err := parser.ParseFile(fileSet, name, source, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	structName := unusedName(file, "path", "params", "pathParams")
+	offset := func(pos token.Pos) int { return fileSet.Position(pos).Offset }
+
+	var edits []sourceEdit
+	for _, migration := range migrations {
+		functionType, body := functionAt(fileSet, file, migration.Line)
+		if functionType == nil {
+			return nil, fmt.Errorf("handler %s is not found at %s:%d", migration.Handler, name, migration.Line)
+		}
+
+		var arguments []*ast.Ident
+		var types []ast.Expr
+		var last *ast.Field
+		for _, field := range functionType.Params.List {
+			if len(types) == len(migration.Parameters) {
+				break
+			}
+			if len(types)+max(len(field.Names), 1) > len(migration.Parameters) {
+				return nil, fmt.Errorf("path parameters of handler %s at %s:%d share the type with other ones", migration.Handler, name, migration.Line)
+			}
+			if len(field.Names) == 0 {
+				arguments, types = append(arguments, nil), append(types, field.Type)
+			}
+			for _, argument := range field.Names {
+				arguments, types = append(arguments, argument), append(types, field.Type)
+			}
+			last = field
+		}
+		if len(types) < len(migration.Parameters) || last == nil {
+			return nil, fmt.Errorf("handler %s at %s:%d has less parameters than its path", migration.Handler, name, migration.Line)
+		}
+
+		var declaration strings.Builder
+		fields := map[*ast.Object]string{}
+		fmt.Fprintf(&declaration, "%s struct {\n", structName)
+		for i, parameter := range migration.Parameters {
+			fieldName := migration.Fields[i]
+			fmt.Fprintf(&declaration, "\t%s %s `path:%q`\n", fieldName, source[offset(types[i].Pos()):offset(types[i].End())], parameter)
+			if arguments[i] != nil && arguments[i].Obj != nil {
+				fields[arguments[i].Obj] = fieldName
+			}
+		}
+		declaration.WriteString("}")
+		edits = append(edits, sourceEdit{start: offset(functionType.Params.List[0].Pos()), end: offset(last.End()), text: declaration.String()})
+
+		if body == nil {
+			continue
+		}
+		ast.Inspect(body, func(node ast.Node) bool {
+			if ident, is := node.(*ast.Ident); is && ident.Obj != nil {
+				if fieldName, found := fields[ident.Obj]; found {
+					edits = append(edits, sourceEdit{start: offset(ident.Pos()), end: offset(ident.End()), text: structName + "." + fieldName})
+				}
+			}
+			return true
+		})
+	}
+
+	sort.Slice(edits, func(i, j int) bool { return edits[i].start > edits[j].start })
+	rewritten := append([]byte(nil), source...)
+	for _, edit := range edits {
+		rewritten = append(rewritten[:edit.start], append([]byte(edit.text), rewritten[edit.end:]...)...)
+	}
+	return format.Source(rewritten)
+}
+
+// functionAt returns the type and the body of the function declared at the line of the file.
+func functionAt(fileSet *token.FileSet, file *ast.File, line int) (*ast.FuncType, *ast.BlockStmt) {
+	var functionType *ast.FuncType
+	var body *ast.BlockStmt
+	ast.Inspect(file, func(node ast.Node) bool {
+		if functionType != nil {
+			return false
+		}
+		switch function := node.(type) {
+		case *ast.FuncDecl:
+			if fileSet.Position(function.Type.Pos()).Line == line {
+				functionType, body = function.Type, function.Body
+			}
+		case *ast.FuncLit:
+			if fileSet.Position(function.Type.Pos()).Line == line {
+				functionType, body = function.Type, function.Body
+			}
+		}
+		return functionType == nil
+	})
+	return functionType, body
+}
+
+// unusedName returns the first of the names which is not an identifier of the file.
+func unusedName(file *ast.File, names ...string) string {
+	used := map[string]bool{}
+	ast.Inspect(file, func(node ast.Node) bool {
+		if ident, is := node.(*ast.Ident); is {
+			used[ident.Name] = true
+		}
+		return true
+	})
+	for _, name := range names {
+		if !used[name] {
+			return name
+		}
+	}
+	return names[len(names)-1] + "_"
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// libraryModule is the module path of the library imported by generated projects.
+const libraryModule = "github.com/pavelmemory/feel"
+
+// projectFiles are templates of files of generated projects by their names, delimiters differ from the default
+// ones which clash with composite literals of Go.
+var projectFiles = map[string]*template.Template{
+	"go.mod":  template.Must(template.New("go.mod").Delims("[[", "]]").Parse(projectGoMod)),
+	"main.go": template.Must(template.New("main.go").Delims("[[", "]]").Parse(projectMain)),
+}
+
+// newCommand generates a runnable service skeleton in the directory, the current one by default, which is created
+// if it doesn't exist: go.mod of the module and main.go wiring the router, an API key interceptor, the JSON codec,
+// health endpoints and the server with graceful shutdown. Existing files are never overwritten.
+func newCommand(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("new", flag.ContinueOnError)
+	flags.SetOutput(stdout)
+	module := flags.String("module", "", "module path of the project, e.g. example.com/users")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	dir := "."
+	if flags.NArg() > 0 {
+		dir = flags.Arg(0)
+	}
+	if err := newProject(dir, *module); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "project %s is created in %s, run \"go mod tidy\" there to add the library\n", *module, dir)
+	return nil
+}
+
+func newProject(dir, module string) error {
+	if module == "" || strings.ContainsAny(module, " \t\n\"`") {
+		return fmt.Errorf("invalid module path %q", module)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	for name, file := range projectFiles {
+		var content strings.Builder
+		if err := file.Execute(&content, struct{ Module, Library string }{Module: module, Library: libraryModule}); err != nil {
+			return err
+		}
+		if err := createFile(filepath.Join(dir, name), []byte(content.String())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func createFile(path string, content []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return fmt.Errorf("file %s of the project already exists", path)
+		}
+		return err
+	}
+	if _, err := file.Write(content); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+const projectGoMod = `module [[.Module]]
+
+go 1.24
+`
+
+const projectMain = `package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"[[.Library]]"
+)
+
+// appCaller is the client authenticated by its API key.
+type appCaller struct {
+	Name string
+}
+
+type appUser struct {
+	ID   int
+	Name string
+}
+
+func (u appUser) Validate() error {
+	if u.Name == "" {
+		return feel.ValidationError{Fields: []feel.FieldError{{Field: "Name", Message: "is required"}}}
+	}
+	return nil
+}
+
+// appUsers keeps users in memory, replace it with a storage of the service.
+type appUsers struct {
+	mu    sync.Mutex
+	users map[int]appUser
+}
+
+func (s *appUsers) get(id int) (appUser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, found := s.users[id]
+	if !found {
+		return appUser{}, feel.Problem{Status: http.StatusNotFound, Title: "user not found"}
+	}
+	return user, nil
+}
+
+func (s *appUsers) create(caller appCaller, user appUser) (appUser, int, http.Header) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user.ID = len(s.users) + 1
+	s.users[user.ID] = user
+	slog.Info("user created", "id", user.ID, "by", caller.Name)
+	return user, http.StatusCreated, http.Header{"Location": {"/users/" + strconv.Itoa(user.ID)}}
+}
+
+func main() {
+	users := &appUsers{users: map[int]appUser{}}
+	apiKey := os.Getenv("API_KEY")
+	authenticate := feel.APIKeyAuth(feel.APIKey{Header: "X-API-Key"}, func(ctx context.Context, key string) (appCaller, error) {
+		if apiKey == "" || subtle.ConstantTimeCompare([]byte(key), []byte(apiKey)) != 1 {
+			return appCaller{}, feel.ErrInvalidCredentials
+		}
+		return appCaller{Name: "client"}, nil
+	})
+
+	router := feel.NewRouter().AccessLog(feel.SlogAccessLogger{Logger: slog.Default()}, feel.AccessLogConfig{})
+	err := router.Register(
+		feel.Health(),
+		feel.Ready(),
+		feel.GET("/users/:id").Codec(feel.JSON).Handler(users.get),
+		feel.POST("/users").Codec(feel.JSON).Before(authenticate).Handler(users.create),
+	)
+	if err != nil {
+		slog.Error("routes of [[.Module]] are invalid", "error", err)
+		os.Exit(1)
+	}
+
+	addr := os.Getenv("ADDR")
+	if addr == "" {
+		addr = ":8080"
+	}
+	err = feel.NewServer(addr, router).
+		Timeouts(feel.ServerTimeouts{ReadHeader: 5 * time.Second, Idle: time.Minute}).
+		OnStart(func(ctx context.Context, addr net.Addr) error {
+			slog.Info("[[.Module]] is serving", "addr", addr.String())
+			return nil
+		}).
+		Run(context.Background())
+	if err != nil {
+		slog.Error("[[.Module]] stopped", "error", err)
+		os.Exit(1)
+	}
+}
+`
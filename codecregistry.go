@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"strings"
+	"sync"
+)
+
+// Codec encodes and decodes bodies of its media type, so the decoder, the encoder and Content-Type
+// of the endpoint configured with it couldn't drift apart.
+type Codec interface {
+	Decoder
+	Encoder
+}
+
+var (
+	JSON    Codec = jsonCodec{}
+	XML     Codec = xmlCodec{}
+	NDJSON  Codec = ndjsonCodec{}
+	CSV     Codec = csvCodec{}
+	Msgpack Codec = MsgpackEncoder.(Codec)
+	CBOR    Codec = CBOREncoder.(Codec)
+	Proto   Codec = ProtoEncoder.(Codec)
+)
+
+var codecRegistry = struct {
+	mu     sync.RWMutex
+	codecs map[string]Codec
+}{codecs: map[string]Codec{}}
+
+func init() {
+	for _, codec := range []Codec{JSON, XML, NDJSON, CSV, Msgpack, CBOR, Proto} {
+		RegisterCodec(codec)
+	}
+}
+
+// RegisterCodec makes the codec available by its media type for CodecFor, replacing the registered one.
+// Built-in codecs are registered from the start.
+func RegisterCodec(codec Codec) {
+	codecRegistry.mu.Lock()
+	defer codecRegistry.mu.Unlock()
+	codecRegistry.codecs[strings.ToLower(codec.MediaType())] = codec
+}
+
+// LookupCodec returns codec registered for the media type, parameters of the media type are ignored.
+func LookupCodec(mediaType string) (Codec, bool) {
+	if parsed, _, err := mime.ParseMediaType(mediaType); err == nil {
+		mediaType = parsed
+	}
+	codecRegistry.mu.RLock()
+	defer codecRegistry.mu.RUnlock()
+	codec, found := codecRegistry.codecs[strings.ToLower(mediaType)]
+	return codec, found
+}
+
+// Codec configures decoding of the request body, encoding of the response body and its Content-Type
+// by the codecs. The first one is used when Content-Type or Accept request headers don't match any of them.
+// Response content type set before with ResponseContentType is reset.
+func (b builder) Codec(codecs ...Codec) Builder {
+	if len(codecs) == 0 {
+		return b
+	}
+	var configured Builder = b
+	for _, codec := range codecs[1:] {
+		configured = configured.DecoderFor(codec.MediaType(), codec).EncoderFor(codec.MediaType(), codec)
+	}
+	if len(codecs) > 1 {
+		configured = configured.DecoderFor(codecs[0].MediaType(), codecs[0]).EncoderFor(codecs[0].MediaType(), codecs[0])
+	}
+	cloned := configured.(builder).clone()
+	cloned.decoder = codecs[0]
+	cloned.encoder = codecs[0]
+	cloned.contentTypeProvider = nil
+	return cloned
+}
+
+// CodecFor is like Codec with codecs registered for the media types.
+func (b builder) CodecFor(mediaTypes ...string) Builder {
+	codecs := make([]Codec, 0, len(mediaTypes))
+	for _, mediaType := range mediaTypes {
+		codec, found := LookupCodec(mediaType)
+		if !found {
+			b.addError(InvalidMappingError(fmt.Errorf("no codec is registered for media type %q", mediaType)))
+			return b
+		}
+		codecs = append(codecs, codec)
+	}
+	return b.Codec(codecs...)
+}
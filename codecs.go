@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+)
+
+type DecodeError struct {
+	MediaType string
+	Offset    int64
+	Field     string
+	Cause     error
+}
+
+func (e DecodeError) Error() string {
+	if e.Field != "" {
+		return fmt.Sprintf("decode %s: field %s at offset %d: %v", e.MediaType, e.Field, e.Offset, e.Cause)
+	}
+	return fmt.Sprintf("decode %s: at offset %d: %v", e.MediaType, e.Offset, e.Cause)
+}
+
+func (e DecodeError) Unwrap() error {
+	return e.Cause
+}
+
+type EncodeError struct {
+	MediaType string
+	Cause     error
+}
+
+func (e EncodeError) Error() string {
+	return fmt.Sprintf("encode %s: %v", e.MediaType, e.Cause)
+}
+
+func (e EncodeError) Unwrap() error {
+	return e.Cause
+}
+
+func (df DecoderFunc) MediaType() string {
+	return ""
+}
+
+func (df DecoderFunc) NewDecodeStream(reader io.Reader) DecodeStream {
+	return funcDecodeStream(df(reader))
+}
+
+type funcDecodeStream func(v interface{}) error
+
+func (fds funcDecodeStream) Decode(v interface{}) error {
+	return fds(v)
+}
+
+func (ef EncoderFunc) MediaType() string {
+	return ""
+}
+
+func (ef EncoderFunc) NewEncodeStream(writer io.Writer) EncodeStream {
+	return funcEncodeStream{writer: writer, encode: ef(writer)}
+}
+
+type funcEncodeStream struct {
+	writer io.Writer
+	encode func(v interface{}) error
+}
+
+func (fes funcEncodeStream) Encode(v interface{}) error {
+	return fes.encode(v)
+}
+
+func (fes funcEncodeStream) Flush() error {
+	return flushWriter(fes.writer)
+}
+
+func flushWriter(writer io.Writer) error {
+	if flusher, ok := writer.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) MediaType() string {
+	return "application/json"
+}
+
+func (jc jsonCodec) NewDecodeStream(reader io.Reader) DecodeStream {
+	return jsonDecodeStream{decoder: json.NewDecoder(reader)}
+}
+
+func (jc jsonCodec) NewEncodeStream(writer io.Writer) EncodeStream {
+	return jsonEncodeStream{writer: writer, encoder: json.NewEncoder(writer)}
+}
+
+type jsonDecodeStream struct {
+	decoder *json.Decoder
+}
+
+func (jds jsonDecodeStream) Decode(v interface{}) error {
+	err := jds.decoder.Decode(v)
+	if err == nil || err == io.EOF {
+		return err
+	}
+
+	decodeErr := DecodeError{MediaType: jsonCodec{}.MediaType(), Offset: jds.decoder.InputOffset(), Cause: err}
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		decodeErr.Offset = syntaxErr.Offset
+	case errors.As(err, &typeErr):
+		decodeErr.Offset = typeErr.Offset
+		decodeErr.Field = typeErr.Field
+	}
+	return decodeErr
+}
+
+type jsonEncodeStream struct {
+	writer  io.Writer
+	encoder *json.Encoder
+}
+
+func (jes jsonEncodeStream) Encode(v interface{}) error {
+	if err := jes.encoder.Encode(v); err != nil {
+		return EncodeError{MediaType: jsonCodec{}.MediaType(), Cause: err}
+	}
+	return nil
+}
+
+func (jes jsonEncodeStream) Flush() error {
+	return flushWriter(jes.writer)
+}
+
+// ndjsonCodec reads and writes newline-delimited JSON values. Slices are decoded from all values of the body
+// and encoded as a value per line, other types as a single value.
+type ndjsonCodec struct{}
+
+func (ndjsonCodec) MediaType() string {
+	return "application/x-ndjson"
+}
+
+// FramesItems reports that every streamed item is a line.
+func (ndjsonCodec) FramesItems() bool {
+	return true
+}
+
+func (nc ndjsonCodec) NewDecodeStream(reader io.Reader) DecodeStream {
+	return ndjsonDecodeStream{jsonDecodeStream{decoder: json.NewDecoder(reader)}}
+}
+
+func (nc ndjsonCodec) NewEncodeStream(writer io.Writer) EncodeStream {
+	return ndjsonEncodeStream{jsonEncodeStream{writer: writer, encoder: json.NewEncoder(writer)}}
+}
+
+type ndjsonDecodeStream struct {
+	jsonDecodeStream
+}
+
+func (nds ndjsonDecodeStream) Decode(v interface{}) error {
+	target := reflect.ValueOf(v)
+	if target.Kind() != reflect.Ptr || target.Elem().Kind() != reflect.Slice {
+		return nds.decode(v)
+	}
+
+	slice := target.Elem()
+	slice.SetLen(0)
+	for {
+		item := reflect.New(slice.Type().Elem())
+		err := nds.decode(item.Interface())
+		if err == io.EOF {
+			if slice.IsNil() {
+				slice.Set(reflect.MakeSlice(slice.Type(), 0, 0))
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		slice.Set(reflect.Append(slice, item.Elem()))
+	}
+}
+
+func (nds ndjsonDecodeStream) decode(v interface{}) error {
+	err := nds.jsonDecodeStream.Decode(v)
+	var decodeErr DecodeError
+	if errors.As(err, &decodeErr) {
+		decodeErr.MediaType = ndjsonCodec{}.MediaType()
+		return decodeErr
+	}
+	return err
+}
+
+type ndjsonEncodeStream struct {
+	jsonEncodeStream
+}
+
+func (nes ndjsonEncodeStream) Encode(v interface{}) error {
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Slice || value.Type().Elem().Kind() == reflect.Uint8 {
+		return nes.encode(v)
+	}
+	for i := 0; i < value.Len(); i++ {
+		if err := nes.encode(value.Index(i).Interface()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (nes ndjsonEncodeStream) encode(v interface{}) error {
+	if err := nes.encoder.Encode(v); err != nil {
+		return EncodeError{MediaType: ndjsonCodec{}.MediaType(), Cause: err}
+	}
+	return nil
+}
+
+type xmlCodec struct{}
+
+func (xmlCodec) MediaType() string {
+	return "application/xml"
+}
+
+func (xc xmlCodec) NewDecodeStream(reader io.Reader) DecodeStream {
+	return xmlDecodeStream{decoder: xml.NewDecoder(reader)}
+}
+
+func (xc xmlCodec) NewEncodeStream(writer io.Writer) EncodeStream {
+	return xmlEncodeStream{writer: writer, encoder: xml.NewEncoder(writer)}
+}
+
+type xmlDecodeStream struct {
+	decoder *xml.Decoder
+}
+
+func (xds xmlDecodeStream) Decode(v interface{}) error {
+	err := xds.decoder.Decode(v)
+	if err == nil || err == io.EOF {
+		return err
+	}
+	return DecodeError{MediaType: xmlCodec{}.MediaType(), Offset: xds.decoder.InputOffset(), Cause: err}
+}
+
+type xmlEncodeStream struct {
+	writer  io.Writer
+	encoder *xml.Encoder
+}
+
+func (xes xmlEncodeStream) Encode(v interface{}) error {
+	if err := xes.encoder.Encode(v); err != nil {
+		return EncodeError{MediaType: xmlCodec{}.MediaType(), Cause: err}
+	}
+	return nil
+}
+
+func (xes xmlEncodeStream) Flush() error {
+	if err := xes.encoder.Flush(); err != nil {
+		return EncodeError{MediaType: xmlCodec{}.MediaType(), Cause: err}
+	}
+	return flushWriter(xes.writer)
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJSONDecodeError(t *testing.T) {
+	var key Key
+	err := JSONDecoder.NewDecodeStream(strings.NewReader(`{"Value": "k", "Part": "one"}`)).Decode(&key)
+
+	var decodeErr DecodeError
+	if !errors.As(err, &decodeErr) {
+		t.Fatal("unexpected error", err)
+	}
+	if decodeErr.Field != "Part" || decodeErr.Offset == 0 || decodeErr.MediaType != "application/json" {
+		t.Errorf("received: %#v", decodeErr)
+	}
+	var typeErr *json.UnmarshalTypeError
+	if !errors.As(err, &typeErr) {
+		t.Error("cause is not preserved", err)
+	}
+}
+
+func TestEncoderFuncAdapter(t *testing.T) {
+	var encoder Encoder = EncoderFunc(func(writer io.Writer) func(v interface{}) error {
+		return func(v interface{}) error {
+			_, err := io.WriteString(writer, v.(Key).Value)
+			return err
+		}
+	})
+	by := GET("/").Encoder(encoder).Handler(func() Key { return Key{Value: "plain"} })
+
+	r := newGET(t, "http://localhost")
+	w := httptest.NewRecorder()
+	if err := by.Build().Handle(w, r); err != nil {
+		t.Fatal(err)
+	}
+	if w.Body.String() != "plain" {
+		t.Error("unexpected response body", w.Body.String())
+	}
+	if !w.Flushed {
+		t.Error("response is not flushed")
+	}
+	if w.Header().Get("Content-Type") != "" {
+		t.Error("unexpected Content-Type", w.Header().Get("Content-Type"))
+	}
+}
+
+func TestEncoderMediaTypeAsContentType(t *testing.T) {
+	by := GET("/").Encoder(JSONEncoder).Handler(func() Key { return Key{Value: "v"} })
+
+	w := httptest.NewRecorder()
+	if err := by.Build().Handle(w, newGET(t, "http://localhost")); err != nil {
+		t.Fatal(err)
+	}
+	if w.Header().Get("Content-Type") != "application/json" {
+		t.Error("unexpected Content-Type", w.Header().Get("Content-Type"))
+	}
+	if !bytes.Contains(w.Body.Bytes(), []byte(`"Value":"v"`)) {
+		t.Error("unexpected response body", w.Body.String())
+	}
+	if w.Code != http.StatusOK {
+		t.Error("unexpected response code", w.Code)
+	}
+}
+
+func TestNDJSON(t *testing.T) {
+	var keys []Key
+	if err := NDJSONDecoder.NewDecodeStream(strings.NewReader("{\"Value\": \"a\"}\n{\"Value\": \"b\"}\n")).Decode(&keys); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0].Value != "a" || keys[1].Value != "b" {
+		t.Errorf("received: %#v", keys)
+	}
+
+	for _, handler := range []interface{}{
+		func() <-chan Key {
+			keys := make(chan Key)
+			go func() {
+				defer close(keys)
+				keys <- Key{Value: "a"}
+				keys <- Key{Value: "b"}
+			}()
+			return keys
+		},
+		func() func(yield func(Key) bool) {
+			return func(yield func(Key) bool) {
+				_ = yield(Key{Value: "a"}) && yield(Key{Value: "b"})
+			}
+		},
+		func() []Key { return []Key{{Value: "a"}, {Value: "b"}} },
+	} {
+		by := GET("/keys").Encoder(NDJSONEncoder).BufferResponse(1 << 10).Handler(handler)
+
+		w := httptest.NewRecorder()
+		if err := by.Build().Handle(w, newGET(t, "http://localhost/keys")); err != nil {
+			t.Fatal(err)
+		}
+		if w.Header().Get("Content-Type") != "application/x-ndjson" {
+			t.Error("unexpected content type", w.Header().Get("Content-Type"))
+		}
+		if w.Body.String() != "{\"Value\":\"a\",\"Part\":0}\n{\"Value\":\"b\",\"Part\":0}\n" {
+			t.Errorf("unexpected response body %q", w.Body.String())
+		}
+	}
+
+	err := GET("/keys").Handler(func() <-chan Key { return nil }).Build().Handle(httptest.NewRecorder(), newGET(t, "http://localhost/keys"))
+	if !errors.Is(err, InvalidMapping) {
+		t.Error("stream without encoder is accepted", err)
+	}
+}
+
+// brokenConnection fails writes after the first one like a connection closed by the client.
+type brokenConnection struct {
+	*httptest.ResponseRecorder
+	writes int
+}
+
+func (bc *brokenConnection) Write(data []byte) (int, error) {
+	if bc.writes++; bc.writes > 1 {
+		return 0, errors.New("connection reset by peer")
+	}
+	return bc.ResponseRecorder.Write(data)
+}
+
+func TestStreamEncoders(t *testing.T) {
+	// the producer stops without closing the channel once the request is done
+	keys := func(r *http.Request) <-chan Key {
+		keys := make(chan Key)
+		go func() {
+			for i := 0; i < 100; i++ {
+				select {
+				case keys <- Key{Value: strconv.Itoa(i)}:
+				case <-r.Context().Done():
+					return
+				}
+			}
+			close(keys)
+		}()
+		return keys
+	}
+
+	w := httptest.NewRecorder()
+	if err := GET("/keys").Encoder(JSONEncoder).Handler(keys).Build().Handle(w, newGET(t, "http://localhost/keys")); err != nil {
+		t.Fatal(err)
+	}
+	var received []Key
+	if err := json.Unmarshal(w.Body.Bytes(), &received); err != nil || len(received) != 100 {
+		t.Error("streamed JSON is not an array", err, w.Body.String())
+	}
+
+	err := GET("/keys").Encoder(XMLEncoder).Handler(keys).Build().Handle(httptest.NewRecorder(), newGET(t, "http://localhost/keys"))
+	if !errors.Is(err, InvalidMapping) {
+		t.Error("stream of undelimited XML items is accepted", err)
+	}
+
+	goroutines := runtime.NumGoroutine()
+	by := GET("/keys").Encoder(NDJSONEncoder).Handler(keys)
+	_ = by.Build().Handle(&brokenConnection{ResponseRecorder: httptest.NewRecorder()}, newGET(t, "http://localhost/keys"))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_ = by.Build().Handle(httptest.NewRecorder(), newGET(t, "http://localhost/keys").WithContext(ctx))
+	for deadline := time.Now().Add(time.Second); runtime.NumGoroutine() > goroutines; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("producers of abandoned streams are blocked", runtime.NumGoroutine(), goroutines)
+		}
+	}
+}
+
+func TestCSV(t *testing.T) {
+	type Audit struct {
+		Author string `csv:"author"`
+	}
+	type Entry struct {
+		Audit
+		Day    time.Time `csv:"day" layout:"2006-01-02"`
+		Amount float64   `csv:"amount"`
+		Note   *string   `csv:"note"`
+		Secret string    `csv:"-"`
+	}
+	var entries []Entry
+	err := CSVDecoder.NewDecodeStream(strings.NewReader("amount,unknown,day,author\n1.5,x,2024-03-01,ann\n2,y,2024-03-02,bob\n")).Decode(&entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Author != "ann" || entries[1].Amount != 2 || entries[1].Day.Day() != 2 {
+		t.Errorf("received: %#v", entries)
+	}
+
+	err = CSVDecoder.NewDecodeStream(strings.NewReader("amount\nmany\n")).Decode(&entries)
+	var decodeErr DecodeError
+	if !errors.As(err, &decodeErr) || decodeErr.Field != "amount" || decodeErr.MediaType != "text/csv" {
+		t.Error("unexpected error", err)
+	}
+
+	by := GET("/entries").Encoder(CSVEncoder).Handler(func() []*Entry { return []*Entry{&entries[0], nil, &entries[1]} })
+	w := httptest.NewRecorder()
+	if err := by.Build().Handle(w, newGET(t, "http://localhost/entries")); err != nil {
+		t.Fatal(err)
+	}
+	if w.Header().Get("Content-Type") != "text/csv" {
+		t.Error("unexpected content type", w.Header().Get("Content-Type"))
+	}
+	if w.Body.String() != "author,day,amount,note\nann,2024-03-01,1.5,\nbob,2024-03-02,2,\n" {
+		t.Errorf("unexpected response body %q", w.Body.String())
+	}
+}
+
+// reversingMarshaler stands for binary marshaler of a third-party library.
+type reversingMarshaler struct{}
+
+func (reversingMarshaler) Marshal(v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	for i, j := 0, len(data)-1; i < j; i, j = i+1, j-1 {
+		data[i], data[j] = data[j], data[i]
+	}
+	return data, err
+}
+
+func (reversingMarshaler) Unmarshal(data []byte, v interface{}) error {
+	reversed := make([]byte, len(data))
+	for i := range data {
+		reversed[len(data)-1-i] = data[i]
+	}
+	return json.Unmarshal(reversed, v)
+}
+
+func TestMarshalerCodecs(t *testing.T) {
+	by := POST("/keys").
+		DecoderFor("application/cbor", CBORDecoder).
+		EncoderFor("application/cbor", CBOREncoder).
+		Handler(func(key Key) Key { return Key{Value: key.Value + "!"} })
+
+	r := newRequest(t, http.MethodPost, "http://localhost/keys", strings.NewReader(`}"a":"eulaV"{`))
+	r.Header.Set("Content-Type", "application/cbor")
+	r.Header.Set("Accept", "application/cbor")
+	w := httptest.NewRecorder()
+	if err := by.Build().Handle(w, r); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "UseCBOR") {
+		t.Error("unexpected response without marshaler", w.Code, w.Body.String())
+	}
+
+	UseCBOR(reversingMarshaler{})
+	defer cborMarshaler.Store((*Marshaler)(nil))
+	r = newRequest(t, http.MethodPost, "http://localhost/keys", strings.NewReader(`}"a":"eulaV"{`))
+	r.Header.Set("Content-Type", "application/cbor")
+	r.Header.Set("Accept", "application/cbor")
+	w = httptest.NewRecorder()
+	if err := by.Build().Handle(w, r); err != nil {
+		t.Fatal(err)
+	}
+	if w.Header().Get("Content-Type") != "application/cbor" || w.Body.String() != `}0:"traP","!a":"eulaV"{` {
+		t.Errorf("unexpected response %v %q", w.Header(), w.Body.String())
+	}
+}
+
+// generatedMessage marshals itself as messages generated by gogo/protobuf.
+type generatedMessage struct {
+	Name string
+}
+
+func (m *generatedMessage) Marshal() ([]byte, error) {
+	return []byte{0x0a, byte(len(m.Name))}, nil
+}
+
+func (m *generatedMessage) Unmarshal(data []byte) error {
+	if len(data) < 2 || data[0] != 0x0a {
+		return errors.New("invalid message")
+	}
+	m.Name = strings.Repeat("x", int(data[1]))
+	return nil
+}
+
+func TestProtoCodec(t *testing.T) {
+	by := POST("/messages").
+		Decoder(ProtoDecoder).
+		Encoder(ProtoEncoder).
+		Handler(func(message *generatedMessage) *generatedMessage { return &generatedMessage{Name: message.Name + "y"} })
+
+	w := httptest.NewRecorder()
+	if err := by.Build().Handle(w, newRequest(t, http.MethodPost, "http://localhost/messages", bytes.NewReader([]byte{0x0a, 3}))); err != nil {
+		t.Fatal(err)
+	}
+	if w.Header().Get("Content-Type") != "application/x-protobuf" || !bytes.Equal(w.Body.Bytes(), []byte{0x0a, 4}) {
+		t.Errorf("unexpected response %v %v", w.Header(), w.Body.Bytes())
+	}
+
+	w = httptest.NewRecorder()
+	err := POST("/keys").Encoder(ProtoEncoder).Handler(func() Key { return Key{} }).Build().Handle(w, newRequest(t, http.MethodPost, "http://localhost/keys", nil))
+	if !strings.Contains(fmt.Sprint(err), "UseProto") {
+		t.Error("message without marshaler is encoded", err)
+	}
+}
+
+func TestCodec(t *testing.T) {
+	by := POST("/keys").
+		ResponseContentType(Application.JSON).
+		CodecFor("application/xml", "application/json; charset=utf-8").
+		Handler(func(key Key) Key { return key })
+
+	for _, toCheck := range []struct {
+		contentType string
+		accept      string
+		body        string
+		expected    string
+	}{
+		{contentType: "application/xml", body: `<Key><value>k</value></Key>`, expected: "application/xml"},
+		{contentType: "application/json", accept: "application/json", body: `{"Value":"k"}`, expected: "application/json"},
+	} {
+		r := newRequest(t, http.MethodPost, "http://localhost/keys", strings.NewReader(toCheck.body))
+		r.Header.Set("Content-Type", toCheck.contentType)
+		if toCheck.accept != "" {
+			r.Header.Set("Accept", toCheck.accept)
+		}
+		w := httptest.NewRecorder()
+		if err := by.Build().Handle(w, r); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != toCheck.expected || !strings.Contains(w.Body.String(), "k") {
+			t.Error("unexpected response", w.Code, w.Header(), w.Body.String())
+		}
+	}
+
+	err := POST("/keys").CodecFor("application/yaml").Handler(func(key Key) {}).Build().Handle(httptest.NewRecorder(), newGET(t, "http://localhost/keys"))
+	if !errors.Is(err, InvalidMapping) {
+		t.Error("unknown media type is accepted", err)
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const defaultCompressionMinSize = 1 << 10
+
+// CompressWriter compresses data written into the underlying writer, Reset allows to reuse it for another one.
+// It is implemented by writers of compress/gzip, compress/flate and most of third-party packages, e.g. brotli.
+type CompressWriter interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// Compression produces content coding of the response with the Encoding name, e.g. "gzip" or "br".
+type Compression struct {
+	Encoding  string
+	NewWriter func(w io.Writer) CompressWriter
+}
+
+var (
+	Gzip = Compression{Encoding: "gzip", NewWriter: func(w io.Writer) CompressWriter {
+		return gzip.NewWriter(w)
+	}}
+
+	Deflate = Compression{Encoding: "deflate", NewWriter: func(w io.Writer) CompressWriter {
+		writer, _ := flate.NewWriter(w, flate.DefaultCompression)
+		return writer
+	}}
+)
+
+// CompressionConfig defines which responses are compressed and how.
+type CompressionConfig struct {
+	// Compressions are offered to the client in order of preference, Gzip and Deflate are used if empty.
+	Compressions []Compression
+	// MinSize of the body to be compressed, 1 KiB is used if zero. Smaller responses are sent as is.
+	MinSize int
+	// ContentTypes are compressed media types, "text/*" form matches any subtype.
+	// Text, JSON and XML ones are compressed if empty.
+	ContentTypes []string
+}
+
+var defaultCompressedContentTypes = []string{"text/*", "application/json", "application/xml", "application/problem+json", "image/svg+xml"}
+
+// compressor negotiates content coding and pools writers of each compression.
+type compressor struct {
+	config CompressionConfig
+	pools  []*sync.Pool
+}
+
+func newCompressor(config CompressionConfig) *compressor {
+	if len(config.Compressions) == 0 {
+		config.Compressions = []Compression{Gzip, Deflate}
+	}
+	if config.MinSize == 0 {
+		config.MinSize = defaultCompressionMinSize
+	}
+	if len(config.ContentTypes) == 0 {
+		config.ContentTypes = defaultCompressedContentTypes
+	}
+	c := &compressor{config: config}
+	for _, compression := range config.Compressions {
+		newWriter := compression.NewWriter
+		c.pools = append(c.pools, &sync.Pool{New: func() interface{} { return newWriter(io.Discard) }})
+	}
+	return c
+}
+
+// negotiate returns index of the compression with the highest quality in Accept-Encoding header or -1.
+func (c *compressor) negotiate(acceptEncoding string) int {
+	ranges := parseAccept(acceptEncoding)
+	best, bestQuality := -1, 0.0
+	for index, compression := range c.config.Compressions {
+		quality := 0.0
+		for _, ar := range ranges {
+			if ar.mediaType == strings.ToLower(compression.Encoding) {
+				quality = ar.quality
+				break
+			}
+			if ar.mediaType == "*" {
+				quality = ar.quality
+			}
+		}
+		if quality > bestQuality {
+			best, bestQuality = index, quality
+		}
+	}
+	return best
+}
+
+func (c *compressor) compressible(header http.Header) bool {
+	if header.Get("Content-Encoding") != "" {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	for _, contentType := range c.config.ContentTypes {
+		if contentType == mediaType || strings.HasSuffix(contentType, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(contentType, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// wrap returns writer compressing the response if the client accepts any of compressions, it must be closed.
+func (c *compressor) wrap(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, func()) {
+	index := c.negotiate(r.Header.Get("Accept-Encoding"))
+	if index < 0 || r.Method == http.MethodHead {
+		return w, func() {}
+	}
+	cw := &compressResponseWriter{ResponseWriter: w, compressor: c, index: index}
+	return cw, cw.close
+}
+
+// compressResponseWriter buffers the body until the decision whether to compress it could be made.
+type compressResponseWriter struct {
+	http.ResponseWriter
+	compressor *compressor
+	index      int
+	statusCode int
+	buffer     bytes.Buffer
+	decided    bool
+	writer     CompressWriter
+}
+
+func (cw *compressResponseWriter) WriteHeader(statusCode int) {
+	if cw.decided || cw.statusCode != 0 {
+		return
+	}
+	if statusCode >= 100 && statusCode < 200 {
+		cw.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+	cw.statusCode = statusCode
+	if !bodyAllowed(statusCode) {
+		cw.decide(false)
+	}
+}
+
+func (cw *compressResponseWriter) Write(data []byte) (int, error) {
+	if cw.statusCode == 0 {
+		cw.statusCode = http.StatusOK
+	}
+	if !cw.decided {
+		if cw.buffer.Len()+len(data) < cw.compressor.config.MinSize {
+			return cw.buffer.Write(data)
+		}
+		if err := cw.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	if cw.writer != nil {
+		return cw.writer.Write(data)
+	}
+	return cw.ResponseWriter.Write(data)
+}
+
+// decide sends the header and the buffered part of the body compressed if it is allowed and the body is eligible.
+func (cw *compressResponseWriter) decide(allowed bool) error {
+	cw.decided = true
+	header := cw.Header()
+	if _, found := header["Content-Type"]; !found && cw.buffer.Len() > 0 {
+		// the same as net/http does, as content type must be detected before the body is compressed
+		header.Set("Content-Type", http.DetectContentType(cw.buffer.Bytes()))
+	}
+	if cw.compressor.compressible(header) {
+		header.Add("Vary", "Accept-Encoding")
+		if allowed {
+			pool := cw.compressor.pools[cw.index]
+			cw.writer = pool.Get().(CompressWriter)
+			cw.writer.Reset(cw.ResponseWriter)
+			header.Set("Content-Encoding", cw.compressor.config.Compressions[cw.index].Encoding)
+			header.Del("Content-Length")
+		}
+	}
+	if cw.statusCode != 0 {
+		cw.ResponseWriter.WriteHeader(cw.statusCode)
+	}
+	if cw.buffer.Len() == 0 {
+		return nil
+	}
+	var err error
+	if cw.writer != nil {
+		_, err = cw.writer.Write(cw.buffer.Bytes())
+	} else {
+		_, err = cw.ResponseWriter.Write(cw.buffer.Bytes())
+	}
+	cw.buffer.Reset()
+	return err
+}
+
+// Flush sends the response uncompressed if it is still smaller than the min size, e.g. on streaming of small events.
+func (cw *compressResponseWriter) Flush() {
+	if !cw.decided {
+		cw.decide(false)
+	}
+	if cw.writer != nil {
+		cw.writer.Flush()
+	}
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (cw *compressResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := cw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer doesn't support hijacking")
+	}
+	cw.decided = true
+	return hijacker.Hijack()
+}
+
+// close sends small responses uncompressed and returns the compress writer to the pool.
+func (cw *compressResponseWriter) close() {
+	if !cw.decided && (cw.statusCode != 0 || cw.buffer.Len() > 0) {
+		cw.decide(false)
+	}
+	if cw.writer != nil {
+		cw.writer.Close()
+		cw.writer.Reset(io.Discard)
+		cw.compressor.pools[cw.index].Put(cw.writer)
+		cw.writer = nil
+	}
+}
+
+func (cw *compressResponseWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// Compress enables compression of responses negotiated with Accept-Encoding request header.
+func (rt *Router) Compress(config CompressionConfig) *Router {
+	rt.compressor = newCompressor(config)
+	return rt
+}
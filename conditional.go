@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Conditional enables conditional GET and HEAD requests. ETag and Last-Modified headers returned by the handler
+// are compared with If-None-Match and If-Modified-Since request headers, so the response is 304 Not Modified
+// without encoding the body if they match. If computeETag is set, ETag is generated from the encoded body
+// of successful responses without own ETag.
+func (b builder) Conditional(computeETag bool) Builder {
+	cloned := b.clone()
+	cloned.conditional = &computeETag
+	return cloned
+}
+
+// buildConditional returns function checking the response after its headers are resolved and before the status
+// and the body are. It returns writer for the rest of the response and function finishing it, if the response is
+// not finished already as not modified.
+func (b *builder) buildConditional() func(results []reflect.Value, w http.ResponseWriter, r *http.Request) (http.ResponseWriter, func() error, bool) {
+	if b.conditional == nil {
+		return nil
+	}
+	computeETag := *b.conditional
+	statusIndex := b.resultIndex(responseStatusCodeParametersGroup)
+	responseStatusCodeParameters := b.responseStatusCodeParameters
+	return func(results []reflect.Value, w http.ResponseWriter, r *http.Request) (http.ResponseWriter, func() error, bool) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			return w, nil, false
+		}
+		if statusIndex >= 0 && responseStatusCodeParameters(results[statusIndex]) != http.StatusOK {
+			return w, nil, false
+		}
+		if notModified(r.Header, w.Header()) {
+			w.WriteHeader(http.StatusNotModified)
+			return w, nil, true
+		}
+		if !computeETag || w.Header().Get("ETag") != "" {
+			return w, nil, false
+		}
+		computing := &etagResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		return computing, func() error { return computing.finish(r) }, false
+	}
+}
+
+// notModified reports if validators of the response match conditions of the request.
+// If-Modified-Since is ignored if the request has If-None-Match header.
+func notModified(requestHeader, responseHeader http.Header) bool {
+	if ifNoneMatch := requestHeader.Get("If-None-Match"); ifNoneMatch != "" {
+		etag := responseHeader.Get("ETag")
+		return etag != "" && matchesETag(ifNoneMatch, etag)
+	}
+	ifModifiedSince, err := http.ParseTime(requestHeader.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	lastModified, err := http.ParseTime(responseHeader.Get("Last-Modified"))
+	if err != nil {
+		return false
+	}
+	return !lastModified.Truncate(time.Second).After(ifModifiedSince)
+}
+
+// matchesETag compares entity tags of If-None-Match with the weak comparison.
+func matchesETag(ifNoneMatch, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// etagResponseWriter keeps the body to generate ETag from it before the response is sent.
+type etagResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (erw *etagResponseWriter) WriteHeader(statusCode int) {
+	erw.statusCode = statusCode
+}
+
+func (erw *etagResponseWriter) Write(data []byte) (int, error) {
+	return erw.body.Write(data)
+}
+
+// Flush is ignored, as encoders flush every response.
+func (erw *etagResponseWriter) Flush() {}
+
+func (erw *etagResponseWriter) finish(r *http.Request) error {
+	if erw.statusCode == http.StatusOK {
+		sum := sha256.Sum256(erw.body.Bytes())
+		erw.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+		if notModified(r.Header, erw.Header()) {
+			erw.ResponseWriter.WriteHeader(http.StatusNotModified)
+			return nil
+		}
+	}
+	erw.ResponseWriter.WriteHeader(erw.statusCode)
+	_, err := erw.ResponseWriter.Write(erw.body.Bytes())
+	return err
+}
+
+func (erw *etagResponseWriter) Unwrap() http.ResponseWriter {
+	return erw.ResponseWriter
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+)
+
+var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+
+// ContextValue registers extractor of handler parameters of type T from the request context,
+// e.g. values put by authentication middleware. Extractor is a function of signature
+// func(ctx context.Context) (T, error), returned error is mapped as the handler one.
+func (b builder) ContextValue(extractor interface{}) Builder {
+	extractorType := reflect.TypeOf(extractor)
+	if extractorType == nil || extractorType.Kind() != reflect.Func ||
+		extractorType.NumIn() != 1 || extractorType.In(0) != contextType ||
+		extractorType.NumOut() != 2 || extractorType.Out(1) != errorType {
+		b.addError(InvalidMappingError(fmt.Errorf("context value extractor %T is not func(context.Context) (T, error)", extractor)))
+		return b
+	}
+
+	cloned := b.clone()
+	extractors := make(map[reflect.Type]reflect.Value, len(cloned.contextExtractors)+1)
+	for valueType, registered := range cloned.contextExtractors {
+		extractors[valueType] = registered
+	}
+	extractors[extractorType.Out(0)] = reflect.ValueOf(extractor)
+	cloned.contextExtractors = extractors
+	return cloned
+}
+
+// ContextKey creates extractor for ContextValue which takes value of type T stored in the context with the key.
+// Missing value results in the error with 500 status code as it means misconfiguration of the middleware.
+func ContextKey[T any](key interface{}) func(ctx context.Context) (T, error) {
+	return func(ctx context.Context) (T, error) {
+		value, ok := ctx.Value(key).(T)
+		if !ok {
+			return value, InvalidValueError(fmt.Errorf("no value of type %T in context by key %v", value, key))
+		}
+		return value, nil
+	}
+}
+
+func (b *builder) buildContextValueCollector(parameterType reflect.Type) func(w http.ResponseWriter, r *http.Request) ([]reflect.Value, error) {
+	extractor := b.contextExtractors[parameterType]
+	return func(w http.ResponseWriter, r *http.Request) ([]reflect.Value, error) {
+		results := extractor.Call([]reflect.Value{reflect.ValueOf(r.Context())})
+		if err, _ := results[1].Interface().(error); err != nil {
+			return nil, err
+		}
+		return results[:1], nil
+	}
+}
+
+func (b *builder) hasContextExtractor(parameterType reflect.Type) bool {
+	_, found := b.contextExtractors[parameterType]
+	return found
+}
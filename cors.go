@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSConfig defines which cross-origin requests are allowed.
+type CORSConfig struct {
+	// AllowedOrigins are origins allowed to make requests, "*" allows any origin.
+	AllowedOrigins []string
+	// AllowedMethods are methods allowed by preflight, methods of routes of the path are allowed if empty.
+	AllowedMethods []string
+	// AllowedHeaders are request headers allowed by preflight, requested headers are allowed if empty.
+	// Headers required by the route of the requested method and Content-Type if it consumes
+	// not CORS-safelisted media types are allowed in addition.
+	AllowedHeaders []string
+	// ExposedHeaders are response headers exposed to the client.
+	ExposedHeaders []string
+	// AllowCredentials allows requests with credentials, the origin is echoed instead of "*" then.
+	AllowCredentials bool
+	// MaxAge defines for how long preflight response could be cached, it is omitted if zero.
+	MaxAge time.Duration
+}
+
+// CORS creates OPTIONS handler answering preflight requests according to the config.
+// OPTIONS requests which are not preflight ones are answered with DefaultOptionsHandler.
+func CORS(config CORSConfig) OptionsHandler {
+	return func(w http.ResponseWriter, r *http.Request, allowed []string) {
+		requestedMethod := r.Header.Get("Access-Control-Request-Method")
+		if requestedMethod == "" || !config.setOriginHeaders(w, r) {
+			DefaultOptionsHandler(w, r, allowed)
+			return
+		}
+
+		methods := config.AllowedMethods
+		if len(methods) == 0 {
+			methods = allowed
+		}
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+		headers := config.AllowedHeaders
+		if len(headers) == 0 {
+			if requestedHeaders := r.Header.Get("Access-Control-Request-Headers"); requestedHeaders != "" {
+				for _, header := range strings.Split(requestedHeaders, ",") {
+					headers = append(headers, strings.TrimSpace(header))
+				}
+				w.Header().Add("Vary", "Access-Control-Request-Headers")
+			}
+		}
+		headers = appendRouteHeaders(headers, RoutesMetadataFromContext(r.Context()), requestedMethod)
+		if len(headers) > 0 {
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+		}
+		if config.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(config.MaxAge/time.Second)))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// corsSafelistedContentTypes are media types of Content-Type sent without preflight.
+var corsSafelistedContentTypes = []string{"application/x-www-form-urlencoded", "multipart/form-data", "text/plain"}
+
+// appendRouteHeaders appends headers used by the route of the method which are not in the headers yet.
+func appendRouteHeaders(headers []string, metadata []RouteMetadata, method string) []string {
+	add := func(header string) {
+		for _, existing := range headers {
+			if strings.EqualFold(existing, header) {
+				return
+			}
+		}
+		headers = append(headers, header)
+	}
+	for _, route := range metadata {
+		if route.Method != method {
+			continue
+		}
+		for _, header := range route.RequiredHeaders {
+			add(header)
+		}
+		for _, mediaType := range route.Consumes {
+			if !containsAny(corsSafelistedContentTypes, []string{mediaType}) {
+				add("Content-Type")
+				break
+			}
+		}
+	}
+	return headers
+}
+
+// setOriginHeaders sets headers allowing the origin of the request and reports if it is allowed.
+func (config CORSConfig) setOriginHeaders(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	anyOrigin := false
+	allowed := false
+	for _, allowedOrigin := range config.AllowedOrigins {
+		if allowedOrigin == "*" {
+			anyOrigin = true
+			allowed = true
+			break
+		}
+		if strings.EqualFold(allowedOrigin, origin) {
+			allowed = true
+			break
+		}
+	}
+	if !anyOrigin || config.AllowCredentials {
+		w.Header().Add("Vary", "Origin")
+	}
+	if !allowed {
+		return false
+	}
+
+	if anyOrigin && !config.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+	if config.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+	if len(config.ExposedHeaders) > 0 {
+		w.Header().Set("Access-Control-Expose-Headers", strings.Join(config.ExposedHeaders, ", "))
+	}
+	return true
+}
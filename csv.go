@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"reflect"
+	"time"
+)
+
+const csvTag = "csv"
+
+// csvCodec maps slices of structs to CSV rows with a header row. Columns are named by csv tag of fields
+// or by names of exported fields without it, "-" skips the field. Time fields are formatted with layout tag or RFC 3339,
+// byte slices and arrays with ByteEncoding of the layout tag.
+// Decoded columns are matched to fields by the header, unknown columns are ignored.
+type csvCodec struct{}
+
+func (csvCodec) MediaType() string {
+	return "text/csv"
+}
+
+// FramesItems reports that rows of streamed items follow a single header row.
+func (csvCodec) FramesItems() bool {
+	return true
+}
+
+func (cc csvCodec) NewDecodeStream(reader io.Reader) DecodeStream {
+	return csvDecodeStream{reader: csv.NewReader(reader)}
+}
+
+func (cc csvCodec) NewEncodeStream(writer io.Writer) EncodeStream {
+	return &csvEncodeStream{writer: writer, csv: csv.NewWriter(writer)}
+}
+
+type csvColumn struct {
+	name   string
+	index  []int
+	layout string
+}
+
+// csvColumns returns columns of the struct type, fields of embedded structs are flattened.
+func csvColumns(structType reflect.Type) []csvColumn {
+	var columns []csvColumn
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		name, tagged := field.Tag.Lookup(csvTag)
+		if name == "-" {
+			continue
+		}
+		if embeddedType, embedded := embeddedStruct(field); embedded && !tagged && field.Type.Kind() == reflect.Struct {
+			for _, column := range csvColumns(embeddedType) {
+				column.index = append([]int{i}, column.index...)
+				columns = append(columns, column)
+			}
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		columns = append(columns, csvColumn{name: name, index: field.Index, layout: field.Tag.Get(layoutTag)})
+	}
+	return columns
+}
+
+// csvRowType returns struct type of rows of the slice type, rows could be pointers to structs.
+func csvRowType(sliceType reflect.Type) (reflect.Type, bool) {
+	if sliceType.Kind() != reflect.Slice && sliceType.Kind() != reflect.Array {
+		return nil, false
+	}
+	rowType := sliceType.Elem()
+	if rowType.Kind() == reflect.Ptr {
+		rowType = rowType.Elem()
+	}
+	return rowType, rowType.Kind() == reflect.Struct
+}
+
+type csvDecodeStream struct {
+	reader *csv.Reader
+}
+
+func (cds csvDecodeStream) Decode(v interface{}) error {
+	target := reflect.ValueOf(v)
+	if target.Kind() != reflect.Ptr || target.Elem().Kind() != reflect.Slice {
+		return cds.error("", fmt.Errorf("unable to decode into %T, pointer to slice of structs is expected", v))
+	}
+	slice := target.Elem()
+	rowType, ok := csvRowType(slice.Type())
+	if !ok {
+		return cds.error("", fmt.Errorf("unable to decode into %T, pointer to slice of structs is expected", v))
+	}
+
+	header, err := cds.reader.Read()
+	if err == io.EOF {
+		return err
+	}
+	if err != nil {
+		return cds.error("", err)
+	}
+	byName := map[string]csvColumn{}
+	for _, column := range csvColumns(rowType) {
+		byName[column.name] = column
+	}
+	columns := make([]*csvColumn, len(header))
+	converters := make([]PathParameterConverter, len(header))
+	for i, name := range header {
+		column, found := byName[name]
+		if !found {
+			continue
+		}
+		converter, err := newLayoutPathParameterConverter(rowType.FieldByIndex(column.index).Type, column.layout)
+		if err != nil {
+			return cds.error(name, err)
+		}
+		columns[i], converters[i] = &column, converter
+	}
+
+	rows := reflect.MakeSlice(slice.Type(), 0, 0)
+	for {
+		record, err := cds.reader.Read()
+		if err == io.EOF {
+			slice.Set(rows)
+			return nil
+		}
+		if err != nil {
+			return cds.error("", err)
+		}
+		row := reflect.New(rowType).Elem()
+		for i, value := range record {
+			if columns[i] == nil {
+				continue
+			}
+			converted, err := converters[i].Convert(value)
+			if err != nil {
+				return cds.error(columns[i].name, err)
+			}
+			row.FieldByIndex(columns[i].index).Set(converted)
+		}
+		if slice.Type().Elem().Kind() == reflect.Ptr {
+			row = row.Addr()
+		}
+		rows = reflect.Append(rows, row)
+	}
+}
+
+func (cds csvDecodeStream) error(field string, cause error) error {
+	return DecodeError{MediaType: csvCodec{}.MediaType(), Offset: cds.reader.InputOffset(), Field: field, Cause: cause}
+}
+
+type csvEncodeStream struct {
+	writer io.Writer
+	csv    *csv.Writer
+	// headerWritten is set after the first encoded value, so items of streamed response share the header row
+	headerWritten bool
+}
+
+func (ces *csvEncodeStream) Encode(v interface{}) error {
+	value := reflect.ValueOf(v)
+	if value.Kind() == reflect.Struct {
+		// single row, e.g. an item of streamed response
+		slice := reflect.MakeSlice(reflect.SliceOf(value.Type()), 1, 1)
+		slice.Index(0).Set(value)
+		value = slice
+	}
+	rowType, ok := csvRowType(value.Type())
+	if !ok {
+		return EncodeError{MediaType: csvCodec{}.MediaType(), Cause: fmt.Errorf("unable to encode %T, slice of structs is expected", v)}
+	}
+
+	columns := csvColumns(rowType)
+	record := make([]string, len(columns))
+	if !ces.headerWritten {
+		for i, column := range columns {
+			record[i] = column.name
+		}
+		if err := ces.csv.Write(record); err != nil {
+			return EncodeError{MediaType: csvCodec{}.MediaType(), Cause: err}
+		}
+		ces.headerWritten = true
+	}
+	for i := 0; i < value.Len(); i++ {
+		row := value.Index(i)
+		if row.Kind() == reflect.Ptr {
+			if row.IsNil() {
+				continue
+			}
+			row = row.Elem()
+		}
+		for j, column := range columns {
+			formatted, err := formatCSVValue(row.FieldByIndex(column.index), column.layout)
+			if err != nil {
+				return EncodeError{MediaType: csvCodec{}.MediaType(), Cause: fmt.Errorf("column %s: %w", column.name, err)}
+			}
+			record[j] = formatted
+		}
+		if err := ces.csv.Write(record); err != nil {
+			return EncodeError{MediaType: csvCodec{}.MediaType(), Cause: err}
+		}
+	}
+	return nil
+}
+
+func (ces *csvEncodeStream) Flush() error {
+	ces.csv.Flush()
+	if err := ces.csv.Error(); err != nil {
+		return EncodeError{MediaType: csvCodec{}.MediaType(), Cause: err}
+	}
+	return flushWriter(ces.writer)
+}
+
+func formatCSVValue(value reflect.Value, layout string) (string, error) {
+	if value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return "", nil
+		}
+		value = value.Elem()
+	}
+	if value.Type() == timeType {
+		if layout == "" {
+			layout = time.RFC3339
+		}
+		return value.Interface().(time.Time).Format(layout), nil
+	}
+	if isBytesKind(value.Type()) {
+		bytes := reflect.New(reflect.SliceOf(value.Type().Elem())).Elem()
+		if value.Kind() == reflect.Array {
+			bytes.Set(reflect.MakeSlice(bytes.Type(), value.Len(), value.Len()))
+			reflect.Copy(bytes, value)
+		} else {
+			bytes.Set(value)
+		}
+		return ByteEncoding(layout).encode(bytes.Bytes()), nil
+	}
+	if marshaler, ok := value.Interface().(encoding.TextMarshaler); ok {
+		text, err := marshaler.MarshalText()
+		return string(text), err
+	}
+	return fmt.Sprint(value.Interface()), nil
+}
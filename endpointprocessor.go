@@ -1,20 +1,338 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"net/url"
 	"reflect"
+	"runtime/debug"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
+// EndpointProcessor handles requests of the route built by Builder.
+// It holds only state frozen on Build and settings of the router swapped atomically, so it is safe for concurrent use.
 type EndpointProcessor struct {
-	errors []error
-	processRequest  func(r *http.Request) ([]reflect.Value, error)
+	errors          []error
+	issues          []EndpointIssue
+	route           RouteInfo
+	metadata        RouteMetadata
+	queryConditions url.Values
+	matchesQuery    func(queryValues url.Values) bool
+	constraints     []pathConstraint
+	preconditions   []Interceptor
+	requiredHeaders []requiredHeader
+	writerInjected  bool
+	streams         bool
+	unreadBody      UnreadBodyPolicy
+	bufferLimit     int
+	limits          RequestLimits
+	maxBodyBytes    int64
+	maxResponse     ResponseTooLargeError
+	cache           CachePolicy
+	invalidations   []cacheInvalidation
+	propagated      []string
+	encoderFallback bool
+	tempDirs        *string
+	pool            *WorkerPool
+	retryHints      *RetryHints
+	middleware      http.Handler
+	interceptors    []interceptor
+	after           []afterInterceptor
+	scoped          bool
+	pipeline        []PipelineStep
+	handler         reflect.Value
+	positionalPath  []reflect.Type
+	noAccessLog     bool
+	timeout         TimeoutError
+	headers         http.Header
+	errorMapper     ErrorMapper
+	requestMapper   ErrorMapper
+	debug           bool
+	hooks           Hooks
+	clock           Clock
+	settings        *atomic.Pointer[routerSettings]
+	bindParameters  func(w http.ResponseWriter, r *http.Request) ([]reflect.Value, error)
+	admit           func(values []reflect.Value) error
+	limit           func(r *http.Request, values []reflect.Value, now time.Time) error
+	invoke          func(values []reflect.Value) []reflect.Value
+	resultError     func(results []reflect.Value) error
 	produceResponse func(executionResult []reflect.Value, executionError error, w http.ResponseWriter, r *http.Request) error
 }
 
 func (ep EndpointProcessor) Handle(w http.ResponseWriter, r *http.Request) error {
+	if ep.settings != nil {
+		if settings := ep.settings.Load(); settings != nil {
+			ep.hooks = settings.hooks
+			if settings.clock != nil {
+				ep.clock = settings.clock
+			}
+		}
+	}
+	if ep.scoped {
+		// created before middleware, so it could provide values too
+		r = r.WithContext(context.WithValue(r.Context(), injectedValuesKey{}, injectedValues{}))
+	}
+	if ep.middleware != nil {
+		return ep.handleWithMiddleware(w, r)
+	}
+	return ep.handle(w, r)
+}
+
+func (ep EndpointProcessor) handle(w http.ResponseWriter, r *http.Request) (err error) {
 	if ep.errors != nil {
 		return ep.errors[0]
 	}
-	results, err := ep.processRequest(r)
-	return ep.produceResponse(results, err, w, r)
+	if r.Method == http.MethodHead {
+		// committed after the panic is mapped
+		head := &headResponseWriter{ResponseWriter: w}
+		defer head.commit()
+		w = head
+	}
+	var values []reflect.Value
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			panicErr, propagated := recovered.(PanicError)
+			if !propagated {
+				panicErr = PanicError{Value: recovered}
+				if ep.debug {
+					panicErr.Stack = debug.Stack()
+				}
+			}
+			if panicErr.Parameters == nil {
+				panicErr.Parameters = snapshotParameters(values)
+			}
+			ep.hooks.error(ep.route, r, InvokeStage, panicErr)
+			err = ep.errorMapper(panicErr, w, r)
+		}
+	}()
+	r = r.WithContext(context.WithValue(r.Context(), routeInfoKey{}, ep.route))
+	if ep.streams {
+		// producers of abandoned streams are stopped, e.g. on errors of encoding
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		r = r.WithContext(ctx)
+	}
+	if ep.tempDirs != nil {
+		dirs := newTempDirs(*ep.tempDirs)
+		defer dirs.release()
+		r = r.WithContext(context.WithValue(r.Context(), tempDirsKey{}, dirs))
+	}
+	if len(ep.propagated) > 0 {
+		r = r.WithContext(context.WithValue(r.Context(), propagatedHeadersKey{}, propagatedHeaders(r, ep.propagated)))
+	}
+	for name, values := range ep.headers {
+		w.Header()[name] = append([]string(nil), values...)
+	}
+	if err := ep.limits.check(r); err != nil {
+		return ep.requestMapper(RequestError{Cause: err}, w, r)
+	}
+	if len(ep.constraints) > 0 {
+		if err := checkConstraints(ep.constraints, strings.Split(r.URL.EscapedPath(), pathSeparator)); err != nil {
+			return ep.requestMapper(RequestError{Cause: err}, w, r)
+		}
+	}
+	if ep.retryHints != nil {
+		hints, timeout := *ep.retryHints, ep.timeout.Timeout
+		w = &onFirstWriteHeader{ResponseWriter: w, before: func(header http.Header, statusCode int) {
+			hints.setHeaders(header, statusCode, timeout)
+		}}
+	}
+	body := trackBody(r)
+	defer ep.unreadBody.finish(body)
+	if ep.maxBodyBytes > 0 && body != nil {
+		if r.ContentLength > ep.maxBodyBytes {
+			ep.unreadBody.markEarlyResponse(body, w.Header())
+			return ep.requestMapper(RequestError{Cause: &http.MaxBytesError{Limit: ep.maxBodyBytes}}, w, r)
+		}
+		body.ReadCloser = http.MaxBytesReader(w, body.ReadCloser, ep.maxBodyBytes)
+	}
+	var guarded *timeoutResponseWriter
+	if ep.timeout.Timeout > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), ep.timeout.Timeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+		guarded = newTimeoutResponseWriter(w)
+		w = guarded
+	}
+
+	var tracked *trackingResponseWriter
+	if ep.writerInjected || ep.unreadBody.CloseConnection {
+		tracked = &trackingResponseWriter{ResponseWriter: w, beforeWrite: func(header http.Header) {
+			ep.unreadBody.markEarlyResponse(body, header)
+		}}
+		w = tracked
+	}
+	for _, precondition := range ep.preconditions {
+		if !precondition(w, r) {
+			return nil
+		}
+	}
+	if err := checkRequiredHeaders(ep.requiredHeaders, r); err != nil {
+		ep.hooks.error(ep.route, r, BindStage, err)
+		ep.unreadBody.markEarlyResponse(body, w.Header())
+		return ep.requestMapper(err, w, r)
+	}
+	for _, intercept := range ep.interceptors {
+		var proceed bool
+		if r, proceed, err = intercept(w, r); proceed {
+			continue
+		}
+		if err == nil {
+			return nil
+		}
+		ep.unreadBody.markEarlyResponse(body, w.Header())
+		var requestErr RequestError
+		if errors.As(err, &requestErr) {
+			ep.hooks.error(ep.route, r, BindStage, err)
+			return ep.requestMapper(err, w, r)
+		}
+		ep.hooks.error(ep.route, r, InterceptStage, err)
+		return ep.errorMapper(err, w, r)
+	}
+	if ep.cache.Store != nil && ep.cache.cacheable(r) {
+		recorder, served := ep.serveCached(w, r)
+		if served {
+			return nil
+		}
+		defer func() { ep.store(recorder, err) }()
+		w = recorder
+	}
+	if len(ep.invalidations) > 0 {
+		recorder := &cacheResponseWriter{ResponseWriter: w}
+		defer func() { ep.invalidate(recorder, r, err) }()
+		w = recorder
+	}
+	startedAt := ep.clock.Now()
+	values, err = ep.bindParameters(w, r)
+	ep.hooks.bind(ep.route, r, values, ep.clock.Now().Sub(startedAt), err)
+	if err != nil {
+		ep.unreadBody.markEarlyResponse(body, w.Header())
+		var requestErr RequestError
+		if errors.As(err, &requestErr) {
+			return ep.requestMapper(err, w, r)
+		}
+		return err
+	}
+	if err = ep.admit(values); err != nil {
+		ep.hooks.error(ep.route, r, AdmissionStage, err)
+		ep.unreadBody.markEarlyResponse(body, w.Header())
+		return ep.errorMapper(err, w, r)
+	}
+	if ep.limit != nil {
+		if err = ep.limit(r, values, ep.clock.Now()); err != nil {
+			ep.hooks.error(ep.route, r, AdmissionStage, err)
+			ep.unreadBody.markEarlyResponse(body, w.Header())
+			return ep.errorMapper(err, w, r)
+		}
+	}
+
+	startedAt = ep.clock.Now()
+	var results []reflect.Value
+	switch {
+	case ep.pool != nil:
+		var completed bool
+		results, completed, err = ep.pool.execute(r.Context(), ep.invoke, values, ep.debug)
+		if err != nil {
+			ep.hooks.error(ep.route, r, AdmissionStage, err)
+			ep.unreadBody.markEarlyResponse(body, w.Header())
+			return ep.errorMapper(err, w, r)
+		}
+		if !completed && guarded != nil {
+			return ep.respondTimeout(guarded, r)
+		}
+		if !completed {
+			return r.Context().Err()
+		}
+	case guarded != nil:
+		var completed bool
+		if results, completed = ep.invokeWithin(r.Context(), values); !completed {
+			return ep.respondTimeout(guarded, r)
+		}
+	default:
+		results = ep.invoke(values)
+	}
+	ep.hooks.invoke(ep.route, r, values, results, ep.clock.Now().Sub(startedAt), ep.resultError(results))
+	if ep.writerInjected && tracked.written {
+		return nil
+	}
+	for _, intercept := range ep.after {
+		proceed, err := intercept(results, w, r)
+		if proceed {
+			continue
+		}
+		if err == nil {
+			return nil
+		}
+		ep.hooks.error(ep.route, r, InterceptStage, err)
+		return ep.errorMapper(err, w, r)
+	}
+
+	startedAt = ep.clock.Now()
+	if ep.encoderFallback && ep.hooks.OnEncoderFallback != nil {
+		r = withFallbackReporter(r, func(from, to string, err error) { ep.hooks.fallback(ep.route, r, from, to, err) })
+	}
+	if ep.bufferLimit > 0 && !ep.writerInjected {
+		err = ep.produceBufferedResponse(results, w, r)
+	} else {
+		err = ep.produceDeferredResponse(results, w, r)
+	}
+	ep.hooks.encode(ep.route, r, ep.clock.Now().Sub(startedAt), err)
+	var tooLarge ResponseTooLargeError
+	var partialErr PartialResponseError
+	if errors.As(err, &tooLarge) && !errors.As(err, &partialErr) {
+		return ep.errorMapper(err, w, r)
+	}
+	return err
+}
+
+// respondTimeout maps TimeoutError unless the handler has already started the response through injected writer.
+func (ep EndpointProcessor) respondTimeout(guarded *timeoutResponseWriter, r *http.Request) error {
+	ep.hooks.error(ep.route, r, InvokeStage, ep.timeout)
+	if !guarded.timeout() {
+		return PartialResponseError{Cause: ep.timeout}
+	}
+	return ep.errorMapper(ep.timeout, guarded.target, r)
+}
+
+// produceBufferedResponse discards the response on error if it is not streamed yet, so the error could be mapped.
+func (ep EndpointProcessor) produceBufferedResponse(results []reflect.Value, w http.ResponseWriter, r *http.Request) error {
+	buffered := newBufferedResponseWriter(w, ep.bufferLimit)
+	if err := ep.produceResponse(results, nil, ep.limitResponse(buffered), r); err != nil {
+		if buffered.flushed {
+			return PartialResponseError{Cause: err}
+		}
+		return err
+	}
+	return buffered.commit()
+}
+
+// produceDeferredResponse restores headers on error if nothing is sent yet, so the error could be mapped.
+func (ep EndpointProcessor) produceDeferredResponse(results []reflect.Value, w http.ResponseWriter, r *http.Request) error {
+	header := w.Header().Clone()
+	deferred := &deferredResponseWriter{ResponseWriter: w}
+	if err := ep.produceResponse(results, nil, ep.limitResponse(deferred), r); err != nil {
+		if deferred.committed {
+			return PartialResponseError{Cause: err}
+		}
+		for name := range w.Header() {
+			delete(w.Header(), name)
+		}
+		for name, values := range header {
+			w.Header()[name] = values
+		}
+		return err
+	}
+	if !deferred.committed {
+		// nothing is written, so there is no body to describe
+		w.Header().Del("Content-Type")
+	}
+	deferred.commit()
+	return nil
+}
+
+func (ep EndpointProcessor) RouteInfo() RouteInfo {
+	return ep.route
 }
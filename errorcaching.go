@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ErrorCacheRule attaches caching headers to error responses with the status code or caused by the error.
+// Headers already set by the error mapper or the error itself are not changed.
+type ErrorCacheRule struct {
+	// StatusCode of the error response matched by the rule, any if zero.
+	StatusCode int
+	// Error matched with errors.Is, any if nil.
+	Error error
+	// CacheControl is a value of Cache-Control header, omitted if empty.
+	CacheControl string
+	// RetryAfter is a delay set in Retry-After header in seconds, omitted if zero.
+	RetryAfter time.Duration
+}
+
+func (rule ErrorCacheRule) matches(err error, statusCode int) bool {
+	if rule.StatusCode != 0 && rule.StatusCode != statusCode {
+		return false
+	}
+	return rule.Error == nil || errors.Is(err, rule.Error)
+}
+
+// ErrorCachePolicy is a list of rules, headers of all matched rules are set with the first matched one winning.
+type ErrorCachePolicy []ErrorCacheRule
+
+// Apply decorates the error mapper with setting caching headers of the policy on error responses.
+func (policy ErrorCachePolicy) Apply(errorMapper ErrorMapper) ErrorMapper {
+	return func(err error, w http.ResponseWriter, r *http.Request) error {
+		return errorMapper(err, &onFirstWriteHeader{ResponseWriter: w, before: func(header http.Header, statusCode int) {
+			policy.setHeaders(err, statusCode, header)
+		}}, r)
+	}
+}
+
+func (policy ErrorCachePolicy) setHeaders(err error, statusCode int, header http.Header) {
+	for _, rule := range policy {
+		if !rule.matches(err, statusCode) {
+			continue
+		}
+		if rule.CacheControl != "" && header.Get("Cache-Control") == "" {
+			header.Set("Cache-Control", rule.CacheControl)
+		}
+		if rule.RetryAfter > 0 && header.Get("Retry-After") == "" {
+			header.Set("Retry-After", strconv.FormatInt(seconds(rule.RetryAfter), 10))
+		}
+	}
+}
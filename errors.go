@@ -1,12 +1,19 @@
 package main
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
 
 type GeneralErrorCause error
 
 var (
 	UnsupportedType = errors.New("unsupported type")
 	InvalidMapping  = errors.New("invalid mapping")
+	InvalidValue    = errors.New("invalid value")
+
+	UnsupportedMediaType = errors.New("unsupported media type")
 )
 
 func UnsupportedTypeError(contextCause error) error {
@@ -17,6 +24,14 @@ func InvalidMappingError(contextCause error) error {
 	return Error{GeneralCause: InvalidMapping, ContextCause: contextCause}
 }
 
+func InvalidValueError(contextCause error) error {
+	return Error{GeneralCause: InvalidValue, ContextCause: contextCause}
+}
+
+func UnsupportedMediaTypeError(contextCause error) error {
+	return Error{GeneralCause: UnsupportedMediaType, ContextCause: contextCause}
+}
+
 type Error struct {
 	GeneralCause GeneralErrorCause
 	ContextCause error
@@ -33,3 +48,136 @@ func (e Error) Error() string {
 	}
 	return ""
 }
+
+func (e Error) Unwrap() []error {
+	return []error{e.GeneralCause, e.ContextCause}
+}
+
+// StatusCoder is implemented by errors which define HTTP response status code.
+type StatusCoder interface {
+	StatusCode() int
+}
+
+// HeadersCarrier is implemented by errors which define HTTP response headers.
+type HeadersCarrier interface {
+	Headers() http.Header
+}
+
+func errorStatusCode(err error, defaultStatusCode int) int {
+	var statusCoder StatusCoder
+	if errors.As(err, &statusCoder) {
+		if statusCode := statusCoder.StatusCode(); statusCode != 0 {
+			return statusCode
+		}
+	}
+	return defaultStatusCode
+}
+
+func setErrorHeaders(err error, w http.ResponseWriter) {
+	var headersCarrier HeadersCarrier
+	if !errors.As(err, &headersCarrier) {
+		return
+	}
+	for name, values := range headersCarrier.Headers() {
+		for _, value := range values {
+			w.Header().Add(name, value)
+		}
+	}
+}
+
+// RequestError is returned when the request can't be bound to handler parameters, e.g. on malformed body
+// or path parameter. It is mapped by request error mapper of the endpoint instead of the handler error one.
+type RequestError struct {
+	Cause error
+}
+
+func (e RequestError) Error() string {
+	return "bad request: " + e.Cause.Error()
+}
+
+func (e RequestError) Unwrap() error {
+	return e.Cause
+}
+
+// StatusCode is 400 Bad Request unless the cause defines own status code, it is unsupported media type
+// or the body exceeds the limit.
+func (e RequestError) StatusCode() int {
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.Is(e.Cause, UnsupportedMediaType):
+		return http.StatusUnsupportedMediaType
+	case errors.As(e.Cause, &maxBytesErr):
+		return http.StatusRequestEntityTooLarge
+	}
+	return errorStatusCode(e.Cause, http.StatusBadRequest)
+}
+
+func requestError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return RequestError{Cause: err}
+}
+
+// PanicError is a panic of the handler recovered by the endpoint. Parameters are snapshots
+// of the handler parameters, if they were bound before the panic.
+type PanicError struct {
+	Value      interface{}
+	Stack      []byte
+	Parameters []ParameterSnapshot
+}
+
+func (e PanicError) Error() string {
+	if len(e.Stack) > 0 {
+		return fmt.Sprintf("panic: %v\n%s", e.Value, e.Stack)
+	}
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+func (e PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// BuildError describes misconfiguration of the endpoint detected on Build.
+// Parameter and Result are indexes of the handler parameter and return value caused the error, or -1 if not related to one.
+type BuildError struct {
+	Method    string
+	Template  string
+	Handler   string
+	Parameter int
+	Result    int
+	Cause     error
+}
+
+func (e BuildError) Error() string {
+	location := e.Method + " " + e.Template
+	if e.Handler != "" {
+		location += ", handler " + e.Handler
+	}
+	if e.Parameter >= 0 {
+		location += fmt.Sprintf(", parameter %d", e.Parameter)
+	}
+	if e.Result >= 0 {
+		location += fmt.Sprintf(", result %d", e.Result)
+	}
+	return e.Cause.Error() + " (" + location + ")"
+}
+
+func (e BuildError) Unwrap() error {
+	return e.Cause
+}
+
+// PartialResponseError is returned when encoding of the response fails after part of it is sent to the client.
+// Such response can't be replaced with the error one, so Router aborts the connection for the client to notice it.
+type PartialResponseError struct {
+	Cause error
+}
+
+func (e PartialResponseError) Error() string {
+	return "response is partially written: " + e.Cause.Error()
+}
+
+func (e PartialResponseError) Unwrap() error {
+	return e.Cause
+}
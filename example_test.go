@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+)
+
+type exampleUser struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+func (u exampleUser) Validate() error {
+	if u.Name == "" {
+		return ValidationError{Fields: []FieldError{{Field: "name", Message: "is required"}}}
+	}
+	return nil
+}
+
+type exampleUserFilter struct {
+	Name  string `query:"name" normalize:"trim,lower"`
+	Limit int    `query:"limit"`
+}
+
+type exampleCaller struct {
+	Subject string
+}
+
+type exampleCallerKey struct{}
+
+var errExampleUserNotFound = Problem{Status: http.StatusNotFound, Title: "user not found"}
+
+// exampleUsers is a storage of the example application.
+type exampleUsers struct {
+	mu    sync.Mutex
+	users []exampleUser
+}
+
+func (s *exampleUsers) Get(id int) (exampleUser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, user := range s.users {
+		if user.ID == id {
+			return user, nil
+		}
+	}
+	return exampleUser{}, errExampleUserNotFound
+}
+
+func (s *exampleUsers) List(filter exampleUserFilter) []exampleUser {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	found := []exampleUser{}
+	for _, user := range s.users {
+		if strings.Contains(strings.ToLower(user.Name), filter.Name) && (filter.Limit == 0 || len(found) < filter.Limit) {
+			found = append(found, user)
+		}
+	}
+	return found
+}
+
+func (s *exampleUsers) Create(caller exampleCaller, user exampleUser) (exampleUser, int, http.Header) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user.ID = len(s.users) + 1
+	s.users = append(s.users, user)
+	return user, http.StatusCreated, http.Header{"Location": {fmt.Sprintf("/users/%d", user.ID)}, "X-Created-By": {caller.Subject}}
+}
+
+// exampleAuthentication puts the caller authenticated by the token into the request context.
+func exampleAuthentication(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), exampleCallerKey{}, exampleCaller{Subject: token})))
+	})
+}
+
+func exampleCallerOf(ctx context.Context) (exampleCaller, error) {
+	caller, ok := ctx.Value(exampleCallerKey{}).(exampleCaller)
+	if !ok {
+		return caller, Problem{Status: http.StatusUnauthorized, Title: "authentication required"}
+	}
+	return caller, nil
+}
+
+// Example of the application serving users: routes are registered on the router with JSON codecs,
+// the caller is extracted from the context filled by authentication middleware and errors are rendered as problem details.
+func Example() {
+	users := &exampleUsers{}
+	router := NewRouter().
+		ErrorMapping(ProblemErrorMapper).
+		MaxBodyBytes(1<<20).
+		Tag("team", "identity")
+	err := router.Register(
+		POST("/users").
+			Decoder(JSONDecoder).
+			Encoder(JSONEncoder).
+			ContextValue(exampleCallerOf).
+			Handler(users.Create),
+		GET("/users/:id").
+			Encoder(JSONEncoder).
+			Handler(users.Get),
+		GET("/users").
+			Encoder(JSONEncoder).
+			Handler(users.List),
+	)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	server := httptest.NewServer(exampleAuthentication(router))
+	defer server.Close()
+
+	for _, request := range []struct {
+		method string
+		path   string
+		token  string
+		body   string
+	}{
+		{method: http.MethodPost, path: "/users", token: "admin", body: `{"name": "Ada", "email": "ada@example.com"}`},
+		{method: http.MethodPost, path: "/users", token: "admin", body: `{"email": "anonymous@example.com"}`},
+		{method: http.MethodPost, path: "/users", body: `{"name": "Bob"}`},
+		{method: http.MethodGet, path: "/users/1"},
+		{method: http.MethodGet, path: "/users/2"},
+		{method: http.MethodGet, path: "/users?name=%20ADA%20"},
+	} {
+		r, err := http.NewRequest(request.method, server.URL+request.path, strings.NewReader(request.body))
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if request.token != "" {
+			r.Header.Set("Authorization", "Bearer "+request.token)
+		}
+		response, err := server.Client().Do(r)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		body, err := io.ReadAll(response.Body)
+		response.Body.Close()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Println(request.method, request.path, response.StatusCode, response.Header.Get("Location"), strings.TrimSpace(string(body)))
+	}
+
+	// Output:
+	// POST /users 201 /users/1 {"id":1,"name":"Ada","email":"ada@example.com"}
+	// POST /users 400  {"detail":"bad request: validation failed: name: is required","fields":[{"field":"name","message":"is required"}],"instance":"/users","status":400,"title":"Bad Request","type":"about:blank"}
+	// POST /users 401  {"instance":"/users","status":401,"title":"authentication required","type":"about:blank"}
+	// GET /users/1 200  {"id":1,"name":"Ada","email":"ada@example.com"}
+	// GET /users/2 404  {"instance":"/users/2","status":404,"title":"user not found","type":"about:blank"}
+	// GET /users?name=%20ADA%20 200  [{"id":1,"name":"Ada","email":"ada@example.com"}]
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+)
+
+// ExampleMismatchError reports the response of the route replayed by ReplayExamples which doesn't match
+// its response examples. Expected is empty if the route has no example of the status.
+type ExampleMismatchError struct {
+	Route    RouteInfo
+	Status   int
+	Expected string
+	Received string
+}
+
+func (e ExampleMismatchError) Error() string {
+	if e.Expected == "" {
+		return fmt.Sprintf("%s %s responded with %d without example: %q", e.Route.Method, e.Route.Template, e.Status, e.Received)
+	}
+	return fmt.Sprintf("%s %s responded with %d: expected %q, received %q", e.Route.Method, e.Route.Template, e.Status, e.Expected, e.Received)
+}
+
+// ReplayExamples sends the request example of the route to the handler, e.g. the router serving it, at the target
+// URL and compares the response with the response example of its status encoded as Mock responds with it.
+// The request example is encoded by the codec of the media type of the route decoder, routes without it are
+// requested without body. It returns ExampleMismatchError if the response differs, e.g. in golden tests:
+//
+//	if err := ReplayExamples(router, "/accounts/7", route); err != nil {
+//		t.Error(err)
+//	}
+func ReplayExamples(handler http.Handler, target string, route Builder) error {
+	b, ok := route.(builder)
+	if !ok {
+		return InvalidMappingError(fmt.Errorf("unable to replay examples of route of type %T", route))
+	}
+	var body io.Reader
+	contentType := ""
+	if b.requestExample != nil {
+		codec, err := b.exampleCodec()
+		if err != nil {
+			return err
+		}
+		var encoded bytes.Buffer
+		if err := encode(codec, &encoded, b.requestExample); err != nil {
+			return err
+		}
+		body, contentType = &encoded, codec.MediaType()
+	}
+	r := httptest.NewRequest(b.method, target, body)
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	mismatch := ExampleMismatchError{Route: b.routeInfo(), Status: w.Code, Received: w.Body.String()}
+	for _, example := range b.responseExamples {
+		if example.statusCode != w.Code {
+			continue
+		}
+		rep, _ := b.buildNegotiation()(r.Header.Get("Accept"))
+		var expected bytes.Buffer
+		if err := writeExample(&expected, rep, example.value); err != nil {
+			return err
+		}
+		if expected.String() == mismatch.Received {
+			return nil
+		}
+		mismatch.Expected = expected.String()
+		return mismatch
+	}
+	return mismatch
+}
+
+// exampleCodec returns codec encoding the request example for the decoder of the route.
+func (b builder) exampleCodec() (Codec, error) {
+	var mediaTypes []string
+	if b.decoder != nil {
+		mediaTypes = append(mediaTypes, b.decoder.MediaType())
+	}
+	var registered []string
+	for mediaType := range b.decoders {
+		registered = append(registered, mediaType)
+	}
+	sort.Strings(registered)
+	for _, mediaType := range append(mediaTypes, registered...) {
+		if codec, found := LookupCodec(mediaType); found {
+			return codec, nil
+		}
+	}
+	return nil, InvalidMappingError(fmt.Errorf("no codec encodes request example of %s %s", b.method, b.pathTemplate))
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+)
+
+// EncoderFallback sets encoders tried in order when the negotiated encoder fails to encode the response body,
+// e.g. ProtoEncoder given a plain struct during migration between codecs. The body is encoded in memory then,
+// so nothing is sent by failed encoders, and Content-Type is set to media type of the encoder which succeeded.
+// Decisions are reported to OnEncoderFallback hook. Streamed bodies are encoded by the negotiated encoder only.
+func (b builder) EncoderFallback(encoders ...Encoder) Builder {
+	cloned := b.clone()
+	cloned.fallbackEncoders = append([]Encoder(nil), encoders...)
+	return cloned
+}
+
+type encoderFallbackKey struct{}
+
+// fallbackReporter is put into the request context by the endpoint if OnEncoderFallback hook is set.
+type fallbackReporter func(from, to string, err error)
+
+func withFallbackReporter(r *http.Request, report fallbackReporter) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), encoderFallbackKey{}, report))
+}
+
+// fallbackEncoder encodes with the first of encoders which succeeds.
+type fallbackEncoder struct {
+	encoders []Encoder
+	report   fallbackReporter
+}
+
+func (fe fallbackEncoder) MediaType() string {
+	return fe.encoders[0].MediaType()
+}
+
+func (fe fallbackEncoder) NewEncodeStream(writer io.Writer) EncodeStream {
+	return fallbackEncodeStream{fallbackEncoder: fe, writer: writer}
+}
+
+type fallbackEncodeStream struct {
+	fallbackEncoder
+	writer io.Writer
+}
+
+func (fes fallbackEncodeStream) Encode(v interface{}) error {
+	var firstErr error
+	for i, encoder := range fes.encoders {
+		var buffer bytes.Buffer
+		err := encode(encoder, &buffer, v)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if i > 0 {
+			if fes.report != nil {
+				fes.report(fes.encoders[0].MediaType(), encoder.MediaType(), firstErr)
+			}
+			if w, ok := fes.writer.(http.ResponseWriter); ok && encoder.MediaType() != "" {
+				w.Header().Set("Content-Type", encoder.MediaType())
+			}
+		}
+		_, err = buffer.WriteTo(fes.writer)
+		return err
+	}
+	return firstErr
+}
+
+func (fes fallbackEncodeStream) Flush() error {
+	return flushWriter(fes.writer)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Fields enables sparse fieldsets of encoded response bodies, e.g. ?fields=id,name with "fields" query parameter.
+// Fields of struct bodies, of their pointers, slices and arrays are selected by names of json, xml and csv tags
+// or Go names, case-insensitively. Fields of embedded structs are selected as promoted ones, nested fields are kept whole.
+// Unknown names are ignored and the body is not filtered if the query parameter is absent.
+func (b builder) Fields(queryParameter string) Builder {
+	cloned := b.clone()
+	cloned.fieldsQuery = queryParameter
+	return cloned
+}
+
+// buildFields returns function replacing the body in results with its copy having only requested fields.
+func (b *builder) buildFields() func(results []reflect.Value, r *http.Request) []reflect.Value {
+	bodyIndex := b.resultIndex(responseBodyParametersGroup)
+	if b.fieldsQuery == "" || bodyIndex < 0 || (b.encoder == nil && len(b.encoders) == 0) || b.streamsBody() {
+		return nil
+	}
+	query := b.fieldsQuery
+	return func(results []reflect.Value, r *http.Request) []reflect.Value {
+		values, requested := r.URL.Query()[query]
+		if !requested {
+			return results
+		}
+		selected := map[string]bool{}
+		for _, value := range values {
+			for _, name := range strings.Split(value, ",") {
+				if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+					selected[name] = true
+				}
+			}
+		}
+		filtered := append([]reflect.Value(nil), results...)
+		filtered[bodyIndex] = filterFields(results[bodyIndex], selected)
+		return filtered
+	}
+}
+
+// filterFields copies struct value, or items of slice and array, into struct having only selected fields.
+// Other values are returned as is.
+func filterFields(value reflect.Value, selected map[string]bool) reflect.Value {
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return value
+		}
+		value = value.Elem()
+	}
+	switch value.Kind() {
+	case reflect.Struct:
+		projection := projectionOf(value.Type(), selected)
+		filtered := reflect.New(projection.target).Elem()
+		for i, index := range projection.indexes {
+			if field, err := value.FieldByIndexErr(index); err == nil {
+				filtered.Field(i).Set(field)
+			}
+		}
+		return filtered
+
+	case reflect.Slice, reflect.Array:
+		if value.Kind() == reflect.Slice && (value.IsNil() || value.Type().Elem().Kind() == reflect.Uint8) {
+			return value
+		}
+		items := make([]interface{}, value.Len())
+		for i := range items {
+			items[i] = filterFields(value.Index(i), selected).Interface()
+		}
+		return reflect.ValueOf(items)
+	}
+	return value
+}
+
+// projection is struct type having only selected fields of the source type, which are at indexes of it.
+type projection struct {
+	target  reflect.Type
+	indexes [][]int
+}
+
+var projections sync.Map
+
+func projectionOf(source reflect.Type, selected map[string]bool) projection {
+	names := make([]string, 0, len(selected))
+	for name := range selected {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	key := struct {
+		source reflect.Type
+		names  string
+	}{source: source, names: strings.Join(names, ",")}
+	if cached, found := projections.Load(key); found {
+		return cached.(projection)
+	}
+
+	var fields []reflect.StructField
+	var indexes [][]int
+	for _, field := range reflect.VisibleFields(source) {
+		if !field.IsExported() || field.Anonymous || !selectedField(field, selected) {
+			continue
+		}
+		indexes = append(indexes, field.Index)
+		fields = append(fields, reflect.StructField{Name: field.Name, Type: field.Type, Tag: field.Tag})
+	}
+	created := projection{target: reflect.StructOf(fields), indexes: indexes}
+	projections.Store(key, created)
+	return created
+}
+
+func selectedField(field reflect.StructField, selected map[string]bool) bool {
+	if selected[strings.ToLower(field.Name)] {
+		return true
+	}
+	for _, key := range [3]string{"json", "xml", "csv"} {
+		name, _, _ := strings.Cut(field.Tag.Get(key), ",")
+		if name != "" && name != "-" && selected[strings.ToLower(name)] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// headResponseWriter measures the body of the response to HEAD request instead of writing it,
+// so Content-Length is the one of the response to GET request. The header is sent once the endpoint returns,
+// even if the response is flushed, as the length is unknown before.
+type headResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+	length     int
+	committed  bool
+}
+
+func (hrw *headResponseWriter) WriteHeader(statusCode int) {
+	if hrw.committed || hrw.statusCode != 0 {
+		return
+	}
+	if statusCode >= 100 && statusCode < 200 {
+		hrw.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+	hrw.statusCode = statusCode
+}
+
+func (hrw *headResponseWriter) Write(data []byte) (int, error) {
+	if hrw.statusCode == 0 {
+		hrw.WriteHeader(http.StatusOK)
+	}
+	hrw.length += len(data)
+	return len(data), nil
+}
+
+// Flush is ignored, as encoders flush every response.
+func (hrw *headResponseWriter) Flush() {
+	if hrw.statusCode == 0 {
+		hrw.WriteHeader(http.StatusOK)
+	}
+}
+
+// commit sends the header with Content-Length of the measured body unless nothing is written,
+// so errors returned by the endpoint could still be mapped.
+func (hrw *headResponseWriter) commit() {
+	if hrw.committed || hrw.statusCode == 0 {
+		return
+	}
+	hrw.committed = true
+	header := hrw.ResponseWriter.Header()
+	if bodyAllowed(hrw.statusCode) && header.Get("Content-Length") == "" {
+		header.Set("Content-Length", strconv.Itoa(hrw.length))
+	}
+	hrw.ResponseWriter.WriteHeader(hrw.statusCode)
+}
+
+func (hrw *headResponseWriter) Unwrap() http.ResponseWriter {
+	return hrw.ResponseWriter
+}
+
+// AutoHead makes the router respond to HEAD requests to paths of GET routes without own HEAD route
+// with GET endpoints. Their response bodies are measured for Content-Length instead of being sent.
+func (rt *Router) AutoHead(enabled bool) *Router {
+	rt.autoHead = enabled
+	return rt
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Statuses of health reports and their checks.
+const (
+	HealthUp   = "up"
+	HealthDown = "down"
+)
+
+// defaultCheckTimeout limits checks without own timeout, so a hanging dependency doesn't hang probes.
+const defaultCheckTimeout = 5 * time.Second
+
+// Check is a readiness check of the dependency, e.g. database ping or reachability of the upstream service.
+// Timeout limits the check, 5 seconds by default. Panic of the check fails it with PanicError.
+type Check struct {
+	Name    string
+	Check   func(ctx context.Context) error
+	Timeout time.Duration
+}
+
+// CheckResult is the status of the check with its duration and error message if it fails.
+type CheckResult struct {
+	Status   string        `json:"status"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// HealthReport is the body of health and readiness responses, Checks are results by names of checks.
+type HealthReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// Health returns builder of GET /healthz liveness endpoint, which responds with 200 OK while the process serves requests.
+func Health() Builder {
+	return GET("/healthz").Encoder(JSONEncoder).Handler(func() HealthReport {
+		return HealthReport{Status: HealthUp}
+	})
+}
+
+// Ready returns builder of GET /readyz readiness endpoint running the checks concurrently. It responds with 200 OK
+// if all of them pass and 503 Service Unavailable otherwise, with results of all checks in the body.
+// Durations of checks are measured by the clock of the router.
+func Ready(checks ...Check) Builder {
+	return GET("/readyz").Encoder(JSONEncoder).Handler(func(r *http.Request) (HealthReport, int) {
+		report := runChecks(r.Context(), checks)
+		if report.Status != HealthUp {
+			return report, http.StatusServiceUnavailable
+		}
+		return report, http.StatusOK
+	})
+}
+
+func runChecks(ctx context.Context, checks []Check) HealthReport {
+	report := HealthReport{Status: HealthUp, Checks: make(map[string]CheckResult, len(checks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, check := range checks {
+		check := check
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := check.run(ctx)
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[check.Name] = result
+			if result.Status != HealthUp {
+				report.Status = HealthDown
+			}
+		}()
+	}
+	wg.Wait()
+	return report
+}
+
+func (c Check) run(ctx context.Context) CheckResult {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = defaultCheckTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	startedAt := nowOf(ctx)
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				done <- PanicError{Value: recovered}
+			}
+		}()
+		done <- c.Check(ctx)
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	result := CheckResult{Status: HealthUp, Duration: nowOf(ctx).Sub(startedAt)}
+	if err != nil {
+		result.Status, result.Error = HealthDown, err.Error()
+	}
+	return result
+}
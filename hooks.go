@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"time"
+)
+
+// Hooks are notified about stages of request processing by endpoints of the router.
+// Any of them could be nil.
+type Hooks struct {
+	OnBind   func(event BindEvent)
+	OnInvoke func(event InvokeEvent)
+	OnEncode func(event EncodeEvent)
+	OnError  func(event ErrorEvent)
+	// OnEncoderFallback is notified when the response body is encoded by fallback encoder set with EncoderFallback.
+	OnEncoderFallback func(event EncoderFallbackEvent)
+}
+
+type BindEvent struct {
+	Route    RouteInfo
+	Request  *http.Request
+	Values   []interface{}
+	Duration time.Duration
+	Err      error
+}
+
+type InvokeEvent struct {
+	Route    RouteInfo
+	Request  *http.Request
+	Values   []interface{}
+	Results  []interface{}
+	Duration time.Duration
+}
+
+// EncodeEvent reports failure of encoding with Err, Partial is set if the response is sent partially then.
+type EncodeEvent struct {
+	Route    RouteInfo
+	Request  *http.Request
+	Duration time.Duration
+	Err      error
+	Partial  bool
+}
+
+// EncoderFallbackEvent reports media type From of the encoder which failed with Err
+// and media type To of the fallback encoder used instead.
+type EncoderFallbackEvent struct {
+	Route   RouteInfo
+	Request *http.Request
+	From    string
+	To      string
+	Err     error
+}
+
+const (
+	BindStage      = "bind"
+	AdmissionStage = "admission"
+	InvokeStage    = "invoke"
+	EncodeStage    = "encode"
+)
+
+type ErrorEvent struct {
+	Route   RouteInfo
+	Request *http.Request
+	Stage   string
+	Err     error
+}
+
+func interfaces(values []reflect.Value) []interface{} {
+	result := make([]interface{}, len(values))
+	for i, value := range values {
+		if value.IsValid() && value.CanInterface() {
+			result[i] = value.Interface()
+		}
+	}
+	return result
+}
+
+func (h Hooks) bind(route RouteInfo, r *http.Request, values []reflect.Value, duration time.Duration, err error) {
+	if h.OnBind != nil {
+		h.OnBind(BindEvent{Route: route, Request: r, Values: interfaces(values), Duration: duration, Err: err})
+	}
+	if err != nil {
+		h.error(route, r, BindStage, err)
+	}
+}
+
+func (h Hooks) invoke(route RouteInfo, r *http.Request, values, results []reflect.Value, duration time.Duration, err error) {
+	if h.OnInvoke != nil {
+		h.OnInvoke(InvokeEvent{Route: route, Request: r, Values: interfaces(values), Results: interfaces(results), Duration: duration})
+	}
+	if err != nil {
+		h.error(route, r, InvokeStage, err)
+	}
+}
+
+func (h Hooks) encode(route RouteInfo, r *http.Request, duration time.Duration, err error) {
+	if h.OnEncode != nil {
+		var partialErr PartialResponseError
+		h.OnEncode(EncodeEvent{Route: route, Request: r, Duration: duration, Err: err, Partial: errors.As(err, &partialErr)})
+	}
+	if err != nil {
+		h.error(route, r, EncodeStage, err)
+	}
+}
+
+func (h Hooks) error(route RouteInfo, r *http.Request, stage string, err error) {
+	recordError(r, err)
+	if h.OnError != nil {
+		h.OnError(ErrorEvent{Route: route, Request: r, Stage: stage, Err: err})
+	}
+}
+
+func (h Hooks) fallback(route RouteInfo, r *http.Request, from, to string, err error) {
+	if h.OnEncoderFallback != nil {
+		h.OnEncoderFallback(EncoderFallbackEvent{Route: route, Request: r, From: from, To: to, Err: err})
+	}
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+)
+
+// InterceptStage is a stage of typed interceptors registered with Before.
+const InterceptStage = "intercept"
+
+type injectedValuesKey struct{}
+
+// injectedValues are request-scoped values provided by interceptors of the request by their types.
+type injectedValues map[reflect.Type]reflect.Value
+
+// Provide sets request-scoped value of type T injected into parameters of type T of the handler and typed interceptors,
+// e.g. by Interceptor or middleware set with Wrap authenticating the request. The type should be declared with Provides.
+// It reports false if the endpoint handling the request declares no request-scoped values.
+func Provide[T any](r *http.Request, value T) bool {
+	values, found := r.Context().Value(injectedValuesKey{}).(injectedValues)
+	if found {
+		values[reflect.TypeOf((*T)(nil)).Elem()] = reflect.ValueOf(&value).Elem()
+	}
+	return found
+}
+
+// Provides declares types of request-scoped values set with Provide, so they are injected into parameters
+// of the handler and typed interceptors, e.g. reflect.TypeOf(TenantID("")).
+func (b builder) Provides(types ...reflect.Type) Builder {
+	var cloned Builder = b
+	for _, providedType := range types {
+		cloned = cloned.ContextValue(injectedValueExtractor(providedType).Interface())
+	}
+	defined := cloned.(builder).clone()
+	defined.provided = append(append([]reflect.Type(nil), defined.provided...), types...)
+	return defined
+}
+
+// interceptor is a step of the endpoint before the handler binding. It returns the request for the next steps,
+// false if the response is produced and the request must not be handled further, or the error to map.
+type interceptor func(w http.ResponseWriter, r *http.Request) (*http.Request, bool, error)
+
+var interceptorType = reflect.TypeOf(Interceptor(nil))
+
+// Before registers interceptor called before the handler in order of registration. It is either Interceptor,
+// which stops handling of the request by returning false after it responds itself, or a function with parameters
+// mapped like the ones of the handler, e.g. func(headers http.Header) (User, error). Path parameters are passed
+// to the latter only if it declares all of them first, as the handler does. Its results besides the trailing error
+// are injected into parameters of their types of the handler and of interceptors registered later.
+// Returned error stops handling of the request and is mapped by the error mapper of the endpoint.
+// Request body could be bound either by the interceptor or by the handler.
+func (b builder) Before(interceptor interface{}) Builder {
+	if untyped, ok := interceptor.(func(w http.ResponseWriter, r *http.Request) bool); ok {
+		interceptor = Interceptor(untyped)
+	}
+	interceptorValue := reflect.ValueOf(interceptor)
+	if interceptorValue.Kind() != reflect.Func || interceptorValue.IsNil() {
+		b.addError(InvalidMappingError(fmt.Errorf("interceptor %T is not a function", interceptor)))
+		return b
+	}
+
+	var cloned Builder = b
+	for _, resultType := range injectedTypes(interceptorValue.Type()) {
+		cloned = cloned.ContextValue(injectedValueExtractor(resultType).Interface())
+	}
+	defined := cloned.(builder).clone()
+	interceptors := make([]reflect.Value, len(defined.interceptors), len(defined.interceptors)+1)
+	copy(interceptors, defined.interceptors)
+	defined.interceptors = append(interceptors, interceptorValue)
+	return defined
+}
+
+// injectedValueExtractor creates context value extractor of the result of type T of typed interceptor.
+func injectedValueExtractor(resultType reflect.Type) reflect.Value {
+	extractorType := reflect.FuncOf([]reflect.Type{contextType}, []reflect.Type{resultType, errorType}, false)
+	return reflect.MakeFunc(extractorType, func(args []reflect.Value) []reflect.Value {
+		values, _ := args[0].Interface().(context.Context).Value(injectedValuesKey{}).(injectedValues)
+		value, found := values[resultType]
+		if !found {
+			err := InvalidValueError(fmt.Errorf("no value of type %s returned by interceptor", resultType))
+			return []reflect.Value{reflect.Zero(resultType), reflect.ValueOf(&err).Elem()}
+		}
+		return []reflect.Value{value, reflect.Zero(errorType)}
+	})
+}
+
+// scoped reports if the endpoint has request-scoped values provided by interceptors.
+func (b *builder) scoped() bool {
+	if len(b.provided) > 0 {
+		return true
+	}
+	for _, interceptorValue := range b.interceptors {
+		if len(injectedTypes(interceptorValue.Type())) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyProviders checks that request-scoped values are provided before typed interceptors requesting them,
+// as they are called in order of registration.
+func (b *builder) verifyProviders() {
+	provided := map[reflect.Type]bool{}
+	for _, providedType := range b.provided {
+		provided[providedType] = true
+	}
+	providedBy := map[reflect.Type]int{}
+	for i, interceptorValue := range b.interceptors {
+		for _, resultType := range injectedTypes(interceptorValue.Type()) {
+			if _, found := providedBy[resultType]; !found {
+				providedBy[resultType] = i
+			}
+		}
+	}
+	for i, interceptorValue := range b.interceptors {
+		interceptorType := interceptorValue.Type()
+		for j := 0; j < interceptorType.NumIn(); j++ {
+			parameterType := interceptorType.In(j)
+			if index, found := providedBy[parameterType]; found && index >= i && !provided[parameterType] {
+				b.addError(InvalidMappingError(fmt.Errorf("interceptor %d requests %s provided by interceptor %d registered after it", i+1, parameterType, index+1)))
+			}
+		}
+	}
+}
+
+// injectedTypes returns types of results of typed interceptor injected into parameters.
+func injectedTypes(functionType reflect.Type) []reflect.Type {
+	if functionType == interceptorType {
+		return nil
+	}
+	var types []reflect.Type
+	for i := 0; i < functionType.NumOut(); i++ {
+		if resultType := functionType.Out(i); resultType != errorType || i != functionType.NumOut()-1 {
+			types = append(types, resultType)
+		}
+	}
+	return types
+}
+
+func (b *builder) buildInterceptors() []interceptor {
+	b.verifyProviders()
+	var interceptors []interceptor
+	for _, interceptorValue := range b.interceptors {
+		if untyped, ok := interceptorValue.Interface().(Interceptor); ok {
+			interceptors = append(interceptors, func(w http.ResponseWriter, r *http.Request) (*http.Request, bool, error) {
+				return r, untyped(w, r), nil
+			})
+			continue
+		}
+		if typed := b.buildTypedInterceptor(interceptorValue); typed != nil {
+			interceptors = append(interceptors, typed)
+		}
+	}
+	return interceptors
+}
+
+// buildTypedInterceptor binds parameters of the interceptor with the mapping of the handler.
+func (b *builder) buildTypedInterceptor(interceptorValue reflect.Value) interceptor {
+	interceptorType := interceptorValue.Type()
+	sub := b.clone()
+	sub.serviceValue = interceptorValue
+	sub.errors = nil
+	if !b.declaresPathParameters(interceptorType) {
+		sub.pathParamsAmount = 0
+	}
+	sub.orderOfOtherParameters = nil
+	sub.groupRequestParameters(interceptorType)
+	if _, bindsBody := sub.hasParametersIn(bodyParametersGroup); bindsBody {
+		if _, handlerBindsBody := b.hasParametersIn(bodyParametersGroup); handlerBindsBody {
+			sub.addError(InvalidMappingError(errors.New("request body is bound by both interceptor and handler")))
+		}
+	}
+	if len(sub.errors) == 0 {
+		sub.defineProviders()
+	}
+	if len(sub.errors) > 0 {
+		b.errors = append(b.errors, sub.errors...)
+		return nil
+	}
+
+	bind := sub.buildBindParameters()
+	errorIndex := -1
+	if interceptorType.NumOut() > 0 && interceptorType.Out(interceptorType.NumOut()-1) == errorType {
+		errorIndex = interceptorType.NumOut() - 1
+	}
+	return func(w http.ResponseWriter, r *http.Request) (*http.Request, bool, error) {
+		values, err := bind(w, r)
+		if err != nil {
+			return r, false, err
+		}
+		results := interceptorValue.Call(values)
+		if errorIndex >= 0 {
+			if err, _ := results[errorIndex].Interface().(error); err != nil {
+				return r, false, err
+			}
+		}
+		injected, _ := r.Context().Value(injectedValuesKey{}).(injectedValues)
+		for i, result := range results {
+			if i != errorIndex {
+				injected[interceptorType.Out(i)] = result
+			}
+		}
+		return r, true, nil
+	}
+}
+
+// declaresPathParameters reports if the function declares all path parameters of the route first.
+func (b *builder) declaresPathParameters(functionType reflect.Type) bool {
+	if b.pathParamsAmount == 0 || functionType.NumIn() < b.pathParamsAmount {
+		return false
+	}
+	for i := 0; i < b.pathParamsAmount; i++ {
+		parameterType := functionType.In(i)
+		switch parameterType {
+		case headersType, urlQueryType, cookiesType, requestType, responseWriterType, routeInfoType:
+			return false
+		}
+		if b.hasContextExtractor(parameterType) {
+			return false
+		}
+		if _, err := newPathParameterConverter(parameterType); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// afterInterceptor is a step of the endpoint between the handler and encoding of its results.
+// It replaces the results in place and returns false if the response is produced, or the error to map.
+type afterInterceptor func(results []reflect.Value, w http.ResponseWriter, r *http.Request) (bool, error)
+
+// After registers interceptor called after the handler in order of registration, before its results are encoded.
+// It is either Interceptor, which stops handling of the request by returning false after it responds itself,
+// or a function of the request, response headers and results of the handler by their types: the body entity,
+// status code and error, e.g. func(order Order, status int, err error) (Order, error).
+// Its results of the body entity and status code types replace the ones of the handler,
+// returned error stops handling of the request and is mapped by the error mapper of the endpoint.
+// Response headers it sets are replaced by the ones of the same names returned by the handler.
+func (b builder) After(interceptor interface{}) Builder {
+	if untyped, ok := interceptor.(func(w http.ResponseWriter, r *http.Request) bool); ok {
+		interceptor = Interceptor(untyped)
+	}
+	interceptorValue := reflect.ValueOf(interceptor)
+	if interceptorValue.Kind() != reflect.Func || interceptorValue.IsNil() {
+		b.addError(InvalidMappingError(fmt.Errorf("interceptor %T is not a function", interceptor)))
+		return b
+	}
+	cloned := b.clone()
+	afterInterceptors := make([]reflect.Value, len(cloned.afterInterceptors), len(cloned.afterInterceptors)+1)
+	copy(afterInterceptors, cloned.afterInterceptors)
+	cloned.afterInterceptors = append(afterInterceptors, interceptorValue)
+	return cloned
+}
+
+func (b *builder) buildAfterInterceptors() []afterInterceptor {
+	var interceptors []afterInterceptor
+	for _, interceptorValue := range b.afterInterceptors {
+		if untyped, ok := interceptorValue.Interface().(Interceptor); ok {
+			interceptors = append(interceptors, func(results []reflect.Value, w http.ResponseWriter, r *http.Request) (bool, error) {
+				return untyped(w, r), nil
+			})
+			continue
+		}
+		if typed := b.buildTypedAfterInterceptor(interceptorValue); typed != nil {
+			interceptors = append(interceptors, typed)
+		}
+	}
+	return interceptors
+}
+
+// buildTypedAfterInterceptor maps parameters and results of the interceptor to results of the handler by types.
+func (b *builder) buildTypedAfterInterceptor(interceptorValue reflect.Value) afterInterceptor {
+	interceptorType := interceptorValue.Type()
+	resultIndex := func(valueType reflect.Type) (int, error) {
+		var group int
+		switch {
+		case valueType == httpStatusType:
+			group = responseStatusCodeParametersGroup
+		case valueType == errorType:
+			group = responseErrorParametersGroup
+		default:
+			group = responseBodyParametersGroup
+			if bodyTypes := b.parametersBy[group]; len(bodyTypes) == 0 || bodyTypes[0] != valueType {
+				return -1, fmt.Errorf("handler returns no body entity of type %s", valueType)
+			}
+		}
+		index := b.resultIndex(group)
+		if index == -1 {
+			return -1, fmt.Errorf("handler returns no value of type %s", valueType)
+		}
+		return index, nil
+	}
+
+	const (
+		requestArgument = -1 - iota
+		headerArgument
+	)
+	arguments := make([]int, interceptorType.NumIn())
+	for i := range arguments {
+		switch parameterType := interceptorType.In(i); parameterType {
+		case requestType:
+			arguments[i] = requestArgument
+		case headersType:
+			arguments[i] = headerArgument
+		default:
+			index, err := resultIndex(parameterType)
+			if err != nil {
+				b.addErrorAt(i, -1, InvalidMappingError(fmt.Errorf("after interceptor: %w", err)))
+				return nil
+			}
+			arguments[i] = index
+		}
+	}
+	errorIndex := -1
+	replaced := make([]int, interceptorType.NumOut())
+	for i := range replaced {
+		resultType := interceptorType.Out(i)
+		if resultType == errorType && i == interceptorType.NumOut()-1 {
+			errorIndex, replaced = i, replaced[:i]
+			break
+		}
+		index, err := resultIndex(resultType)
+		if err != nil || resultType == errorType {
+			b.addErrorAt(-1, i, InvalidMappingError(fmt.Errorf("after interceptor: unable to replace result of type %s", resultType)))
+			return nil
+		}
+		replaced[i] = index
+	}
+
+	return func(results []reflect.Value, w http.ResponseWriter, r *http.Request) (bool, error) {
+		values := make([]reflect.Value, len(arguments))
+		for i, argument := range arguments {
+			switch argument {
+			case requestArgument:
+				values[i] = reflect.ValueOf(r)
+			case headerArgument:
+				values[i] = reflect.ValueOf(w.Header())
+			default:
+				values[i] = reflect.New(interceptorType.In(i)).Elem()
+				if !isNil(results[argument]) {
+					values[i].Set(results[argument])
+				}
+			}
+		}
+		returned := interceptorValue.Call(values)
+		if errorIndex >= 0 {
+			if err, _ := returned[errorIndex].Interface().(error); err != nil {
+				return false, err
+			}
+		}
+		for i, index := range replaced {
+			results[index] = returned[i]
+		}
+		return true, nil
+	}
+}
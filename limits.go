@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// RequestLimits restricts size of the request URL query and headers, zero value of a field disables the limit.
+// Requests exceeding limits of the router are rejected before the query is parsed or the endpoint is looked up.
+type RequestLimits struct {
+	// MaxQueryLength is a max length of the raw query string, exceeding it results into 414 status code.
+	MaxQueryLength int
+	// MaxQueryParameters is a max number of query parameters, exceeding it results into 414 status code.
+	MaxQueryParameters int
+	// MaxHeaders is a max number of header values, exceeding it results into 431 status code.
+	MaxHeaders int
+	// MaxHeaderBytes is a max total size of header names and values, exceeding it results into 431 status code.
+	MaxHeaderBytes int
+}
+
+// RequestLimitError is returned for requests exceeding RequestLimits.
+type RequestLimitError struct {
+	Limit  string
+	Max    int
+	Actual int
+	Status int
+}
+
+func (e RequestLimitError) Error() string {
+	return fmt.Sprintf("request limit exceeded: %s is %d, max is %d", e.Limit, e.Actual, e.Max)
+}
+
+func (e RequestLimitError) StatusCode() int {
+	return e.Status
+}
+
+// Limits sets limits of the request query and headers checked before the request is bound, in addition
+// to the limits of the router, e.g. to restrict endpoints with expensive queries further.
+func (b builder) Limits(limits RequestLimits) Builder {
+	cloned := b.clone()
+	cloned.requestLimits = limits
+	return cloned
+}
+
+func (limits RequestLimits) check(r *http.Request) error {
+	rawQuery := r.URL.RawQuery
+	if limits.MaxQueryLength > 0 && len(rawQuery) > limits.MaxQueryLength {
+		return RequestLimitError{Limit: "query length", Max: limits.MaxQueryLength, Actual: len(rawQuery), Status: http.StatusRequestURITooLong}
+	}
+	if limits.MaxQueryParameters > 0 && rawQuery != "" {
+		if count := strings.Count(rawQuery, "&") + 1; count > limits.MaxQueryParameters {
+			return RequestLimitError{Limit: "query parameters", Max: limits.MaxQueryParameters, Actual: count, Status: http.StatusRequestURITooLong}
+		}
+	}
+	if limits.MaxHeaders <= 0 && limits.MaxHeaderBytes <= 0 {
+		return nil
+	}
+
+	var count, size int
+	for name, values := range r.Header {
+		count += len(values)
+		for _, value := range values {
+			size += len(name) + len(value)
+		}
+	}
+	if limits.MaxHeaders > 0 && count > limits.MaxHeaders {
+		return RequestLimitError{Limit: "headers", Max: limits.MaxHeaders, Actual: count, Status: http.StatusRequestHeaderFieldsTooLarge}
+	}
+	if limits.MaxHeaderBytes > 0 && size > limits.MaxHeaderBytes {
+		return RequestLimitError{Limit: "header bytes", Max: limits.MaxHeaderBytes, Actual: size, Status: http.StatusRequestHeaderFieldsTooLarge}
+	}
+	return nil
+}
+
+// ResponseTooLargeError is returned when the encoded response body exceeds the limit set with MaxResponseBytes.
+// It is mapped by error mapper of the endpoint with 500 Internal Server Error unless the response is already sent.
+type ResponseTooLargeError struct {
+	Limit int64
+	// Type is a type of the response body returned by the handler.
+	Type string
+}
+
+func (e ResponseTooLargeError) Error() string {
+	return fmt.Sprintf("response body of type %s exceeds %d bytes", e.Type, e.Limit)
+}
+
+func (e ResponseTooLargeError) StatusCode() int {
+	return http.StatusInternalServerError
+}
+
+// MaxResponseBytes limits size of the response body produced from results of the handler, e.g. to catch
+// accidentally unbounded lists. Encoding is aborted once the limit is exceeded, the error is reported to OnError hook
+// with EncodeStage. Writes into injected http.ResponseWriter are not limited. Zero or negative n disables the limit.
+func (b builder) MaxResponseBytes(n int64) Builder {
+	cloned := b.clone()
+	cloned.maxResponseBytes = n
+	return cloned
+}
+
+func (b *builder) buildMaxResponse() ResponseTooLargeError {
+	if b.maxResponseBytes <= 0 {
+		return ResponseTooLargeError{}
+	}
+	tooLarge := ResponseTooLargeError{Limit: b.maxResponseBytes}
+	if bodyTypes, hasBody := b.hasParametersIn(responseBodyParametersGroup); hasBody {
+		tooLarge.Type = bodyTypes[0].String()
+	}
+	return tooLarge
+}
+
+func (ep EndpointProcessor) limitResponse(w http.ResponseWriter) http.ResponseWriter {
+	if ep.maxResponse.Limit <= 0 {
+		return w
+	}
+	return &limitedResponseWriter{ResponseWriter: w, remaining: ep.maxResponse.Limit, tooLarge: ep.maxResponse}
+}
+
+// limitedResponseWriter rejects writes exceeding the limit as a whole, so nothing is sent if encoder writes
+// the body at once.
+type limitedResponseWriter struct {
+	http.ResponseWriter
+	remaining int64
+	tooLarge  ResponseTooLargeError
+}
+
+func (lrw *limitedResponseWriter) Write(data []byte) (int, error) {
+	if int64(len(data)) > lrw.remaining {
+		return 0, lrw.tooLarge
+	}
+	lrw.remaining -= int64(len(data))
+	return lrw.ResponseWriter.Write(data)
+}
+
+func (lrw *limitedResponseWriter) Flush() {
+	if flusher, ok := lrw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (lrw *limitedResponseWriter) Unwrap() http.ResponseWriter {
+	return lrw.ResponseWriter
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sync/atomic"
+)
+
+// Marshaler marshals values of binary formats like MessagePack or CBOR. It is implemented by adapters
+// of third-party libraries, e.g. vmihailenco/msgpack or fxamacker/cbor, so the package doesn't depend on them.
+type Marshaler interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	msgpackMarshaler atomic.Value
+	cborMarshaler    atomic.Value
+	protoMarshaler   atomic.Value
+)
+
+// UseMsgpack sets marshaler of MsgpackEncoder and MsgpackDecoder.
+func UseMsgpack(marshaler Marshaler) {
+	msgpackMarshaler.Store(&marshaler)
+}
+
+// UseCBOR sets marshaler of CBOREncoder and CBORDecoder.
+func UseCBOR(marshaler Marshaler) {
+	cborMarshaler.Store(&marshaler)
+}
+
+// UseProto sets marshaler of ProtoEncoder and ProtoDecoder, e.g. adapter of proto.Marshal and proto.Unmarshal
+// of google.golang.org/protobuf. Until then messages are marshaled with their own methods as generated by gogo/protobuf.
+func UseProto(marshaler Marshaler) {
+	protoMarshaler.Store(&marshaler)
+}
+
+// generatedMarshaler marshals messages with their own Marshal and Unmarshal methods.
+type generatedMarshaler struct {
+	use string
+}
+
+func (gm generatedMarshaler) Marshal(v interface{}) ([]byte, error) {
+	message, ok := v.(interface{ Marshal() ([]byte, error) })
+	if !ok {
+		return nil, fmt.Errorf("%T has no Marshal method, marshaler must be set with %s", v, gm.use)
+	}
+	return message.Marshal()
+}
+
+func (gm generatedMarshaler) Unmarshal(data []byte, v interface{}) error {
+	message, ok := v.(interface{ Unmarshal(data []byte) error })
+	if !ok {
+		return fmt.Errorf("%T has no Unmarshal method, marshaler must be set with %s", v, gm.use)
+	}
+	return message.Unmarshal(data)
+}
+
+// marshalerCodec encodes and decodes with the marshaler set for the media type.
+// Values are encoded one after another and the whole body is decoded into a single value.
+type marshalerCodec struct {
+	mediaType string
+	use       string
+	marshaler *atomic.Value
+	// fallback is used until marshaler is set
+	fallback Marshaler
+}
+
+func (mc marshalerCodec) MediaType() string {
+	return mc.mediaType
+}
+
+func (mc marshalerCodec) get() (Marshaler, error) {
+	marshaler, _ := mc.marshaler.Load().(*Marshaler)
+	if marshaler == nil && mc.fallback != nil {
+		return mc.fallback, nil
+	}
+	if marshaler == nil {
+		return nil, fmt.Errorf("no marshaler of %s, it must be set with %s", mc.mediaType, mc.use)
+	}
+	return *marshaler, nil
+}
+
+func (mc marshalerCodec) NewDecodeStream(reader io.Reader) DecodeStream {
+	return funcDecodeStream(func(v interface{}) error {
+		marshaler, err := mc.get()
+		if err != nil {
+			return DecodeError{MediaType: mc.mediaType, Cause: err}
+		}
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			return DecodeError{MediaType: mc.mediaType, Offset: int64(len(data)), Cause: err}
+		}
+		if len(data) == 0 {
+			return io.EOF
+		}
+		target := reflect.ValueOf(v)
+		if target.Kind() == reflect.Ptr && target.Elem().Kind() == reflect.Ptr {
+			// handler parameters of pointer types, e.g. generated messages, are decoded into pointers to them
+			if target.Elem().IsNil() {
+				target.Elem().Set(reflect.New(target.Elem().Type().Elem()))
+			}
+			v = target.Elem().Interface()
+		}
+		if err := marshaler.Unmarshal(data, v); err != nil {
+			return DecodeError{MediaType: mc.mediaType, Cause: err}
+		}
+		return nil
+	})
+}
+
+func (mc marshalerCodec) NewEncodeStream(writer io.Writer) EncodeStream {
+	return funcEncodeStream{writer: writer, encode: func(v interface{}) error {
+		marshaler, err := mc.get()
+		if err != nil {
+			return EncodeError{MediaType: mc.mediaType, Cause: err}
+		}
+		data, err := marshaler.Marshal(v)
+		if err != nil {
+			return EncodeError{MediaType: mc.mediaType, Cause: err}
+		}
+		_, err = writer.Write(data)
+		return err
+	}}
+}
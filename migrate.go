@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"runtime"
+	"strings"
+	"unicode"
+)
+
+// PositionalPathPolicy is a compatibility mode of handlers binding path parameters by position instead of
+// struct fields tagged with path, which bind them by names.
+type PositionalPathPolicy int
+
+const (
+	// AllowPositionalPath keeps positional path parameters working silently.
+	AllowPositionalPath PositionalPathPolicy = iota
+	// ReportPositionalPath reports endpoints with positional path parameters by Router.Check.
+	ReportPositionalPath
+	// RejectPositionalPath fails registration of endpoints with positional path parameters.
+	RejectPositionalPath
+)
+
+// PositionalPathIssue is a kind of issue reported by Router.Check for endpoints binding path parameters by position.
+const PositionalPathIssue = "positional path"
+
+// PositionalPath sets compatibility mode of endpoints with positional path parameters registered afterwards.
+func (rt *Router) PositionalPath(policy PositionalPathPolicy) *Router {
+	rt.positionalPath = policy
+	return rt
+}
+
+// PathMigration describes migration of the endpoint from positional path parameters to named ones.
+type PathMigration struct {
+	Route RouteInfo
+	// Handler is the name of the handler function declared at File and Line.
+	Handler string
+	File    string
+	Line    int
+	// Parameters are names of path parameters in order of the template.
+	Parameters []string
+	// Arguments are names of positional parameters in the source of the handler, if it is found.
+	Arguments []string
+	// Mismatched reports arguments named like path parameters at other positions, which are likely bound by mistake.
+	Mismatched bool
+	// Struct is declaration of the struct parameter replacing positional ones.
+	Struct string
+	// Fields are names of fields of Struct binding Parameters.
+	Fields []string
+}
+
+// PathMigrations returns migrations of registered endpoints with positional path parameters in order of registration.
+// Names of handler arguments are read from the source, so they are found only if it is available at its build path.
+// Migrations encoded with encoding/json are reported and applied to the sources by the migrate command of cmd/feel.
+func (rt *Router) PathMigrations() []PathMigration {
+	rt.mu.RLock()
+	endpoints := append([]*EndpointProcessor(nil), rt.endpoints...)
+	rt.mu.RUnlock()
+
+	sources := map[string]parsedSource{}
+	var migrations []PathMigration
+	for _, endpoint := range endpoints {
+		if len(endpoint.positionalPath) == 0 {
+			continue
+		}
+		migration := PathMigration{
+			Route:      endpoint.route,
+			Handler:    functionName(endpoint.handler),
+			Parameters: pathParameterNames(endpoint.route.Template),
+			Struct:     pathStruct(pathParameterNames(endpoint.route.Template), endpoint.positionalPath),
+		}
+		for _, parameter := range migration.Parameters {
+			migration.Fields = append(migration.Fields, exportedName(parameter))
+		}
+		if fn := runtime.FuncForPC(endpoint.handler.Pointer()); fn != nil {
+			migration.File, migration.Line = fn.FileLine(fn.Entry())
+		}
+		migration.Arguments = sourceArguments(sources, migration.File, migration.Line, len(endpoint.positionalPath))
+		for i, argument := range migration.Arguments {
+			for j, parameter := range migration.Parameters {
+				if i != j && strings.EqualFold(argument, parameter) {
+					migration.Mismatched = true
+				}
+			}
+		}
+		migrations = append(migrations, migration)
+	}
+	return migrations
+}
+
+// pathStruct declares struct with fields tagged with path of the path parameters.
+func pathStruct(names []string, types []reflect.Type) string {
+	var declaration strings.Builder
+	declaration.WriteString("struct {\n")
+	for i, name := range names {
+		fmt.Fprintf(&declaration, "\t%s %s `path:%q`\n", exportedName(name), types[i], name)
+	}
+	declaration.WriteString("}")
+	return declaration.String()
+}
+
+// exportedName converts the name like "user_id" into "UserID".
+func exportedName(name string) string {
+	var exported strings.Builder
+	for _, part := range strings.FieldsFunc(name, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+		if strings.EqualFold(part, "id") {
+			part = "ID"
+		}
+		exported.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	if exported.Len() == 0 || !unicode.IsLetter([]rune(exported.String())[0]) {
+		return "P" + exported.String()
+	}
+	return exported.String()
+}
+
+// parsedSource is the source file of handlers with the file set resolving its positions.
+type parsedSource struct {
+	fileSet *token.FileSet
+	file    *ast.File
+}
+
+// sourceArguments returns names of the first parameters of the function declared at the line of the file.
+func sourceArguments(sources map[string]parsedSource, file string, line, amount int) []string {
+	source, parsed := sources[file]
+	if !parsed {
+		source.fileSet = token.NewFileSet()
+		source.file, _ = parser.ParseFile(source.fileSet, file, nil, 0)
+		sources[file] = source
+	}
+	if source.file == nil {
+		return nil
+	}
+
+	var arguments []string
+	ast.Inspect(source.file, func(node ast.Node) bool {
+		var functionType *ast.FuncType
+		switch function := node.(type) {
+		case *ast.FuncDecl:
+			functionType = function.Type
+		case *ast.FuncLit:
+			functionType = function.Type
+		}
+		if arguments != nil || functionType == nil || source.fileSet.Position(functionType.Pos()).Line != line {
+			return arguments == nil
+		}
+		arguments = []string{}
+		for _, field := range functionType.Params.List {
+			for _, name := range field.Names {
+				if len(arguments) < amount {
+					arguments = append(arguments, name.Name)
+				}
+			}
+		}
+		return false
+	})
+	if len(arguments) < amount {
+		return nil
+	}
+	return arguments
+}
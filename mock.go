@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// Mock creates router which responds to the routes with their first response example instead of calling handlers.
+// Routes without response examples respond with 501 Not Implemented.
+func Mock(routes ...Builder) (*Router, error) {
+	router := NewRouter()
+	for _, route := range routes {
+		b, ok := route.(builder)
+		if !ok {
+			return nil, InvalidMappingError(fmt.Errorf("unable to mock route of type %T", route))
+		}
+		endpoint := b.buildMock()
+		if err := router.add(&endpoint); err != nil {
+			return nil, err
+		}
+	}
+	return router, nil
+}
+
+func (b builder) buildMock() EndpointProcessor {
+	b = b.clone()
+	negotiate := b.buildNegotiation()
+	acceptableMediaTypes := strings.Join(b.encoderMediaTypes(), ", ")
+	var example *responseExample
+	if len(b.responseExamples) > 0 {
+		first := b.responseExamples[0]
+		example = &first
+	}
+
+	return EndpointProcessor{
+		route:           b.routeInfo(),
+		metadata:        b.buildMetadata(),
+		queryConditions: b.queryConditions,
+		matchesQuery:    b.buildMatchesQuery(),
+		constraints:     b.resolveConstraints(),
+		requiredHeaders: b.requiredHeaders,
+		limits:          b.requestLimits,
+		errorMapper:     b.buildErrorMapper(),
+		requestMapper:   b.buildRequestErrorMapper(),
+		headers:         b.responseHeaders,
+		clock:           SystemClock,
+		bindParameters: func(w http.ResponseWriter, r *http.Request) ([]reflect.Value, error) {
+			return nil, nil
+		},
+		admit: func(values []reflect.Value) error {
+			return nil
+		},
+		invoke: func(values []reflect.Value) []reflect.Value {
+			return nil
+		},
+		resultError: func(results []reflect.Value) error {
+			return nil
+		},
+		produceResponse: func(executionResult []reflect.Value, executionError error, w http.ResponseWriter, r *http.Request) error {
+			if example == nil {
+				http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+				return nil
+			}
+			rep, acceptable := negotiate(r.Header.Get("Accept"))
+			if !acceptable {
+				http.Error(w, "acceptable media types: "+acceptableMediaTypes, http.StatusNotAcceptable)
+				return nil
+			}
+			if rep.contentType != "" {
+				w.Header().Set("Content-Type", rep.contentType)
+			}
+			w.WriteHeader(example.statusCode)
+			return writeExample(w, rep, example.value)
+		},
+	}
+}
+
+// writeExample writes the example as is if the representation has no encoder and it is a string or bytes.
+func writeExample(w io.Writer, rep representation, example interface{}) error {
+	switch value := example.(type) {
+	case nil:
+		return nil
+	case string:
+		if rep.encoder == nil {
+			_, err := io.WriteString(w, value)
+			return err
+		}
+	case []byte:
+		if rep.encoder == nil {
+			_, err := w.Write(value)
+			return err
+		}
+	}
+	if rep.encoder == nil {
+		_, err := fmt.Fprint(w, example)
+		return err
+	}
+	return encode(rep.encoder, w, example)
+}
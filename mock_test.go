@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMock(t *testing.T) {
+	router, err := Mock(
+		GET("/accounts/:id").Encoder(JSONEncoder).ResponseExample(http.StatusOK, Account{ID: 7, Name: "seven"}),
+		DELETE("/accounts/:id"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, newGET(t, "http://localhost/accounts/1"))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Error("unexpected response", w.Code, w.Header())
+	}
+	if w.Body.String() != `{"id":7,"name":"seven","children":null}`+"\n" {
+		t.Error("unexpected response body", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, newRequest(t, http.MethodDelete, "http://localhost/accounts/1", nil))
+	if w.Code != http.StatusNotImplemented {
+		t.Error("unexpected response code", w.Code)
+	}
+}
+
+func TestReplayExamples(t *testing.T) {
+	create := POST("/accounts").
+		Codec(JSON).
+		RequestExample(Account{Name: "new"}).
+		ResponseExample(http.StatusCreated, Account{ID: 1, Name: "new"}).
+		ResponseExample(http.StatusConflict, "exists")
+	get := GET("/accounts/:id").Codec(JSON).ResponseExample(http.StatusOK, Account{ID: 7, Name: "seven"})
+	router := NewRouter()
+	err := router.Register(
+		create.Handler(func(account Account) (Account, int) {
+			account.ID = 1
+			return account, http.StatusCreated
+		}),
+		get.Handler(func(id uint64) Account { return Account{ID: id, Name: "seven"} }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ReplayExamples(router, "/accounts", create); err != nil {
+		t.Error(err)
+	}
+	if err := ReplayExamples(router, "/accounts/7", get); err != nil {
+		t.Error(err)
+	}
+	var mismatch ExampleMismatchError
+	if err := ReplayExamples(router, "/accounts/8", get); !errors.As(err, &mismatch) ||
+		mismatch.Status != http.StatusOK || mismatch.Received != `{"id":8,"name":"seven","children":null}`+"\n" {
+		t.Error("unexpected error of mismatched response", err)
+	}
+	if err := ReplayExamples(router, "/accounts/x", get); !errors.As(err, &mismatch) || mismatch.Expected != "" {
+		t.Error("unexpected error of response without example", err)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"reflect"
+)
+
+// MultipartResponse is a handler return value sent as multipart response of its parts in order, e.g. JSON metadata
+// of a generated document followed by the document itself. Parts are streamed as they are written.
+type MultipartResponse struct {
+	// Subtype of the multipart media type, mixed if empty.
+	Subtype string
+	// Boundary separating parts, random if empty.
+	Boundary string
+	Parts    []Part
+}
+
+// Part is a part of MultipartResponse. Body is written as is if it is io.Reader, []byte or string, readers are
+// closed if they are closable. Other values are encoded with Encoder, or with JSONEncoder if it is nil.
+// Content-Type of the part is set by the type of Body unless it is in Header.
+type Part struct {
+	// Name and Filename are sent in Content-Disposition header unless it is in Header.
+	Name     string
+	Filename string
+	Header   http.Header
+	Body     interface{}
+	Encoder  Encoder
+}
+
+var multipartType = reflect.TypeOf(MultipartResponse{})
+
+// withBoundary returns the response with a random boundary unless it has one.
+func (response MultipartResponse) withBoundary() MultipartResponse {
+	if response.Boundary == "" {
+		response.Boundary = multipart.NewWriter(io.Discard).Boundary()
+	}
+	return response
+}
+
+// mediaType returns the multipart media type with the boundary.
+func (response MultipartResponse) mediaType() string {
+	subtype := response.Subtype
+	if subtype == "" {
+		subtype = "mixed"
+	}
+	return mime.FormatMediaType("multipart/"+subtype, map[string]string{"boundary": response.Boundary})
+}
+
+// writeMultipart writes parts of the multipart response separated by its boundary.
+func writeMultipart(w http.ResponseWriter, response MultipartResponse) error {
+	writer := multipart.NewWriter(w)
+	if err := writer.SetBoundary(response.Boundary); err != nil {
+		return err
+	}
+	for _, part := range response.Parts {
+		if err := writePart(writer, part); err != nil {
+			return err
+		}
+		if err := flushWriter(w); err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
+
+func writePart(writer *multipart.Writer, part Part) error {
+	header := textproto.MIMEHeader{}
+	for name, values := range part.Header {
+		header[textproto.CanonicalMIMEHeaderKey(name)] = append([]string(nil), values...)
+	}
+	if header.Get("Content-Disposition") == "" && (part.Name != "" || part.Filename != "") {
+		params := map[string]string{}
+		if part.Name != "" {
+			params["name"] = part.Name
+		}
+		if part.Filename != "" {
+			params["filename"] = part.Filename
+		}
+		disposition := "inline"
+		if part.Filename != "" {
+			disposition = "attachment"
+		}
+		header.Set("Content-Disposition", mime.FormatMediaType(disposition, params))
+	}
+
+	encoder := part.Encoder
+	if encoder == nil {
+		encoder = JSONEncoder
+	}
+	var contentType string
+	switch body := part.Body.(type) {
+	case io.Reader:
+		if closer, ok := body.(io.Closer); ok {
+			defer closer.Close()
+		}
+		contentType = "application/octet-stream"
+	case []byte:
+		contentType = "application/octet-stream"
+	case string:
+		contentType = "text/plain; charset=utf-8"
+	default:
+		contentType = encoder.MediaType()
+	}
+	if header.Get("Content-Type") == "" && contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+
+	partWriter, err := writer.CreatePart(header)
+	if err != nil {
+		return err
+	}
+	switch body := part.Body.(type) {
+	case io.Reader:
+		_, err = io.Copy(partWriter, body)
+	case []byte:
+		_, err = partWriter.Write(body)
+	case string:
+		_, err = io.WriteString(partWriter, body)
+	case nil:
+	default:
+		stream := encoder.NewEncodeStream(partWriter)
+		if err = stream.Encode(body); err != nil {
+			return fmt.Errorf("part %q: %w", part.Name, err)
+		}
+	}
+	return err
+}
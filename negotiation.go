@@ -0,0 +1,111 @@
+package main
+
+import (
+	"mime"
+	"strconv"
+	"strings"
+)
+
+type registeredEncoder struct {
+	mediaType string
+	encoder   Encoder
+}
+
+type representation struct {
+	encoder     Encoder
+	contentType string
+}
+
+type acceptRange struct {
+	mediaType string
+	quality   float64
+}
+
+func parseAccept(accept string) []acceptRange {
+	var ranges []acceptRange
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, found := params["q"]; found {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+		ranges = append(ranges, acceptRange{mediaType: mediaType, quality: quality})
+	}
+	return ranges
+}
+
+func (ar acceptRange) specificity(mediaType string) int {
+	switch {
+	case ar.mediaType == mediaType:
+		return 3
+	case strings.HasSuffix(ar.mediaType, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(ar.mediaType, "*")):
+		return 2
+	case ar.mediaType == "*/*":
+		return 1
+	}
+	return 0
+}
+
+// negotiateMediaType returns index of the offered media type with the highest quality in accept header or -1.
+// Quality of the offer is taken from the most specific matching media range, ties are resolved by order of offers.
+func negotiateMediaType(accept string, offers []string) int {
+	ranges := parseAccept(accept)
+	best, bestQuality := -1, 0.0
+	for index, offer := range offers {
+		mediaType, _, err := mime.ParseMediaType(offer)
+		if err != nil {
+			continue
+		}
+		quality, specificity := 0.0, 0
+		for _, ar := range ranges {
+			if s := ar.specificity(mediaType); s > specificity {
+				quality, specificity = ar.quality, s
+			}
+		}
+		if quality > bestQuality {
+			best, bestQuality = index, quality
+		}
+	}
+	return best
+}
+
+func (b *builder) buildNegotiation() func(accept string) (representation, bool) {
+	encoder, encoders, contentTypeProvider := b.encoder, b.encoders, b.contentTypeProvider
+	fallback := func() representation {
+		rep := representation{encoder: encoder}
+		if contentTypeProvider != nil {
+			rep.contentType = contentTypeProvider()
+		} else if encoder != nil {
+			rep.contentType = encoder.MediaType()
+		}
+		return rep
+	}
+
+	if len(encoders) == 0 {
+		return func(accept string) (representation, bool) {
+			return fallback(), true
+		}
+	}
+
+	offers := b.encoderMediaTypes()
+	return func(accept string) (representation, bool) {
+		if accept == "" {
+			if encoder != nil {
+				return fallback(), true
+			}
+			return representation{encoder: encoders[0].encoder, contentType: encoders[0].mediaType}, true
+		}
+		if index := negotiateMediaType(accept, offers); index != -1 {
+			return representation{encoder: encoders[index].encoder, contentType: encoders[index].mediaType}, true
+		}
+		if encoder != nil {
+			return fallback(), true
+		}
+		return representation{}, false
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNegotiateMediaType(t *testing.T) {
+	offers := []string{"application/json", "application/xml; charset=utf-8", "text/csv"}
+	for _, toCheck := range []struct {
+		accept   string
+		expected int
+	}{
+		{accept: "application/xml", expected: 1},
+		{accept: "text/*", expected: 2},
+		{accept: "*/*", expected: 0},
+		{accept: "application/json;q=0.5, application/xml", expected: 1},
+		{accept: "application/*;q=0.2, application/json;q=0", expected: 1},
+		{accept: "image/png", expected: -1},
+		{accept: "invalid", expected: -1},
+	} {
+		if index := negotiateMediaType(toCheck.accept, offers); index != toCheck.expected {
+			t.Error(toCheck.accept, "unexpected offer", index)
+		}
+	}
+}
+
+func TestEncoderFor(t *testing.T) {
+	by := GET("/").
+		EncoderFor("application/json", JSONEncoder).
+		EncoderFor("application/xml", XMLEncoder).
+		Handler(func() Key { return Key{Value: "v", Part: 1} })
+
+	for _, toCheck := range []struct {
+		accept      string
+		contentType string
+		expected    int
+	}{
+		{accept: "", contentType: "application/json", expected: http.StatusOK},
+		{accept: "application/xml", contentType: "application/xml", expected: http.StatusOK},
+		{accept: "application/json;q=0.1, */*", contentType: "application/xml", expected: http.StatusOK},
+		{accept: "text/html", contentType: "text/plain; charset=utf-8", expected: http.StatusNotAcceptable},
+	} {
+		r := newGET(t, "http://localhost")
+		if toCheck.accept != "" {
+			r.Header.Set("Accept", toCheck.accept)
+		}
+		w := httptest.NewRecorder()
+		if err := by.Build().Handle(w, r); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != toCheck.expected {
+			t.Error(toCheck.accept, "unexpected response code", w.Code)
+		}
+		if w.Header().Get("Content-Type") != toCheck.contentType {
+			t.Error(toCheck.accept, "unexpected Content-Type", w.Header().Get("Content-Type"))
+		}
+	}
+
+	r := newGET(t, "http://localhost")
+	r.Header.Set("Accept", "text/html")
+	w := httptest.NewRecorder()
+	if err := by.Encoder(JSONEncoder).ResponseContentType(Application.JSON).Build().Handle(w, r); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != Application.JSON() {
+		t.Error("fallback encoder is not used", w.Code, w.Header())
+	}
+}
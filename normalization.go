@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+const normalizeTag = "normalize"
+
+// StringNormalization defines how values of string parameters are sanitized before they are bound.
+type StringNormalization struct {
+	// Trim removes leading and trailing white space.
+	Trim bool
+	// CollapseSpaces replaces runs of white space with a single space.
+	CollapseSpaces bool
+	// Lower converts the value to lower case.
+	Lower bool
+	// Unicode converts the value into a normalization form, e.g. norm.NFC.String of golang.org/x/text/unicode/norm.
+	Unicode func(s string) string
+}
+
+func (n StringNormalization) enabled() bool {
+	return n.Trim || n.CollapseSpaces || n.Lower || n.Unicode != nil
+}
+
+func (n StringNormalization) apply(s string) string {
+	if n.Unicode != nil {
+		s = n.Unicode(s)
+	}
+	if n.CollapseSpaces {
+		s = strings.Join(strings.FieldsFunc(s, unicode.IsSpace), " ")
+	} else if n.Trim {
+		s = strings.TrimSpace(s)
+	}
+	if n.Lower {
+		s = strings.ToLower(s)
+	}
+	return s
+}
+
+// parseNormalizationTag parses comma separated options of the field tag: trim, collapse, lower and unicode.
+// Option unicode uses Unicode function of the builder policy, "-" disables the policy for the field.
+func parseNormalizationTag(tag string, policy StringNormalization) (StringNormalization, error) {
+	var n StringNormalization
+	if tag == "-" {
+		return n, nil
+	}
+	for _, option := range strings.Split(tag, ",") {
+		switch strings.TrimSpace(option) {
+		case "trim":
+			n.Trim = true
+		case "collapse":
+			n.CollapseSpaces = true
+		case "lower":
+			n.Lower = true
+		case "unicode":
+			if policy.Unicode == nil {
+				return n, InvalidMappingError(fmt.Errorf("%s tag option unicode requires Unicode function of the builder policy", normalizeTag))
+			}
+			n.Unicode = policy.Unicode
+		default:
+			return n, InvalidMappingError(fmt.Errorf("unknown %s tag option %q", normalizeTag, option))
+		}
+	}
+	return n, nil
+}
+
+func isStringKind(t reflect.Type) bool {
+	if t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.String
+}
+
+// NormalizeStrings sets normalization of string path parameters and tagged struct fields,
+// fields with normalize tag are normalized according to the tag instead.
+func (b builder) NormalizeStrings(policy StringNormalization) Builder {
+	cloned := b.clone()
+	cloned.normalization = policy
+	return cloned
+}
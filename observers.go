@@ -0,0 +1,64 @@
+package main
+
+const (
+	RouteAdded   = "added"
+	RouteRemoved = "removed"
+	// RouteRebuilt is sent for each route when settings of the router applied to all of them are changed,
+	// e.g. Hooks or Clock.
+	RouteRebuilt = "rebuilt"
+	// RoutesReloaded is sent once LoadRoutes registers all routes of the configuration, Route is empty then.
+	RoutesReloaded = "reloaded"
+)
+
+// RouteEvent notifies about change of routes of the router.
+type RouteEvent struct {
+	Kind  string
+	Route RouteInfo
+}
+
+// RouteObserver is notified about route events synchronously after the change is applied,
+// so it could inspect the router, but must not register or remove routes.
+type RouteObserver func(event RouteEvent)
+
+type subscription struct {
+	observer RouteObserver
+}
+
+// Subscribe notifies the observer about routes added or removed afterwards, already registered routes are
+// returned by Routes. Returned function cancels the subscription.
+func (rt *Router) Subscribe(observer RouteObserver) func() {
+	subscribed := &subscription{observer: observer}
+	rt.mu.Lock()
+	rt.subscriptions = append(rt.subscriptions, subscribed)
+	rt.mu.Unlock()
+
+	return func() {
+		rt.mu.Lock()
+		defer rt.mu.Unlock()
+		for i, registered := range rt.subscriptions {
+			if registered == subscribed {
+				rt.subscriptions = append(rt.subscriptions[:i:i], rt.subscriptions[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+func (rt *Router) notify(kind string, endpoints []*EndpointProcessor) {
+	events := make([]RouteEvent, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		events = append(events, RouteEvent{Kind: kind, Route: endpoint.route})
+	}
+	rt.publish(events...)
+}
+
+func (rt *Router) publish(events ...RouteEvent) {
+	rt.mu.RLock()
+	subscriptions := rt.subscriptions
+	rt.mu.RUnlock()
+	for _, event := range events {
+		for _, subscribed := range subscriptions {
+			subscribed.observer(event)
+		}
+	}
+}
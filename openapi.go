@@ -0,0 +1,491 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const openAPIVersion = "3.0.3"
+
+type OpenAPIDocument struct {
+	OpenAPI    string                     `json:"openapi"`
+	Info       OpenAPIInfo                `json:"info"`
+	Paths      map[string]OpenAPIPathItem `json:"paths"`
+	Components OpenAPIComponents          `json:"components,omitempty"`
+}
+
+type OpenAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// OpenAPIPathItem maps lower-cased HTTP method to operation.
+type OpenAPIPathItem map[string]*OpenAPIOperation
+
+type OpenAPIOperation struct {
+	OperationID string                      `json:"operationId,omitempty"`
+	Parameters  []OpenAPIParameter          `json:"parameters,omitempty"`
+	RequestBody *OpenAPIRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*OpenAPIResponse `json:"responses"`
+}
+
+type OpenAPIParameter struct {
+	Name     string         `json:"name"`
+	In       string         `json:"in"`
+	Required bool           `json:"required,omitempty"`
+	Schema   *OpenAPISchema `json:"schema,omitempty"`
+}
+
+type OpenAPIRequestBody struct {
+	Required bool                        `json:"required,omitempty"`
+	Content  map[string]OpenAPIMediaType `json:"content"`
+}
+
+type OpenAPIResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]OpenAPIMediaType `json:"content,omitempty"`
+}
+
+type OpenAPIMediaType struct {
+	Schema  *OpenAPISchema `json:"schema,omitempty"`
+	Example interface{}    `json:"example,omitempty"`
+}
+
+type OpenAPIComponents struct {
+	Schemas map[string]*OpenAPISchema `json:"schemas,omitempty"`
+}
+
+type OpenAPISchema struct {
+	Ref                  string                    `json:"$ref,omitempty"`
+	Type                 string                    `json:"type,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Enum                 []string                  `json:"enum,omitempty"`
+	Pattern              string                    `json:"pattern,omitempty"`
+	Minimum              *float64                  `json:"minimum,omitempty"`
+	Items                *OpenAPISchema            `json:"items,omitempty"`
+	Properties           map[string]*OpenAPISchema `json:"properties,omitempty"`
+	Required             []string                  `json:"required,omitempty"`
+	AdditionalProperties *OpenAPISchema            `json:"additionalProperties,omitempty"`
+}
+
+// OpenAPI describes routes as OpenAPI 3 document.
+// Title and version of the document could be changed in Info before publishing.
+func OpenAPI(routes ...Builder) (OpenAPIDocument, error) {
+	doc := OpenAPIDocument{
+		OpenAPI:    openAPIVersion,
+		Info:       OpenAPIInfo{Title: "feel", Version: "1.0.0"},
+		Paths:      map[string]OpenAPIPathItem{},
+		Components: OpenAPIComponents{Schemas: map[string]*OpenAPISchema{}},
+	}
+	schemas := newOpenAPISchemas(doc.Components.Schemas, "#/components/schemas/")
+
+	// queried are operations of routes dispatched by URL query, which share the path and the method with others
+	queried := map[*OpenAPIOperation]bool{}
+	for _, route := range routes {
+		b, ok := route.(builder)
+		if !ok {
+			return doc, InvalidMappingError(fmt.Errorf("unable to describe route of type %T", route))
+		}
+		operation, err := b.openAPIOperation(schemas)
+		if err != nil {
+			return doc, err
+		}
+
+		path := openAPIPath(b.pathTemplate)
+		pathItem, found := doc.Paths[path]
+		if !found {
+			pathItem = OpenAPIPathItem{}
+			doc.Paths[path] = pathItem
+		}
+		method := strings.ToLower(b.method)
+		existing, found := pathItem[method]
+		switch {
+		case !found:
+			pathItem[method] = operation
+			queried[operation] = len(b.queryConditions) > 0
+		case queried[existing] || len(b.queryConditions) > 0:
+			mergeOpenAPIOperations(existing, operation)
+			queried[existing] = true
+		default:
+			return doc, InvalidMappingError(fmt.Errorf("duplicate route: %s %s", b.method, b.pathTemplate))
+		}
+	}
+	return doc, nil
+}
+
+// mergeOpenAPIOperations describes routes dispatched by URL query on the same path and method as the single
+// operation, as OpenAPI has no other way to describe them: conditions of the query are optional parameters
+// enumerating values of all routes, parameters and responses missing in the operation are added.
+func mergeOpenAPIOperations(operation, added *OpenAPIOperation) {
+	if operation.OperationID == "" {
+		operation.OperationID = added.OperationID
+	}
+	for i, parameter := range operation.Parameters {
+		merged := false
+		for _, addedParameter := range added.Parameters {
+			if parameter.In != addedParameter.In || parameter.Name != addedParameter.Name {
+				continue
+			}
+			merged = true
+			operation.Parameters[i].Required = parameter.Required && addedParameter.Required
+			if parameter.Schema != nil && addedParameter.Schema != nil && len(parameter.Schema.Enum) > 0 && len(addedParameter.Schema.Enum) > 0 {
+				schema := *parameter.Schema
+				schema.Enum = mergeEnum(schema.Enum, addedParameter.Schema.Enum)
+				operation.Parameters[i].Schema = &schema
+			}
+		}
+		if !merged {
+			operation.Parameters[i].Required = parameter.In == "path"
+		}
+	}
+	for _, addedParameter := range added.Parameters {
+		found := false
+		for _, parameter := range operation.Parameters {
+			found = found || parameter.In == addedParameter.In && parameter.Name == addedParameter.Name
+		}
+		if !found {
+			addedParameter.Required = addedParameter.In == "path"
+			operation.Parameters = append(operation.Parameters, addedParameter)
+		}
+	}
+	if operation.RequestBody == nil {
+		operation.RequestBody = added.RequestBody
+	}
+	for status, response := range added.Responses {
+		if _, found := operation.Responses[status]; !found {
+			operation.Responses[status] = response
+		}
+	}
+}
+
+// mergeEnum appends values missing in the enumeration.
+func mergeEnum(enum, values []string) []string {
+	merged := append([]string(nil), enum...)
+	for _, value := range values {
+		found := false
+		for _, existing := range merged {
+			found = found || existing == value
+		}
+		if !found {
+			merged = append(merged, value)
+		}
+	}
+	return merged
+}
+
+func openAPIPath(urlPathTemplate string) string {
+	segments := strings.Split(urlPathTemplate, "/")
+	for i, name := range openAPIPathParameterNames(urlPathTemplate) {
+		segments[i] = "{" + name + "}"
+	}
+	return strings.Join(segments, "/")
+}
+
+// openAPIPathParameterNames returns names of path parameters by index of the template segment.
+// Unnamed parameters are named by their position.
+func openAPIPathParameterNames(urlPathTemplate string) map[int]string {
+	names := map[int]string{}
+	position := 0
+	for i, segment := range strings.Split(urlPathTemplate, "/") {
+		if !strings.HasPrefix(segment, ":") {
+			continue
+		}
+		position++
+		name := segment[1:]
+		if name == "" {
+			name = "param" + strconv.Itoa(position)
+		}
+		names[i] = name
+	}
+	return names
+}
+
+func (b builder) openAPIOperation(schemas openAPISchemas) (*OpenAPIOperation, error) {
+	if !b.serviceValue.IsValid() {
+		return nil, InvalidMappingError(errors.New("route has no handler: " + b.method + " " + b.pathTemplate))
+	}
+	cloned := b.clone()
+	cloned.groupParameters(cloned.serviceValue.Type())
+	if len(cloned.errors) > 0 {
+		return nil, cloned.errors[0]
+	}
+
+	operation := &OpenAPIOperation{OperationID: cloned.name, Responses: map[string]*OpenAPIResponse{}}
+
+	pathParameterTypes := cloned.parametersBy[pathParametersGroup]
+	names := openAPIPathParameterNames(cloned.pathTemplate)
+	segments := strings.Split(cloned.pathTemplate, "/")
+	position := 0
+	for i := range segments {
+		name, found := names[i]
+		if !found {
+			continue
+		}
+		parameter := OpenAPIParameter{Name: name, In: "path", Required: true}
+		if position < len(pathParameterTypes) {
+			parameter.Schema = schemas.of(pathParameterTypes[position])
+		}
+		for _, constraint := range cloned.constraints {
+			if constraint.name == name && parameter.Schema != nil && parameter.Schema.Ref == "" {
+				parameter.Schema.Pattern = "^(?:" + constraint.pattern.String() + ")$"
+			}
+		}
+		operation.Parameters = append(operation.Parameters, parameter)
+		position++
+	}
+
+	queryNames := make([]string, 0, len(cloned.queryConditions))
+	for name := range cloned.queryConditions {
+		queryNames = append(queryNames, name)
+	}
+	sort.Strings(queryNames)
+	for _, name := range queryNames {
+		operation.Parameters = append(operation.Parameters, OpenAPIParameter{
+			Name: name, In: "query", Required: true, Schema: &OpenAPISchema{Type: "string", Enum: cloned.queryConditions[name]},
+		})
+	}
+
+	for _, required := range cloned.requiredHeaders {
+		operation.Parameters = append(operation.Parameters, OpenAPIParameter{
+			Name: required.name, In: "header", Required: true, Schema: &OpenAPISchema{Type: "string"},
+		})
+	}
+
+	if structTypes, exist := cloned.hasParametersIn(structParametersGroup); exist {
+		bindings, err := newStructFieldBindings(structTypes[0], cloned.timeLayout)
+		if err != nil {
+			return nil, err
+		}
+		for _, binding := range bindings {
+			if binding.tag == headerTag && cloned.isRequiredHeader(binding.name) {
+				continue
+			}
+			if binding.tag == pathTag {
+				for i, parameter := range operation.Parameters {
+					if parameter.In == "path" && parameter.Name == binding.name && parameter.Schema == nil {
+						operation.Parameters[i].Schema = schemas.of(binding.fieldType)
+					}
+				}
+				continue
+			}
+			operation.Parameters = append(operation.Parameters, OpenAPIParameter{
+				Name: binding.name, In: binding.tag, Schema: schemas.of(binding.fieldType),
+			})
+		}
+	}
+
+	if bodyTypes, exist := cloned.hasParametersIn(bodyParametersGroup); exist {
+		var mediaTypes []string
+		for mediaType := range cloned.decoders {
+			mediaTypes = append(mediaTypes, mediaType)
+		}
+		if cloned.decoder != nil {
+			mediaTypes = append(mediaTypes, openAPIMediaType(cloned.decoder.MediaType()))
+		}
+		operation.RequestBody = &OpenAPIRequestBody{
+			Required: true,
+			Content:  openAPIContent(mediaTypes, schemas.of(bodyTypes[0]), cloned.requestExample),
+		}
+	}
+
+	successStatus := strconv.Itoa(http.StatusOK)
+	if _, exist := cloned.hasParametersIn(responseStatusCodeParametersGroup); exist {
+		successStatus = "2XX"
+	}
+	success := &OpenAPIResponse{Description: http.StatusText(http.StatusOK)}
+	var responseMediaTypes []string
+	var responseSchema *OpenAPISchema
+	if bodyTypes, exist := cloned.hasParametersIn(responseBodyParametersGroup); exist {
+		responseSchema = schemas.of(bodyTypes[0])
+		for _, mediaType := range cloned.encoderMediaTypes() {
+			responseMediaTypes = append(responseMediaTypes, openAPIMediaType(mediaType))
+		}
+		switch {
+		case cloned.contentTypeProvider != nil:
+			responseMediaTypes = append(responseMediaTypes, openAPIMediaType(cloned.contentTypeProvider()))
+		case cloned.encoder != nil:
+			responseMediaTypes = append(responseMediaTypes, openAPIMediaType(cloned.encoder.MediaType()))
+		case len(cloned.encoders) == 0:
+			responseMediaTypes = append(responseMediaTypes, "application/octet-stream")
+		}
+		success.Content = openAPIContent(responseMediaTypes, responseSchema, nil)
+	}
+	operation.Responses[successStatus] = success
+
+	for _, example := range cloned.responseExamples {
+		status := strconv.Itoa(example.statusCode)
+		operation.Responses[status] = &OpenAPIResponse{
+			Description: http.StatusText(example.statusCode),
+			Content:     openAPIContent(responseMediaTypes, responseSchema, example.value),
+		}
+	}
+
+	if _, exist := cloned.hasParametersIn(responseErrorParametersGroup); exist {
+		operation.Responses["default"] = &OpenAPIResponse{Description: "Error"}
+	}
+	return operation, nil
+}
+
+func openAPIContent(mediaTypes []string, schema *OpenAPISchema, example interface{}) map[string]OpenAPIMediaType {
+	if len(mediaTypes) == 0 {
+		return nil
+	}
+	content := make(map[string]OpenAPIMediaType, len(mediaTypes))
+	for _, mediaType := range mediaTypes {
+		content[mediaType] = OpenAPIMediaType{Schema: schema, Example: example}
+	}
+	return content
+}
+
+func (b *builder) isRequiredHeader(name string) bool {
+	for _, required := range b.requiredHeaders {
+		if required.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func openAPIMediaType(contentType string) string {
+	if contentType == "" {
+		return "*/*"
+	}
+	if index := strings.Index(contentType, ";"); index != -1 {
+		return strings.TrimSpace(contentType[:index])
+	}
+	return contentType
+}
+
+type openAPISchemas struct {
+	components map[string]*OpenAPISchema
+	// refPrefix is a location of components referenced by schemas of named types
+	refPrefix string
+	// names are names of components by their types
+	names map[reflect.Type]string
+}
+
+func newOpenAPISchemas(components map[string]*OpenAPISchema, refPrefix string) openAPISchemas {
+	return openAPISchemas{components: components, refPrefix: refPrefix, names: map[reflect.Type]string{}}
+}
+
+// nameOf names the component of the type by its name. Types named like already described ones are qualified by
+// the path of their package if it differs, or numbered otherwise, e.g. types declared in functions.
+func (s openAPISchemas) nameOf(t reflect.Type) string {
+	if name, found := s.names[t]; found {
+		return name
+	}
+	sanitize := func(name string) string {
+		return strings.Map(func(r rune) rune {
+			if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' || r == '_' {
+				return r
+			}
+			return '_'
+		}, name)
+	}
+	name := sanitize(t.Name())
+	for described, describedName := range s.names {
+		if describedName == name && described.PkgPath() != t.PkgPath() {
+			name = sanitize(strings.ReplaceAll(t.PkgPath(), "/", ".") + "." + t.Name())
+		}
+	}
+	for i, base := 2, name; ; i++ {
+		if _, taken := s.components[name]; !taken {
+			break
+		}
+		name = base + strconv.Itoa(i)
+	}
+	s.names[t] = name
+	return name
+}
+
+var byteSizeType = reflect.TypeOf(ByteSize(0))
+
+func (s openAPISchemas) of(t reflect.Type) *OpenAPISchema {
+	switch t {
+	case timeType:
+		return &OpenAPISchema{Type: "string", Format: "date-time"}
+	case durationType:
+		return &OpenAPISchema{Type: "string", Format: "duration"}
+	case byteSizeType:
+		return &OpenAPISchema{Type: "string", Format: "byte-size"}
+	case netipAddrType, netIPType:
+		return &OpenAPISchema{Type: "string", Format: "ip"}
+	case netipPrefixType:
+		return &OpenAPISchema{Type: "string", Format: "cidr"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return s.of(t.Elem())
+	case reflect.String:
+		return &OpenAPISchema{Type: "string"}
+	case reflect.Bool:
+		return &OpenAPISchema{Type: "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int:
+		return &OpenAPISchema{Type: "integer", Format: "int32"}
+	case reflect.Int64:
+		return &OpenAPISchema{Type: "integer", Format: "int64"}
+	case reflect.Uint8, reflect.Uint16:
+		minimum := 0.0
+		return &OpenAPISchema{Type: "integer", Format: "int32", Minimum: &minimum}
+	case reflect.Uint32, reflect.Uint, reflect.Uint64:
+		minimum := 0.0
+		return &OpenAPISchema{Type: "integer", Format: "int64", Minimum: &minimum}
+	case reflect.Float32:
+		return &OpenAPISchema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &OpenAPISchema{Type: "number", Format: "double"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &OpenAPISchema{Type: "string", Format: "byte"}
+		}
+		return &OpenAPISchema{Type: "array", Items: s.of(t.Elem())}
+	case reflect.Map:
+		return &OpenAPISchema{Type: "object", AdditionalProperties: s.of(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.ofStruct(t)
+		}
+		if name, found := s.names[t]; found {
+			return &OpenAPISchema{Ref: s.refPrefix + name}
+		}
+		name := s.nameOf(t)
+		// placeholder prevents infinite recursion on self-referencing types
+		s.components[name] = &OpenAPISchema{}
+		*s.components[name] = *s.ofStruct(t)
+		return &OpenAPISchema{Ref: s.refPrefix + name}
+	}
+	return &OpenAPISchema{}
+}
+
+func (s openAPISchemas) ofStruct(t reflect.Type) *OpenAPISchema {
+	schema := &OpenAPISchema{Type: "object", Properties: map[string]*OpenAPISchema{}}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+		name := field.Name
+		if tag, found := field.Tag.Lookup("json"); found {
+			tagName := strings.Split(tag, ",")[0]
+			if tagName == "-" {
+				continue
+			}
+			if tagName != "" {
+				name = tagName
+			}
+		} else if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			for embeddedName, embedded := range s.ofStruct(field.Type).Properties {
+				schema.Properties[embeddedName] = embedded
+			}
+			continue
+		}
+		schema.Properties[name] = s.of(field.Type)
+	}
+	return schema
+}
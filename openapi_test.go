@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+type Account struct {
+	ID       uint64    `json:"id"`
+	Name     string    `json:"name,omitempty"`
+	Children []Account `json:"children"`
+	Secret   string    `json:"-"`
+}
+
+func TestOpenAPI(t *testing.T) {
+	doc, err := OpenAPI(
+		GET("/accounts/:id").
+			Name("getAccount").
+			Constraint("id", regexp.MustCompile("[0-9]+")).
+			RequireHeader("X-Tenant-Id").
+			Encoder(JSONEncoder).
+			ResponseExample(http.StatusNotFound, "no such account").
+			Handler(func(id uint64, meta RequestMeta) (Account, error) { return Account{}, nil }),
+		POST("/accounts").
+			DecoderFor("application/xml", XMLDecoder).
+			Decoder(JSONDecoder).
+			RequestExample(Account{Name: "new"}).
+			Handler(func(account Account) int { return http.StatusCreated }),
+		GET("/accounts/:id").WhenQuery("action", "export").RequireHeader("X-Tenant-Id").Handler(func(id uint64) []byte { return nil }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := doc.Paths["/accounts/{id}"]["get"]
+	if get == nil || get.OperationID != "getAccount" {
+		t.Fatalf("received: %#v", doc.Paths)
+	}
+	parameters := map[string]OpenAPIParameter{}
+	for _, parameter := range get.Parameters {
+		parameters[parameter.In+":"+parameter.Name] = parameter
+	}
+	if p := parameters["path:id"]; !p.Required || p.Schema.Type != "integer" || p.Schema.Format != "int64" || p.Schema.Pattern != "^(?:[0-9]+)$" {
+		t.Errorf("received: %#v", p)
+	}
+	if p := parameters["header:X-Tenant-Id"]; !p.Required {
+		t.Errorf("received: %#v", p)
+	}
+	if p := parameters["header:X-Request-Id"]; p.Required || p.Schema.Type != "string" {
+		t.Errorf("received: %#v", p)
+	}
+	if p := parameters["query:page"]; p.Schema == nil || p.Schema.Type != "integer" {
+		t.Errorf("received: %#v", p)
+	}
+	if schema := get.Responses["200"].Content["application/json"].Schema; schema == nil || schema.Ref != "#/components/schemas/Account" {
+		t.Errorf("received: %#v", get.Responses["200"])
+	}
+	if example := get.Responses["404"].Content["application/json"].Example; example != "no such account" {
+		t.Errorf("received: %#v", get.Responses["404"])
+	}
+	if get.Responses["default"] == nil {
+		t.Error("error response is not described")
+	}
+
+	account := doc.Components.Schemas["Account"]
+	if account == nil || account.Properties["children"].Items.Ref != "#/components/schemas/Account" {
+		t.Fatalf("received: %#v", account)
+	}
+	if _, found := account.Properties["Secret"]; found {
+		t.Error("ignored field is described")
+	}
+
+	post := doc.Paths["/accounts"]["post"]
+	if post == nil || len(post.RequestBody.Content) != 2 {
+		t.Fatalf("received: %#v", post)
+	}
+	if example, _ := post.RequestBody.Content["application/xml"].Example.(Account); example.Name != "new" {
+		t.Errorf("received: %#v", example)
+	}
+	if post.Responses["2XX"] == nil {
+		t.Errorf("received: %#v", post.Responses)
+	}
+
+	if p := parameters["query:action"]; p.Required || !reflect.DeepEqual(p.Schema.Enum, []string{"export"}) {
+		t.Errorf("received: %#v", p)
+	}
+
+	if _, err := json.Marshal(doc); err != nil {
+		t.Error(err)
+	}
+}
+
+// createAccount is declared outside of tests to describe Account of the package next to the local one.
+func createAccount(account Account) {}
+
+func TestOpenAPIDocument(t *testing.T) {
+	type Account struct {
+		Owner uint `json:"owner"`
+	}
+	doc, err := OpenAPI(
+		GET("/accounts").
+			Name("listAccounts").
+			WhenQuery("view", "full").
+			WhenQuery("format", "csv", "tsv").
+			Handler(func() []Account { return nil }),
+		GET("/accounts").
+			WhenQuery("view", "brief").
+			Handler(func(query url.Values) []string { return nil }),
+		GET("/accounts/:id").Encoder(JSONEncoder).Handler(func(id uint32) (Account, error) { return Account{}, nil }),
+		PUT("/accounts/:id").Decoder(JSONDecoder).Handler(func(id uint64, account struct{ Parent Account }) {}),
+		POST("/accounts").Decoder(JSONDecoder).Handler(createAccount),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := os.ReadFile(filepath.Join("testdata", "openapi.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(received) != strings.TrimSpace(string(expected)) {
+		t.Errorf("unexpected document:\n%s", received)
+	}
+
+	if _, err := OpenAPI(GET("/accounts").Handler(func() {}), GET("/accounts").Handler(func() {})); !errors.Is(err, InvalidMapping) {
+		t.Error("expected error of duplicate routes", err)
+	}
+}
+
+func TestEchoSchema(t *testing.T) {
+	invoked := false
+	by := POST("/accounts/:parent/children").
+		Decoder(JSONDecoder).
+		RequireHeader("X-Tenant").
+		EchoSchema(true).
+		Handler(func(parent uint64, account Account) { invoked = true })
+
+	w := httptest.NewRecorder()
+	if err := by.Build().Handle(w, newRequest(t, http.MethodPost, "http://localhost/accounts/1/children?schema", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if invoked || w.Header().Get("Content-Type") != "application/schema+json" {
+		t.Fatal("unexpected response", invoked, w.Header())
+	}
+
+	var schema map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &schema); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"type":    "object",
+		"properties": map[string]interface{}{
+			"path": map[string]interface{}{
+				"type":       "object",
+				"properties": map[string]interface{}{"parent": map[string]interface{}{"type": "integer", "format": "int64", "minimum": 0.0}},
+				"required":   []interface{}{"parent"},
+			},
+			"header": map[string]interface{}{
+				"type":       "object",
+				"properties": map[string]interface{}{"X-Tenant": map[string]interface{}{"type": "string"}},
+				"required":   []interface{}{"X-Tenant"},
+			},
+			"body": map[string]interface{}{"$ref": "#/$defs/Account"},
+		},
+		"required": []interface{}{"body", "path", "header"},
+		"$defs": map[string]interface{}{
+			"Account": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"id":       map[string]interface{}{"type": "integer", "format": "int64", "minimum": 0.0},
+					"name":     map[string]interface{}{"type": "string"},
+					"children": map[string]interface{}{"type": "array", "items": map[string]interface{}{"$ref": "#/$defs/Account"}},
+				},
+			},
+		},
+	}
+	if !reflect.DeepEqual(schema, expected) {
+		t.Errorf("unexpected schema %s", w.Body.String())
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+)
+
+// Cursor is an opaque position of the next page.
+type Cursor string
+
+// PageOf is a page of a list returned by handlers. Total and Next are sent in headers configured
+// by PaginationPolicy and only Items are encoded, unless the policy encodes the whole page.
+type PageOf[T any] struct {
+	Items []T    `json:"items" xml:"items"`
+	Total int64  `json:"total" xml:"total"`
+	Next  Cursor `json:"next,omitempty" xml:"next,omitempty"`
+}
+
+func (p PageOf[T]) page() (interface{}, int64, Cursor) {
+	if p.Items == nil {
+		return []T{}, p.Total, p.Next
+	}
+	return p.Items, p.Total, p.Next
+}
+
+// page is implemented by PageOf of any item type.
+type page interface {
+	page() (items interface{}, total int64, next Cursor)
+}
+
+var pageType = reflect.TypeOf((*page)(nil)).Elem()
+
+// PaginationPolicy configures responses of handlers returning PageOf.
+type PaginationPolicy struct {
+	// TotalHeader is a name of the header with the total amount of items, X-Total-Count if empty.
+	TotalHeader string
+	// CursorQuery is a name of the query parameter with the cursor in the link of the next page, cursor if empty.
+	// The link is sent in Link header with rel="next" if the page has Next cursor.
+	CursorQuery string
+	// Envelope encodes the whole page instead of its items, headers are sent anyway.
+	Envelope bool
+}
+
+// Pagination sets the policy of responses of PageOf returned by the handler.
+// They are sent with default PaginationPolicy otherwise.
+func (b builder) Pagination(policy PaginationPolicy) Builder {
+	cloned := b.clone()
+	cloned.paginationPolicy = policy
+	return cloned
+}
+
+// buildPagination returns function sending headers of the page in results and replacing it with its items.
+func (b *builder) buildPagination() func(results []reflect.Value, w http.ResponseWriter, r *http.Request) []reflect.Value {
+	bodyIndex := b.resultIndex(responseBodyParametersGroup)
+	if bodyIndex < 0 || !b.parametersBy[responseBodyParametersGroup][0].Implements(pageType) {
+		return nil
+	}
+	policy := b.paginationPolicy
+	if policy.TotalHeader == "" {
+		policy.TotalHeader = "X-Total-Count"
+	}
+	if policy.CursorQuery == "" {
+		policy.CursorQuery = "cursor"
+	}
+	return func(results []reflect.Value, w http.ResponseWriter, r *http.Request) []reflect.Value {
+		body := results[bodyIndex]
+		if isNil(body) {
+			return results
+		}
+		items, total, next := body.Interface().(page).page()
+		w.Header().Set(policy.TotalHeader, strconv.FormatInt(total, 10))
+		if next != "" {
+			query := r.URL.Query()
+			query.Set(policy.CursorQuery, string(next))
+			w.Header().Add("Link", "<"+r.URL.Path+"?"+query.Encode()+`>; rel="next"`)
+		}
+		if policy.Envelope {
+			return results
+		}
+		unpacked := append([]reflect.Value(nil), results...)
+		unpacked[bodyIndex] = reflect.ValueOf(items)
+		return unpacked
+	}
+}
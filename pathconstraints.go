@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+type pathConstraint struct {
+	name    string
+	segment int
+	pattern *regexp.Regexp
+}
+
+// Constraint restricts path parameter with the name (as in template, without colon) to values entirely matching the pattern.
+// Router responds with 404 Not Found if none of routes matches the path, so it also allows
+// to register several routes with the same template differing by constraints only.
+// Routes with matching constraints take precedence over routes of the same template without them.
+func (b builder) Constraint(name string, pattern *regexp.Regexp) Builder {
+	cloned := b.clone()
+	constraints := make([]pathConstraint, len(cloned.constraints), len(cloned.constraints)+1)
+	copy(constraints, cloned.constraints)
+	cloned.constraints = append(constraints, pathConstraint{name: strings.TrimPrefix(name, pathParameterPrefix), segment: -1, pattern: pattern})
+	return cloned
+}
+
+// resolveConstraints binds constraints to segments of the path template.
+func (b *builder) resolveConstraints() []pathConstraint {
+	if len(b.constraints) == 0 {
+		return nil
+	}
+	segments := strings.Split(b.pathTemplate, pathSeparator)
+	resolved := make([]pathConstraint, 0, len(b.constraints))
+	for _, constraint := range b.constraints {
+		for _, index := range pathParameterSegments(b.pathTemplate) {
+			if segments[index] == pathParameterPrefix+constraint.name {
+				constraint.segment = index
+				break
+			}
+		}
+		if constraint.segment < 0 {
+			b.addError(InvalidMappingError(fmt.Errorf("constrained path parameter %q is not in the template", constraint.name)))
+			continue
+		}
+		resolved = append(resolved, constraint)
+	}
+	return resolved
+}
+
+// ConstraintError is returned by the endpoint handling the request which path doesn't satisfy its constraints,
+// e.g. when the endpoint is used without Router. It is mapped with 404 Not Found status code.
+type ConstraintError struct {
+	Parameter string
+	Value     string
+}
+
+func (e ConstraintError) Error() string {
+	return fmt.Sprintf("path parameter %q doesn't match the constraint: %q", e.Parameter, e.Value)
+}
+
+func (e ConstraintError) StatusCode() int {
+	return http.StatusNotFound
+}
+
+func matchesConstraints(constraints []pathConstraint, segments []string) bool {
+	return checkConstraints(constraints, segments) == nil
+}
+
+// checkConstraints returns ConstraintError of the first constraint not matching the path segments.
+func checkConstraints(constraints []pathConstraint, segments []string) error {
+	for _, constraint := range constraints {
+		if constraint.segment >= len(segments) {
+			return ConstraintError{Parameter: constraint.name}
+		}
+		value, err := url.PathUnescape(segments[constraint.segment])
+		if err != nil {
+			return ConstraintError{Parameter: constraint.name, Value: segments[constraint.segment]}
+		}
+		location := constraint.pattern.FindStringIndex(value)
+		if location == nil || location[0] != 0 || location[1] != len(value) {
+			return ConstraintError{Parameter: constraint.name, Value: value}
+		}
+	}
+	return nil
+}
+
+func sameConstraints(a, b []pathConstraint) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, constraint := range a {
+		found := false
+		for _, other := range b {
+			if constraint.segment == other.segment && constraint.pattern.String() == other.pattern.String() {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// PathParameterLimits restricts unescaped values of path parameters before they are converted,
+// zero value of a field disables the check. Violations result into 400 status code.
+type PathParameterLimits struct {
+	// MaxLength is a max length of the value in bytes.
+	MaxLength int
+	// RejectControl rejects values with control characters including NUL and invalid UTF-8.
+	RejectControl bool
+	// RejectSeparators rejects values with slashes, backslashes and dot segments "." and "..",
+	// which could escape the directory if the value is used as a file name.
+	RejectSeparators bool
+	// Allowed reports whether the character is allowed in the value.
+	Allowed func(r rune) bool
+}
+
+func (limits PathParameterLimits) check(value string) error {
+	if limits.MaxLength > 0 && len(value) > limits.MaxLength {
+		return InvalidValueError(fmt.Errorf("path parameter length is %d, max is %d", len(value), limits.MaxLength))
+	}
+	if limits.RejectControl && !utf8.ValidString(value) {
+		return InvalidValueError(fmt.Errorf("path parameter %q is not valid UTF-8", value))
+	}
+	if limits.RejectSeparators && (value == "." || value == ".." || strings.ContainsAny(value, `/\`)) {
+		return InvalidValueError(fmt.Errorf("path parameter %q contains path separator", value))
+	}
+	for _, r := range value {
+		if limits.RejectControl && unicode.IsControl(r) {
+			return InvalidValueError(fmt.Errorf("path parameter %q contains control character", value))
+		}
+		if limits.Allowed != nil && !limits.Allowed(r) {
+			return InvalidValueError(fmt.Errorf("path parameter %q contains disallowed character %q", value, r))
+		}
+	}
+	return nil
+}
+
+// PathLimits sets limits checked for values of path parameters before they are converted.
+func (b builder) PathLimits(limits PathParameterLimits) Builder {
+	cloned := b.clone()
+	cloned.pathLimits = &limits
+	return cloned
+}
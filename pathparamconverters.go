@@ -1,8 +1,19 @@
 package main
 
 import (
+	"encoding"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net/http"
 	"reflect"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 type PathParameterConverter interface {
@@ -11,6 +22,34 @@ type PathParameterConverter interface {
 
 var PathParameterConverterType = reflect.TypeOf((*PathParameterConverter)(nil)).Elem()
 
+// ConverterFunc adapts function to PathParameterConverter.
+type ConverterFunc func(pathPart string) (reflect.Value, error)
+
+func (f ConverterFunc) Convert(pathPart string) (reflect.Value, error) {
+	return f(pathPart)
+}
+
+var registeredConverters = struct {
+	sync.RWMutex
+	byType map[reflect.Type]PathParameterConverter
+}{byType: map[reflect.Type]PathParameterConverter{}}
+
+// RegisterConverter registers conversion of path, query and header values into the type for all endpoints,
+// e.g. for types of third party packages. Registered converter takes precedence over any other conversion.
+// Endpoints resolve converters on Build, so registration should be done before endpoints are built.
+func RegisterConverter(parameterType reflect.Type, converter PathParameterConverter) {
+	registeredConverters.Lock()
+	defer registeredConverters.Unlock()
+	registeredConverters.byType[parameterType] = converter
+}
+
+func registeredConverter(parameterType reflect.Type) (PathParameterConverter, bool) {
+	registeredConverters.RLock()
+	defer registeredConverters.RUnlock()
+	converter, found := registeredConverters.byType[parameterType]
+	return converter, found
+}
+
 type StringPathParameterConverter struct{}
 
 func (sc StringPathParameterConverter) Convert(pathPart string) (reflect.Value, error) {
@@ -19,6 +58,40 @@ func (sc StringPathParameterConverter) Convert(pathPart string) (reflect.Value,
 
 var stringPathParameterConverterSingleton = StringPathParameterConverter{}
 
+// RangeError is returned for integer values out of range of the parameter type, it results into 400 status code.
+// Parameter is the name of the path parameter or of the query, header or cookie bound into struct field.
+type RangeError struct {
+	Parameter string
+	Value     string
+	Min       string
+	Max       string
+}
+
+func (e RangeError) Error() string {
+	if e.Parameter == "" {
+		return fmt.Sprintf("value %s is out of range [%s, %s]", e.Value, e.Min, e.Max)
+	}
+	return fmt.Sprintf("parameter %s: value %s is out of range [%s, %s]", e.Parameter, e.Value, e.Min, e.Max)
+}
+
+func (e RangeError) StatusCode() int {
+	return http.StatusBadRequest
+}
+
+func (e RangeError) ProblemExtensions() map[string]interface{} {
+	return map[string]interface{}{"parameter": e.Parameter, "min": e.Min, "max": e.Max}
+}
+
+// withParameter names the parameter of RangeError found in the error chain.
+func withParameter(err error, name string) error {
+	var rangeErr RangeError
+	if !errors.As(err, &rangeErr) {
+		return err
+	}
+	rangeErr.Parameter = name
+	return rangeErr
+}
+
 type IntPathParameterConverter struct {
 	bitSize int
 	valueOf func(parsed int64) reflect.Value
@@ -26,6 +99,10 @@ type IntPathParameterConverter struct {
 
 func (ic IntPathParameterConverter) Convert(pathPart string) (reflect.Value, error) {
 	parsed, err := strconv.ParseInt(pathPart, 10, ic.bitSize)
+	if errors.Is(err, strconv.ErrRange) {
+		maxValue := int64(1)<<(ic.bitSize-1) - 1
+		return reflect.Value{}, RangeError{Value: pathPart, Min: strconv.FormatInt(-maxValue-1, 10), Max: strconv.FormatInt(maxValue, 10)}
+	}
 	if err != nil {
 		return reflect.Value{}, err
 	}
@@ -39,12 +116,33 @@ type UintPathParameterConverter struct {
 
 func (uc UintPathParameterConverter) Convert(pathPart string) (reflect.Value, error) {
 	parsed, err := strconv.ParseUint(pathPart, 10, uc.bitSize)
+	if errors.Is(err, strconv.ErrRange) {
+		maxValue := uint64(1)<<(uc.bitSize-1)<<1 - 1
+		return reflect.Value{}, RangeError{Value: pathPart, Min: "0", Max: strconv.FormatUint(maxValue, 10)}
+	}
 	if err != nil {
 		return reflect.Value{}, err
 	}
 	return uc.valueOf(parsed), nil
 }
 
+// FloatPathParameterConverter converts finite decimal numbers, NaN and infinities are rejected.
+type FloatPathParameterConverter struct {
+	bitSize int
+	valueOf func(parsed float64) reflect.Value
+}
+
+func (fc FloatPathParameterConverter) Convert(pathPart string) (reflect.Value, error) {
+	parsed, err := strconv.ParseFloat(pathPart, fc.bitSize)
+	if err != nil {
+		return reflect.Value{}, InvalidValueError(err)
+	}
+	if math.IsNaN(parsed) || math.IsInf(parsed, 0) {
+		return reflect.Value{}, InvalidValueError(fmt.Errorf("not finite number: %q", pathPart))
+	}
+	return fc.valueOf(parsed), nil
+}
+
 type BoolPathParameterConverter struct{}
 
 func (bc BoolPathParameterConverter) Convert(pathPart string) (reflect.Value, error) {
@@ -57,23 +155,323 @@ func (bc BoolPathParameterConverter) Convert(pathPart string) (reflect.Value, er
 
 var boolPathParameterConverterSingleton = BoolPathParameterConverter{}
 
-type SliceBytePathParameterConverter struct{}
+// ByteEncoding is encoding of byte slices and arrays in path, query and header values.
+type ByteEncoding string
+
+const (
+	// RawBytes takes UTF-8 bytes of the value as is.
+	RawBytes ByteEncoding = ""
+	// HexBytes decodes hexadecimal value of any case.
+	HexBytes ByteEncoding = "hex"
+	// Base64Bytes decodes standard or URL-safe base64 value with or without padding.
+	Base64Bytes ByteEncoding = "base64"
+)
+
+func (be ByteEncoding) decode(pathPart string) ([]byte, error) {
+	switch be {
+	case RawBytes:
+		return []byte(pathPart), nil
+	case HexBytes:
+		decoded, err := hex.DecodeString(pathPart)
+		if err != nil {
+			return nil, InvalidValueError(err)
+		}
+		return decoded, nil
+	case Base64Bytes:
+		encoding := base64.RawStdEncoding
+		if strings.ContainsAny(pathPart, "-_") {
+			encoding = base64.RawURLEncoding
+		}
+		decoded, err := encoding.DecodeString(strings.TrimRight(pathPart, "="))
+		if err != nil {
+			return nil, InvalidValueError(err)
+		}
+		return decoded, nil
+	}
+	return nil, UnsupportedTypeError(fmt.Errorf("byte encoding %q", string(be)))
+}
+
+func (be ByteEncoding) encode(value []byte) string {
+	switch be {
+	case HexBytes:
+		return hex.EncodeToString(value)
+	case Base64Bytes:
+		return base64.RawURLEncoding.EncodeToString(value)
+	}
+	return string(value)
+}
+
+func (be ByteEncoding) valid() bool {
+	return be == RawBytes || be == HexBytes || be == Base64Bytes
+}
+
+type SliceBytePathParameterConverter struct {
+	Encoding ByteEncoding
+}
 
 func (sbc SliceBytePathParameterConverter) Convert(pathPart string) (reflect.Value, error) {
-	return reflect.ValueOf([]byte(pathPart)), nil
+	decoded, err := sbc.Encoding.decode(pathPart)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	return reflect.ValueOf(decoded), nil
 }
 
 var sliceBytePathParameterConverterSingleton = SliceBytePathParameterConverter{}
 
+// ArrayBytePathParameterConverter converts values of exactly the length of the array once decoded,
+// as fixed-size arrays are usually binary identifiers or hashes which are invalid if truncated or padded.
 type ArrayBytePathParameterConverter struct {
 	length      int
 	elementType reflect.Type
+	encoding    ByteEncoding
 }
 
 func (abc ArrayBytePathParameterConverter) Convert(pathPart string) (reflect.Value, error) {
-	arrayType := reflect.ArrayOf(abc.length, abc.elementType)
-	arrayValuePtr := reflect.New(arrayType)
-	arrayValue := arrayValuePtr.Elem()
-	reflect.Copy(arrayValue, reflect.ValueOf(pathPart))
+	decoded, err := abc.encoding.decode(pathPart)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	if len(decoded) != abc.length {
+		return reflect.Value{}, InvalidValueError(fmt.Errorf("%d bytes instead of %d", len(decoded), abc.length))
+	}
+	arrayValue := reflect.New(reflect.ArrayOf(abc.length, abc.elementType)).Elem()
+	reflect.Copy(arrayValue, reflect.ValueOf(decoded))
 	return arrayValue, nil
 }
+
+type DurationPathParameterConverter struct{}
+
+func (dc DurationPathParameterConverter) Convert(pathPart string) (reflect.Value, error) {
+	parsed, err := time.ParseDuration(pathPart)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	return reflect.ValueOf(parsed), nil
+}
+
+var durationPathParameterConverterSingleton = DurationPathParameterConverter{}
+
+// TimePathParameterConverter parses time with the layout, RFC 3339 is used by default.
+type TimePathParameterConverter struct {
+	Layout string
+}
+
+func (tc TimePathParameterConverter) Convert(pathPart string) (reflect.Value, error) {
+	layout := tc.Layout
+	if layout == "" {
+		layout = time.RFC3339
+	}
+	parsed, err := time.Parse(layout, pathPart)
+	if err != nil {
+		return reflect.Value{}, InvalidValueError(err)
+	}
+	return reflect.ValueOf(parsed), nil
+}
+
+type TextUnmarshalerPathParameterConverter struct {
+	valueType reflect.Type
+	pointer   bool
+}
+
+func (tuc TextUnmarshalerPathParameterConverter) Convert(pathPart string) (reflect.Value, error) {
+	valuePtr := reflect.New(tuc.valueType)
+	if err := valuePtr.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(pathPart)); err != nil {
+		return reflect.Value{}, InvalidValueError(err)
+	}
+	if tuc.pointer {
+		return valuePtr, nil
+	}
+	return valuePtr.Elem(), nil
+}
+
+// ByteSize is amount of bytes converted from values like "512", "10KB", "1.5MiB".
+// Units with "i" are powers of 1024, others are powers of 1000.
+type ByteSize int64
+
+const (
+	Byte ByteSize = 1
+
+	KB = 1000 * Byte
+	MB = 1000 * KB
+	GB = 1000 * MB
+	TB = 1000 * GB
+	PB = 1000 * TB
+	EB = 1000 * PB
+
+	KiB = 1024 * Byte
+	MiB = 1024 * KiB
+	GiB = 1024 * MiB
+	TiB = 1024 * GiB
+	PiB = 1024 * TiB
+	EiB = 1024 * PiB
+)
+
+var byteSizeUnits = map[string]ByteSize{
+	"":   Byte,
+	"B":  Byte,
+	"KB": KB, "MB": MB, "GB": GB, "TB": TB, "PB": PB, "EB": EB,
+	"KIB": KiB, "MIB": MiB, "GIB": GiB, "TIB": TiB, "PIB": PiB, "EIB": EiB,
+}
+
+var _ PathParameterConverter = ByteSize(0)
+
+func (ByteSize) Convert(pathPart string) (reflect.Value, error) {
+	parsed, err := ParseByteSize(pathPart)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	return reflect.ValueOf(parsed), nil
+}
+
+func ParseByteSize(s string) (ByteSize, error) {
+	trimmed := strings.TrimSpace(s)
+	numberEnd := strings.IndexFunc(trimmed, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if numberEnd == -1 {
+		numberEnd = len(trimmed)
+	}
+	unit, found := byteSizeUnits[strings.ToUpper(strings.TrimSpace(trimmed[numberEnd:]))]
+	if !found || numberEnd == 0 {
+		return 0, fmt.Errorf("invalid byte size: %q", s)
+	}
+	// decimal fractions are exact, so "1.1KB" is 1100 bytes and "1.5" isn't a size
+	size, ok := new(big.Rat).SetString(trimmed[:numberEnd])
+	if !ok {
+		return 0, fmt.Errorf("invalid byte size: %q", s)
+	}
+	size.Mul(size, new(big.Rat).SetInt64(int64(unit)))
+	if !size.IsInt() {
+		return 0, fmt.Errorf("byte size is not a whole number of bytes: %q", s)
+	}
+	if !size.Num().IsInt64() {
+		return 0, fmt.Errorf("byte size overflows: %q", s)
+	}
+	return ByteSize(size.Num().Int64()), nil
+}
+
+// newLayoutPathParameterConverter is like newPathParameterConverter, but parses time with the layout if it is set.
+// Layout of byte slices and arrays is their ByteEncoding.
+func newLayoutPathParameterConverter(parameterType reflect.Type, layout string) (PathParameterConverter, error) {
+	if converter, found := registeredConverter(parameterType); found {
+		return converter, nil
+	}
+	if parameterType == timeType && layout != "" {
+		return TimePathParameterConverter{Layout: layout}, nil
+	}
+	converter, err := newPathParameterConverter(parameterType)
+	if err != nil || layout == "" || !isBytesKind(parameterType) {
+		return converter, err
+	}
+	encoding := ByteEncoding(layout)
+	if !encoding.valid() {
+		return nil, UnsupportedTypeError(fmt.Errorf("byte encoding %q", layout))
+	}
+	switch converter := converter.(type) {
+	case SliceBytePathParameterConverter:
+		converter.Encoding = encoding
+		return converter, nil
+	case ArrayBytePathParameterConverter:
+		converter.encoding = encoding
+		return converter, nil
+	}
+	return converter, nil
+}
+
+func isBytesKind(parameterType reflect.Type) bool {
+	kind := parameterType.Kind()
+	return (kind == reflect.Slice || kind == reflect.Array) && parameterType.Elem().Kind() == reflect.Uint8
+}
+
+// newPathParameterConverter looks up conversion of the type in order: registered by RegisterConverter, the type
+// implementing PathParameterConverter, encoding.TextUnmarshaler, time.Duration and then by kind of the type.
+func newPathParameterConverter(parameterType reflect.Type) (PathParameterConverter, error) {
+	if converter, found := registeredConverter(parameterType); found {
+		return converter, nil
+	}
+	if parameterType.Implements(PathParameterConverterType) {
+		return reflect.New(parameterType).Elem().Interface().(PathParameterConverter), nil
+	}
+
+	// standard interface takes precedence over built-in conversions, so types like time.Time,
+	// netip.Addr or user-defined IDs and enums based on strings are parsed by their own rules
+	switch {
+	case reflect.PtrTo(parameterType).Implements(textUnmarshalerType):
+		return TextUnmarshalerPathParameterConverter{valueType: parameterType}, nil
+	case parameterType.Kind() == reflect.Ptr && parameterType.Implements(textUnmarshalerType):
+		return TextUnmarshalerPathParameterConverter{valueType: parameterType.Elem(), pointer: true}, nil
+	}
+
+	if parameterType == durationType {
+		return durationPathParameterConverterSingleton, nil
+	}
+
+	switch parameterType.Kind() {
+	case reflect.String:
+		return stringPathParameterConverterSingleton, nil
+	case reflect.Int8:
+		return IntPathParameterConverter{bitSize: 8, valueOf: func(parsed int64) reflect.Value {
+			return reflect.ValueOf(int8(parsed))
+		}}, nil
+	case reflect.Int16:
+		return IntPathParameterConverter{bitSize: 16, valueOf: func(parsed int64) reflect.Value {
+			return reflect.ValueOf(int16(parsed))
+		}}, nil
+	case reflect.Int32:
+		return IntPathParameterConverter{bitSize: 32, valueOf: func(parsed int64) reflect.Value {
+			return reflect.ValueOf(int32(parsed))
+		}}, nil
+	case reflect.Int64:
+		return IntPathParameterConverter{bitSize: 64, valueOf: func(parsed int64) reflect.Value {
+			return reflect.ValueOf(parsed)
+		}}, nil
+	case reflect.Int:
+		return IntPathParameterConverter{bitSize: strconv.IntSize, valueOf: func(parsed int64) reflect.Value {
+			return reflect.ValueOf(int(parsed))
+		}}, nil
+	case reflect.Uint8:
+		return UintPathParameterConverter{bitSize: 8, valueOf: func(parsed uint64) reflect.Value {
+			return reflect.ValueOf(uint8(parsed))
+		}}, nil
+	case reflect.Uint16:
+		return UintPathParameterConverter{bitSize: 16, valueOf: func(parsed uint64) reflect.Value {
+			return reflect.ValueOf(uint16(parsed))
+		}}, nil
+	case reflect.Uint32:
+		return UintPathParameterConverter{bitSize: 32, valueOf: func(parsed uint64) reflect.Value {
+			return reflect.ValueOf(uint32(parsed))
+		}}, nil
+	case reflect.Uint64:
+		return UintPathParameterConverter{bitSize: 64, valueOf: func(parsed uint64) reflect.Value {
+			return reflect.ValueOf(parsed)
+		}}, nil
+	case reflect.Uint:
+		return UintPathParameterConverter{bitSize: strconv.IntSize, valueOf: func(parsed uint64) reflect.Value {
+			return reflect.ValueOf(uint(parsed))
+		}}, nil
+	case reflect.Float32:
+		return FloatPathParameterConverter{bitSize: 32, valueOf: func(parsed float64) reflect.Value {
+			return reflect.ValueOf(float32(parsed))
+		}}, nil
+	case reflect.Float64:
+		return FloatPathParameterConverter{bitSize: 64, valueOf: func(parsed float64) reflect.Value {
+			return reflect.ValueOf(parsed)
+		}}, nil
+	case reflect.Bool:
+		return boolPathParameterConverterSingleton, nil
+	case reflect.Slice:
+		if parameterType.Elem().Kind() != reflect.Uint8 {
+			return nil, UnsupportedTypeError(errors.New("supports only slice/array of bytes"))
+		}
+		return sliceBytePathParameterConverterSingleton, nil
+	case reflect.Array:
+		returnParameterTypeElem := parameterType.Elem()
+		if returnParameterTypeElem.Kind() != reflect.Uint8 {
+			return nil, UnsupportedTypeError(errors.New("supports only array of bytes"))
+		}
+		return ArrayBytePathParameterConverter{length: parameterType.Len(), elementType: returnParameterTypeElem}, nil
+	default:
+		return nil, UnsupportedTypeError(errors.New("for path parameter: " + parameterType.String()))
+	}
+}
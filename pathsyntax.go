@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// PathSyntax recognizes placeholder of path parameter in the segment of path template, e.g. "{id}" or "<id:int>".
+// It returns name of the parameter and pattern its values must match entirely, which is nil if any value matches.
+// Segments which are not placeholders are kept as is.
+type PathSyntax func(segment string) (name string, pattern *regexp.Regexp, placeholder bool, err error)
+
+var (
+	// ColonPathSyntax recognizes ":id" placeholders, which are native ones of the router.
+	ColonPathSyntax PathSyntax = func(segment string) (string, *regexp.Regexp, bool, error) {
+		if !strings.HasPrefix(segment, pathParameterPrefix) {
+			return "", nil, false, nil
+		}
+		return segment[len(pathParameterPrefix):], nil, true, nil
+	}
+
+	// BracePathSyntax recognizes "{id}" and "{id:pattern}" placeholders, e.g. "{id:[0-9]+}".
+	BracePathSyntax PathSyntax = func(segment string) (string, *regexp.Regexp, bool, error) {
+		if !strings.HasPrefix(segment, "{") || !strings.HasSuffix(segment, "}") {
+			return "", nil, false, nil
+		}
+		name, expression, typed := strings.Cut(segment[1:len(segment)-1], ":")
+		if !typed {
+			return name, nil, true, nil
+		}
+		pattern, err := regexp.Compile(expression)
+		if err != nil {
+			return "", nil, false, fmt.Errorf("invalid pattern of path parameter %q: %w", name, err)
+		}
+		return name, pattern, true, nil
+	}
+
+	// AnglePathSyntax recognizes "<id>" and "<id:type>" placeholders, where type is one of
+	// string, int, float or uuid.
+	AnglePathSyntax PathSyntax = func(segment string) (string, *regexp.Regexp, bool, error) {
+		if !strings.HasPrefix(segment, "<") || !strings.HasSuffix(segment, ">") {
+			return "", nil, false, nil
+		}
+		name, typeName, typed := strings.Cut(segment[1:len(segment)-1], ":")
+		if !typed {
+			return name, nil, true, nil
+		}
+		pattern, found := anglePathTypes[typeName]
+		if !found {
+			return "", nil, false, fmt.Errorf("unknown type %q of path parameter %q", typeName, name)
+		}
+		return name, pattern, true, nil
+	}
+)
+
+var anglePathTypes = map[string]*regexp.Regexp{
+	"string": nil,
+	"int":    regexp.MustCompile(`-?[0-9]+`),
+	"float":  regexp.MustCompile(`-?[0-9]+(\.[0-9]+)?`),
+	"uuid":   regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`),
+}
+
+// convert rewrites placeholders of the template into ":name" form and returns constraints of typed ones.
+func (syntax PathSyntax) convert(urlPathTemplate string) (string, []pathConstraint, error) {
+	segments := strings.Split(urlPathTemplate, pathSeparator)
+	var constraints []pathConstraint
+	for index, segment := range segments {
+		name, pattern, placeholder, err := syntax(segment)
+		if err != nil {
+			return "", nil, err
+		}
+		if !placeholder {
+			continue
+		}
+		if name == "" {
+			return "", nil, fmt.Errorf("path parameter without name in %q", urlPathTemplate)
+		}
+		segments[index] = pathParameterPrefix + name
+		if pattern != nil {
+			constraints = append(constraints, pathConstraint{name: name, segment: -1, pattern: pattern})
+		}
+	}
+	return strings.Join(segments, pathSeparator), constraints, nil
+}
+
+// withPathSyntax converts the template of the builder from the syntax, so parameters are resolved by native placeholders.
+func (b builder) withPathSyntax(syntax PathSyntax) Builder {
+	urlPathTemplate, constraints, err := syntax.convert(b.pathTemplate)
+	if err != nil {
+		b.addError(InvalidMappingError(err))
+		return b
+	}
+	cloned := b.clone()
+	pathParameterIndexes := pathParameterSegments(urlPathTemplate)
+	cloned.pathTemplate = urlPathTemplate
+	cloned.pathValues = pathValuesBySegments(pathParameterIndexes)
+	cloned.pathParamsAmount = len(pathParameterIndexes)
+	for _, constraint := range constraints {
+		cloned = cloned.Constraint(constraint.name, constraint.pattern).(builder)
+	}
+	return cloned
+}
+
+// PathSyntax sets syntax of placeholders in path templates of routes registered afterwards and of Unregister,
+// e.g. BracePathSyntax to register routes maintained for other frameworks. Templates are converted into ":name" form
+// reported in RouteInfo.
+func (rt *Router) PathSyntax(syntax PathSyntax) *Router {
+	rt.pathSyntax = syntax
+	return rt
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"reflect"
+	"runtime"
+	"strings"
+)
+
+// Kinds of steps of the pipeline handling requests of the endpoint, in order of execution.
+const (
+	RouterMiddlewareStep = "router middleware"
+	MiddlewareStep       = "middleware"
+	PreconditionStep     = "precondition"
+	InterceptorStep      = "interceptor"
+	BindStep             = "bind"
+	AdmissionStep        = "admission"
+	RateLimitStep        = "rate limit"
+	HandlerStep          = "handler"
+	AfterInterceptorStep = "after interceptor"
+	EncodeStep           = "encode"
+)
+
+// PipelineStep is a step of handling of requests. Name is the name of the function of the step,
+// header name of the precondition, bucket of the rate limit or media types of encoders.
+type PipelineStep struct {
+	Kind string
+	Name string
+}
+
+// Pipeline is the resolved order of steps handling requests of the route.
+type Pipeline struct {
+	Route RouteInfo
+	Steps []PipelineStep
+}
+
+// Pipeline returns steps handling requests of the endpoint in order of execution, frozen on Build.
+func (ep EndpointProcessor) Pipeline() []PipelineStep {
+	return append([]PipelineStep(nil), ep.pipeline...)
+}
+
+// Pipelines returns pipelines of registered endpoints in order of registration, including middleware of the router,
+// e.g. to verify in tests that authentication precedes rate limiting.
+func (rt *Router) Pipelines() []Pipeline {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+	routerSteps := make([]PipelineStep, 0, len(rt.middleware))
+	for _, middleware := range rt.middleware {
+		routerSteps = append(routerSteps, PipelineStep{Kind: RouterMiddlewareStep, Name: functionName(reflect.ValueOf(middleware))})
+	}
+	pipelines := make([]Pipeline, 0, len(rt.endpoints))
+	for _, endpoint := range rt.endpoints {
+		steps := append(append([]PipelineStep(nil), routerSteps...), endpoint.pipeline...)
+		pipelines = append(pipelines, Pipeline{Route: endpoint.route, Steps: steps})
+	}
+	return pipelines
+}
+
+func (b *builder) buildPipeline() []PipelineStep {
+	var steps []PipelineStep
+	for _, middleware := range b.middleware {
+		steps = append(steps, PipelineStep{Kind: MiddlewareStep, Name: functionName(reflect.ValueOf(middleware))})
+	}
+	for _, required := range b.requiredHeaders {
+		steps = append(steps, PipelineStep{Kind: PreconditionStep, Name: required.name})
+	}
+	for _, interceptorValue := range b.interceptors {
+		steps = append(steps, PipelineStep{Kind: InterceptorStep, Name: functionName(interceptorValue)})
+	}
+	steps = append(steps, PipelineStep{Kind: BindStep})
+	for _, controller := range b.admissions {
+		steps = append(steps, PipelineStep{Kind: AdmissionStep, Name: functionName(controller)})
+	}
+	for _, limit := range b.rateLimits {
+		steps = append(steps, PipelineStep{Kind: RateLimitStep, Name: limit.limiter.Name})
+	}
+	steps = append(steps, PipelineStep{Kind: HandlerStep, Name: functionName(b.serviceValue)})
+	for _, interceptorValue := range b.afterInterceptors {
+		steps = append(steps, PipelineStep{Kind: AfterInterceptorStep, Name: functionName(interceptorValue)})
+	}
+	return append(steps, PipelineStep{Kind: EncodeStep, Name: strings.Join(b.buildMetadata().Produces, ", ")})
+}
+
+// functionName returns the name of the function, closures are named after the enclosing function.
+func functionName(function reflect.Value) string {
+	if !function.IsValid() || function.Kind() != reflect.Func || function.IsNil() {
+		return ""
+	}
+	if fn := runtime.FuncForPC(function.Pointer()); fn != nil {
+		return fn.Name()
+	}
+	return ""
+}
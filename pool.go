@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"runtime/debug"
+	"sync"
+)
+
+// OverflowPolicy decides what happens with requests to endpoints of WorkerPool when its queue is full.
+type OverflowPolicy int
+
+const (
+	// RejectOverflow responds with PoolOverflowError mapped by the error mapper of the endpoint.
+	RejectOverflow OverflowPolicy = iota
+	// WaitOverflow waits for a place in the queue until the request context is done.
+	WaitOverflow
+)
+
+// PoolOverflowError is returned when the handler is not queued to the full WorkerPool.
+// It is mapped by error mapper of the endpoint with 503 Service Unavailable.
+type PoolOverflowError struct {
+	Workers int
+	Queue   int
+}
+
+func (e PoolOverflowError) Error() string {
+	return fmt.Sprintf("worker pool of %d workers has its queue of %d handlers full", e.Workers, e.Queue)
+}
+
+func (e PoolOverflowError) StatusCode() int {
+	return http.StatusServiceUnavailable
+}
+
+// ErrPoolClosed is returned for handlers of endpoints using the closed WorkerPool, e.g. for requests
+// in flight during shutdown. It is mapped by error mapper of the endpoint with 503 Service Unavailable.
+var ErrPoolClosed error = poolClosedError{}
+
+type poolClosedError struct{}
+
+func (poolClosedError) Error() string {
+	return "worker pool is closed"
+}
+
+func (poolClosedError) StatusCode() int {
+	return http.StatusServiceUnavailable
+}
+
+// WorkerPool runs handlers of endpoints assigned with Pool on a fixed amount of goroutines, so CPU-heavy
+// endpoints don't starve latency-sensitive ones. Handlers wait in the bounded queue for a free worker.
+// It is safe for concurrent use by many endpoints.
+type WorkerPool struct {
+	workers  int
+	queue    int
+	overflow OverflowPolicy
+	// slots are taken by running and queued handlers
+	slots chan struct{}
+	tasks chan func()
+	// mu guards tasks from sending after they are closed
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewWorkerPool starts workers goroutines, which run until the pool is closed.
+func NewWorkerPool(workers, queue int, overflow OverflowPolicy) *WorkerPool {
+	if workers < 1 {
+		workers = 1
+	}
+	if queue < 0 {
+		queue = 0
+	}
+	pool := &WorkerPool{
+		workers:  workers,
+		queue:    queue,
+		overflow: overflow,
+		slots:    make(chan struct{}, workers+queue),
+		tasks:    make(chan func(), workers+queue),
+	}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for task := range pool.tasks {
+				task()
+			}
+		}()
+	}
+	return pool
+}
+
+// Close stops workers once queued handlers are done. Handlers of endpoints using the pool afterwards are not run,
+// the endpoints respond with ErrPoolClosed.
+func (p *WorkerPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.closed {
+		p.closed = true
+		close(p.tasks)
+	}
+}
+
+// Pool runs the handler of the endpoint on the worker pool instead of the goroutine serving the request.
+func (b builder) Pool(pool *WorkerPool) Builder {
+	cloned := b.clone()
+	cloned.pool = pool
+	return cloned
+}
+
+// execute queues the handler and waits for its results until the context is done.
+// The handler isn't called if the context is done before a worker takes it. Panic of the handler is propagated
+// to the calling goroutine.
+func (p *WorkerPool) execute(ctx context.Context, invoke func(values []reflect.Value) []reflect.Value, values []reflect.Value, withStack bool) ([]reflect.Value, bool, error) {
+	done := make(chan []reflect.Value, 1)
+	panicked := make(chan PanicError, 1)
+	// the directory is kept until the abandoned handler returns
+	release := holdTempDirs(ctx)
+	task := func() {
+		defer release()
+		if ctx.Err() != nil {
+			<-p.slots
+			return
+		}
+		results, panicErr := callRecovering(invoke, values, withStack)
+		// the slot is free before the response, so the next request could take it
+		<-p.slots
+		if panicErr != nil {
+			panicked <- *panicErr
+			return
+		}
+		done <- results
+	}
+
+	select {
+	case p.slots <- struct{}{}:
+	default:
+		if p.overflow == RejectOverflow {
+			release()
+			return nil, false, PoolOverflowError{Workers: p.workers, Queue: p.queue}
+		}
+		select {
+		case p.slots <- struct{}{}:
+		case <-ctx.Done():
+			release()
+			return nil, false, nil
+		}
+	}
+	// doesn't block as queued tasks are limited by slots
+	p.mu.RLock()
+	if p.closed {
+		p.mu.RUnlock()
+		<-p.slots
+		release()
+		return nil, false, ErrPoolClosed
+	}
+	p.tasks <- task
+	p.mu.RUnlock()
+
+	select {
+	case results := <-done:
+		return results, true, nil
+	case panicErr := <-panicked:
+		panic(panicErr)
+	case <-ctx.Done():
+		return nil, false, nil
+	}
+}
+
+func callRecovering(invoke func(values []reflect.Value) []reflect.Value, values []reflect.Value, withStack bool) (results []reflect.Value, panicErr *PanicError) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			panicErr = &PanicError{Value: recovered}
+			if withStack {
+				panicErr.Stack = debug.Stack()
+			}
+		}
+	}()
+	return invoke(values), nil
+}
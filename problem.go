@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+const problemMediaType = "application/problem+json"
+
+// Problem is RFC 7807 problem details object.
+// Extensions are rendered as additional members of the object.
+type Problem struct {
+	Type       string
+	Title      string
+	Status     int
+	Detail     string
+	Instance   string
+	Extensions map[string]interface{}
+}
+
+func (p Problem) Error() string {
+	if p.Detail != "" {
+		return p.Title + ": " + p.Detail
+	}
+	return p.Title
+}
+
+func (p Problem) StatusCode() int {
+	return p.Status
+}
+
+func (p Problem) MarshalJSON() ([]byte, error) {
+	members := make(map[string]interface{}, len(p.Extensions)+5)
+	for name, value := range p.Extensions {
+		members[name] = value
+	}
+	members["type"] = p.Type
+	if p.Type == "" {
+		members["type"] = "about:blank"
+	}
+	if p.Title != "" {
+		members["title"] = p.Title
+	}
+	if p.Status != 0 {
+		members["status"] = p.Status
+	}
+	if p.Detail != "" {
+		members["detail"] = p.Detail
+	}
+	if p.Instance != "" {
+		members["instance"] = p.Instance
+	}
+	return json.Marshal(members)
+}
+
+// ProblemExtender is implemented by structured errors to add extension members to problem details.
+type ProblemExtender interface {
+	ProblemExtensions() map[string]interface{}
+}
+
+// ProblemOf converts error into problem details.
+// The first Problem found in the error chain is used as is, so the outermost one wins over problems it wraps.
+// Otherwise status is taken from the first StatusCoder of the chain, then derived from known error causes:
+// UnsupportedMediaType, InvalidValue and DecodeError.
+// Extensions of the first ProblemExtender in the chain override extensions of the problem.
+func ProblemOf(err error) Problem {
+	var problem Problem
+	if !errors.As(err, &problem) {
+		problem = Problem{Status: problemStatusOf(err), Detail: err.Error()}
+	}
+	if problem.Status == 0 {
+		problem.Status = http.StatusInternalServerError
+	}
+	if problem.Title == "" {
+		problem.Title = http.StatusText(problem.Status)
+	}
+
+	var extender ProblemExtender
+	if errors.As(err, &extender) {
+		extensions := make(map[string]interface{}, len(problem.Extensions))
+		for name, value := range problem.Extensions {
+			extensions[name] = value
+		}
+		for name, value := range extender.ProblemExtensions() {
+			extensions[name] = value
+		}
+		problem.Extensions = extensions
+	}
+	return problem
+}
+
+func problemStatusOf(err error) int {
+	var decodeErr DecodeError
+	switch statusCode := errorStatusCode(err, 0); {
+	case statusCode != 0:
+		return statusCode
+	case errors.Is(err, UnsupportedMediaType):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, InvalidValue), errors.As(err, &decodeErr):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+func (e DecodeError) ProblemExtensions() map[string]interface{} {
+	extensions := map[string]interface{}{"offset": e.Offset}
+	if e.Field != "" {
+		extensions["field"] = e.Field
+	}
+	return extensions
+}
+
+var ProblemErrorMapper ErrorMapper = func(err error, w http.ResponseWriter, r *http.Request) error {
+	problem := ProblemOf(err)
+	if problem.Instance == "" {
+		// the query isn't included as it may carry credentials
+		problem.Instance = r.URL.Path
+	}
+	setErrorHeaders(err, w)
+	w.Header().Set("Content-Type", problemMediaType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(problem.Status)
+	return json.NewEncoder(w).Encode(problem)
+}
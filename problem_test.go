@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type quotaError struct {
+	limit int
+}
+
+func (e quotaError) Error() string {
+	return fmt.Sprintf("quota of %d exceeded", e.limit)
+}
+
+func (e quotaError) ProblemExtensions() map[string]interface{} {
+	return map[string]interface{}{"limit": e.limit}
+}
+
+func TestProblemErrorMapper(t *testing.T) {
+	router := NewRouter().ErrorMapping(ProblemErrorMapper)
+	err := router.Register(
+		GET("/quota").Handler(func() error { return quotaError{limit: 10} }),
+		GET("/gone").Handler(func() error {
+			return fmt.Errorf("wrapped: %w", Problem{Type: "https://example.com/gone", Status: http.StatusGone, Detail: "removed"})
+		}),
+		POST("/keys").Decoder(JSONDecoder).Handler(func(key Key) {}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, toCheck := range []struct {
+		r        *http.Request
+		expected map[string]interface{}
+	}{
+		{
+			r: newGET(t, "http://localhost/quota"),
+			expected: map[string]interface{}{
+				"type": "about:blank", "title": "Internal Server Error", "status": 500.0,
+				"detail": "quota of 10 exceeded", "instance": "/quota", "limit": 10.0,
+			},
+		},
+		{
+			r: newGET(t, "http://localhost/gone?token=secret"),
+			expected: map[string]interface{}{
+				"type": "https://example.com/gone", "title": "Gone", "status": 410.0, "detail": "removed", "instance": "/gone",
+			},
+		},
+		{
+			r: newPOST(t, "http://localhost/keys", strings.NewReader(`{"Part": "x"}`)),
+			expected: map[string]interface{}{
+				"type": "about:blank", "title": "Bad Request", "status": 400.0, "instance": "/keys", "field": "Part", "offset": 12.0,
+			},
+		},
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, toCheck.r)
+		if w.Header().Get("Content-Type") != "application/problem+json" {
+			t.Error("unexpected Content-Type", w.Header().Get("Content-Type"))
+		}
+		var problem map[string]interface{}
+		if err := json.NewDecoder(w.Body).Decode(&problem); err != nil {
+			t.Fatal(err)
+		}
+		for name, value := range toCheck.expected {
+			if problem[name] != value {
+				t.Error("unexpected problem member", name, problem[name])
+			}
+		}
+		if int(problem["status"].(float64)) != w.Code {
+			t.Error("unexpected response code", w.Code)
+		}
+	}
+}
+
+func TestProblemOf(t *testing.T) {
+	problem := ProblemOf(UnsupportedMediaTypeError(errors.New("text/plain")))
+	if problem.Status != http.StatusUnsupportedMediaType || problem.Title != "Unsupported Media Type" {
+		t.Errorf("received: %#v", problem)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+type propagatedHeadersKey struct{}
+
+// PropagateHeaders injects *http.Client parameters of the handler which copy the headers of the inbound request,
+// e.g. trace IDs, authorization or tenant, into outbound requests unless they are set already.
+// The client is a copy of base with wrapped transport, http.DefaultClient is used if base is nil.
+func (b builder) PropagateHeaders(base *http.Client, headers ...string) Builder {
+	if base == nil {
+		base = http.DefaultClient
+	}
+	canonical := make([]string, len(headers))
+	for i, header := range headers {
+		canonical[i] = http.CanonicalHeaderKey(header)
+	}
+	cloned := b.ContextValue(func(ctx context.Context) (*http.Client, error) {
+		propagated, _ := ctx.Value(propagatedHeadersKey{}).(http.Header)
+		client := *base
+		client.Transport = propagatingTransport{base: base.Transport, header: propagated}
+		return &client, nil
+	}).(builder)
+	cloned.propagatedHeaders = canonical
+	return cloned
+}
+
+// propagatedHeaders returns values of the headers in the inbound request.
+func propagatedHeaders(r *http.Request, headers []string) http.Header {
+	propagated := make(http.Header, len(headers))
+	for _, header := range headers {
+		if values := r.Header.Values(header); len(values) > 0 {
+			propagated[header] = append([]string(nil), values...)
+		}
+	}
+	return propagated
+}
+
+// propagatingTransport sets the headers on outbound requests which have none of them.
+type propagatingTransport struct {
+	base   http.RoundTripper
+	header http.Header
+}
+
+func (pt propagatingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	base := pt.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	var outbound *http.Request
+	for header, values := range pt.header {
+		if _, set := r.Header[header]; set {
+			continue
+		}
+		if outbound == nil {
+			// RoundTripper must not modify the request
+			outbound = r.Clone(r.Context())
+		}
+		outbound.Header[header] = append([]string(nil), values...)
+	}
+	if outbound == nil {
+		outbound = r
+	}
+	return base.RoundTrip(outbound)
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimit is a token bucket refilled with Requests tokens per period up to Burst tokens, Requests by default.
+type RateLimit struct {
+	Requests int
+	Per      time.Duration
+	Burst    int
+}
+
+func (rl RateLimit) burst() float64 {
+	if rl.Burst > 0 {
+		return float64(rl.Burst)
+	}
+	return float64(rl.Requests)
+}
+
+// refill is amount of tokens added to the bucket during the elapsed time.
+func (rl RateLimit) refill(elapsed time.Duration) float64 {
+	return float64(rl.Requests) * float64(elapsed) / float64(rl.Per)
+}
+
+// RateLimitStore keeps token buckets by keys, e.g. in a shared database for limits of multiple instances
+// of the service. Implementations must be safe for concurrent use.
+type RateLimitStore interface {
+	// Take takes a token from the bucket of the key at the time. If the bucket is empty, it returns false
+	// and the time after which the token is available.
+	Take(ctx context.Context, key string, limit RateLimit, now time.Time) (bool, time.Duration, error)
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+	limit   RateLimit
+}
+
+// take refills the bucket at the time and takes a token if it is available.
+func (tb *tokenBucket) take(now time.Time) (bool, time.Duration) {
+	tb.tokens = math.Min(tb.limit.burst(), tb.tokens+tb.limit.refill(now.Sub(tb.updated)))
+	tb.updated = now
+	if tb.tokens < 1 {
+		return false, time.Duration((1 - tb.tokens) * float64(tb.limit.Per) / float64(tb.limit.Requests))
+	}
+	tb.tokens--
+	return true, 0
+}
+
+func (tb tokenBucket) full(now time.Time) bool {
+	return tb.tokens+tb.limit.refill(now.Sub(tb.updated)) >= tb.limit.burst()
+}
+
+// rateLimitSweepInterval is a min time between removals of full buckets from MemoryRateLimitStore, so requests
+// don't pay for scanning buckets of all clients.
+const rateLimitSweepInterval = time.Minute
+
+// MemoryRateLimitStore is RateLimitStore keeping buckets of the single instance in a map.
+// Full buckets are removed on Take once a minute.
+type MemoryRateLimitStore struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	swept   time.Time
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: map[string]*tokenBucket{}}
+}
+
+func (mrs *MemoryRateLimitStore) Take(ctx context.Context, key string, limit RateLimit, now time.Time) (bool, time.Duration, error) {
+	mrs.mu.Lock()
+	defer mrs.mu.Unlock()
+	if now.Sub(mrs.swept) >= rateLimitSweepInterval || now.Before(mrs.swept) {
+		mrs.swept = now
+		for stored, bucket := range mrs.buckets {
+			if stored != key && bucket.full(now) {
+				delete(mrs.buckets, stored)
+			}
+		}
+	}
+
+	bucket, found := mrs.buckets[key]
+	if !found {
+		bucket = &tokenBucket{tokens: limit.burst(), updated: now}
+		mrs.buckets[key] = bucket
+	}
+	bucket.limit = limit
+	taken, retryAfter := bucket.take(now)
+	return taken, retryAfter, nil
+}
+
+// RateLimiter is a named bucket of requests in the store, endpoints limited by the same name share it,
+// e.g. all write endpoints limited by "writes".
+type RateLimiter struct {
+	Name  string
+	Limit RateLimit
+	Store RateLimitStore
+}
+
+// RateLimitError is returned for requests exceeding the rate limit, it results into 429 status code
+// with Retry-After header.
+type RateLimitError struct {
+	Bucket     string
+	Key        string
+	RetryAfter time.Duration
+}
+
+func (e RateLimitError) Error() string {
+	return fmt.Sprintf("rate limit %s exceeded, retry after %s", e.Bucket, e.RetryAfter)
+}
+
+func (e RateLimitError) StatusCode() int {
+	return http.StatusTooManyRequests
+}
+
+func (e RateLimitError) Headers() http.Header {
+	return http.Header{"Retry-After": {strconv.FormatInt(seconds(e.RetryAfter), 10)}}
+}
+
+type rateLimit struct {
+	limiter RateLimiter
+	key     reflect.Value
+}
+
+// RateLimit limits requests of the endpoint with the limiter after the request is bound and admitted.
+// Key splits the bucket, e.g. per account, it is either nil, func(*http.Request) string
+// or func(T) string of the bound handler parameter of type T.
+func (b builder) RateLimit(limiter RateLimiter, key interface{}) Builder {
+	keyValue := reflect.ValueOf(key)
+	if key != nil {
+		keyType := keyValue.Type()
+		if keyType.Kind() != reflect.Func || keyType.NumIn() != 1 || keyType.NumOut() != 1 || keyType.Out(0).Kind() != reflect.String {
+			b.addError(InvalidMappingError(fmt.Errorf("rate limit key %T is not func(T) string", key)))
+			return b
+		}
+	}
+	if limiter.Store == nil || limiter.Limit.Requests <= 0 || limiter.Limit.Per <= 0 {
+		b.addError(InvalidMappingError(fmt.Errorf("rate limiter %q has no store or positive limit", limiter.Name)))
+		return b
+	}
+	cloned := b.clone()
+	rateLimits := make([]rateLimit, len(cloned.rateLimits), len(cloned.rateLimits)+1)
+	copy(rateLimits, cloned.rateLimits)
+	cloned.rateLimits = append(rateLimits, rateLimit{limiter: limiter, key: keyValue})
+	return cloned
+}
+
+// buildRateLimit binds keys of rate limits to the request or the handler parameters of their types.
+func (b *builder) buildRateLimit() func(r *http.Request, values []reflect.Value, now time.Time) error {
+	if len(b.rateLimits) == 0 {
+		return nil
+	}
+	type boundLimit struct {
+		rateLimit
+		parameter int
+	}
+	var limits []boundLimit
+	serviceType := b.serviceValue.Type()
+	for _, limit := range b.rateLimits {
+		bound := boundLimit{rateLimit: limit, parameter: -1}
+		if limit.key.IsValid() && limit.key.Type().In(0) != requestType {
+			parameterType := limit.key.Type().In(0)
+			for i := 0; i < serviceType.NumIn() && bound.parameter < 0; i++ {
+				if serviceType.In(i) == parameterType {
+					bound.parameter = i
+				}
+			}
+			if bound.parameter < 0 {
+				b.addError(InvalidMappingError(fmt.Errorf("handler has no parameter of type %s of rate limit key", parameterType)))
+				continue
+			}
+		}
+		limits = append(limits, bound)
+	}
+
+	return func(r *http.Request, values []reflect.Value, now time.Time) error {
+		for _, limit := range limits {
+			var key string
+			switch {
+			case limit.parameter >= 0:
+				key = limit.key.Call([]reflect.Value{values[limit.parameter]})[0].String()
+			case limit.key.IsValid():
+				key = limit.key.Call([]reflect.Value{reflect.ValueOf(r)})[0].String()
+			}
+			taken, retryAfter, err := limit.limiter.Store.Take(r.Context(), limit.limiter.Name+":"+key, limit.limiter.Limit, now)
+			if err != nil {
+				return err
+			}
+			if !taken {
+				return RateLimitError{Bucket: limit.limiter.Name, Key: key, RetryAfter: retryAfter}
+			}
+		}
+		return nil
+	}
+}
+
+// ClientIP is a rate limit key of the address of the client connection, proxies should set
+// RemoteAddr of requests from forwarding headers they trust before the router.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// HeaderKey returns rate limit key of the header value, e.g. of API key.
+func HeaderKey(name string) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		return r.Header.Get(name)
+	}
+}
+
+// Middleware limits requests before they are routed with the bucket split by the key, e.g. ClientIP,
+// for Router.Wrap or Wrap of endpoints. Requests exceeding the limit get 429 Too Many Requests with Retry-After.
+// The clock and the error mapper of the router serving the request are used, SystemClock and DefaultErrorMapper
+// without router.
+func (rl RateLimiter) Middleware(key func(r *http.Request) string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			bucketKey := key(r)
+			taken, retryAfter, err := rl.Store.Take(r.Context(), rl.Name+":"+bucketKey, rl.Limit, nowOf(r.Context()))
+			if err == nil && !taken {
+				err = RateLimitError{Bucket: rl.Name, Key: bucketKey, RetryAfter: retryAfter}
+			}
+			if err != nil {
+				mapRoutedError(err, w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RateLimit limits requests of each endpoint registered afterwards with own bucket of the limiter,
+// named after the limiter and the route. Key splits buckets like in RateLimit of the endpoint.
+func (rt *Router) RateLimit(limiter RateLimiter, key interface{}) *Router {
+	rt.rateLimits = append(append([]routeRateLimit(nil), rt.rateLimits...), routeRateLimit{limiter: limiter, key: key})
+	return rt
+}
+
+type routeRateLimit struct {
+	limiter RateLimiter
+	key     interface{}
+}
+
+// forRoute returns the limiter with the bucket of the route.
+func (rrl routeRateLimit) forRoute(route RouteInfo) RateLimiter {
+	limiter := rrl.limiter
+	limiter.Name += ":" + route.Method + " " + route.Template
+	return limiter
+}
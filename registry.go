@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+const PublishStage = "publish"
+
+// ServiceRegistry is implemented by clients of service registries or API gateways, e.g. Consul or Eureka ones.
+// Register is called again with the same service ID on every change of routes, so it must update the registration.
+type ServiceRegistry interface {
+	Register(ctx context.Context, service ServiceRegistration) error
+	Deregister(ctx context.Context, service ServiceRegistration) error
+}
+
+// ServiceRegistration describes the service published in the registry.
+type ServiceRegistration struct {
+	ID      string
+	Name    string
+	Address string
+	// HealthPath is a path of the endpoint checked by the registry, it is omitted if empty.
+	HealthPath string
+	// Routes are routes of the router with their tags, set on publishing.
+	Routes []RouteInfo
+}
+
+// Publish registers the service with routes of the router in the registry and updates it when routes
+// are added or removed, errors of updates are reported to OnError hook with PublishStage.
+// Returned function stops updates and deregisters the service, it is meant to be called on shutdown.
+func (rt *Router) Publish(ctx context.Context, registry ServiceRegistry, service ServiceRegistration) (func(ctx context.Context) error, error) {
+	var mu sync.Mutex
+	stopped := false
+	register := func(ctx context.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if stopped {
+			return nil
+		}
+		service.Routes = rt.Routes()
+		return registry.Register(ctx, service)
+	}
+
+	unsubscribe := rt.Subscribe(func(event RouteEvent) {
+		if err := register(context.Background()); err != nil {
+			rt.snapshot().hooks.error(event.Route, nil, PublishStage, err)
+		}
+	})
+	if err := register(ctx); err != nil {
+		unsubscribe()
+		return nil, err
+	}
+
+	return func(ctx context.Context) error {
+		unsubscribe()
+		mu.Lock()
+		defer mu.Unlock()
+		stopped = true
+		return registry.Deregister(ctx, service)
+	}, nil
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// Response combines body, status code, headers and cookies of the response into a single handler return value.
+// Zero Status is sent as 200 OK or 204 No Content if Body is nil, nil Headers and Cookies are not sent.
+// Body is not sent with statuses which don't allow it, e.g. 204 No Content.
+//
+// Handlers could return own structs instead, with fields tagged as parts of the response:
+//
+//	type KeyResponse struct {
+//		Key     Key            `feel:"body"`
+//		Status  int            `feel:"status"`
+//		Version string         `feel:"header:X-Version"`
+//		Links   []string       `feel:"header:Link"`
+//		Headers http.Header    `feel:"headers"`
+//		Cookies []*http.Cookie `feel:"cookies"`
+//		Trace   string         `feel:"-"`
+//	}
+//
+// Header fields are strings or slices of strings, empty ones are not sent. Zero status is sent like the one
+// of Response. Fields are validated on build, exported fields without the tag are rejected.
+type Response[T any] struct {
+	Body    T
+	Status  int
+	Headers http.Header
+	Cookies []*http.Cookie
+}
+
+func (Response[T]) responseWrapper() {}
+
+// Created returns response with 201 Created status and Location header of the created entity.
+func Created[T any](location string, body T) Response[T] {
+	return Response[T]{Body: body, Status: http.StatusCreated, Headers: http.Header{"Location": {location}}}
+}
+
+// NoContent returns response with 204 No Content status, e.g. as a result of a handler returning
+// Response[T] when there is nothing to send.
+func NoContent[T any]() Response[T] {
+	return Response[T]{Status: http.StatusNoContent}
+}
+
+// Redirect is a handler return value sent as redirection to URL in Location header without body.
+// Zero Status is sent as 302 Found, others should be 301, 303, 307 or 308.
+type Redirect struct {
+	URL    string
+	Status int
+}
+
+// redirectFields are types of Redirect values unpacked in the order of response parameters.
+var redirectFields = [...]reflect.Type{httpStatusType, headersType}
+
+func (rd Redirect) unpack() []reflect.Value {
+	status := rd.Status
+	if status == 0 {
+		status = http.StatusFound
+	}
+	return []reflect.Value{reflect.ValueOf(status), reflect.ValueOf(http.Header{"Location": {rd.URL}})}
+}
+
+// responseTag is the struct tag of fields of tagged responses described by Response.
+const responseTag = "feel"
+
+// taggedResponse is a struct returned by the handler with fields tagged by responseTag.
+// Indexes of absent fields are -1.
+type taggedResponse struct {
+	body    int
+	status  int
+	headers int
+	header  []taggedHeader
+	cookies int
+}
+
+type taggedHeader struct {
+	name  string
+	field int
+}
+
+// isTaggedResponse reports if any field of the struct type is tagged by responseTag.
+func isTaggedResponse(t reflect.Type) bool {
+	if t.Kind() != reflect.Struct {
+		return false
+	}
+	for i := 0; i < t.NumField(); i++ {
+		if _, tagged := t.Field(i).Tag.Lookup(responseTag); tagged {
+			return true
+		}
+	}
+	return false
+}
+
+func newTaggedResponse(t reflect.Type) (taggedResponse, error) {
+	tagged := taggedResponse{body: -1, status: -1, headers: -1, cookies: -1}
+	assign := func(index *int, field reflect.StructField, fieldType reflect.Type) error {
+		if *index >= 0 {
+			return fmt.Errorf("field %s of %s repeats tag %q", field.Name, t, field.Tag.Get(responseTag))
+		}
+		if fieldType != nil && field.Type != fieldType {
+			return fmt.Errorf("field %s of %s tagged %q is not %s", field.Name, t, field.Tag.Get(responseTag), fieldType)
+		}
+		*index = field.Index[0]
+		return nil
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, found := field.Tag.Lookup(responseTag)
+		if !found || tag == "-" {
+			if !found && field.IsExported() {
+				return tagged, fmt.Errorf("field %s of %s has no %s tag", field.Name, t, responseTag)
+			}
+			continue
+		}
+		if !field.IsExported() {
+			return tagged, fmt.Errorf("field %s of %s is unexported", field.Name, t)
+		}
+		var err error
+		switch {
+		case tag == "body":
+			err = assign(&tagged.body, field, nil)
+		case tag == "status":
+			err = assign(&tagged.status, field, httpStatusType)
+		case tag == "headers":
+			err = assign(&tagged.headers, field, headersType)
+		case tag == "cookies":
+			err = assign(&tagged.cookies, field, cookiesType)
+		case strings.HasPrefix(tag, "header:"):
+			name := http.CanonicalHeaderKey(strings.TrimPrefix(tag, "header:"))
+			if name == "" {
+				return tagged, fmt.Errorf("field %s of %s has no header name", field.Name, t)
+			}
+			if field.Type.Kind() != reflect.String && (field.Type.Kind() != reflect.Slice || field.Type.Elem().Kind() != reflect.String) {
+				return tagged, fmt.Errorf("header field %s of %s is neither string nor slice of strings", field.Name, t)
+			}
+			tagged.header = append(tagged.header, taggedHeader{name: name, field: i})
+		default:
+			err = fmt.Errorf("field %s of %s has unknown tag %q", field.Name, t, tag)
+		}
+		if err != nil {
+			return tagged, err
+		}
+	}
+	if tagged.body == -1 && tagged.status == -1 && tagged.headers == -1 && len(tagged.header) == 0 && tagged.cookies == -1 {
+		return tagged, fmt.Errorf("no fields of %s are part of the response", t)
+	}
+	return tagged, nil
+}
+
+// types returns types of present parts of the response in the order of response parameters.
+func (tr taggedResponse) types(t reflect.Type) []reflect.Type {
+	var types []reflect.Type
+	if tr.body >= 0 {
+		types = append(types, t.Field(tr.body).Type)
+	}
+	if tr.status >= 0 {
+		types = append(types, httpStatusType)
+	}
+	if tr.headers >= 0 || len(tr.header) > 0 {
+		types = append(types, headersType)
+	}
+	if tr.cookies >= 0 {
+		types = append(types, cookiesType)
+	}
+	return types
+}
+
+func (tr taggedResponse) unpack(value reflect.Value) []reflect.Value {
+	var unpacked []reflect.Value
+	if tr.body >= 0 {
+		unpacked = append(unpacked, value.Field(tr.body))
+	}
+	if tr.status >= 0 {
+		status := value.Field(tr.status)
+		if status.Int() == 0 {
+			status = reflect.ValueOf(http.StatusOK)
+			if tr.body >= 0 && isNil(value.Field(tr.body)) {
+				status = reflect.ValueOf(http.StatusNoContent)
+			}
+		}
+		unpacked = append(unpacked, status)
+	}
+	if tr.headers >= 0 || len(tr.header) > 0 {
+		var header http.Header
+		if tr.headers >= 0 {
+			header = value.Field(tr.headers).Interface().(http.Header).Clone()
+		}
+		for _, tagged := range tr.header {
+			field := value.Field(tagged.field)
+			var values []string
+			if field.Kind() == reflect.String {
+				if field.Len() > 0 {
+					values = append(values, field.String())
+				}
+			} else {
+				for i := 0; i < field.Len(); i++ {
+					values = append(values, field.Index(i).String())
+				}
+			}
+			if len(values) == 0 {
+				continue
+			}
+			if header == nil {
+				header = http.Header{}
+			}
+			header[tagged.name] = append(header[tagged.name], values...)
+		}
+		unpacked = append(unpacked, reflect.ValueOf(header))
+	}
+	if tr.cookies >= 0 {
+		unpacked = append(unpacked, value.Field(tr.cookies))
+	}
+	return unpacked
+}
+
+// responseWrapper is implemented by Response of any body type.
+type responseWrapper interface {
+	responseWrapper()
+}
+
+// responseWrapperFields are fields of Response unpacked in the order of response parameters.
+var responseWrapperFields = [...]string{"Body", "Status", "Headers", "Cookies"}
+
+// buildInvoke calls the handler and unpacks returned Response, Redirect and tagged response values, so results match
+// response parameters.
+func (b *builder) buildInvoke() func(values []reflect.Value) []reflect.Value {
+	call := b.serviceValue.Call
+	wrappedResults := b.wrappedResults
+	if len(wrappedResults) == 0 {
+		return call
+	}
+
+	taggedResponses := map[int]taggedResponse{}
+	for _, i := range wrappedResults {
+		if resultType := b.serviceValue.Type().Out(i); isTaggedResponse(resultType) {
+			// validated when results are grouped
+			taggedResponses[i], _ = newTaggedResponse(resultType)
+		}
+	}
+
+	return func(values []reflect.Value) []reflect.Value {
+		results := call(values)
+		unpacked := make([]reflect.Value, 0, len(results)+len(responseWrapperFields)*len(wrappedResults))
+		next := 0
+		for i, result := range results {
+			if next >= len(wrappedResults) || wrappedResults[next] != i {
+				unpacked = append(unpacked, result)
+				continue
+			}
+			next++
+			if redirect, ok := result.Interface().(Redirect); ok {
+				unpacked = append(unpacked, redirect.unpack()...)
+				continue
+			}
+			if tagged, ok := taggedResponses[i]; ok {
+				unpacked = append(unpacked, tagged.unpack(result)...)
+				continue
+			}
+			for _, field := range responseWrapperFields {
+				value := result.FieldByName(field)
+				if field == "Status" && value.Int() == 0 {
+					value = reflect.ValueOf(http.StatusOK)
+					if isNil(result.FieldByName("Body")) {
+						value = reflect.ValueOf(http.StatusNoContent)
+					}
+				}
+				unpacked = append(unpacked, value)
+			}
+		}
+		return unpacked
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// trackingResponseWriter remembers if the response is written and calls beforeWrite just before it.
+type trackingResponseWriter struct {
+	http.ResponseWriter
+	written     bool
+	beforeWrite func(header http.Header)
+}
+
+func (trw *trackingResponseWriter) markWritten() {
+	if !trw.written && trw.beforeWrite != nil {
+		trw.beforeWrite(trw.Header())
+	}
+	trw.written = true
+}
+
+func (trw *trackingResponseWriter) WriteHeader(statusCode int) {
+	trw.markWritten()
+	trw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (trw *trackingResponseWriter) Write(data []byte) (int, error) {
+	trw.markWritten()
+	return trw.ResponseWriter.Write(data)
+}
+
+func (trw *trackingResponseWriter) Flush() {
+	trw.markWritten()
+	if flusher, ok := trw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (trw *trackingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := trw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer doesn't support hijacking")
+	}
+	trw.markWritten()
+	return hijacker.Hijack()
+}
+
+func (trw *trackingResponseWriter) Unwrap() http.ResponseWriter {
+	return trw.ResponseWriter
+}
+
+// onFirstWriteHeader calls before once the final status code is written, so headers depending on it could be set.
+// Informational 1xx status codes are passed through.
+type onFirstWriteHeader struct {
+	http.ResponseWriter
+	before  func(header http.Header, statusCode int)
+	applied bool
+}
+
+func (ofw *onFirstWriteHeader) WriteHeader(statusCode int) {
+	if !ofw.applied && statusCode >= http.StatusOK {
+		ofw.applied = true
+		ofw.before(ofw.Header(), statusCode)
+	}
+	ofw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (ofw *onFirstWriteHeader) Write(data []byte) (int, error) {
+	if !ofw.applied {
+		ofw.WriteHeader(http.StatusOK)
+	}
+	return ofw.ResponseWriter.Write(data)
+}
+
+func (ofw *onFirstWriteHeader) Flush() {
+	if !ofw.applied {
+		ofw.WriteHeader(http.StatusOK)
+	}
+	if flusher, ok := ofw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (ofw *onFirstWriteHeader) Unwrap() http.ResponseWriter {
+	return ofw.ResponseWriter
+}
+
+// bufferedResponseWriter keeps the response up to the limit of body bytes, so it could be discarded
+// on late errors or sent with Content-Length. Bigger responses are streamed once the limit is exceeded.
+type bufferedResponseWriter struct {
+	target     http.ResponseWriter
+	header     http.Header
+	statusCode int
+	body       []byte
+	limit      int
+	flushed    bool
+}
+
+func newBufferedResponseWriter(w http.ResponseWriter, limit int) *bufferedResponseWriter {
+	return &bufferedResponseWriter{target: w, header: w.Header().Clone(), limit: limit}
+}
+
+func (brw *bufferedResponseWriter) Header() http.Header {
+	if brw.flushed {
+		return brw.target.Header()
+	}
+	return brw.header
+}
+
+// WriteHeader could change status code until the response is flushed.
+func (brw *bufferedResponseWriter) WriteHeader(statusCode int) {
+	if brw.flushed {
+		return
+	}
+	brw.statusCode = statusCode
+}
+
+func (brw *bufferedResponseWriter) Write(data []byte) (int, error) {
+	if brw.statusCode == 0 {
+		brw.WriteHeader(http.StatusOK)
+	}
+	if brw.flushed {
+		return brw.target.Write(data)
+	}
+	if len(brw.body)+len(data) <= brw.limit {
+		brw.body = append(brw.body, data...)
+		return len(data), nil
+	}
+	if err := brw.flush(false); err != nil {
+		return 0, err
+	}
+	return brw.target.Write(data)
+}
+
+// commit sends buffered response with Content-Length unless it is already streamed.
+func (brw *bufferedResponseWriter) commit() error {
+	if brw.flushed {
+		return nil
+	}
+	return brw.flush(true)
+}
+
+func (brw *bufferedResponseWriter) flush(complete bool) error {
+	brw.flushed = true
+	header := brw.target.Header()
+	for name := range header {
+		delete(header, name)
+	}
+	for name, values := range brw.header {
+		header[name] = values
+	}
+	if brw.statusCode == 0 {
+		brw.statusCode = http.StatusOK
+	}
+	if complete && header.Get("Content-Length") == "" && bodyAllowed(brw.statusCode) {
+		header.Set("Content-Length", strconv.Itoa(len(brw.body)))
+	}
+	if complete && len(brw.body) == 0 || !bodyAllowed(brw.statusCode) {
+		header.Del("Content-Type")
+	}
+	brw.target.WriteHeader(brw.statusCode)
+	if len(brw.body) == 0 {
+		return nil
+	}
+	_, err := brw.target.Write(brw.body)
+	brw.body = nil
+	return err
+}
+
+func (brw *bufferedResponseWriter) Unwrap() http.ResponseWriter {
+	return brw.target
+}
+
+func bodyAllowed(statusCode int) bool {
+	return statusCode >= http.StatusOK && statusCode != http.StatusNoContent && statusCode != http.StatusNotModified
+}
+
+// deferredResponseWriter holds the status code until the body is written or flushed,
+// so the response could still be replaced if encoding fails before it writes anything.
+type deferredResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+	committed  bool
+}
+
+func (drw *deferredResponseWriter) WriteHeader(statusCode int) {
+	if drw.committed || drw.statusCode != 0 {
+		return
+	}
+	drw.statusCode = statusCode
+}
+
+func (drw *deferredResponseWriter) Write(data []byte) (int, error) {
+	drw.commit()
+	return drw.ResponseWriter.Write(data)
+}
+
+func (drw *deferredResponseWriter) Flush() {
+	drw.commit()
+	if flusher, ok := drw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (drw *deferredResponseWriter) commit() {
+	if drw.committed {
+		return
+	}
+	drw.committed = true
+	if drw.statusCode != 0 && !bodyAllowed(drw.statusCode) {
+		drw.Header().Del("Content-Type")
+	}
+	if drw.statusCode != 0 {
+		drw.ResponseWriter.WriteHeader(drw.statusCode)
+	}
+}
+
+func (drw *deferredResponseWriter) Unwrap() http.ResponseWriter {
+	return drw.ResponseWriter
+}
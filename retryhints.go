@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryHints are headers telling clients when to retry or poll, set on responses with 429 Too Many Requests,
+// 503 Service Unavailable and 202 Accepted status codes, whether they are produced by the handler or mapped from
+// errors, e.g. TimeoutError, AdmissionError or PoolOverflowError. Headers already set aren't changed, so errors
+// carrying own Retry-After take precedence. Zero durations and empty names are omitted.
+type RetryHints struct {
+	// RetryAfter is sent in Retry-After header of 429 and 503 responses.
+	RetryAfter time.Duration
+	// PollAfter is sent in Retry-After header of 202 responses, when the result of accepted work is expected.
+	PollAfter time.Duration
+	// KeepAlive is sent as timeout of Keep-Alive header, how long the idle connection is kept for the retry.
+	KeepAlive time.Duration
+	// TimeoutHeader is a name of the header with timeout of the endpoint set with Timeout, e.g. X-Request-Timeout.
+	TimeoutHeader string
+}
+
+// RetryHints sets hints of responses of the endpoint.
+func (b builder) RetryHints(hints RetryHints) Builder {
+	cloned := b.clone()
+	cloned.retryHints = &hints
+	return cloned
+}
+
+func (hints RetryHints) setHeaders(header http.Header, statusCode int, timeout time.Duration) {
+	var retryAfter time.Duration
+	switch statusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		retryAfter = hints.RetryAfter
+	case http.StatusAccepted:
+		retryAfter = hints.PollAfter
+	default:
+		return
+	}
+	setHint := func(name, value string) {
+		if header.Get(name) == "" {
+			header.Set(name, value)
+		}
+	}
+	if retryAfter > 0 {
+		setHint("Retry-After", strconv.FormatInt(seconds(retryAfter), 10))
+	}
+	if hints.KeepAlive > 0 {
+		setHint("Keep-Alive", "timeout="+strconv.FormatInt(seconds(hints.KeepAlive), 10))
+	}
+	if hints.TimeoutHeader != "" && timeout > 0 {
+		setHint(hints.TimeoutHeader, strconv.FormatInt(seconds(timeout), 10))
+	}
+}
+
+// seconds rounds the duration up to whole seconds.
+func seconds(duration time.Duration) int64 {
+	return int64((duration + time.Second - 1) / time.Second)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// RoutesConfig is a configuration file declaring routes bound to handlers registered in RouteBindings, e.g.
+//
+//	{"routes": [{"route": "GET /users/:id", "handler": "getUser", "codecs": ["application/json"], "middleware": ["auth"]}]}
+type RoutesConfig struct {
+	Routes []RouteConfig `json:"routes" yaml:"routes"`
+}
+
+// RouteConfig declares the route in form accepted by Route handled by the named handler. Codecs are media types
+// of codecs registered with RegisterCodec, middleware are names of options applied in order.
+// Disabled routes are skipped, e.g. to toggle routes per environment.
+type RouteConfig struct {
+	Route      string   `json:"route" yaml:"route"`
+	Handler    string   `json:"handler" yaml:"handler"`
+	Codecs     []string `json:"codecs,omitempty" yaml:"codecs,omitempty"`
+	Middleware []string `json:"middleware,omitempty" yaml:"middleware,omitempty"`
+	Disabled   bool     `json:"disabled,omitempty" yaml:"disabled,omitempty"`
+}
+
+// RouteBindings are handler functions and middleware referenced by names in the configuration.
+// Middleware configures the builder of the route, e.g. adds interceptors with Before.
+type RouteBindings struct {
+	Handlers   map[string]interface{}
+	Middleware map[string]func(b Builder) Builder
+}
+
+// LoadRoutes decodes RoutesConfig with the decoder, JSONDecoder if it is nil, and registers its routes.
+// Other formats like YAML are read with decoders adapting their libraries. Unknown handlers, middleware
+// or codecs fail the load before any route is registered. Observers of the router are notified with RoutesReloaded
+// after routes are registered.
+func LoadRoutes(router *Router, config io.Reader, decoder Decoder, bindings RouteBindings) error {
+	if decoder == nil {
+		decoder = JSONDecoder
+	}
+	var routesConfig RoutesConfig
+	if err := decoder.NewDecodeStream(config).Decode(&routesConfig); err != nil && err != io.EOF {
+		return err
+	}
+	builders, err := routesConfig.builders(bindings)
+	if err != nil {
+		return err
+	}
+	if err := router.Register(builders...); err != nil {
+		return err
+	}
+	router.publish(RouteEvent{Kind: RoutesReloaded})
+	return nil
+}
+
+func (rc RoutesConfig) builders(bindings RouteBindings) ([]Builder, error) {
+	var builders []Builder
+	for _, route := range rc.Routes {
+		if route.Disabled {
+			continue
+		}
+		handler, found := bindings.Handlers[route.Handler]
+		if !found {
+			return nil, InvalidMappingError(fmt.Errorf("route %q has unknown handler %q", route.Route, route.Handler))
+		}
+		for _, mediaType := range route.Codecs {
+			if _, found := LookupCodec(mediaType); !found {
+				return nil, InvalidMappingError(fmt.Errorf("route %q has unknown codec %q", route.Route, mediaType))
+			}
+		}
+		b := Route(route.Route)
+		if len(route.Codecs) > 0 {
+			b = b.CodecFor(route.Codecs...)
+		}
+		for _, name := range route.Middleware {
+			middleware, found := bindings.Middleware[name]
+			if !found {
+				return nil, InvalidMappingError(fmt.Errorf("route %q has unknown middleware %q", route.Route, name))
+			}
+			b = middleware(b)
+		}
+		builders = append(builders, b.Handler(handler))
+	}
+	return builders, nil
+}
@@ -0,0 +1,531 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Router is safe for concurrent use, routes could be registered and removed while it serves requests.
+type Router struct {
+	mu                 sync.RWMutex
+	root               routeNode
+	endpoints          []*EndpointProcessor
+	errorMapper        ErrorMapper
+	requestErrorMapper ErrorMapper
+	limits             RequestLimits
+	pathLimits         *PathParameterLimits
+	maxBodyBytes       int64
+	pathSyntax         PathSyntax
+	options            OptionsHandler
+	autoHead           bool
+	cors               *CORSConfig
+	compressor         *compressor
+	settings           atomic.Pointer[routerSettings]
+	tags               map[string]string
+	subscriptions      []*subscription
+	middleware         []func(next http.Handler) http.Handler
+	wrapped            http.Handler
+	positionalPath     PositionalPathPolicy
+	rateLimits         []routeRateLimit
+	accessLogger       AccessLogger
+	accessLogConfig    AccessLogConfig
+}
+
+// OptionsHandler responds to OPTIONS request to the path of registered routes without own OPTIONS route.
+// Allowed are methods of routes registered for the path including OPTIONS.
+type OptionsHandler func(w http.ResponseWriter, r *http.Request, allowed []string)
+
+// DefaultOptionsHandler responds with 204 No Content and Allow header.
+// Accept-Post and Accept-Patch headers list media types consumed by POST and PATCH routes of the path.
+var DefaultOptionsHandler OptionsHandler = func(w http.ResponseWriter, r *http.Request, allowed []string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	for _, metadata := range RoutesMetadataFromContext(r.Context()) {
+		if len(metadata.Consumes) == 0 {
+			continue
+		}
+		switch metadata.Method {
+		case http.MethodPost:
+			w.Header().Set("Accept-Post", strings.Join(metadata.Consumes, ", "))
+		case http.MethodPatch:
+			w.Header().Set("Accept-Patch", strings.Join(metadata.Consumes, ", "))
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func NewRouter() *Router {
+	return &Router{}
+}
+
+// ErrorMapping sets error mapper used by endpoints registered afterwards without own error mapper.
+func (rt *Router) ErrorMapping(errorMapper ErrorMapper) *Router {
+	rt.errorMapper = errorMapper
+	return rt
+}
+
+// RequestErrorMapping sets request error mapper used by endpoints registered afterwards without own one.
+func (rt *Router) RequestErrorMapping(errorMapper ErrorMapper) *Router {
+	rt.requestErrorMapper = errorMapper
+	return rt
+}
+
+// Hooks sets hooks notified about request processing stages of all endpoints of the router.
+// Requests in flight keep hooks they started with.
+func (rt *Router) Hooks(hooks Hooks) *Router {
+	rt.update(func(settings *routerSettings) { settings.hooks = hooks })
+	return rt
+}
+
+// routerSettings are applied to all endpoints of the router and could be changed while they serve requests,
+// so they are swapped atomically and each request uses the snapshot taken when it starts.
+type routerSettings struct {
+	hooks Hooks
+	clock Clock
+}
+
+// snapshot returns settings applied to endpoints of the router.
+func (rt *Router) snapshot() routerSettings {
+	if settings := rt.settings.Load(); settings != nil {
+		return *settings
+	}
+	return routerSettings{}
+}
+
+// update swaps settings of the router with the changed copy and notifies observers about rebuilt endpoints.
+func (rt *Router) update(change func(settings *routerSettings)) {
+	rt.mu.Lock()
+	updated := rt.snapshot()
+	change(&updated)
+	rt.settings.Store(&updated)
+	endpoints := append([]*EndpointProcessor(nil), rt.endpoints...)
+	rt.mu.Unlock()
+
+	rt.notify(RouteRebuilt, endpoints)
+}
+
+// Limits sets limits of the request query and headers checked before the endpoint is looked up.
+func (rt *Router) Limits(limits RequestLimits) *Router {
+	rt.limits = limits
+	return rt
+}
+
+// PathLimits sets limits of path parameters used by endpoints registered afterwards without own limits.
+func (rt *Router) PathLimits(limits PathParameterLimits) *Router {
+	rt.pathLimits = &limits
+	return rt
+}
+
+// MaxBodyBytes sets limit of the request body size of endpoints registered afterwards without own limit.
+func (rt *Router) MaxBodyBytes(n int64) *Router {
+	rt.maxBodyBytes = n
+	return rt
+}
+
+// AutoOptions enables automatic responses to OPTIONS requests with the handler, e.g. DefaultOptionsHandler
+// or the one responding to CORS preflight requests. Nil handler disables them.
+func (rt *Router) AutoOptions(handler OptionsHandler) *Router {
+	rt.options = handler
+	return rt
+}
+
+// CORS allows cross-origin requests to the routes according to the config,
+// preflight requests are answered automatically for paths of registered routes.
+func (rt *Router) CORS(config CORSConfig) *Router {
+	rt.cors = &config
+	rt.options = CORS(config)
+	return rt
+}
+
+// Tag labels routes registered afterwards with the key and value unless they have own tag with the key.
+func (rt *Router) Tag(key, value string) *Router {
+	tags := make(map[string]string, len(rt.tags)+1)
+	for registeredKey, registeredValue := range rt.tags {
+		tags[registeredKey] = registeredValue
+	}
+	tags[key] = value
+	rt.tags = tags
+	return rt
+}
+
+// Routes returns info about registered routes in order of registration.
+func (rt *Router) Routes() []RouteInfo {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+	routes := make([]RouteInfo, 0, len(rt.endpoints))
+	for _, endpoint := range rt.endpoints {
+		routes = append(routes, endpoint.route)
+	}
+	return routes
+}
+
+func (rt *Router) Register(builders ...Builder) error {
+	for _, b := range builders {
+		if defined, ok := b.(builder); ok && rt.pathSyntax != nil {
+			b = defined.withPathSyntax(rt.pathSyntax)
+		}
+		if defined, ok := b.(builder); ok && defined.errorMapper == nil && rt.errorMapper != nil {
+			b = defined.ErrorMapping(rt.errorMapper)
+		}
+		if defined, ok := b.(builder); ok && defined.requestErrorMapper == nil && rt.requestErrorMapper != nil {
+			b = defined.RequestErrorMapping(rt.requestErrorMapper)
+		}
+		if defined, ok := b.(builder); ok && defined.pathLimits == nil && rt.pathLimits != nil {
+			b = defined.PathLimits(*rt.pathLimits)
+		}
+		if defined, ok := b.(builder); ok && defined.maxBodyBytes == nil && rt.maxBodyBytes > 0 {
+			b = defined.MaxBodyBytes(rt.maxBodyBytes)
+		}
+		for key, value := range rt.tags {
+			if defined, ok := b.(builder); ok {
+				if _, found := defined.routeInfo().Tag(key); !found {
+					b = defined.Tag(key, value)
+				}
+			}
+		}
+		for _, limit := range rt.rateLimits {
+			if defined, ok := b.(builder); ok {
+				b = defined.RateLimit(limit.forRoute(defined.routeInfo()), limit.key)
+			}
+		}
+		endpoint := b.Build()
+		if len(endpoint.errors) > 0 {
+			return endpoint.errors[0]
+		}
+		if len(endpoint.positionalPath) > 0 {
+			switch rt.positionalPath {
+			case RejectPositionalPath:
+				return InvalidMappingError(fmt.Errorf("%s %s binds path parameters by position", endpoint.route.Method, endpoint.route.Template))
+			case ReportPositionalPath:
+				endpoint.issues = append(endpoint.issues, EndpointIssue{
+					Kind:    PositionalPathIssue,
+					Message: "path parameters are bound by position, bind them into struct fields tagged with path instead",
+				})
+			}
+		}
+		if err := rt.add(&endpoint); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (rt *Router) add(endpoint *EndpointProcessor) error {
+	rt.mu.Lock()
+	endpoint.settings = &rt.settings
+	if err := rt.root.insert(strings.Split(endpoint.route.Template, pathSeparator), endpoint); err != nil {
+		rt.mu.Unlock()
+		return err
+	}
+	rt.endpoints = append(rt.endpoints, endpoint)
+	rt.mu.Unlock()
+
+	rt.notify(RouteAdded, []*EndpointProcessor{endpoint})
+	return nil
+}
+
+// Unregister removes routes with the method and path template, including ones differing by query conditions
+// or constraints only. It returns info about removed routes.
+func (rt *Router) Unregister(method, urlPathTemplate string) []RouteInfo {
+	if rt.pathSyntax != nil {
+		converted, _, err := rt.pathSyntax.convert(urlPathTemplate)
+		if err != nil {
+			// routes with invalid templates couldn't be registered
+			return nil
+		}
+		urlPathTemplate = converted
+	}
+	rt.mu.Lock()
+	removed := rt.root.remove(strings.Split(urlPathTemplate, pathSeparator), method, urlPathTemplate)
+	if len(removed) > 0 {
+		endpoints := make([]*EndpointProcessor, 0, len(rt.endpoints)-len(removed))
+		for _, endpoint := range rt.endpoints {
+			if endpoint.route.Method != method || endpoint.route.Template != urlPathTemplate {
+				endpoints = append(endpoints, endpoint)
+			}
+		}
+		rt.endpoints = endpoints
+	}
+	rt.mu.Unlock()
+
+	rt.notify(RouteRemoved, removed)
+	routes := make([]RouteInfo, 0, len(removed))
+	for _, endpoint := range removed {
+		routes = append(routes, endpoint.route)
+	}
+	return routes
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// middleware and handlers use the clock and the error mapper of the router
+	r = r.WithContext(context.WithValue(r.Context(), routerKey{}, rt))
+	serve := rt.serve
+	if rt.wrapped != nil {
+		serve = rt.wrapped.ServeHTTP
+	}
+	if rt.accessLogger != nil {
+		rt.serveLogged(w, r, serve)
+		return
+	}
+	serve(w, r)
+}
+
+func (rt *Router) serve(w http.ResponseWriter, r *http.Request) {
+	if rt.compressor != nil {
+		var closeWriter func()
+		w, closeWriter = rt.compressor.wrap(w, r)
+		defer closeWriter()
+	}
+	if err := rt.limits.check(r); err != nil {
+		rt.mapError(err, w, r)
+		return
+	}
+
+	endpoint, allowed := rt.lookup(r, r.Method)
+	if rt.autoHead && containsAny(allowed, []string{http.MethodGet}) {
+		if r.Method == http.MethodHead {
+			endpoint, allowed = rt.lookup(r, http.MethodGet)
+		} else {
+			allowed = append(allowed, http.MethodHead)
+		}
+	}
+	if rt.cors != nil && (endpoint != nil || r.Method != http.MethodOptions) {
+		rt.cors.setOriginHeaders(w, r)
+	}
+	if endpoint == nil {
+		if len(allowed) > 0 && r.Method == http.MethodOptions && rt.options != nil {
+			r = r.WithContext(context.WithValue(r.Context(), routesMetadataKey{}, rt.routesMetadata(r)))
+			rt.options(w, r, append(allowed, http.MethodOptions))
+			return
+		}
+		if len(allowed) > 0 {
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		http.NotFound(w, r)
+		return
+	}
+
+	record, logged := r.Context().Value(accessLogKey{}).(*accessRecord)
+	if logged {
+		record.endpoint = endpoint
+	}
+	if err := endpoint.Handle(w, r); err != nil {
+		if logged {
+			record.err = err
+		}
+		var partialErr PartialResponseError
+		if errors.As(err, &partialErr) {
+			panic(http.ErrAbortHandler)
+		}
+		rt.mapError(err, w, r)
+	}
+}
+
+// lookup walks routes of the method matching the path with static segments preferred over parameters.
+// If nothing matches it returns methods allowed for the path.
+func (rt *Router) lookup(r *http.Request, method string) (*EndpointProcessor, []string) {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+	var found *EndpointProcessor
+	var allowed []string
+	methodMatched := false
+	queryValues := r.URL.Query()
+	segments := strings.Split(r.URL.EscapedPath(), pathSeparator)
+	rt.root.walk(segments, func(node *routeNode) bool {
+		var methods []string
+		var pathMatched bool
+		found, methods, pathMatched = node.endpointFor(method, segments, queryValues)
+		if found != nil {
+			return true
+		}
+		if !pathMatched {
+			return false
+		}
+		if len(methods) == 0 {
+			methodMatched = true
+		}
+		if allowed == nil {
+			allowed = methods
+		}
+		return false
+	})
+	if found == nil && methodMatched {
+		return nil, nil
+	}
+	return found, allowed
+}
+
+// mapRoutedError maps the error by the router serving the request, by DefaultErrorMapper without router.
+func mapRoutedError(err error, w http.ResponseWriter, r *http.Request) {
+	if rt, routed := r.Context().Value(routerKey{}).(*Router); routed {
+		rt.mapError(err, w, r)
+		return
+	}
+	DefaultErrorMapper(err, w, r)
+}
+
+func (rt *Router) mapError(err error, w http.ResponseWriter, r *http.Request) {
+	if rt.errorMapper != nil {
+		rt.errorMapper(err, w, r)
+		return
+	}
+	DefaultErrorMapper(err, w, r)
+}
+
+// routeNode is a node of the trie of path template segments shared by all routes of the router.
+type routeNode struct {
+	static    map[string]*routeNode
+	parameter *routeNode
+	endpoints []*EndpointProcessor
+}
+
+func (n *routeNode) insert(segments []string, endpoint *EndpointProcessor) error {
+	node := n
+	for _, segment := range segments {
+		if strings.HasPrefix(segment, pathParameterPrefix) {
+			if node.parameter == nil {
+				node.parameter = &routeNode{}
+			}
+			node = node.parameter
+			continue
+		}
+		if node.static == nil {
+			node.static = map[string]*routeNode{}
+		}
+		child, found := node.static[segment]
+		if !found {
+			child = &routeNode{}
+			node.static[segment] = child
+		}
+		node = child
+	}
+
+	for _, registered := range node.endpoints {
+		if registered.route.Method == endpoint.route.Method && sameQueryConditions(registered.queryConditions, endpoint.queryConditions) &&
+			sameConstraints(registered.constraints, endpoint.constraints) {
+			return InvalidMappingError(fmt.Errorf("route %s %s conflicts with %s %s", endpoint.route.Method, endpoint.route.Template, registered.route.Method, registered.route.Template))
+		}
+	}
+	node.endpoints = append(node.endpoints, endpoint)
+	return nil
+}
+
+// remove detaches endpoints with the method and template from the node of the segments.
+func (n *routeNode) remove(segments []string, method, urlPathTemplate string) []*EndpointProcessor {
+	node := n
+	for _, segment := range segments {
+		if strings.HasPrefix(segment, pathParameterPrefix) {
+			node = node.parameter
+		} else {
+			node = node.static[segment]
+		}
+		if node == nil {
+			return nil
+		}
+	}
+
+	var removed []*EndpointProcessor
+	endpoints := make([]*EndpointProcessor, 0, len(node.endpoints))
+	for _, endpoint := range node.endpoints {
+		if endpoint.route.Method == method && endpoint.route.Template == urlPathTemplate {
+			removed = append(removed, endpoint)
+			continue
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+	node.endpoints = endpoints
+	return removed
+}
+
+func sameQueryConditions(a, b url.Values) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return len(a) == len(b)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// walk visits nodes with endpoints matching path segments until visit returns true.
+func (n *routeNode) walk(segments []string, visit func(node *routeNode) bool) bool {
+	if len(segments) == 0 {
+		return len(n.endpoints) > 0 && visit(n)
+	}
+
+	if n.static != nil {
+		segment := segments[0]
+		if unescaped, err := url.PathUnescape(segment); err == nil {
+			segment = unescaped
+		}
+		if child, found := n.static[segment]; found && child.walk(segments[1:], visit) {
+			return true
+		}
+	}
+	return n.parameter != nil && segments[0] != "" && n.parameter.walk(segments[1:], visit)
+}
+
+// routesMetadata returns metadata of routes of the first node matching the path.
+func (rt *Router) routesMetadata(r *http.Request) []RouteMetadata {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+	var metadata []RouteMetadata
+	segments := strings.Split(r.URL.EscapedPath(), pathSeparator)
+	rt.root.walk(segments, func(node *routeNode) bool {
+		for _, endpoint := range node.endpoints {
+			if matchesConstraints(endpoint.constraints, segments) {
+				metadata = append(metadata, endpoint.metadata)
+			}
+		}
+		return len(metadata) > 0
+	})
+	return metadata
+}
+
+// endpointFor prefers endpoints with matched query conditions over unconditional ones with the same method.
+// Endpoints with path constraints not matching the segments are skipped.
+// If nothing matches it returns methods of endpoints of the node which differ from requested one
+// and whether any endpoint matched the path.
+func (n *routeNode) endpointFor(method string, segments []string, queryValues url.Values) (*EndpointProcessor, []string, bool) {
+	// endpoints matching query conditions are preferred, then constrained ones, then the first registered
+	var matched *EndpointProcessor
+	matchedRank := -1
+	var methods []string
+	methodMatched := false
+	pathMatched := false
+	for _, endpoint := range n.endpoints {
+		if !matchesConstraints(endpoint.constraints, segments) {
+			continue
+		}
+		pathMatched = true
+		if endpoint.route.Method != method {
+			if !containsAny(methods, []string{endpoint.route.Method}) {
+				methods = append(methods, endpoint.route.Method)
+			}
+			continue
+		}
+		methodMatched = true
+		rank := 0
+		if len(endpoint.queryConditions) > 0 {
+			if !endpoint.matchesQuery(queryValues) {
+				continue
+			}
+			rank += 2
+		}
+		if len(endpoint.constraints) > 0 {
+			rank++
+		}
+		if rank > matchedRank {
+			matched, matchedRank = endpoint, rank
+		}
+	}
+	if methodMatched {
+		return matched, nil, true
+	}
+	return nil, methods, pathMatched
+}
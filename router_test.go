@@ -0,0 +1,1739 @@
+package main
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRouterWhenQuery(t *testing.T) {
+	router := NewRouter()
+	err := router.Register(
+		GET("/reports/:id").Handler(func(id string) int { return http.StatusOK }),
+		GET("/reports/:id").WhenQuery("action", "export").Handler(func(id string) int { return http.StatusAccepted }),
+		GET("/reports/:id").WhenQuery("preview").Handler(func(id string) int { return http.StatusPartialContent }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, toCheck := range []struct {
+		method   string
+		url      string
+		expected int
+	}{
+		{method: http.MethodGet, url: "http://localhost/reports/1", expected: http.StatusOK},
+		{method: http.MethodGet, url: "http://localhost/reports/1?action=import", expected: http.StatusOK},
+		{method: http.MethodGet, url: "http://localhost/reports/1?action=export", expected: http.StatusAccepted},
+		{method: http.MethodGet, url: "http://localhost/reports/1?preview", expected: http.StatusPartialContent},
+		{method: http.MethodGet, url: "http://localhost/reports", expected: http.StatusNotFound},
+		{method: http.MethodPost, url: "http://localhost/reports/1", expected: http.StatusMethodNotAllowed},
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, newRequest(t, toCheck.method, toCheck.url, nil))
+		if w.Code != toCheck.expected {
+			t.Error(toCheck.method, toCheck.url, "unexpected response code", w.Code)
+		}
+	}
+}
+
+func TestRouterHooks(t *testing.T) {
+	var stages []string
+	router := NewRouter().Hooks(Hooks{
+		OnBind: func(event BindEvent) {
+			stages = append(stages, "bind")
+			if event.Err != nil {
+				return
+			}
+			if _, ok := event.Values[0].(int8); len(event.Values) != 1 || !ok {
+				t.Errorf("received: %#v", event.Values)
+			}
+		},
+		OnInvoke: func(event InvokeEvent) {
+			stages = append(stages, "invoke")
+			if event.Route.Template != "/items/:id" || len(event.Results) != 2 {
+				t.Errorf("received: %#v", event)
+			}
+		},
+		OnEncode: func(event EncodeEvent) {
+			stages = append(stages, "encode")
+		},
+		OnError: func(event ErrorEvent) {
+			stages = append(stages, "error:"+event.Stage)
+		},
+	})
+	err := router.Register(GET("/items/:id").Handler(func(id int8) (int, error) {
+		if id == 0 {
+			return 0, errors.New("zero")
+		}
+		return http.StatusNoContent, nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, toCheck := range []struct {
+		url      string
+		expected []string
+	}{
+		{url: "http://localhost/items/7", expected: []string{"bind", "invoke", "encode"}},
+		{url: "http://localhost/items/0", expected: []string{"bind", "invoke", "error:invoke", "encode"}},
+		{url: "http://localhost/items/x", expected: []string{"bind", "error:bind"}},
+	} {
+		stages = nil
+		router.ServeHTTP(httptest.NewRecorder(), newGET(t, toCheck.url))
+		if !reflect.DeepEqual(stages, toCheck.expected) {
+			t.Error(toCheck.url, "unexpected stages", stages)
+		}
+	}
+}
+
+func TestRouterPathMatching(t *testing.T) {
+	router := NewRouter()
+	err := router.Register(
+		GET("/users/me").Handler(func() string { return "me" }),
+		GET("/users/:id").Handler(func(id string) string { return "user " + id }),
+		POST("/users/:id").Handler(func(id string) string { return "updated " + id }),
+		GET("/users/:id/posts/:post").Handler(func(id, post string) string { return id + ":" + post }),
+		DELETE("/users/me").Handler(func() string { return "deleted me" }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, toCheck := range []struct {
+		method   string
+		url      string
+		expected int
+		body     string
+	}{
+		{method: http.MethodGet, url: "http://localhost/users/me", expected: http.StatusOK, body: "me"},
+		{method: http.MethodGet, url: "http://localhost/users/7", expected: http.StatusOK, body: "user 7"},
+		{method: http.MethodPost, url: "http://localhost/users/me", expected: http.StatusOK, body: "updated me"},
+		{method: http.MethodGet, url: "http://localhost/users/a%2Fb/posts/2", expected: http.StatusOK, body: "a/b:2"},
+		{method: http.MethodGet, url: "http://localhost/users/", expected: http.StatusNotFound},
+		{method: http.MethodGet, url: "http://localhost/users/7/posts", expected: http.StatusNotFound},
+		{method: http.MethodPut, url: "http://localhost/users/7", expected: http.StatusMethodNotAllowed},
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, newRequest(t, toCheck.method, toCheck.url, nil))
+		if w.Code != toCheck.expected {
+			t.Error(toCheck.method, toCheck.url, "unexpected response code", w.Code)
+		}
+		if toCheck.body != "" && w.Body.String() != toCheck.body {
+			t.Error(toCheck.method, toCheck.url, "unexpected response body", w.Body.String())
+		}
+	}
+
+	err = router.Register(GET("/users/:name").Handler(func(name string) {}))
+	if !errors.Is(err, InvalidMapping) {
+		t.Error("conflicting route is registered", err)
+	}
+}
+
+func TestRouterLimits(t *testing.T) {
+	router := NewRouter().Limits(RequestLimits{MaxQueryLength: 20, MaxQueryParameters: 2, MaxHeaders: 2, MaxHeaderBytes: 40})
+	err := router.Register(
+		GET("/reports").Handler(func() int { return http.StatusOK }),
+		GET("/search").Limits(RequestLimits{MaxQueryParameters: 1}).RequestErrorMapping(ProblemErrorMapper).Handler(func() int { return http.StatusOK }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, toCheck := range []struct {
+		url      string
+		headers  map[string]string
+		expected int
+	}{
+		{url: "http://localhost/reports?a=1&b=2", expected: http.StatusOK},
+		{url: "http://localhost/reports?a=1&b=2&c=3", expected: http.StatusRequestURITooLong},
+		{url: "http://localhost/reports?name=0123456789abcdef", expected: http.StatusRequestURITooLong},
+		{url: "http://localhost/reports", headers: map[string]string{"A": "1", "B": "2", "C": "3"}, expected: http.StatusRequestHeaderFieldsTooLarge},
+		{url: "http://localhost/reports", headers: map[string]string{"Authorization": "0123456789abcdef0123456789abcdef"}, expected: http.StatusRequestHeaderFieldsTooLarge},
+		{url: "http://localhost/search?q=a", expected: http.StatusOK},
+		{url: "http://localhost/search?q=a&page=2", expected: http.StatusRequestURITooLong},
+		{url: "http://localhost/search?q=a&page=2&size=3", expected: http.StatusRequestURITooLong},
+	} {
+		r := newRequest(t, http.MethodGet, toCheck.url, nil)
+		for name, value := range toCheck.headers {
+			r.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != toCheck.expected {
+			t.Error(toCheck.url, toCheck.headers, "unexpected response code", w.Code)
+		}
+		if toCheck.url == "http://localhost/search?q=a&page=2" && w.Header().Get("Content-Type") != problemMediaType {
+			t.Error(toCheck.url, "limit error is not mapped by the endpoint", w.Header())
+		}
+	}
+}
+
+func TestRouterConstraints(t *testing.T) {
+	router := NewRouter()
+	err := router.Register(
+		GET("/users/:id").Constraint("id", regexp.MustCompile("[0-9]+")).Handler(func(id int) int { return http.StatusOK }),
+		GET("/users/:name").Constraint("name", regexp.MustCompile("[a-z]+")).Handler(func(name string) int { return http.StatusAccepted }),
+		GET("/orders/:id").Constraint("id", regexp.MustCompile("[0-9]+")).Handler(func(id int) int { return http.StatusOK }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, toCheck := range []struct {
+		url      string
+		expected int
+	}{
+		{url: "http://localhost/users/42", expected: http.StatusOK},
+		{url: "http://localhost/users/john", expected: http.StatusAccepted},
+		{url: "http://localhost/users/John42", expected: http.StatusNotFound},
+		{url: "http://localhost/orders/42a", expected: http.StatusNotFound},
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, newRequest(t, http.MethodGet, toCheck.url, nil))
+		if w.Code != toCheck.expected {
+			t.Error(toCheck.url, "unexpected response code", w.Code)
+		}
+	}
+
+	err = router.Register(GET("/users/:id").Constraint("id", regexp.MustCompile("[0-9]+")).Handler(func(id int) {}))
+	if !errors.Is(err, InvalidMapping) {
+		t.Error("conflicting route is registered", err)
+	}
+	err = router.Register(GET("/accounts/:id").Constraint("name", regexp.MustCompile("[a-z]+")).Handler(func(id int) {}))
+	if !errors.Is(err, InvalidMapping) {
+		t.Error("constraint of unknown parameter is accepted", err)
+	}
+}
+
+func TestRouterConstraintPrecedence(t *testing.T) {
+	constrained := GET("/users/<id:int>").Handler(func(id int) int { return http.StatusCreated })
+	unconstrained := GET("/users/<id>").Handler(func(id string) int { return http.StatusAccepted })
+	for _, builders := range [][]Builder{{constrained, unconstrained}, {unconstrained, constrained}} {
+		router := NewRouter().PathSyntax(AnglePathSyntax)
+		if err := router.Register(builders...); err != nil {
+			t.Fatal(err)
+		}
+		for url, expected := range map[string]int{
+			"http://localhost/users/123":  http.StatusCreated,
+			"http://localhost/users/john": http.StatusAccepted,
+		} {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, newGET(t, url))
+			if w.Code != expected {
+				t.Error(url, "unexpected response code", w.Code)
+			}
+		}
+	}
+
+	w := httptest.NewRecorder()
+	by := GET("/users/:id").Constraint("id", regexp.MustCompile("[0-9]+")).Handler(func(id int) {})
+	if err := by.Build().Handle(w, newGET(t, "http://localhost/users/john")); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusNotFound {
+		t.Error("constraint is not checked by endpoint", w.Code)
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	router := NewRouter()
+	err := router.Register(Routes(map[string]interface{}{
+		"GET /users/:id": func(id int) int { return http.StatusOK },
+		"post  /users":   func() int { return http.StatusCreated },
+	})...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, toCheck := range []struct {
+		method   string
+		url      string
+		expected int
+	}{
+		{method: http.MethodGet, url: "http://localhost/users/1", expected: http.StatusOK},
+		{method: http.MethodPost, url: "http://localhost/users", expected: http.StatusCreated},
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, newRequest(t, toCheck.method, toCheck.url, nil))
+		if w.Code != toCheck.expected {
+			t.Error(toCheck.method, toCheck.url, "unexpected response code", w.Code)
+		}
+	}
+
+	err = router.Register(Route("/users").Handler(func() {}))
+	if !errors.Is(err, InvalidMapping) {
+		t.Error("route without method is registered", err)
+	}
+}
+
+func TestRouterAutoOptions(t *testing.T) {
+	router := NewRouter()
+	err := router.Register(
+		GET("/reports/:id").Handler(func(id string) {}),
+		GET("/reports/:id").WhenQuery("preview").Handler(func(id string) {}),
+		DELETE("/reports/:id").Handler(func(id string) {}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, newRequest(t, http.MethodOptions, "http://localhost/reports/1", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Error("unexpected response code without auto options", w.Code)
+	}
+
+	router.AutoOptions(DefaultOptionsHandler)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, newRequest(t, http.MethodOptions, "http://localhost/reports/1", nil))
+	if w.Code != http.StatusNoContent || w.Header().Get("Allow") != "GET, DELETE, OPTIONS" {
+		t.Error("unexpected response", w.Code, w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, newRequest(t, http.MethodOptions, "http://localhost/orders/1", nil))
+	if w.Code != http.StatusNotFound {
+		t.Error("unexpected response code for unknown path", w.Code)
+	}
+}
+
+func TestRouterCORS(t *testing.T) {
+	router := NewRouter().CORS(CORSConfig{
+		AllowedOrigins:   []string{"https://example.com"},
+		ExposedHeaders:   []string{"X-Total-Count"},
+		AllowCredentials: true,
+		MaxAge:           time.Hour,
+	})
+	err := router.Register(
+		GET("/reports/:id").Handler(func(id string) {}),
+		PUT("/reports/:id").Handler(func(id string) {}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := newRequest(t, http.MethodOptions, "http://localhost/reports/1", nil)
+	r.Header.Set("Origin", "https://example.com")
+	r.Header.Set("Access-Control-Request-Method", http.MethodPut)
+	r.Header.Set("Access-Control-Request-Headers", "Content-Type")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	expected := http.Header{
+		"Access-Control-Allow-Origin":      {"https://example.com"},
+		"Access-Control-Allow-Credentials": {"true"},
+		"Access-Control-Expose-Headers":    {"X-Total-Count"},
+		"Access-Control-Allow-Methods":     {"GET, PUT, OPTIONS"},
+		"Access-Control-Allow-Headers":     {"Content-Type"},
+		"Access-Control-Max-Age":           {"3600"},
+		"Vary":                             {"Origin", "Access-Control-Request-Headers"},
+	}
+	if w.Code != http.StatusNoContent || !reflect.DeepEqual(w.Header(), expected) {
+		t.Error("unexpected preflight response", w.Code, w.Header())
+	}
+
+	r = newRequest(t, http.MethodGet, "http://localhost/reports/1", nil)
+	r.Header.Set("Origin", "https://example.com")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != "https://example.com" {
+		t.Error("unexpected response", w.Code, w.Header())
+	}
+
+	r = newRequest(t, http.MethodGet, "http://localhost/reports/1", nil)
+	r.Header.Set("Origin", "https://attacker.com")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Error("origin is allowed", w.Header())
+	}
+}
+
+func TestRouterOptionsMetadata(t *testing.T) {
+	router := NewRouter().CORS(CORSConfig{AllowedOrigins: []string{"*"}})
+	err := router.Register(
+		GET("/reports").Encoder(JSONEncoder).Handler(func() []Key { return nil }),
+		POST("/reports").
+			Decoder(JSONDecoder).
+			DecoderFor("text/csv", CSVDecoder).
+			RequireHeader("x-tenant").
+			Handler(func(keys []Key) {}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, newRequest(t, http.MethodOptions, "http://localhost/reports", nil))
+	if w.Code != http.StatusNoContent || w.Header().Get("Accept-Post") != "text/csv, application/json" {
+		t.Error("unexpected response", w.Code, w.Header())
+	}
+
+	for method, expected := range map[string]string{
+		http.MethodPost: "X-Requested-With, content-type, X-Tenant",
+		http.MethodGet:  "X-Requested-With, content-type",
+	} {
+		r := newRequest(t, http.MethodOptions, "http://localhost/reports", nil)
+		r.Header.Set("Origin", "https://example.com")
+		r.Header.Set("Access-Control-Request-Method", method)
+		r.Header.Set("Access-Control-Request-Headers", "X-Requested-With, content-type")
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Header().Get("Access-Control-Allow-Headers") != expected {
+			t.Error(method, "unexpected allowed headers", w.Header())
+		}
+	}
+
+	r := newRequest(t, http.MethodOptions, "http://localhost/reports", nil)
+	r.Header.Set("Origin", "https://example.com")
+	r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Header().Get("Access-Control-Allow-Headers") != "X-Tenant, Content-Type" {
+		t.Error("unexpected allowed headers without requested ones", w.Header())
+	}
+}
+
+func TestRouterPathSyntax(t *testing.T) {
+	for _, toCheck := range []struct {
+		syntax    PathSyntax
+		templates [2]string
+	}{
+		{syntax: BracePathSyntax, templates: [2]string{"/users/{id:[0-9]+}/notes", "/groups/{name}/notes"}},
+		{syntax: AnglePathSyntax, templates: [2]string{"/users/<id:int>/notes", "/groups/<name>/notes"}},
+	} {
+		router := NewRouter().PathSyntax(toCheck.syntax)
+		err := router.Register(
+			GET(toCheck.templates[0]).Handler(func(id int) string { return "id " + strconv.Itoa(id) }),
+			GET(toCheck.templates[1]).Handler(func(name string) string { return "name " + name }),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if routes := router.Routes(); routes[0].Template != "/users/:id/notes" || routes[1].Template != "/groups/:name/notes" {
+			t.Error("unexpected routes", routes)
+		}
+		for url, expected := range map[string]string{
+			"http://localhost/users/12/notes":   "id 12",
+			"http://localhost/users/ann/notes":  "404 page not found\n",
+			"http://localhost/groups/ann/notes": "name ann",
+		} {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, newGET(t, url))
+			if w.Body.String() != expected {
+				t.Error(url, "unexpected response body", w.Body.String())
+			}
+		}
+		if removed := router.Unregister(http.MethodGet, toCheck.templates[1]); len(removed) != 1 {
+			t.Error("route is not removed", removed)
+		}
+	}
+
+	err := NewRouter().PathSyntax(AnglePathSyntax).Register(GET("/files/<name:path>").Handler(func(name string) {}))
+	if !errors.Is(err, InvalidMapping) {
+		t.Error("unknown type of path parameter is accepted", err)
+	}
+}
+
+func TestRouterCheck(t *testing.T) {
+	digits := regexp.MustCompile(`[0-9]+`)
+	router := NewRouter()
+	err := router.Register(
+		GET("/reports/:id").Constraint("id", digits).Handler(func(id string) string { return id }),
+		GET("/reports/:name").Handler(func(name string) string { return name }),
+		GET("/orders/:id").WhenQuery("status").Handler(func(id string) string { return id }),
+		GET("/orders/:id").WhenQuery("status").Constraint("id", digits).Handler(func(id string) string { return id }),
+		GET("/keys/:id/parts/:id").Encoder(JSONEncoder).Handler(func(id, part string) Key { return Key{} }),
+		GET("/files/annual report").Handler(func() string { return "report" }),
+		GET("/health").Handler(func() string { return "ok" }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var received []string
+	for _, report := range router.Check() {
+		for _, issue := range report.Issues {
+			received = append(received, report.Route.Method+" "+report.Route.Template+": "+issue.Kind)
+		}
+	}
+	expected := []string{
+		"GET /reports/:id: shadowed",
+		"GET /orders/:id: shadowed",
+		"GET /keys/:id/parts/:id: unused path parameter",
+		"GET /files/annual report: unreachable",
+	}
+	if !reflect.DeepEqual(received, expected) {
+		t.Errorf("unexpected issues %q", received)
+	}
+}
+
+func TestRouterAutoHead(t *testing.T) {
+	router := NewRouter()
+	err := router.Register(
+		GET("/keys/:id").Encoder(JSONEncoder).Handler(func(id string) (Key, error) {
+			if id == "missing" {
+				return Key{}, Problem{Status: http.StatusNotFound}
+			}
+			return Key{Value: id}, nil
+		}),
+		GET("/events").Handler(func(w http.ResponseWriter) {
+			io.WriteString(w, "first")
+			w.(http.Flusher).Flush()
+			io.WriteString(w, "second")
+		}),
+		POST("/keys").Handler(func() {}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, newRequest(t, http.MethodHead, "http://localhost/keys/1", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Error("unexpected response code without auto HEAD", w.Code)
+	}
+
+	router.AutoHead(true)
+	for _, url := range []string{"http://localhost/keys/1", "http://localhost/keys/missing", "http://localhost/events"} {
+		expected := httptest.NewRecorder()
+		router.ServeHTTP(expected, newGET(t, url))
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, newRequest(t, http.MethodHead, url, nil))
+		if w.Code != expected.Code || w.Header().Get("Content-Length") != strconv.Itoa(expected.Body.Len()) || w.Body.Len() != 0 {
+			t.Error(url, "unexpected response", w.Code, w.Header(), w.Body.String())
+		}
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, newRequest(t, http.MethodDelete, "http://localhost/keys/1", nil))
+	if w.Header().Get("Allow") != "GET, HEAD" {
+		t.Error("unexpected allowed methods", w.Header())
+	}
+}
+
+func TestRouterEncodeFailure(t *testing.T) {
+	encoderWriting := func(partial string) Encoder {
+		return EncoderFunc(func(writer io.Writer) func(v interface{}) error {
+			return func(v interface{}) error {
+				if partial != "" {
+					io.WriteString(writer, partial)
+				}
+				return errors.New("encoding failed")
+			}
+		})
+	}
+	var events []EncodeEvent
+	router := NewRouter().Hooks(Hooks{OnEncode: func(event EncodeEvent) { events = append(events, event) }})
+	err := router.Register(
+		GET("/early").Encoder(encoderWriting("")).Handler(func() Key { return Key{} }),
+		GET("/late").Encoder(encoderWriting("partial")).Handler(func() Key { return Key{} }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, newRequest(t, http.MethodGet, "http://localhost/early", nil))
+	if w.Code != http.StatusInternalServerError || w.Body.String() != "encoding failed\n" {
+		t.Error("unexpected response", w.Code, w.Body.String())
+	}
+
+	func() {
+		defer func() {
+			if recovered := recover(); recovered != http.ErrAbortHandler {
+				t.Error("partial response is not aborted", recovered)
+			}
+		}()
+		router.ServeHTTP(httptest.NewRecorder(), newRequest(t, http.MethodGet, "http://localhost/late", nil))
+	}()
+
+	if len(events) != 2 || events[0].Partial || !events[1].Partial {
+		t.Errorf("unexpected encode events: %+v", events)
+	}
+}
+
+func TestRouterTags(t *testing.T) {
+	var routes []RouteInfo
+	router := NewRouter().
+		Tag("team", "billing").
+		Tag("tier", "gold").
+		Hooks(Hooks{OnInvoke: func(event InvokeEvent) { routes = append(routes, event.Route) }})
+	err := router.Register(
+		GET("/invoices").Tag("tier", "silver").Handler(func() {}),
+		GET("/invoices/:id").Handler(func(id string, route RouteInfo) {
+			if tier, _ := route.Tag("tier"); tier != "gold" {
+				t.Errorf("received: %#v", route.Tags())
+			}
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, url := range []string{"http://localhost/invoices", "http://localhost/invoices/1"} {
+		router.ServeHTTP(httptest.NewRecorder(), newRequest(t, http.MethodGet, url, nil))
+	}
+	if len(routes) != 2 || !reflect.DeepEqual(routes[0].Tags(), map[string]string{"team": "billing", "tier": "silver"}) {
+		t.Errorf("unexpected routes: %+v", routes)
+	}
+	if registered := router.Routes(); len(registered) != 2 || registered[1] != routes[1] {
+		t.Errorf("unexpected registered routes: %+v", registered)
+	}
+}
+
+func TestRouterRequestErrorMapping(t *testing.T) {
+	var mapped []error
+	router := NewRouter().RequestErrorMapping(func(err error, w http.ResponseWriter, r *http.Request) error {
+		mapped = append(mapped, err)
+		return DefaultRequestErrorMapper(err, w, r)
+	})
+	err := router.Register(
+		POST("/keys").Decoder(JSONDecoder).Handler(func(key Key) int { return http.StatusCreated }),
+		GET("/keys/:id").Handler(func(id int) error { return errors.New("storage failure") }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, toCheck := range []struct {
+		r        *http.Request
+		expected int
+	}{
+		{r: newPOST(t, "http://localhost/keys", strings.NewReader(`{"Value": `)), expected: http.StatusBadRequest},
+		{r: newGET(t, "http://localhost/keys/first"), expected: http.StatusBadRequest},
+		{r: newGET(t, "http://localhost/keys/1"), expected: http.StatusInternalServerError},
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, toCheck.r)
+		if w.Code != toCheck.expected {
+			t.Error(toCheck.r.URL, "unexpected response code", w.Code)
+		}
+	}
+	var requestErr RequestError
+	if len(mapped) != 2 || !errors.As(mapped[0], &requestErr) {
+		t.Error("unexpected mapped request errors", mapped)
+	}
+}
+
+func TestRouterPathLimits(t *testing.T) {
+	router := NewRouter().PathLimits(PathParameterLimits{MaxLength: 8, RejectControl: true, RejectSeparators: true})
+	err := router.Register(
+		GET("/files/:name").Handler(func(name string) string { return name }),
+		GET("/codes/:code").
+			PathLimits(PathParameterLimits{Allowed: func(r rune) bool { return r >= 'A' && r <= 'Z' }}).
+			Handler(func(code string) string { return code }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, toCheck := range []struct {
+		url      string
+		expected int
+	}{
+		{url: "http://localhost/files/a.txt", expected: http.StatusOK},
+		{url: "http://localhost/files/long-name.txt", expected: http.StatusBadRequest},
+		{url: "http://localhost/files/a%00b", expected: http.StatusBadRequest},
+		{url: "http://localhost/files/%ff", expected: http.StatusBadRequest},
+		{url: "http://localhost/files/..%2Fetc", expected: http.StatusBadRequest},
+		{url: "http://localhost/files/..", expected: http.StatusBadRequest},
+		{url: "http://localhost/codes/ABCDEFGHIJ", expected: http.StatusOK},
+		{url: "http://localhost/codes/AB1", expected: http.StatusBadRequest},
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, newGET(t, toCheck.url))
+		if w.Code != toCheck.expected {
+			t.Error(toCheck.url, "unexpected response code", w.Code)
+		}
+	}
+}
+
+func TestRouterMaxBodyBytes(t *testing.T) {
+	router := NewRouter().MaxBodyBytes(16)
+	err := router.Register(
+		POST("/keys").Decoder(JSONDecoder).Handler(func(key Key) int { return http.StatusCreated }),
+		POST("/uploads").MaxBodyBytes(0).Handler(func(body io.ReadSeeker) int { return http.StatusCreated }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, toCheck := range []struct {
+		url      string
+		body     io.Reader
+		expected int
+	}{
+		{url: "http://localhost/keys", body: strings.NewReader(`{"Value": "k"}`), expected: http.StatusCreated},
+		{url: "http://localhost/keys", body: strings.NewReader(`{"Value": "very long key"}`), expected: http.StatusRequestEntityTooLarge},
+		{url: "http://localhost/keys", body: io.MultiReader(strings.NewReader(`{"Value": "very long key"}`)), expected: http.StatusRequestEntityTooLarge},
+		{url: "http://localhost/uploads", body: strings.NewReader(`{"Value": "very long key"}`), expected: http.StatusCreated},
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, newPOST(t, toCheck.url, toCheck.body))
+		if w.Code != toCheck.expected {
+			t.Error(toCheck.url, "unexpected response code", w.Code)
+		}
+	}
+}
+
+func TestRouterSubscribe(t *testing.T) {
+	router := NewRouter()
+	var events []string
+	unsubscribe := router.Subscribe(func(event RouteEvent) {
+		events = append(events, event.Kind+" "+event.Route.Method+" "+event.Route.Template)
+	})
+	err := router.Register(
+		GET("/users/:id").Handler(func(id int) {}),
+		GET("/users/:id").WhenQuery("expand").Handler(func(id int) {}),
+		DELETE("/users/:id").Handler(func(id int) {}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed := router.Unregister(http.MethodGet, "/users/:id"); len(removed) != 2 {
+		t.Error("unexpected removed routes", removed)
+	}
+	router.Hooks(Hooks{})
+	bindings := RouteBindings{Handlers: map[string]interface{}{"list": func() {}}}
+	if err := LoadRoutes(router, strings.NewReader(`{"routes": [{"route": "GET /users", "handler": "list"}]}`), nil, bindings); err != nil {
+		t.Fatal(err)
+	}
+	unsubscribe()
+	router.Unregister(http.MethodDelete, "/users/:id")
+
+	expected := []string{
+		"added GET /users/:id", "added GET /users/:id", "added DELETE /users/:id",
+		"removed GET /users/:id", "removed GET /users/:id",
+		"rebuilt DELETE /users/:id",
+		"added GET /users", "reloaded  ",
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Error("unexpected events", events)
+	}
+	if len(router.Routes()) != 1 {
+		t.Error("routes are not removed", router.Routes())
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, newGET(t, "http://localhost/users/1"))
+	if w.Code != http.StatusNotFound {
+		t.Error("unexpected response code", w.Code)
+	}
+}
+
+func TestRouterSettingsWhileServing(t *testing.T) {
+	router := NewRouter()
+	if err := router.Register(GET("/users/:id").Handler(func(id int) {})); err != nil {
+		t.Fatal(err)
+	}
+	var invoked atomic.Int64
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			router.Hooks(Hooks{OnInvoke: func(event InvokeEvent) { invoked.Add(1) }})
+			router.Clock(SystemClock)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, newGET(t, "http://localhost/users/1"))
+		if w.Code != http.StatusOK {
+			t.Fatal("unexpected response code", w.Code)
+		}
+	}
+	<-done
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, newGET(t, "http://localhost/users/1"))
+	if invoked.Load() == 0 {
+		t.Error("hooks set while serving are not notified")
+	}
+}
+
+func TestRouterCompress(t *testing.T) {
+	large := strings.Repeat("compressible ", 100)
+	router := NewRouter().Compress(CompressionConfig{MinSize: 64})
+	err := router.Register(
+		GET("/large").Encoder(JSONEncoder).Handler(func() string { return large }),
+		GET("/small").Encoder(JSONEncoder).Handler(func() string { return "small" }),
+		GET("/image").ResponseContentType(func() string { return "image/png" }).Handler(func() []byte { return []byte(large) }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, toCheck := range []struct {
+		url            string
+		acceptEncoding string
+		encoding       string
+		vary           string
+	}{
+		{url: "http://localhost/large", acceptEncoding: "deflate, gzip;q=0.9", encoding: "deflate", vary: "Accept-Encoding"},
+		{url: "http://localhost/large", acceptEncoding: "gzip", encoding: "gzip", vary: "Accept-Encoding"},
+		{url: "http://localhost/large", acceptEncoding: "br, *;q=0.1", encoding: "gzip", vary: "Accept-Encoding"},
+		{url: "http://localhost/large", acceptEncoding: "gzip;q=0, identity", encoding: "", vary: ""},
+		{url: "http://localhost/small", acceptEncoding: "gzip", encoding: "", vary: "Accept-Encoding"},
+		{url: "http://localhost/image", acceptEncoding: "gzip", encoding: "", vary: ""},
+	} {
+		r := newGET(t, toCheck.url)
+		r.Header.Set("Accept-Encoding", toCheck.acceptEncoding)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Header().Get("Content-Encoding") != toCheck.encoding || w.Header().Get("Vary") != toCheck.vary {
+			t.Error(toCheck.url, toCheck.acceptEncoding, "unexpected headers", w.Header())
+			continue
+		}
+
+		var body io.Reader = w.Body
+		switch toCheck.encoding {
+		case "gzip":
+			if body, err = gzip.NewReader(w.Body); err != nil {
+				t.Fatal(err)
+			}
+		case "deflate":
+			body = flate.NewReader(w.Body)
+		}
+		data, err := io.ReadAll(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), "compressible") && !strings.Contains(string(data), "small") {
+			t.Error(toCheck.url, toCheck.acceptEncoding, "unexpected body", string(data))
+		}
+	}
+}
+
+type fakeRegistry struct {
+	registered   map[string]ServiceRegistration
+	deregistered []string
+	failure      error
+}
+
+func (fr *fakeRegistry) Register(ctx context.Context, service ServiceRegistration) error {
+	if fr.failure != nil {
+		return fr.failure
+	}
+	fr.registered[service.ID] = service
+	return nil
+}
+
+func (fr *fakeRegistry) Deregister(ctx context.Context, service ServiceRegistration) error {
+	delete(fr.registered, service.ID)
+	fr.deregistered = append(fr.deregistered, service.ID)
+	return nil
+}
+
+func TestRouterPublish(t *testing.T) {
+	var hookErrors []ErrorEvent
+	router := NewRouter().Hooks(Hooks{OnError: func(event ErrorEvent) { hookErrors = append(hookErrors, event) }})
+	if err := router.Register(GET("/health").Handler(func() {})); err != nil {
+		t.Fatal(err)
+	}
+
+	registry := &fakeRegistry{registered: map[string]ServiceRegistration{}}
+	deregister, err := router.Publish(context.Background(), registry, ServiceRegistration{ID: "users-1", Name: "users", HealthPath: "/health"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := router.Register(GET("/users/:id").Tag("team", "identity").Handler(func(id int) {})); err != nil {
+		t.Fatal(err)
+	}
+	routes := registry.registered["users-1"].Routes
+	if len(routes) != 2 || routes[1].Template != "/users/:id" {
+		t.Error("unexpected published routes", routes)
+	}
+	if team, _ := routes[1].Tag("team"); team != "identity" {
+		t.Error("tags are not published", routes[1].Tags())
+	}
+
+	registry.failure = errors.New("registry is unavailable")
+	router.Unregister(http.MethodGet, "/users/:id")
+	if len(hookErrors) != 1 || hookErrors[0].Stage != PublishStage || !errors.Is(hookErrors[0].Err, registry.failure) {
+		t.Error("unexpected hook errors", hookErrors)
+	}
+
+	registry.failure = nil
+	if err := deregister(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := router.Register(GET("/users").Handler(func() {})); err != nil {
+		t.Fatal(err)
+	}
+	if len(registry.registered) != 0 || !reflect.DeepEqual(registry.deregistered, []string{"users-1"}) {
+		t.Error("service is not deregistered", registry.registered, registry.deregistered)
+	}
+}
+
+type steppingClock struct {
+	now  time.Time
+	step time.Duration
+}
+
+func (sc *steppingClock) Now() time.Time {
+	sc.now = sc.now.Add(sc.step)
+	return sc.now
+}
+
+// stoppedClock is safe for concurrent use unlike steppingClock.
+type stoppedClock struct{}
+
+func (stoppedClock) Now() time.Time {
+	return time.Time{}
+}
+
+func TestRouterClock(t *testing.T) {
+	var durations []time.Duration
+	router := NewRouter().Hooks(Hooks{
+		OnBind:   func(event BindEvent) { durations = append(durations, event.Duration) },
+		OnInvoke: func(event InvokeEvent) { durations = append(durations, event.Duration) },
+		OnEncode: func(event EncodeEvent) { durations = append(durations, event.Duration) },
+	})
+	if err := router.Register(GET("/users/:id").Handler(func(id int) {})); err != nil {
+		t.Fatal(err)
+	}
+	router.Clock(&steppingClock{step: time.Second})
+
+	router.ServeHTTP(httptest.NewRecorder(), newGET(t, "http://localhost/users/1"))
+	if !reflect.DeepEqual(durations, []time.Duration{time.Second, time.Second, time.Second}) {
+		t.Error("unexpected durations", durations)
+	}
+}
+
+func TestRouterCache(t *testing.T) {
+	store := NewMemoryResponseCache()
+	values := map[string]string{"k1": "v1"}
+	var invocations int
+	router := NewRouter()
+	if err := router.Register(GET("/keys/:id").Encoder(JSONEncoder).Cache(CachePolicy{Store: store, TTL: time.Minute}).Handler(func(id string) (Key, http.Header) {
+		invocations++
+		return Key{Value: values[id]}, http.Header{"X-Id": {id}}
+	})); err != nil {
+		t.Fatal(err)
+	}
+	if err := router.Register(PUT("/keys/:id").Invalidates(store, nil).Handler(func(id string) {
+		values[id] = "v2"
+	})); err != nil {
+		t.Fatal(err)
+	}
+	clock := &steppingClock{}
+	router.Clock(clock)
+
+	get := func(expected string, expectedInvocations int) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, newGET(t, "http://localhost/keys/k1"))
+		if w.Code != http.StatusOK || w.Body.String() != `{"Value":"`+expected+`","Part":0}`+"\n" || w.Header().Get("X-Id") != "k1" {
+			t.Error("unexpected response", w.Code, w.Header(), w.Body.String())
+		}
+		if invocations != expectedInvocations {
+			t.Error("unexpected invocations", invocations, "expected", expectedInvocations)
+		}
+	}
+	get("v1", 1)
+	clock.now = clock.now.Add(30 * time.Second)
+	get("v1", 1)
+
+	router.ServeHTTP(httptest.NewRecorder(), newRequest(t, http.MethodPut, "http://localhost/keys/k1", nil))
+	get("v2", 2)
+	clock.now = clock.now.Add(time.Minute)
+	values["k1"] = "v3"
+	get("v3", 3)
+
+	w := httptest.NewRecorder()
+	r := newGET(t, "http://localhost/keys/k1")
+	r.Header.Set("Accept", "application/json")
+	router.ServeHTTP(w, r)
+	if invocations != 4 {
+		t.Error("responses to other media types are shared", invocations)
+	}
+}
+
+func TestRouterCacheSharing(t *testing.T) {
+	var invocations int
+	router := NewRouter().CORS(CORSConfig{AllowedOrigins: []string{"https://a.example", "https://b.example"}})
+	policy := CachePolicy{Store: NewMemoryResponseCache(), TTL: time.Minute}
+	err := router.Register(
+		GET("/keys/:id").Cache(policy).Handler(func(id string, r *http.Request) (string, http.Header) {
+			invocations++
+			return id + ":" + r.Header.Get("Authorization"), http.Header{"Vary": {"Accept-Language"}}
+		}),
+		GET("/private/:id").Cache(policy).Handler(func(id string) (string, http.Header) {
+			invocations++
+			return id, http.Header{"Cache-Control": {"max-age=60, private"}}
+		}),
+		GET("/volatile/:id").Cache(policy).Handler(func(id string) (string, http.Header) {
+			invocations++
+			return id, http.Header{"Cache-Control": {"No-Store"}}
+		}),
+		GET("/shared/:id").Cache(CachePolicy{Store: NewMemoryResponseCache(), TTL: time.Minute, Credentialed: true, Vary: []string{"Authorization"}}).
+			Handler(func(id string, r *http.Request) string {
+				invocations++
+				return id + ":" + r.Header.Get("Authorization")
+			}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(target, origin, authorization string) *httptest.ResponseRecorder {
+		t.Helper()
+		r := newGET(t, target)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	get("http://localhost/keys/k1", "", "Bearer alice")
+	if w := get("http://localhost/keys/k1", "", "Bearer bob"); w.Body.String() != "k1:Bearer bob" || invocations != 2 {
+		t.Error("response to credentialed request is shared", w.Body.String(), invocations)
+	}
+	get("http://localhost/keys/k1", "", "")
+	if w := get("http://localhost/keys/k1", "", "Bearer bob"); w.Body.String() != "k1:Bearer bob" || invocations != 4 {
+		t.Error("cached response is served to credentialed request", w.Body.String(), invocations)
+	}
+
+	get("http://localhost/keys/k2", "https://a.example", "")
+	w := get("http://localhost/keys/k2", "https://b.example", "")
+	if invocations != 5 || w.Header().Get("Access-Control-Allow-Origin") != "https://b.example" ||
+		!reflect.DeepEqual(w.Header()["Vary"], []string{"Origin", "Accept-Language"}) {
+		t.Error("headers of the other request are served", invocations, w.Header())
+	}
+
+	for _, target := range []string{"http://localhost/private/k1", "http://localhost/volatile/k1"} {
+		invoked := invocations
+		get(target, "", "")
+		get(target, "", "")
+		if invocations != invoked+2 {
+			t.Error("response not allowed to be shared is cached", target)
+		}
+	}
+
+	get("http://localhost/shared/k1", "", "Bearer alice")
+	get("http://localhost/shared/k1", "", "Bearer alice")
+	if w := get("http://localhost/shared/k1", "", "Bearer bob"); w.Body.String() != "k1:Bearer bob" || invocations != 11 {
+		t.Error("unexpected credentialed caching", w.Body.String(), invocations)
+	}
+}
+
+func TestRouterCacheLimits(t *testing.T) {
+	store := NewBoundedMemoryResponseCache(2, 64)
+	store.Set("a", CachedResponse{Body: []byte("1")})
+	store.Set("b", CachedResponse{Body: []byte("2")})
+	store.Get("a")
+	store.Set("c", CachedResponse{Body: []byte("3")})
+	if _, found := store.Get("b"); found {
+		t.Error("least recently used response is not evicted")
+	}
+	if _, found := store.Get("a"); !found {
+		t.Error("recently used response is evicted")
+	}
+	store.Set("d", CachedResponse{Body: []byte(strings.Repeat("4", 60))})
+	if _, found := store.Get("c"); found || store.size > 64 {
+		t.Error("responses exceeding max bytes are not evicted", store.size)
+	}
+	store.Set("e", CachedResponse{Body: []byte(strings.Repeat("5", 64))})
+	if _, found := store.Get("e"); found {
+		t.Error("response bigger than max bytes is stored")
+	}
+
+	var invocations int
+	router := NewRouter()
+	err := router.Register(GET("/reports/:id").Cache(CachePolicy{Store: NewMemoryResponseCache(), TTL: time.Minute, MaxBodyBytes: 4}).
+		Handler(func(id string) string {
+			invocations++
+			return id
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, target := range []string{"http://localhost/reports/r1", "http://localhost/reports/r1", "http://localhost/reports/longer", "http://localhost/reports/longer"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, newGET(t, target))
+		if !strings.HasSuffix(target, w.Body.String()) {
+			t.Error("unexpected body", w.Body.String())
+		}
+	}
+	if invocations != 3 {
+		t.Error("response bigger than max body bytes is cached", invocations)
+	}
+}
+
+func TestRouterEncoderFallback(t *testing.T) {
+	var events []EncoderFallbackEvent
+	router := NewRouter().Hooks(Hooks{OnEncoderFallback: func(event EncoderFallbackEvent) { events = append(events, event) }})
+	if err := router.Register(GET("/messages/:name").Encoder(ProtoEncoder).EncoderFallback(JSONEncoder).Handler(func(name string) interface{} {
+		if name == "generated" {
+			return &generatedMessage{Name: name}
+		}
+		return Key{Value: name}
+	})); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, newGET(t, "http://localhost/messages/generated"))
+	if w.Header().Get("Content-Type") != "application/x-protobuf" || w.Body.String() != "\x0a\x09" || len(events) != 0 {
+		t.Error("unexpected proto response", w.Header(), w.Body.Bytes(), events)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, newGET(t, "http://localhost/messages/plain"))
+	if w.Header().Get("Content-Type") != "application/json" || w.Body.String() != `{"Value":"plain","Part":0}`+"\n" {
+		t.Error("unexpected fallback response", w.Header(), w.Body.String())
+	}
+	if len(events) != 1 || events[0].From != "application/x-protobuf" || events[0].To != "application/json" || events[0].Err == nil {
+		t.Error("unexpected fallback events", events)
+	}
+}
+
+func TestRouterClient(t *testing.T) {
+	route := PUT("/:assortment/keys/:id").Codec(JSON)
+	router := NewRouter()
+	if err := router.Register(route.Handler(func(assortment string, id int, query url.Values, key Key) (Key, error) {
+		if id == 0 {
+			return Key{}, rateLimitedError{}
+		}
+		return Key{Value: assortment + "/" + key.Value + query.Get("suffix"), Part: int16(id)}, nil
+	})); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	var put func(ctx context.Context, assortment string, id int, key Key, query url.Values) (Key, error)
+	if err := route.Client(server.URL+"/", nil, &put); err != nil {
+		t.Fatal(err)
+	}
+	key, err := put(context.Background(), "a b", 7, Key{Value: "k"}, url.Values{"suffix": {"!"}})
+	if err != nil || key != (Key{Value: "a b/k!", Part: 7}) {
+		t.Error("unexpected result", key, err)
+	}
+
+	var clientErr ClientError
+	if _, err = put(context.Background(), "a", 0, Key{}, nil); !errors.As(err, &clientErr) || clientErr.Status != http.StatusTooManyRequests {
+		t.Error("unexpected error", err)
+	}
+
+	var invalid func(assortment string) error
+	if err := route.Client(server.URL, nil, &invalid); err == nil {
+		t.Error("function without all path parameters is accepted")
+	}
+}
+
+type keyService struct {
+	values map[string]string
+	_      struct{} `route:"GET /keys/:id" handler:"Get"`
+	_      struct{} `route:"PUT /keys/:id" handler:"Put"`
+}
+
+func (s *keyService) Get(id string) Key {
+	return Key{Value: s.values[id]}
+}
+
+func (s *keyService) Put(id string, key Key) {
+	s.values[id] = key.Value
+}
+
+func (s *keyService) Delete(id string) {
+	delete(s.values, id)
+}
+
+func (s *keyService) Routes() map[string]Builder {
+	return map[string]Builder{"Delete": DELETE("/keys/:id")}
+}
+
+func TestRegisterService(t *testing.T) {
+	service := &keyService{values: map[string]string{}}
+	router := NewRouter()
+	if err := RegisterService(router, service); err != nil {
+		t.Fatal(err)
+	}
+
+	router.ServeHTTP(httptest.NewRecorder(), newRequest(t, http.MethodPut, "http://localhost/keys/k1", strings.NewReader(`{"Value":"v1"}`)))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, newGET(t, "http://localhost/keys/k1"))
+	if w.Body.String() != `{"Value":"v1","Part":0}`+"\n" {
+		t.Error("unexpected response", w.Code, w.Body.String())
+	}
+	router.ServeHTTP(httptest.NewRecorder(), newRequest(t, http.MethodDelete, "http://localhost/keys/k1", nil))
+	if len(service.values) != 0 {
+		t.Error("route of Routes method is not registered", service.values)
+	}
+
+	if err := RegisterService(NewRouter(), struct {
+		_ struct{} `route:"GET /" handler:"Missing"`
+	}{}); err == nil {
+		t.Error("route of missing method is registered")
+	}
+}
+
+func TestLoadRoutes(t *testing.T) {
+	config := `{"routes": [
+		{"route": "GET /keys/:id", "handler": "getKey", "codecs": ["application/json"], "middleware": ["tenant"]},
+		{"route": "DELETE /keys/:id", "handler": "deleteKey", "disabled": true}
+	]}`
+	bindings := RouteBindings{
+		Handlers: map[string]interface{}{
+			"getKey":    func(id string) Key { return Key{Value: id} },
+			"deleteKey": func(id string) {},
+		},
+		Middleware: map[string]func(b Builder) Builder{
+			"tenant": func(b Builder) Builder { return b.RequireHeader("X-Tenant") },
+		},
+	}
+	router := NewRouter()
+	if err := LoadRoutes(router, strings.NewReader(config), nil, bindings); err != nil {
+		t.Fatal(err)
+	}
+
+	r := newGET(t, "http://localhost/keys/k1")
+	r.Header.Set("X-Tenant", "t1")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Body.String() != `{"Value":"k1","Part":0}`+"\n" {
+		t.Error("unexpected response", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, newGET(t, "http://localhost/keys/k1"))
+	if w.Code != http.StatusBadRequest {
+		t.Error("middleware is not applied", w.Code)
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, newRequest(t, http.MethodDelete, "http://localhost/keys/k1", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Error("disabled route is registered", w.Code)
+	}
+
+	err := LoadRoutes(NewRouter(), strings.NewReader(`{"routes": [{"route": "GET /", "handler": "missing"}]}`), nil, bindings)
+	if err == nil {
+		t.Error("route with unknown handler is loaded")
+	}
+}
+
+type tenantKey struct{}
+
+func TestRouterWrap(t *testing.T) {
+	header := func(name, value string) func(next http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Add(name, value)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	tenant := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			value := r.Header.Get("X-Tenant")
+			if value == "" {
+				http.Error(w, "no tenant", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tenantKey{}, value)))
+		})
+	}
+
+	router := NewRouter().Wrap(header("X-Order", "router"))
+	if err := router.Register(GET("/keys/:id").Wrap(tenant, header("X-Order", "endpoint")).ContextValue(ContextKey[string](tenantKey{})).Handler(func(id string, tenant string) string {
+		return tenant + "/" + id
+	})); err != nil {
+		t.Fatal(err)
+	}
+
+	r := newGET(t, "http://localhost/keys/k1")
+	r.Header.Set("X-Tenant", "t1")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Body.String() != "t1/k1" || !reflect.DeepEqual(w.Header().Values("X-Order"), []string{"router", "endpoint"}) {
+		t.Error("unexpected response", w.Code, w.Header(), w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, newGET(t, "http://localhost/keys/k1"))
+	if w.Code != http.StatusUnauthorized || !reflect.DeepEqual(w.Header().Values("X-Order"), []string{"router"}) {
+		t.Error("request is not stopped by middleware", w.Code, w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, newGET(t, "http://localhost/missing"))
+	if w.Code != http.StatusNotFound || w.Header().Get("X-Order") != "router" {
+		t.Error("unmatched request is not wrapped", w.Code, w.Header())
+	}
+}
+
+func authenticate(next http.Handler) http.Handler {
+	return next
+}
+
+func logRequests(next http.Handler) http.Handler {
+	return next
+}
+
+func TestRouterPipelines(t *testing.T) {
+	router := NewRouter().Wrap(logRequests)
+	if err := router.Register(GET("/keys/:id").Wrap(authenticate).RequireHeader("X-Tenant", http.StatusBadRequest).
+		Encoder(JSONEncoder).Before(func(headers http.Header) (principal, error) { return principal{}, nil }).
+		Handler(func(id string, user principal) (Key, error) { return Key{}, nil }).
+		After(func(key Key) Key { return key })); err != nil {
+		t.Fatal(err)
+	}
+
+	pipelines := router.Pipelines()
+	if len(pipelines) != 1 {
+		t.Fatal("unexpected pipelines", pipelines)
+	}
+	var kinds []string
+	for _, step := range pipelines[0].Steps {
+		kinds = append(kinds, step.Kind)
+	}
+	expected := []string{RouterMiddlewareStep, MiddlewareStep, PreconditionStep, InterceptorStep, BindStep, HandlerStep, AfterInterceptorStep, EncodeStep}
+	if !reflect.DeepEqual(kinds, expected) {
+		t.Error("unexpected steps", kinds)
+	}
+	steps := pipelines[0].Steps
+	if !strings.HasSuffix(steps[0].Name, "logRequests") || !strings.HasSuffix(steps[1].Name, "authenticate") ||
+		steps[2].Name != "X-Tenant" || steps[len(steps)-1].Name != "application/json" {
+		t.Error("unexpected step names", steps)
+	}
+}
+
+func TestRouterSharedRateLimit(t *testing.T) {
+	writes := RateLimiter{Name: "writes", Limit: RateLimit{Requests: 2, Per: time.Minute}, Store: NewMemoryRateLimitStore()}
+	perAccount := func(account string) string { return account }
+	clock := &steppingClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	router := NewRouter().Clock(clock)
+	if err := router.Register(
+		POST("/accounts/:account/notes").RateLimit(writes, perAccount).Handler(func(account string) {}),
+		PUT("/accounts/:account/profile").RateLimit(writes, perAccount).Handler(func(account string) {}),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	send := func(method, target string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest(method, target, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+	for i, expected := range []struct {
+		method, target string
+		status         int
+	}{
+		{http.MethodPost, "http://localhost/accounts/a/notes", http.StatusOK},
+		{http.MethodPut, "http://localhost/accounts/a/profile", http.StatusOK},
+		{http.MethodPost, "http://localhost/accounts/a/notes", http.StatusTooManyRequests},
+		{http.MethodPost, "http://localhost/accounts/b/notes", http.StatusOK},
+	} {
+		w := send(expected.method, expected.target)
+		if w.Code != expected.status {
+			t.Error(i, "unexpected status", w.Code)
+		}
+		if expected.status == http.StatusTooManyRequests && w.Header().Get("Retry-After") != "30" {
+			t.Error("unexpected Retry-After", w.Header().Get("Retry-After"))
+		}
+	}
+
+	clock.now = clock.now.Add(30 * time.Second)
+	if w := send(http.MethodPut, "http://localhost/accounts/a/profile"); w.Code != http.StatusOK {
+		t.Error("unexpected status after refill", w.Code)
+	}
+}
+
+func TestRouterPathMigrations(t *testing.T) {
+	router := NewRouter().PositionalPath(ReportPositionalPath)
+	if err := router.Register(
+		GET("/users/:user/posts/:post").Handler(func(post string, user int) string { return post }),
+		GET("/users/:user/notes/:note").Handler(func(p struct {
+			User int    `path:"user"`
+			Note string `path:"note"`
+		}) string {
+			return strconv.Itoa(p.User) + " " + p.Note
+		}),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, newGET(t, "http://localhost/users/7/notes/n%2F1"))
+	if w.Body.String() != "7 n/1" {
+		t.Error("unexpected body of named path parameters", w.Body.String())
+	}
+
+	reports := router.Check()
+	if len(reports) != 1 || reports[0].Route.Template != "/users/:user/posts/:post" || reports[0].Issues[0].Kind != PositionalPathIssue {
+		t.Error("unexpected reports", reports)
+	}
+	migrations := router.PathMigrations()
+	if len(migrations) != 1 {
+		t.Fatal("unexpected migrations", migrations)
+	}
+	migration := migrations[0]
+	if !reflect.DeepEqual(migration.Arguments, []string{"post", "user"}) || !migration.Mismatched ||
+		!strings.HasSuffix(migration.File, "router_test.go") {
+		t.Error("unexpected migration", migration)
+	}
+	if migration.Struct != "struct {\n\tUser string `path:\"user\"`\n\tPost int `path:\"post\"`\n}" || !reflect.DeepEqual(migration.Fields, []string{"User", "Post"}) {
+		t.Error("unexpected struct", migration.Struct)
+	}
+
+	strict := NewRouter().PositionalPath(RejectPositionalPath)
+	if err := strict.Register(GET("/users/:user").Handler(func(user string) {})); err == nil {
+		t.Error("expected error of positional path parameters")
+	}
+}
+
+func TestRouterRateLimit(t *testing.T) {
+	perRoute := RateLimiter{Name: "routes", Limit: RateLimit{Requests: 1, Per: time.Minute}, Store: NewMemoryRateLimitStore()}
+	perKey := RateLimiter{Name: "keys", Limit: RateLimit{Requests: 3, Per: time.Minute}, Store: NewMemoryRateLimitStore()}
+	router := NewRouter().Wrap(perKey.Middleware(HeaderKey("X-API-Key"))).RateLimit(perRoute, ClientIP)
+	if err := router.Register(GET("/a").Handler(func() {}), GET("/b").Handler(func() {})); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, expected := range []struct {
+		target, remoteAddr, key string
+		status                  int
+	}{
+		{"http://localhost/a", "10.0.0.1:1000", "k1", http.StatusOK},
+		{"http://localhost/a", "10.0.0.1:1001", "k1", http.StatusTooManyRequests},
+		{"http://localhost/b", "10.0.0.1:1002", "k1", http.StatusOK},
+		{"http://localhost/a", "10.0.0.2:1000", "k1", http.StatusTooManyRequests},
+		{"http://localhost/a", "10.0.0.2:1000", "k2", http.StatusOK},
+	} {
+		r := newGET(t, expected.target)
+		r.RemoteAddr = expected.remoteAddr
+		r.Header.Set("X-API-Key", expected.key)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != expected.status {
+			t.Error(i, "unexpected status", w.Code)
+		}
+		if w.Code == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+			t.Error(i, "no Retry-After")
+		}
+	}
+
+	clock := &steppingClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	limited := NewRouter().Clock(clock).ErrorMapping(func(err error, w http.ResponseWriter, r *http.Request) error {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return nil
+	})
+	limited.Wrap(RateLimiter{Name: "keys", Limit: RateLimit{Requests: 1, Per: time.Minute}, Store: NewMemoryRateLimitStore()}.Middleware(ClientIP))
+	if err := limited.Register(GET("/a").Handler(func() {})); err != nil {
+		t.Fatal(err)
+	}
+	for i, expected := range []int{http.StatusOK, http.StatusServiceUnavailable, http.StatusOK} {
+		w := httptest.NewRecorder()
+		limited.ServeHTTP(w, newGET(t, "http://localhost/a"))
+		if w.Code != expected {
+			t.Error(i, "unexpected status of the router clock and error mapper", w.Code)
+		}
+		if i == 1 {
+			clock.now = clock.now.Add(time.Minute)
+		}
+	}
+}
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	limit := RateLimit{Requests: 1, Per: time.Second}
+	start := time.Unix(0, 0)
+	for i := 0; i < 3; i++ {
+		if taken, _, _ := store.Take(context.Background(), strconv.Itoa(i), limit, start.Add(time.Duration(i)*time.Second)); !taken {
+			t.Error("token of new bucket is not taken", i)
+		}
+	}
+	if len(store.buckets) != 3 {
+		t.Error("full buckets are removed before the sweep interval", len(store.buckets))
+	}
+	if _, _, err := store.Take(context.Background(), "3", limit, start.Add(rateLimitSweepInterval)); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.buckets) != 1 {
+		t.Error("full buckets are not removed after the sweep interval", len(store.buckets))
+	}
+}
+
+func TestRouterAccessLog(t *testing.T) {
+	var entries []AccessEntry
+	router := NewRouter().Clock(&steppingClock{step: time.Millisecond}).AccessLog(AccessLoggerFunc(func(ctx context.Context, entry AccessEntry) {
+		entries = append(entries, entry)
+	}), AccessLogConfig{Headers: []string{"X-Request-Id", "Authorization"}, Redact: []string{"token", "authorization"}})
+	if err := router.Register(
+		POST("/keys/:id").Tag("team", "identity").Handler(func(id string, r *http.Request) (string, error) {
+			if id == "bad" {
+				return "", rateLimitedError{}
+			}
+			body, err := io.ReadAll(r.Body)
+			return string(body), err
+		}),
+		GET("/health").AccessLog(false).Handler(func() string { return "ok" }),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	r := newPOST(t, "http://localhost/keys/k1?token=secret&page=2", strings.NewReader("value"))
+	r.RemoteAddr = "10.0.0.1:5000"
+	r.Header.Set("X-Request-Id", "r1")
+	r.Header.Set("Authorization", "Bearer secret")
+	router.ServeHTTP(httptest.NewRecorder(), r)
+	if _, replaced := r.Body.(*countingReader); replaced || r.URL.Query().Get("token") != "secret" {
+		t.Error("request of the caller is changed", r.Body, r.URL)
+	}
+	router.ServeHTTP(httptest.NewRecorder(), newPOST(t, "http://localhost/keys/bad", nil))
+	router.ServeHTTP(httptest.NewRecorder(), newGET(t, "http://localhost/health"))
+	router.ServeHTTP(httptest.NewRecorder(), newGET(t, "http://localhost/missing"))
+
+	if len(entries) != 3 {
+		t.Fatal("unexpected entries", entries)
+	}
+	logged := entries[0]
+	if logged.Route != "/keys/:id" || logged.Status != http.StatusOK || logged.RequestBytes != 5 || logged.ResponseBytes != 5 ||
+		logged.RemoteIP != "10.0.0.1" || logged.Duration <= 0 {
+		t.Error("unexpected entry", logged)
+	}
+	if logged.Query.Get("token") != Redacted || logged.Query.Get("page") != "2" ||
+		logged.Header.Get("Authorization") != Redacted || logged.Header.Get("X-Request-Id") != "r1" {
+		t.Error("unexpected redaction", logged.Query, logged.Header)
+	}
+	if !reflect.DeepEqual(logged.Tags, map[string]string{"team": "identity"}) {
+		t.Error("unexpected tags", logged.Tags)
+	}
+	tagged := false
+	for _, attr := range logged.Attrs() {
+		tagged = tagged || attr.Key == "tag.team" && attr.Value.String() == "identity"
+	}
+	if !tagged {
+		t.Error("tags are not in attributes", logged.Attrs())
+	}
+	if entries[1].Status != http.StatusTooManyRequests || entries[1].Err == nil {
+		t.Error("unexpected error entry", entries[1])
+	}
+	if entries[2].Route != "" || entries[2].Status != http.StatusNotFound {
+		t.Error("unexpected entry of unmatched request", entries[2])
+	}
+}
+
+func TestRouterHealth(t *testing.T) {
+	var databaseErr error
+	router := NewRouter()
+	if err := router.Register(Health(), Ready(
+		Check{Name: "database", Check: func(ctx context.Context) error { return databaseErr }},
+		Check{Name: "upstream", Timeout: time.Millisecond, Check: func(ctx context.Context) error {
+			<-ctx.Done()
+			return nil
+		}},
+		Check{Name: "cache", Check: func(ctx context.Context) error { panic("cache is not configured") }},
+	)); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, newGET(t, "http://localhost/healthz"))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"status":"up"`) {
+		t.Error("unexpected liveness response", w.Code, w.Body.String())
+	}
+
+	databaseErr = errors.New("connection refused")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, newGET(t, "http://localhost/readyz"))
+	var report HealthReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusServiceUnavailable || report.Status != HealthDown ||
+		report.Checks["database"].Error != "connection refused" || report.Checks["upstream"].Status != HealthDown ||
+		report.Checks["cache"].Error != "panic: cache is not configured" {
+		t.Error("unexpected readiness response", w.Code, w.Body.String())
+	}
+
+	// durations are measured by the clock of the router, the stopped one reports none
+	router.Clock(stoppedClock{})
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, newGET(t, "http://localhost/readyz"))
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Checks["upstream"].Duration != 0 {
+		t.Error("unexpected duration of the check", report.Checks["upstream"])
+	}
+}
+
+func TestServerGracefulShutdown(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	router := NewRouter()
+	if err := router.Register(GET("/slow").Handler(func() string {
+		close(started)
+		<-release
+		return "done"
+	})); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	addrs := make(chan net.Addr, 1)
+	var stops []string
+	server := NewServer("127.0.0.1:0", router).Signals().
+		OnStart(func(ctx context.Context, addr net.Addr) error {
+			addrs <- addr
+			return nil
+		}).
+		OnStop(func(ctx context.Context) error {
+			stops = append(stops, "database")
+			return nil
+		}).
+		OnStop(func(ctx context.Context) error {
+			stops = append(stops, "cache")
+			return errors.New("cache is gone")
+		})
+	ran := make(chan error, 1)
+	go func() {
+		ran <- server.Run(ctx)
+	}()
+
+	addr := <-addrs
+	responded := make(chan string, 1)
+	go func() {
+		resp, err := http.Get("http://" + addr.String() + "/slow")
+		if err != nil {
+			responded <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		responded <- string(body)
+	}()
+	<-started
+	cancel()
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+
+	if body := <-responded; body != "done" {
+		t.Error("in-flight request is not drained", body)
+	}
+	if err := <-ran; err == nil || err.Error() != "cache is gone" {
+		t.Error("unexpected error", err)
+	}
+	if !reflect.DeepEqual(stops, []string{"cache", "database"}) {
+		t.Error("unexpected order of stop hooks", stops)
+	}
+}
+
+func TestServerH2C(t *testing.T) {
+	router := NewRouter()
+	if err := router.Register(GET("/proto").Handler(func(r *http.Request) string {
+		return r.Proto
+	})); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	addrs := make(chan net.Addr, 1)
+	server := NewServer("127.0.0.1:0", router).Signals().H2C().
+		HTTP2(http.HTTP2Config{MaxConcurrentStreams: 10}).
+		OnStart(func(ctx context.Context, addr net.Addr) error {
+			addrs <- addr
+			return nil
+		})
+	go server.Run(ctx)
+	addr := <-addrs
+
+	for _, test := range []struct {
+		name  string
+		proto string
+		set   func(protocols *http.Protocols)
+	}{
+		{name: "prior knowledge", proto: "HTTP/2.0", set: func(protocols *http.Protocols) { protocols.SetUnencryptedHTTP2(true) }},
+		{name: "http1", proto: "HTTP/1.1", set: func(protocols *http.Protocols) { protocols.SetHTTP1(true) }},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			transport := &http.Transport{Protocols: new(http.Protocols)}
+			test.set(transport.Protocols)
+			defer transport.CloseIdleConnections()
+			resp, err := (&http.Client{Transport: transport}).Get("http://" + addr.String() + "/proto")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			if string(body) != test.proto {
+				t.Error("unexpected protocol", string(body))
+			}
+		})
+	}
+}
+
+func TestServerListeners(t *testing.T) {
+	router := NewRouter()
+	if err := router.Register(GET("/ping").Handler(func() string { return "pong" })); err != nil {
+		t.Fatal(err)
+	}
+	opened, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	socket := t.TempDir() + "/feel.sock"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	addrs := make(chan net.Addr, 3)
+	server := NewServer("", router).Signals().
+		Listen(
+			Listener{Addr: "127.0.0.1:0"},
+			Listener{Network: "unix", Addr: socket},
+			Listener{Name: "activated", Listener: opened},
+		).
+		OnStart(func(ctx context.Context, addr net.Addr) error {
+			addrs <- addr
+			return nil
+		})
+	ran := make(chan error, 1)
+	go func() {
+		ran <- server.Run(ctx)
+	}()
+
+	for i := 0; i < 3; i++ {
+		addr := <-addrs
+		transport := &http.Transport{DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, addr.Network(), addr.String())
+		}}
+		resp, err := (&http.Client{Transport: transport}).Get("http://feel/ping")
+		if err != nil {
+			t.Fatal(addr, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		transport.CloseIdleConnections()
+		if string(body) != "pong" {
+			t.Error("unexpected response", addr, string(body))
+		}
+	}
+	cancel()
+	if err := <-ran; err != nil {
+		t.Error("unexpected error", err)
+	}
+}
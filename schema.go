@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+)
+
+const jsonSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// RequestSchema is JSON Schema of requests of the endpoint. Its properties are "path", "query" and "header"
+// objects with parameters and "body" with the request body, schemas of named types are in Defs.
+type RequestSchema struct {
+	Schema     string                    `json:"$schema"`
+	Type       string                    `json:"type"`
+	Properties map[string]*OpenAPISchema `json:"properties"`
+	Required   []string                  `json:"required,omitempty"`
+	Defs       map[string]*OpenAPISchema `json:"$defs,omitempty"`
+}
+
+// EchoSchema makes the endpoint answer requests with "schema" URL query parameter, e.g. GET /users?schema,
+// with JSON Schema of its request generated from types of handler parameters instead of calling the handler.
+// It allows clients to validate requests without separate distribution of the specification.
+func (b builder) EchoSchema(enabled bool) Builder {
+	cloned := b.clone()
+	cloned.echoSchema = enabled
+	return cloned
+}
+
+func (b builder) requestSchema() (RequestSchema, error) {
+	schema := RequestSchema{
+		Schema:     jsonSchemaDialect,
+		Type:       "object",
+		Properties: map[string]*OpenAPISchema{},
+		Defs:       map[string]*OpenAPISchema{},
+	}
+	operation, err := b.openAPIOperation(newOpenAPISchemas(schema.Defs, "#/$defs/"))
+	if err != nil {
+		return schema, err
+	}
+
+	for _, parameter := range operation.Parameters {
+		in, found := schema.Properties[parameter.In]
+		if !found {
+			in = &OpenAPISchema{Type: "object", Properties: map[string]*OpenAPISchema{}}
+			schema.Properties[parameter.In] = in
+		}
+		parameterSchema := parameter.Schema
+		if parameterSchema == nil {
+			parameterSchema = &OpenAPISchema{}
+		}
+		in.Properties[parameter.Name] = parameterSchema
+		if parameter.Required {
+			in.Required = append(in.Required, parameter.Name)
+		}
+	}
+	if operation.RequestBody != nil {
+		mediaTypes := make([]string, 0, len(operation.RequestBody.Content))
+		for mediaType := range operation.RequestBody.Content {
+			mediaTypes = append(mediaTypes, mediaType)
+		}
+		sort.Strings(mediaTypes)
+		if len(mediaTypes) > 0 {
+			schema.Properties["body"] = operation.RequestBody.Content[mediaTypes[0]].Schema
+			schema.Required = append(schema.Required, "body")
+		}
+	}
+	for _, in := range [3]string{"path", "query", "header"} {
+		if properties, found := schema.Properties[in]; found && len(properties.Required) > 0 {
+			schema.Required = append(schema.Required, in)
+		}
+	}
+	return schema, nil
+}
+
+// buildEchoSchema returns precondition responding with JSON Schema of the request if it is enabled.
+func (b *builder) buildEchoSchema() []Interceptor {
+	if !b.echoSchema {
+		return nil
+	}
+	schema, err := b.requestSchema()
+	if err != nil {
+		b.addError(err)
+		return nil
+	}
+	document, err := json.Marshal(schema)
+	if err != nil {
+		b.addError(InvalidMappingError(err))
+		return nil
+	}
+	return []Interceptor{func(w http.ResponseWriter, r *http.Request) bool {
+		if _, requested := r.URL.Query()["schema"]; !requested {
+			return true
+		}
+		w.Header().Set("Content-Type", "application/schema+json")
+		w.Write(document)
+		return false
+	}}
+}
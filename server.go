@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// listenFdsStart is the first file descriptor passed by systemd socket activation.
+const listenFdsStart = 3
+
+// defaultShutdownTimeout limits draining of in-flight requests when ServerTimeouts doesn't set one.
+const defaultShutdownTimeout = 10 * time.Second
+
+// ServerTimeouts configures timeouts of the underlying http.Server, zero values leave them unlimited.
+// Shutdown limits graceful draining of in-flight requests before connections are closed, 10 seconds by default.
+type ServerTimeouts struct {
+	ReadHeader time.Duration
+	Read       time.Duration
+	Write      time.Duration
+	Idle       time.Duration
+	Shutdown   time.Duration
+}
+
+// Server runs the router on the address until the context is cancelled or the process receives SIGINT or SIGTERM,
+// then stops accepting connections and waits for in-flight requests to complete.
+//
+//	err := NewServer(":8080", router).OnStop(db.Close).Run(ctx)
+type Server struct {
+	addr     string
+	handler  http.Handler
+	timeouts ServerTimeouts
+	tls      *tls.Config
+	h2c      bool
+	http2    *http.HTTP2Config
+	certFile string
+	keyFile  string
+	signals  []os.Signal
+	onStart  []func(ctx context.Context, addr net.Addr) error
+	onStop   []func(ctx context.Context) error
+	listen   []Listener
+}
+
+// Listener is the address served by Server with own TLS configuration, certificate and key files, or the listener
+// already opened, e.g. by systemd socket activation. Network is "tcp" by default, "unix" listens on the Unix domain
+// socket at the path of Addr.
+type Listener struct {
+	Name     string
+	Network  string
+	Addr     string
+	Listener net.Listener
+	TLS      *tls.Config
+	CertFile string
+	KeyFile  string
+}
+
+// SystemdListeners returns listeners passed to the process by systemd socket activation named by FileDescriptorName
+// of their sockets, or none if the process is not activated by a socket.
+func SystemdListeners() ([]Listener, error) {
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid LISTEN_FDS: %w", err)
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	listeners := make([]Listener, 0, count)
+	for i := 0; i < count; i++ {
+		name := "LISTEN_FD_" + strconv.Itoa(listenFdsStart+i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		file := os.NewFile(uintptr(listenFdsStart+i), name)
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			closeListeners(listeners)
+			return nil, fmt.Errorf("listener %s: %w", name, err)
+		}
+		listeners = append(listeners, Listener{Name: name, Network: listener.Addr().Network(), Listener: listener})
+	}
+	return listeners, nil
+}
+
+func NewServer(addr string, router *Router) *Server {
+	return &Server{addr: addr, handler: router, signals: []os.Signal{os.Interrupt, syscall.SIGTERM}}
+}
+
+// Timeouts sets timeouts of reading and writing requests, of idle connections and of graceful shutdown.
+func (s *Server) Timeouts(timeouts ServerTimeouts) *Server {
+	s.timeouts = timeouts
+	return s
+}
+
+// TLS serves HTTPS with the configuration and the certificate and key files. Files could be empty if the
+// configuration provides certificates itself.
+func (s *Server) TLS(config *tls.Config, certFile, keyFile string) *Server {
+	if config == nil {
+		config = &tls.Config{}
+	}
+	s.tls, s.certFile, s.keyFile = config, certFile, keyFile
+	return s
+}
+
+// Listen replaces the address of the server by the listeners served simultaneously by the router. TLS configured
+// for the server applies to listeners without own one.
+func (s *Server) Listen(listeners ...Listener) *Server {
+	s.listen = append(s.listen, listeners...)
+	return s
+}
+
+// H2C additionally serves cleartext HTTP/2 with prior knowledge on connections without TLS, e.g. for gRPC-style
+// clients behind proxies terminating TLS. HTTP/1 is still served on the same listener.
+func (s *Server) H2C() *Server {
+	s.h2c = true
+	return s
+}
+
+// HTTP2 tunes HTTP/2 connections served over TLS or h2c, e.g. limits of concurrent streams and frame sizes.
+func (s *Server) HTTP2(config http.HTTP2Config) *Server {
+	s.http2 = &config
+	return s
+}
+
+// Signals replaces SIGINT and SIGTERM as signals triggering graceful shutdown, none disables handling of signals.
+func (s *Server) Signals(signals ...os.Signal) *Server {
+	s.signals = signals
+	return s
+}
+
+// OnStart adds the hook called in order of addition for each listener once all of them are bound and before
+// requests are served. Error of the hook aborts the start, hooks of stop are not called then.
+func (s *Server) OnStart(hook func(ctx context.Context, addr net.Addr) error) *Server {
+	s.onStart = append(s.onStart, hook)
+	return s
+}
+
+// OnStop adds the hook called after in-flight requests are drained, in reverse order of addition, e.g. to close
+// connections to databases used by handlers. The context of hooks is limited by the shutdown timeout.
+func (s *Server) OnStop(hook func(ctx context.Context) error) *Server {
+	s.onStop = append(s.onStop, hook)
+	return s
+}
+
+// Run listens on the address or listeners and serves requests until the context is cancelled, a signal is received or serving
+// fails. It returns nil after graceful shutdown and otherwise the error of serving, shutdown or hooks.
+func (s *Server) Run(ctx context.Context) error {
+	listeners := s.listen
+	if len(listeners) == 0 {
+		listeners = []Listener{{Addr: s.addr}}
+	}
+	bound := make([]Listener, 0, len(listeners))
+	for _, listener := range listeners {
+		if listener.TLS == nil && s.tls != nil {
+			listener.TLS, listener.CertFile, listener.KeyFile = s.tls, s.certFile, s.keyFile
+		}
+		if listener.Listener == nil {
+			network := listener.Network
+			if network == "" {
+				network = "tcp"
+			}
+			opened, err := net.Listen(network, listener.Addr)
+			if err != nil {
+				closeListeners(bound)
+				return err
+			}
+			listener.Listener = opened
+		}
+		bound = append(bound, listener)
+	}
+	return s.serve(ctx, bound)
+}
+
+func (s *Server) serve(ctx context.Context, listeners []Listener) error {
+	if len(s.signals) > 0 {
+		var stop context.CancelFunc
+		ctx, stop = signal.NotifyContext(ctx, s.signals...)
+		defer stop()
+	}
+	for _, hook := range s.onStart {
+		for _, listener := range listeners {
+			if err := hook(ctx, listener.Listener.Addr()); err != nil {
+				closeListeners(listeners)
+				return err
+			}
+		}
+	}
+
+	servers := make([]*http.Server, len(listeners))
+	served := make(chan error, len(listeners))
+	for i, listener := range listeners {
+		server, listener := s.httpServer(listener.TLS), listener
+		servers[i] = server
+		go func() {
+			if listener.TLS != nil {
+				served <- server.ServeTLS(listener.Listener, listener.CertFile, listener.KeyFile)
+			} else {
+				served <- server.Serve(listener.Listener)
+			}
+		}()
+	}
+
+	var errs []error
+	pending := len(listeners)
+	select {
+	case err := <-served:
+		errs = append(errs, err)
+		pending--
+	case <-ctx.Done():
+	}
+
+	timeout := s.timeouts.Shutdown
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, server := range servers {
+		server := server
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := server.Shutdown(shutdownCtx); err != nil {
+				mu.Lock()
+				defer mu.Unlock()
+				errs = append(errs, err, server.Close())
+			}
+		}()
+	}
+	wg.Wait()
+	for ; pending > 0; pending-- {
+		if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+			errs = append(errs, err)
+		}
+	}
+	for i := len(s.onStop) - 1; i >= 0; i-- {
+		errs = append(errs, s.onStop[i](shutdownCtx))
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Server) httpServer(tlsConfig *tls.Config) *http.Server {
+	server := &http.Server{
+		Addr:              s.addr,
+		Handler:           s.handler,
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: s.timeouts.ReadHeader,
+		ReadTimeout:       s.timeouts.Read,
+		WriteTimeout:      s.timeouts.Write,
+		IdleTimeout:       s.timeouts.Idle,
+		HTTP2:             s.http2,
+	}
+	if s.h2c {
+		server.Protocols = new(http.Protocols)
+		server.Protocols.SetHTTP1(true)
+		server.Protocols.SetHTTP2(true)
+		server.Protocols.SetUnencryptedHTTP2(true)
+	}
+	return server
+}
+
+func closeListeners(listeners []Listener) {
+	for _, listener := range listeners {
+		listener.Listener.Close()
+	}
+}
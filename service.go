@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// RouteProvider is implemented by services declaring builders of routes of their methods, e.g.
+//
+//	func (s *Users) Routes() map[string]Builder {
+//		return map[string]Builder{"Get": GET("/users/:id").Codec(JSON)}
+//	}
+type RouteProvider interface {
+	// Routes returns builders without handlers by names of exported methods handling them.
+	Routes() map[string]Builder
+}
+
+// RegisterService registers routes handled by exported methods of the service. Routes are declared by Routes method
+// of RouteProvider and by tags of fields of the service struct, usually blank ones, with the route in form accepted
+// by Route and the name of the method:
+//
+//	type Users struct {
+//		_ struct{} `route:"GET /users/:id" handler:"Get"`
+//	}
+//
+// Routes declared by tags use JSON codec. Routes are registered in order of method names.
+func RegisterService(router *Router, service interface{}) error {
+	serviceValue := reflect.ValueOf(service)
+	if !serviceValue.IsValid() {
+		return InvalidMappingError(fmt.Errorf("service %T is nil", service))
+	}
+	routes := map[string][]Builder{}
+	structType := serviceValue.Type()
+	for structType.Kind() == reflect.Ptr {
+		structType = structType.Elem()
+	}
+	if structType.Kind() == reflect.Struct {
+		for i := 0; i < structType.NumField(); i++ {
+			field := structType.Field(i)
+			route, routed := field.Tag.Lookup("route")
+			if !routed {
+				continue
+			}
+			handler := field.Tag.Get("handler")
+			if handler == "" {
+				return InvalidMappingError(fmt.Errorf("route %q of %s has no handler tag", route, structType))
+			}
+			routes[handler] = append(routes[handler], Route(route).Codec(JSON))
+		}
+	}
+	if provider, ok := service.(RouteProvider); ok {
+		for handler, b := range provider.Routes() {
+			routes[handler] = append(routes[handler], b)
+		}
+	}
+	if len(routes) == 0 {
+		return InvalidMappingError(fmt.Errorf("service %T declares no routes", service))
+	}
+
+	handlers := make([]string, 0, len(routes))
+	for handler := range routes {
+		handlers = append(handlers, handler)
+	}
+	sort.Strings(handlers)
+	var builders []Builder
+	for _, handler := range handlers {
+		method := serviceValue.MethodByName(handler)
+		if !method.IsValid() {
+			return InvalidMappingError(fmt.Errorf("service %T has no exported method %s", service, handler))
+		}
+		for _, b := range routes[handler] {
+			builders = append(builders, b.Handler(method.Interface()))
+		}
+	}
+	return router.Register(builders...)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ParameterSnapshot is a redacted form of the handler parameter bound before the handler panicked.
+// Values of headers and cookies, bodies and struct fields tagged with redact:"true" are not included,
+// strings and collections are truncated.
+type ParameterSnapshot struct {
+	Type  string
+	Value string
+}
+
+const (
+	snapshotStringLimit = 64
+	snapshotItemsLimit  = 8
+	snapshotDepthLimit  = 3
+	redactedValue       = "[redacted]"
+)
+
+var stringerType = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+
+func snapshotParameters(values []reflect.Value) []ParameterSnapshot {
+	if len(values) == 0 {
+		return nil
+	}
+	snapshots := make([]ParameterSnapshot, len(values))
+	for i, value := range values {
+		if !value.IsValid() {
+			continue
+		}
+		var snapshot strings.Builder
+		writeSnapshot(&snapshot, value, 0)
+		snapshots[i] = ParameterSnapshot{Type: value.Type().String(), Value: snapshot.String()}
+	}
+	return snapshots
+}
+
+func writeSnapshot(snapshot *strings.Builder, value reflect.Value, depth int) {
+	switch value.Type() {
+	case headersType, cookiesType:
+		snapshot.WriteString(strings.Join(snapshotKeys(value), ","))
+		return
+	case requestType:
+		if r := value.Interface().(*http.Request); r != nil {
+			snapshot.WriteString(r.Method + " " + r.URL.Path)
+		}
+		return
+	}
+	if value.Type().Implements(readerType) || value.Type().Implements(responseWriterType) || value.Type().Implements(contextType) {
+		snapshot.WriteString("<" + value.Type().String() + ">")
+		return
+	}
+
+	switch value.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if value.IsNil() {
+			snapshot.WriteString("nil")
+			return
+		}
+		writeSnapshot(snapshot, value.Elem(), depth)
+	case reflect.String:
+		snapshot.WriteString(strconv.Quote(truncateSnapshot(value.String())))
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128:
+		fmt.Fprint(snapshot, value.Interface())
+	case reflect.Slice, reflect.Array:
+		if value.Type().Elem().Kind() == reflect.Uint8 {
+			fmt.Fprintf(snapshot, "<%d bytes>", value.Len())
+			return
+		}
+		writeSnapshotItems(snapshot, value.Len(), depth, func(i int) {
+			writeSnapshot(snapshot, value.Index(i), depth+1)
+		})
+	case reflect.Map:
+		keys := value.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j]) })
+		writeSnapshotItems(snapshot, len(keys), depth, func(i int) {
+			writeSnapshot(snapshot, keys[i], depth+1)
+			snapshot.WriteByte(':')
+			writeSnapshot(snapshot, value.MapIndex(keys[i]), depth+1)
+		})
+	case reflect.Struct:
+		writeStructSnapshot(snapshot, value, depth)
+	default:
+		snapshot.WriteString("<" + value.Type().String() + ">")
+	}
+}
+
+func writeStructSnapshot(snapshot *strings.Builder, value reflect.Value, depth int) {
+	var exported []reflect.StructField
+	for _, field := range reflect.VisibleFields(value.Type()) {
+		if field.IsExported() && !field.Anonymous {
+			exported = append(exported, field)
+		}
+	}
+	if len(exported) == 0 && value.Type().Implements(stringerType) {
+		// values like time.Time or netip.Addr
+		snapshot.WriteString(truncateSnapshot(value.Interface().(fmt.Stringer).String()))
+		return
+	}
+	if depth >= snapshotDepthLimit {
+		snapshot.WriteString("{…}")
+		return
+	}
+	snapshot.WriteByte('{')
+	for i, field := range exported {
+		if i > 0 {
+			snapshot.WriteByte(' ')
+		}
+		snapshot.WriteString(field.Name + ":")
+		fieldValue, err := value.FieldByIndexErr(field.Index)
+		switch {
+		case field.Tag.Get("redact") == "true":
+			snapshot.WriteString(redactedValue)
+		case err != nil:
+			snapshot.WriteString("nil")
+		default:
+			writeSnapshot(snapshot, fieldValue, depth+1)
+		}
+	}
+	snapshot.WriteByte('}')
+}
+
+func writeSnapshotItems(snapshot *strings.Builder, length, depth int, writeItem func(i int)) {
+	if depth >= snapshotDepthLimit {
+		fmt.Fprintf(snapshot, "[%d items]", length)
+		return
+	}
+	snapshot.WriteByte('[')
+	for i := 0; i < length && i < snapshotItemsLimit; i++ {
+		if i > 0 {
+			snapshot.WriteByte(' ')
+		}
+		writeItem(i)
+	}
+	if length > snapshotItemsLimit {
+		fmt.Fprintf(snapshot, " …%d more", length-snapshotItemsLimit)
+	}
+	snapshot.WriteByte(']')
+}
+
+// snapshotKeys returns names of headers or cookies without their values.
+func snapshotKeys(value reflect.Value) []string {
+	var keys []string
+	switch values := value.Interface().(type) {
+	case http.Header:
+		for name := range values {
+			keys = append(keys, name)
+		}
+		sort.Strings(keys)
+	case []*http.Cookie:
+		for _, cookie := range values {
+			keys = append(keys, cookie.Name)
+		}
+	}
+	return keys
+}
+
+func truncateSnapshot(s string) string {
+	if utf8.RuneCountInString(s) <= snapshotStringLimit {
+		return s
+	}
+	return string([]rune(s)[:snapshotStringLimit]) + "…"
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"reflect"
+)
+
+// sniffLength is amount of bytes considered by http.DetectContentType.
+const sniffLength = 512
+
+// isSniffable reports if content type of the response body of the type is detected when no encoder is set.
+func isSniffable(t reflect.Type) bool {
+	if t.Implements(readerType) || t.Kind() == reflect.String {
+		return true
+	}
+	return (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) && t.Elem().Kind() == reflect.Uint8
+}
+
+// isWritable reports if the response body of the type is written as is when no encoder is set.
+func isWritable(t reflect.Type) bool {
+	return isSniffable(t) || t == multipartType
+}
+
+// sniffContentType detects content type of the response body.
+// Reader body is replaced with the one which starts with peeked bytes.
+func sniffContentType(results []reflect.Value, index int) string {
+	value := results[index]
+	if value.Type().Implements(readerType) {
+		return sniffReader(results, index)
+	}
+
+	switch value.Kind() {
+	case reflect.String:
+		text := value.String()
+		if text == "" {
+			return ""
+		}
+		if len(text) > sniffLength {
+			text = text[:sniffLength]
+		}
+		return http.DetectContentType([]byte(text))
+	case reflect.Slice:
+		if value.Len() == 0 {
+			return ""
+		}
+		return http.DetectContentType(value.Bytes())
+	case reflect.Array:
+		length := value.Len()
+		if length > sniffLength {
+			length = sniffLength
+		}
+		data := make([]byte, length)
+		for i := 0; i < length; i++ {
+			data[i] = byte(value.Index(i).Uint())
+		}
+		return http.DetectContentType(data)
+	}
+	return ""
+}
+
+func sniffReader(results []reflect.Value, index int) string {
+	value := results[index]
+	if isNil(value) {
+		return ""
+	}
+	source := value.Interface().(io.Reader)
+	buffered := bufio.NewReaderSize(source, sniffLength)
+	data, _ := buffered.Peek(sniffLength)
+	results[index] = reflect.ValueOf(peekedReader{Reader: buffered, source: source})
+	if len(data) == 0 {
+		return ""
+	}
+	return http.DetectContentType(data)
+}
+
+// peekedReader reads the source through the buffer with peeked bytes and closes the source if it is closable.
+type peekedReader struct {
+	io.Reader
+	source io.Reader
+}
+
+func (pr peekedReader) Close() error {
+	if closer, ok := pr.source.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func isNil(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+		return value.IsNil()
+	}
+	return false
+}
+
+// writeReader copies the reader to the response and closes it if it is closable.
+func writeReader(w io.Writer, value reflect.Value) error {
+	if isNil(value) {
+		return nil
+	}
+	reader := value.Interface().(io.Reader)
+	if closer, ok := reader.(io.Closer); ok {
+		defer closer.Close()
+	}
+	_, err := io.Copy(w, reader)
+	return err
+}
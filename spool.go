@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+)
+
+const defaultSpoolThreshold = 1 << 20
+
+// SpoolPolicy defines how request body bound to io.ReadSeeker handler parameter is buffered.
+type SpoolPolicy struct {
+	// Threshold of the body size kept in memory, bigger bodies are written to a temporary file.
+	Threshold int64
+	// Dir for temporary files, os.TempDir is used if empty.
+	Dir string
+}
+
+var DefaultSpoolPolicy = SpoolPolicy{Threshold: defaultSpoolThreshold}
+
+var readSeekerType = reflect.TypeOf((*io.ReadSeeker)(nil)).Elem()
+
+// SpoolBody sets policy for buffering of the request body bound to io.ReadSeeker handler parameter.
+// Temporary files are removed after the response is produced.
+func (b builder) SpoolBody(policy SpoolPolicy) Builder {
+	cloned := b.clone()
+	cloned.spoolPolicy = policy
+	return cloned
+}
+
+func (policy SpoolPolicy) spool(r *http.Request) (io.ReadSeeker, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return bytes.NewReader(nil), nil
+	}
+
+	var buffer bytes.Buffer
+	n, err := io.CopyN(&buffer, r.Body, policy.Threshold+1)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if n <= policy.Threshold {
+		return bytes.NewReader(buffer.Bytes()), nil
+	}
+
+	file, err := os.CreateTemp(policy.Dir, "feel-body-*")
+	if err != nil {
+		return nil, err
+	}
+	cleanup := func() {
+		file.Close()
+		os.Remove(file.Name())
+	}
+	if tracked, ok := r.Body.(*trackedBody); ok {
+		tracked.onFinish(cleanup)
+	}
+	if _, err = io.Copy(file, io.MultiReader(&buffer, r.Body)); err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+	return file, nil
+}
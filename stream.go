@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"io"
+	"reflect"
+)
+
+var boolType = reflect.TypeOf(true)
+
+// FramingEncoder is implemented by encoders delimiting encoded values, so items of streamed channels and iterators
+// are decoded one by one, e.g. NDJSONEncoder and CSVEncoder. Streams are also encoded by JSONEncoder as an array.
+type FramingEncoder interface {
+	Encoder
+	FramesItems() bool
+}
+
+// streamsItems reports if channels and iterators are encoded by the encoder into a valid body.
+func streamsItems(encoder Encoder) bool {
+	if _, isJSON := encoder.(jsonCodec); isJSON {
+		return true
+	}
+	framing, ok := encoder.(FramingEncoder)
+	return ok && framing.FramesItems()
+}
+
+// isStreamType reports if the response body of the type is streamed item by item: it is a receivable channel
+// or an iterator of signature func(yield func(T) bool), e.g. iter.Seq[T].
+func isStreamType(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Chan:
+		return t.ChanDir()&reflect.RecvDir != 0
+	case reflect.Func:
+		if t.NumIn() != 1 || t.NumOut() != 0 {
+			return false
+		}
+		yield := t.In(0)
+		return yield.Kind() == reflect.Func && yield.NumIn() == 1 && yield.NumOut() == 1 && yield.Out(0) == boolType
+	}
+	return false
+}
+
+// streamsBody reports if the handler returns the response body to be streamed.
+func (b *builder) streamsBody() bool {
+	bodyTypes, hasBody := b.hasParametersIn(responseBodyParametersGroup)
+	return hasBody && isStreamType(bodyTypes[0])
+}
+
+// forEachItem calls fn for every item of the channel until it is closed or of the iterator until it ends.
+// Iteration stops on the first error of fn or once the context is done. The rest of the channel is not received,
+// producers must stop sending once the request context is done, which endpoints cancel when they stop streaming.
+func forEachItem(ctx context.Context, stream reflect.Value, fn func(item reflect.Value) error) error {
+	if stream.IsNil() {
+		return nil
+	}
+	if stream.Kind() == reflect.Chan {
+		cases := []reflect.SelectCase{
+			{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())},
+			{Dir: reflect.SelectRecv, Chan: stream},
+		}
+		for {
+			chosen, item, ok := reflect.Select(cases)
+			if chosen == 0 {
+				return ctx.Err()
+			}
+			if !ok {
+				return nil
+			}
+			if err := fn(item); err != nil {
+				return err
+			}
+		}
+	}
+
+	var err error
+	yield := reflect.MakeFunc(stream.Type().In(0), func(args []reflect.Value) []reflect.Value {
+		if err = ctx.Err(); err == nil {
+			err = fn(args[0])
+		}
+		return []reflect.Value{reflect.ValueOf(err == nil)}
+	})
+	stream.Call([]reflect.Value{yield})
+	return err
+}
+
+// encodeItems encodes every item of the stream and flushes it at once, so the client receives items as they are produced.
+// JSON items are elements of an array, so the body is a single JSON value.
+// The header is flushed before the first item, so errors of encoding are not mapped as the response is already sent.
+func encodeItems(ctx context.Context, encoder Encoder, w io.Writer, stream reflect.Value) error {
+	var open, separator, close string
+	if _, isJSON := encoder.(jsonCodec); isJSON {
+		open, separator, close = "[", ",", "]"
+	}
+	encodeStream := encoder.NewEncodeStream(w)
+	if _, err := io.WriteString(w, open); err != nil {
+		return err
+	}
+	if err := encodeStream.Flush(); err != nil {
+		return err
+	}
+	first := true
+	err := forEachItem(ctx, stream, func(item reflect.Value) error {
+		if !first {
+			if _, err := io.WriteString(w, separator); err != nil {
+				return err
+			}
+		}
+		first = false
+		if err := encodeStream.Encode(item.Interface()); err != nil {
+			return err
+		}
+		return encodeStream.Flush()
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, close); err != nil {
+		return err
+	}
+	return encodeStream.Flush()
+}
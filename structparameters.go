@@ -0,0 +1,284 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+)
+
+const (
+	pathTag    = "path"
+	headerTag  = "header"
+	queryTag   = "query"
+	trailerTag = "trailer"
+	layoutTag  = "layout"
+)
+
+// boundTags bind struct fields to request values. Fields tagged with path are bound to path parameters by names,
+// then handlers have no positional path parameters. Fields tagged with trailer are bound after the body is read,
+// the rest of the body is discarded then, so handlers streaming the body should read r.Trailer of *http.Request instead.
+var boundTags = [...]string{pathTag, headerTag, queryTag, trailerTag}
+
+type structFieldBinding struct {
+	index     []int
+	fieldType reflect.Type
+	tag       string
+	name      string
+	multiple  bool
+	converter PathParameterConverter
+	// normalize is the value of normalize tag if the field has one
+	normalize *string
+}
+
+// embeddedStruct returns struct type of the embedded field, tags of such fields are honored recursively.
+func embeddedStruct(field reflect.StructField) (reflect.Type, bool) {
+	if !field.Anonymous {
+		return nil, false
+	}
+	fieldType := field.Type
+	if fieldType.Kind() == reflect.Ptr {
+		fieldType = fieldType.Elem()
+	}
+	return fieldType, fieldType.Kind() == reflect.Struct
+}
+
+func isBoundStruct(parameterType reflect.Type) bool {
+	return parameterType.Kind() == reflect.Struct && hasBoundFields(parameterType, map[reflect.Type]bool{})
+}
+
+func hasBoundFields(structType reflect.Type, visited map[reflect.Type]bool) bool {
+	if visited[structType] {
+		return false
+	}
+	visited[structType] = true
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		for _, tag := range boundTags {
+			if _, found := field.Tag.Lookup(tag); found {
+				return true
+			}
+		}
+		if embeddedType, embedded := embeddedStruct(field); embedded && hasBoundFields(embeddedType, visited) {
+			return true
+		}
+	}
+	return false
+}
+
+// bindsPathByName reports if the function has struct parameter binding path parameters into fields tagged with path.
+func bindsPathByName(functionType reflect.Type) bool {
+	for i := 0; i < functionType.NumIn(); i++ {
+		if parameterType := functionType.In(i); isBoundStruct(parameterType) {
+			bindings, _ := newStructFieldBindings(parameterType, "")
+			for _, binding := range bindings {
+				if binding.tag == pathTag {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// newStructFieldBindings binds tagged fields, time fields are parsed with layout from the field tag or the default one.
+func newStructFieldBindings(parameterType reflect.Type, timeLayout string) ([]structFieldBinding, error) {
+	return appendStructFieldBindings(nil, parameterType, nil, timeLayout, map[reflect.Type]bool{})
+}
+
+func appendStructFieldBindings(bindings []structFieldBinding, structType reflect.Type, parentIndex []int, timeLayout string, visited map[reflect.Type]bool) ([]structFieldBinding, error) {
+	if visited[structType] {
+		return nil, InvalidMappingError(fmt.Errorf("recursive embedding of %s", structType))
+	}
+	visited[structType] = true
+	defer delete(visited, structType)
+
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		index := append(append([]int{}, parentIndex...), i)
+		bound := false
+		for _, tag := range boundTags {
+			name, found := field.Tag.Lookup(tag)
+			if !found {
+				continue
+			}
+			bound = true
+			if field.PkgPath != "" {
+				return nil, InvalidMappingError(fmt.Errorf("unable to bind %s %q into unexported field %s", tag, name, field.Name))
+			}
+			if tag == headerTag || tag == trailerTag {
+				name = http.CanonicalHeaderKey(name)
+			}
+
+			fieldType := field.Type
+			multiple := fieldType.Kind() == reflect.Slice && fieldType.Elem().Kind() != reflect.Uint8
+			if multiple && tag == pathTag {
+				return nil, InvalidMappingError(fmt.Errorf("unable to bind path parameter %q into slice field %s", name, field.Name))
+			}
+			if multiple {
+				fieldType = fieldType.Elem()
+			}
+			var layout string
+			if fieldType == timeType {
+				layout = timeLayout
+			}
+			if fieldLayout, found := field.Tag.Lookup(layoutTag); found {
+				layout = fieldLayout
+			}
+			converter, err := newLayoutPathParameterConverter(fieldType, layout)
+			if err != nil {
+				return nil, InvalidMappingError(fmt.Errorf("field %s: %v", field.Name, err))
+			}
+			binding := structFieldBinding{index: index, fieldType: field.Type, tag: tag, name: name, multiple: multiple, converter: converter}
+			if normalize, found := field.Tag.Lookup(normalizeTag); found {
+				if !isStringKind(field.Type) {
+					return nil, InvalidMappingError(fmt.Errorf("field %s: %s tag is applicable to string fields only", field.Name, normalizeTag))
+				}
+				binding.normalize = &normalize
+			}
+			bindings = append(bindings, binding)
+		}
+
+		if embeddedType, embedded := embeddedStruct(field); embedded && !bound {
+			if field.Type.Kind() == reflect.Ptr && field.PkgPath != "" {
+				if hasBoundFields(embeddedType, map[reflect.Type]bool{}) {
+					return nil, InvalidMappingError(fmt.Errorf("unable to bind into unexported embedded pointer %s", field.Name))
+				}
+				continue
+			}
+			var err error
+			if bindings, err = appendStructFieldBindings(bindings, embeddedType, index, timeLayout, visited); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return bindings, nil
+}
+
+// fieldByIndex is like reflect.Value.FieldByIndex, but allocates nil embedded pointers on the way.
+func fieldByIndex(structValue reflect.Value, index []int) reflect.Value {
+	value := structValue
+	for i, fieldIndex := range index {
+		if i > 0 && value.Kind() == reflect.Ptr {
+			if value.IsNil() {
+				value.Set(reflect.New(value.Type().Elem()))
+			}
+			value = value.Elem()
+		}
+		value = value.Field(fieldIndex)
+	}
+	return value
+}
+
+func (b *builder) defineStructParameters() {
+	structParameterTypes, exist := b.hasParametersIn(structParametersGroup)
+	if !exist {
+		return
+	}
+
+	structType := structParameterTypes[0]
+	bindings, err := newStructFieldBindings(structType, b.timeLayout)
+	if err != nil {
+		b.addErrorAt(b.parameterIndex(structType), -1, err)
+		return
+	}
+	pathNames := pathParameterNames(b.pathTemplate)
+	pathValues := b.pathValues
+	pathPositions := make([]int, len(bindings))
+	namedPath := false
+	normalizations := make([]StringNormalization, len(bindings))
+	for i, binding := range bindings {
+		if binding.tag == trailerTag {
+			b.trailersBound = true
+		}
+		if binding.tag == pathTag {
+			namedPath = true
+			pathPositions[i] = -1
+			for position, name := range pathNames {
+				if name == binding.name && pathPositions[i] < 0 {
+					pathPositions[i] = position
+				}
+			}
+			if pathPositions[i] < 0 {
+				b.addErrorAt(b.parameterIndex(structType), -1, InvalidMappingError(fmt.Errorf("no path parameter %q in %s", binding.name, b.pathTemplate)))
+				return
+			}
+		}
+		switch {
+		case binding.normalize != nil:
+			if normalizations[i], err = parseNormalizationTag(*binding.normalize, b.normalization); err != nil {
+				b.addErrorAt(b.parameterIndex(structType), -1, err)
+				return
+			}
+		case isStringKind(binding.fieldType):
+			normalizations[i] = b.normalization
+		}
+	}
+
+	trailersBound := b.trailersBound
+	b.structParameters = func(r *http.Request) (reflect.Value, error) {
+		structValue := reflect.New(structType).Elem()
+		queryValues := r.URL.Query()
+		var pathParameters []string
+		if namedPath {
+			pathParameters = pathValues(r.URL.EscapedPath())
+		}
+		if trailersBound && r.Body != nil {
+			// trailers are received after the body, so the rest of it is discarded
+			if _, err := io.Copy(io.Discard, r.Body); err != nil {
+				return structValue, err
+			}
+		}
+		for i, binding := range bindings {
+			var values []string
+			switch binding.tag {
+			case pathTag:
+				if pathPositions[i] < len(pathParameters) {
+					pathValue, err := url.PathUnescape(pathParameters[pathPositions[i]])
+					if err != nil {
+						return structValue, InvalidValueError(err)
+					}
+					values = []string{pathValue}
+				}
+			case headerTag:
+				values = r.Header[binding.name]
+			case queryTag:
+				values = queryValues[binding.name]
+			case trailerTag:
+				values = r.Trailer[binding.name]
+			}
+			if len(values) == 0 {
+				continue
+			}
+			if normalization := normalizations[i]; normalization.enabled() {
+				normalized := make([]string, len(values))
+				for j, value := range values {
+					normalized[j] = normalization.apply(value)
+				}
+				values = normalized
+			}
+
+			field := fieldByIndex(structValue, binding.index)
+			if !binding.multiple {
+				value, err := binding.converter.Convert(values[0])
+				if err != nil {
+					return structValue, InvalidValueError(withParameter(fmt.Errorf("%s %s: %w", binding.tag, binding.name, err), binding.name))
+				}
+				field.Set(value.Convert(field.Type()))
+				continue
+			}
+
+			slice := reflect.MakeSlice(field.Type(), 0, len(values))
+			for _, raw := range values {
+				value, err := binding.converter.Convert(raw)
+				if err != nil {
+					return structValue, InvalidValueError(withParameter(fmt.Errorf("%s %s: %w", binding.tag, binding.name, err), binding.name))
+				}
+				slice = reflect.Append(slice, value.Convert(field.Type().Elem()))
+			}
+			field.Set(slice)
+		}
+		return structValue, nil
+	}
+}
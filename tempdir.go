@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"os"
+	"sync"
+)
+
+// TempDir is a path of the temporary directory of the request injected into handler parameters
+// of endpoints built with TempDirs.
+type TempDir string
+
+type tempDirsKey struct{}
+
+// TempDirs injects TempDir parameters of the handler with a directory created in parent, or in os.TempDir if it is
+// empty. The directory is created per request and removed with its content once the endpoint responds and
+// the handler returns, even if the handler panics, times out or the client disconnects.
+func (b builder) TempDirs(parent string) Builder {
+	cloned := b.ContextValue(func(ctx context.Context) (TempDir, error) {
+		dirs, _ := ctx.Value(tempDirsKey{}).(*tempDirs)
+		return dirs.create()
+	}).(builder)
+	cloned.tempDirs = &parent
+	return cloned
+}
+
+// tempDirs holds the directory of the request until it is released by the endpoint and the handler.
+type tempDirs struct {
+	mu     sync.Mutex
+	parent string
+	dir    string
+	holds  int
+}
+
+func newTempDirs(parent string) *tempDirs {
+	return &tempDirs{parent: parent, holds: 1}
+}
+
+func (td *tempDirs) create() (TempDir, error) {
+	td.mu.Lock()
+	defer td.mu.Unlock()
+	if td.dir == "" {
+		dir, err := os.MkdirTemp(td.parent, "feel-")
+		if err != nil {
+			return "", err
+		}
+		td.dir = dir
+	}
+	return TempDir(td.dir), nil
+}
+
+func (td *tempDirs) hold() {
+	td.mu.Lock()
+	defer td.mu.Unlock()
+	td.holds++
+}
+
+// holdTempDirs holds the directory of the request in the context, if any, until returned function is called.
+func holdTempDirs(ctx context.Context) func() {
+	dirs, _ := ctx.Value(tempDirsKey{}).(*tempDirs)
+	if dirs == nil {
+		return func() {}
+	}
+	dirs.hold()
+	return dirs.release
+}
+
+// release removes the directory once nobody holds it.
+func (td *tempDirs) release() {
+	td.mu.Lock()
+	defer td.mu.Unlock()
+	if td.holds--; td.holds == 0 && td.dir != "" {
+		_ = os.RemoveAll(td.dir)
+		td.dir = ""
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// TimeoutError is mapped by error mapper of the endpoint when the handler doesn't return within the timeout.
+type TimeoutError struct {
+	Timeout time.Duration
+	Status  int
+}
+
+func (e TimeoutError) Error() string {
+	return fmt.Sprintf("handler timed out after %s", e.Timeout)
+}
+
+func (e TimeoutError) StatusCode() int {
+	return e.Status
+}
+
+func (e TimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+// Timeout sets deadline of the request context for the handler, if it is exceeded the response is produced by
+// the error mapper from TimeoutError with 503 Service Unavailable or provided status code, e.g. 504 Gateway Timeout.
+// Result of the handler returned late is discarded and writes to the injected http.ResponseWriter are rejected.
+func (b builder) Timeout(timeout time.Duration, statusCode ...int) Builder {
+	cloned := b.clone()
+	cloned.timeout = TimeoutError{Timeout: timeout, Status: http.StatusServiceUnavailable}
+	if len(statusCode) > 0 {
+		cloned.timeout.Status = statusCode[0]
+	}
+	return cloned
+}
+
+// invokeWithin calls the handler in a separate goroutine and waits for its results until the context is done.
+// Panic of the handler is propagated to the calling goroutine.
+func (ep EndpointProcessor) invokeWithin(ctx context.Context, values []reflect.Value) ([]reflect.Value, bool) {
+	done := make(chan []reflect.Value, 1)
+	panicked := make(chan PanicError, 1)
+	// the directory is kept until the abandoned handler returns
+	release := holdTempDirs(ctx)
+	go func() {
+		defer release()
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				panicErr := PanicError{Value: recovered}
+				if ep.debug {
+					panicErr.Stack = debug.Stack()
+				}
+				panicked <- panicErr
+			}
+		}()
+		done <- ep.invoke(values)
+	}()
+
+	select {
+	case results := <-done:
+		return results, true
+	case panicErr := <-panicked:
+		panic(panicErr)
+	case <-ctx.Done():
+		return nil, false
+	}
+}
+
+// timeoutResponseWriter keeps own headers and passes the response through until the timeout,
+// so the handler running late can't race with the timeout response.
+type timeoutResponseWriter struct {
+	target   http.ResponseWriter
+	header   http.Header
+	mu       sync.Mutex
+	written  bool
+	timedOut bool
+}
+
+func newTimeoutResponseWriter(w http.ResponseWriter) *timeoutResponseWriter {
+	return &timeoutResponseWriter{target: w, header: w.Header().Clone()}
+}
+
+func (tw *timeoutResponseWriter) Header() http.Header {
+	return tw.header
+}
+
+// writeHeaders copies headers to the target on the first write, it must be called with the lock held.
+func (tw *timeoutResponseWriter) writeHeaders() {
+	if tw.written {
+		return
+	}
+	tw.written = true
+	header := tw.target.Header()
+	for name := range header {
+		delete(header, name)
+	}
+	for name, values := range tw.header {
+		header[name] = values
+	}
+}
+
+func (tw *timeoutResponseWriter) WriteHeader(statusCode int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return
+	}
+	tw.writeHeaders()
+	tw.target.WriteHeader(statusCode)
+}
+
+func (tw *timeoutResponseWriter) Write(data []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	tw.writeHeaders()
+	return tw.target.Write(data)
+}
+
+func (tw *timeoutResponseWriter) Flush() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return
+	}
+	tw.writeHeaders()
+	if flusher, ok := tw.target.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// timeout rejects further writes and reports whether the response is not written yet.
+func (tw *timeoutResponseWriter) timeout() bool {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.timedOut = true
+	return !tw.written
+}
+
+func (tw *timeoutResponseWriter) Unwrap() http.ResponseWriter {
+	return tw.target
+}
@@ -1,15 +1,95 @@
 package main
 
 import (
-	"net/http"
+	"context"
 	"io"
+	"net/http"
 )
 
+type RouteInfo struct {
+	Method   string
+	Template string
+	Name     string
+	// tags are kept behind pointer for RouteInfo to stay comparable
+	tags *routeTags
+}
+
+// routeTags are arbitrary labels of the route like owning team or SLA tier, e.g. for metrics and logs.
+type routeTags struct {
+	values map[string]string
+}
+
+// Tag returns value of the route tag with the key.
+func (ri RouteInfo) Tag(key string) (string, bool) {
+	if ri.tags == nil {
+		return "", false
+	}
+	value, found := ri.tags.values[key]
+	return value, found
+}
+
+// Tags returns copy of all tags of the route.
+func (ri RouteInfo) Tags() map[string]string {
+	tags := map[string]string{}
+	if ri.tags != nil {
+		for key, value := range ri.tags.values {
+			tags[key] = value
+		}
+	}
+	return tags
+}
+
+type routeInfoKey struct{}
+
+// RouteInfoFromContext returns info about the route which handles the request.
+func RouteInfoFromContext(ctx context.Context) (RouteInfo, bool) {
+	route, found := ctx.Value(routeInfoKey{}).(RouteInfo)
+	return route, found
+}
+
+// RouteMetadata describes what the route consumes and produces, it is derived from declarations of its builder.
+type RouteMetadata struct {
+	Method string
+	// Consumes are media types of decoders of the request body.
+	Consumes []string
+	// Produces are media types of encoders of the response body.
+	Produces []string
+	// RequiredHeaders are canonical names of headers required by the route.
+	RequiredHeaders []string
+}
+
+type routesMetadataKey struct{}
+
+// RoutesMetadataFromContext returns metadata of routes of the path of OPTIONS request passed to OptionsHandler.
+func RoutesMetadataFromContext(ctx context.Context) []RouteMetadata {
+	metadata, _ := ctx.Value(routesMetadataKey{}).([]RouteMetadata)
+	return metadata
+}
+
 type Interceptor func(w http.ResponseWriter, r *http.Request) bool
 
-type Decoder func(reader io.Reader) func(v interface{}) error
+type Decoder interface {
+	MediaType() string
+	NewDecodeStream(reader io.Reader) DecodeStream
+}
+
+type DecodeStream interface {
+	Decode(v interface{}) error
+}
+
+type Encoder interface {
+	MediaType() string
+	NewEncodeStream(writer io.Writer) EncodeStream
+}
+
+type EncodeStream interface {
+	Encode(v interface{}) error
+	Flush() error
+}
+
+type DecoderFunc func(reader io.Reader) func(v interface{}) error
 
-type Encoder func(writer io.Writer) func(v interface{}) error
+type EncoderFunc func(writer io.Writer) func(v interface{}) error
 
 type ErrorMapper func(err error, w http.ResponseWriter, r *http.Request) error
 
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// Validatable is implemented by body and tagged struct parameters checked after binding before the handler is invoked.
+type Validatable interface {
+	Validate() error
+}
+
+// Validator checks body and tagged struct parameters after their own Validate method.
+type Validator func(v interface{}) error
+
+// FieldError describes invalid field of the bound parameter.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError is returned when bound parameter fails validation, it results into 400 status code.
+// Errors returned by Validate methods and Validator which are not ValidationError are kept as Cause.
+type ValidationError struct {
+	Fields []FieldError
+	Cause  error
+}
+
+func (e ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Fields)+1)
+	if e.Cause != nil {
+		messages = append(messages, e.Cause.Error())
+	}
+	for _, field := range e.Fields {
+		messages = append(messages, field.Field+": "+field.Message)
+	}
+	return "validation failed: " + strings.Join(messages, "; ")
+}
+
+func (e ValidationError) Unwrap() error {
+	return e.Cause
+}
+
+func (e ValidationError) StatusCode() int {
+	return http.StatusBadRequest
+}
+
+func (e ValidationError) ProblemExtensions() map[string]interface{} {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return map[string]interface{}{"fields": e.Fields}
+}
+
+// Validator sets validator of body and tagged struct parameters, e.g. adapter of a validation library.
+func (b builder) Validator(validator Validator) Builder {
+	cloned := b.clone()
+	cloned.validator = validator
+	return cloned
+}
+
+func (b *builder) buildValidate() func(value reflect.Value) error {
+	validator := b.validator
+	return func(value reflect.Value) error {
+		var validatable Validatable
+		switch {
+		case value.CanAddr() && value.Addr().Type().Implements(validatableType):
+			validatable = value.Addr().Interface().(Validatable)
+		case value.Type().Implements(validatableType) && !isNil(value):
+			validatable = value.Interface().(Validatable)
+		}
+		if validatable != nil {
+			if err := validatable.Validate(); err != nil {
+				return validationError(err)
+			}
+		}
+		if validator != nil {
+			if err := validator(value.Interface()); err != nil {
+				return validationError(err)
+			}
+		}
+		return nil
+	}
+}
+
+func validationError(err error) error {
+	var validationErr ValidationError
+	if errors.As(err, &validationErr) {
+		return err
+	}
+	return ValidationError{Cause: err}
+}
+
+var validatableType = reflect.TypeOf((*Validatable)(nil)).Elem()
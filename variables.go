@@ -1,42 +1,77 @@
 package main
 
 import (
-	"encoding/json"
-	"encoding/xml"
+	"encoding"
 	"io"
+	"net"
 	"net/http"
+	"net/netip"
 	"net/url"
 	"reflect"
+	"time"
 )
 
 var (
-	JSONDecoder = func(reader io.Reader) func(v interface{}) error {
-		return json.NewDecoder(reader).Decode
-	}
+	JSONDecoder Decoder = jsonCodec{}
 
-	JSONEncoder = func(writer io.Writer) func(v interface{}) error {
-		return json.NewEncoder(writer).Encode
-	}
+	JSONEncoder Encoder = jsonCodec{}
 
-	XMLDecoder = func(reader io.Reader) func(v interface{}) error {
-		return xml.NewDecoder(reader).Decode
-	}
+	XMLDecoder Decoder = xmlCodec{}
 
-	XMLEncoder = func(writer io.Writer) func(v interface{}) error {
-		return xml.NewEncoder(writer).Encode
-	}
+	XMLEncoder Encoder = xmlCodec{}
+
+	// NDJSONDecoder decodes newline-delimited JSON, e.g. into a slice of all values of the body.
+	NDJSONDecoder Decoder = ndjsonCodec{}
+
+	// NDJSONEncoder encodes newline-delimited JSON, e.g. channels or iterators returned by handlers item by item.
+	NDJSONEncoder Encoder = ndjsonCodec{}
+
+	// CSVDecoder decodes CSV with a header row into a slice of structs with csv tags.
+	CSVDecoder Decoder = csvCodec{}
+
+	// CSVEncoder encodes a slice of structs with csv tags as CSV with a header row.
+	CSVEncoder Encoder = csvCodec{}
+
+	// MsgpackDecoder decodes MessagePack with marshaler set by UseMsgpack.
+	MsgpackDecoder Decoder = marshalerCodec{mediaType: "application/msgpack", use: "UseMsgpack", marshaler: &msgpackMarshaler}
+
+	// MsgpackEncoder encodes MessagePack with marshaler set by UseMsgpack.
+	MsgpackEncoder Encoder = marshalerCodec{mediaType: "application/msgpack", use: "UseMsgpack", marshaler: &msgpackMarshaler}
+
+	// CBORDecoder decodes CBOR with marshaler set by UseCBOR.
+	CBORDecoder Decoder = marshalerCodec{mediaType: "application/cbor", use: "UseCBOR", marshaler: &cborMarshaler}
+
+	// CBOREncoder encodes CBOR with marshaler set by UseCBOR.
+	CBOREncoder Encoder = marshalerCodec{mediaType: "application/cbor", use: "UseCBOR", marshaler: &cborMarshaler}
+
+	// ProtoDecoder decodes protocol buffers messages with marshaler set by UseProto or their own Unmarshal method.
+	ProtoDecoder Decoder = marshalerCodec{mediaType: "application/x-protobuf", use: "UseProto", marshaler: &protoMarshaler, fallback: generatedMarshaler{use: "UseProto"}}
+
+	// ProtoEncoder encodes protocol buffers messages with marshaler set by UseProto or their own Marshal method.
+	ProtoEncoder Encoder = marshalerCodec{mediaType: "application/x-protobuf", use: "UseProto", marshaler: &protoMarshaler, fallback: generatedMarshaler{use: "UseProto"}}
 
 	DefaultErrorMapper ErrorMapper = func(err error, w http.ResponseWriter, r *http.Request) error {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		setErrorHeaders(err, w)
+		http.Error(w, err.Error(), errorStatusCode(err, http.StatusInternalServerError))
+		return nil
+	}
+
+	// DefaultRequestErrorMapper responds with status code of RequestError, which is 400 Bad Request by default.
+	DefaultRequestErrorMapper ErrorMapper = func(err error, w http.ResponseWriter, r *http.Request) error {
+		setErrorHeaders(err, w)
+		http.Error(w, err.Error(), errorStatusCode(err, http.StatusBadRequest))
 		return nil
 	}
 
 	Application = struct {
-		JSON ContentType
-		XML  ContentType
-		ZIP  ContentType
-		GZIP ContentType
-		PDF  ContentType
+		JSON     ContentType
+		XML      ContentType
+		ZIP      ContentType
+		GZIP     ContentType
+		PDF      ContentType
+		MSGPACK  ContentType
+		CBOR     ContentType
+		PROTOBUF ContentType
 	}{
 		JSON: func() string {
 			return "application/json; charset=utf-8"
@@ -53,6 +88,15 @@ var (
 		PDF: func() string {
 			return "application/pdf; charset=utf-8"
 		},
+		MSGPACK: func() string {
+			return "application/msgpack"
+		},
+		CBOR: func() string {
+			return "application/cbor"
+		},
+		PROTOBUF: func() string {
+			return "application/x-protobuf"
+		},
 	}
 
 	Multipart = struct {
@@ -91,9 +135,23 @@ var (
 		},
 	}
 
-	headersType    = reflect.TypeOf(http.Header{})
-	urlQueryType   = reflect.TypeOf(url.Values{})
-	cookiesType    = reflect.TypeOf([]*http.Cookie{})
-	errorType      = reflect.TypeOf((*error)(nil)).Elem()
-	httpStatusType = reflect.TypeOf(http.StatusOK)
+	headersType        = reflect.TypeOf(http.Header{})
+	urlQueryType       = reflect.TypeOf(url.Values{})
+	cookiesType        = reflect.TypeOf([]*http.Cookie{})
+	errorType          = reflect.TypeOf((*error)(nil)).Elem()
+	httpStatusType     = reflect.TypeOf(http.StatusOK)
+	routeInfoType      = reflect.TypeOf(RouteInfo{})
+	requestType        = reflect.TypeOf((*http.Request)(nil))
+	responseWriterType = reflect.TypeOf((*http.ResponseWriter)(nil)).Elem()
+	durationType       = reflect.TypeOf(time.Duration(0))
+	timeType           = reflect.TypeOf(time.Time{})
+	netipAddrType      = reflect.TypeOf(netip.Addr{})
+	netipPrefixType    = reflect.TypeOf(netip.Prefix{})
+	netIPType          = reflect.TypeOf(net.IP{})
+	readerType         = reflect.TypeOf((*io.Reader)(nil)).Elem()
+
+	responseWrapperType = reflect.TypeOf((*responseWrapper)(nil)).Elem()
+	redirectType        = reflect.TypeOf(Redirect{})
+
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
 )
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+// Wrap wraps the endpoint with middleware of the standard shape, e.g. of chi or gorilla. The first middleware
+// is the outermost one. The endpoint is handled when the innermost one calls the next handler, its error is
+// returned by Handle then. Requests stopped by middleware aren't handled and nil is returned.
+func (b builder) Wrap(middleware ...func(next http.Handler) http.Handler) Builder {
+	cloned := b.clone()
+	wrappers := make([]func(next http.Handler) http.Handler, 0, len(cloned.middleware)+len(middleware))
+	cloned.middleware = append(append(wrappers, cloned.middleware...), middleware...)
+	return cloned
+}
+
+// Wrap wraps all requests to the router with middleware of the standard shape, including ones not matching
+// any route. The first middleware is the outermost one. It should be called before the router serves requests.
+func (rt *Router) Wrap(middleware ...func(next http.Handler) http.Handler) *Router {
+	rt.middleware = append(rt.middleware, middleware...)
+	rt.wrapped = chain(http.HandlerFunc(rt.serve), rt.middleware)
+	return rt
+}
+
+func chain(handler http.Handler, middleware []func(next http.Handler) http.Handler) http.Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
+
+type wrappedCallKey struct{}
+
+// wrappedCall passes the endpoint through middleware and takes back its error.
+type wrappedCall struct {
+	endpoint EndpointProcessor
+	err      error
+}
+
+// handleWrapped is the innermost handler of middleware of the endpoint built once on Build,
+// so the endpoint is taken from the request as the router sets hooks and clock on registered endpoints.
+var handleWrapped = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	call := r.Context().Value(wrappedCallKey{}).(*wrappedCall)
+	call.err = call.endpoint.handle(w, r)
+})
+
+func (b *builder) buildMiddleware() http.Handler {
+	if len(b.middleware) == 0 {
+		return nil
+	}
+	return chain(handleWrapped, b.middleware)
+}
+
+func (ep EndpointProcessor) handleWithMiddleware(w http.ResponseWriter, r *http.Request) error {
+	call := &wrappedCall{endpoint: ep}
+	ep.middleware.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), wrappedCallKey{}, call)))
+	return call.err
+}